@@ -51,6 +51,9 @@ type Config struct {
 	DbCapacity    uint64
 	CacheCapacity uint
 	BaseKey       []byte
+	// ChunkCacheSize is the size in bytes of the in-memory cache
+	// of retrieved chunks. Zero value disables the cache.
+	ChunkCacheSize uint64
 
 	*network.HiveParams
 	Swap                 *swap.LocalProfile
@@ -78,6 +81,23 @@ type Config struct {
 	BzzAccount           string
 	GlobalStoreAPI       string
 	privateKey           *ecdsa.PrivateKey
+
+	// SyncHighWatermarkRatio is the ratio of the local store garbage
+	// collection target above which requesting of new chunks from
+	// syncing streams is delayed. Zero value disables it.
+	SyncHighWatermarkRatio float64
+	// PushSyncReplication is the number of nodes closest to an uploaded
+	// chunk that the chunk is pushed to, and uploads return only after
+	// a majority of them send receipts. Zero value disables push-sync.
+	PushSyncReplication int
+	// ChunkReplication is the number of nodes closest to an uploaded
+	// chunk that the chunk is replicated to, if it is within the node's
+	// area of responsibility. Zero value disables replication.
+	ChunkReplication int
+	// FallbackGateway is the URL of the HTTP gateway that chunks are
+	// retrieved from if peers do not deliver them in time. Empty value
+	// disables the fallback.
+	FallbackGateway string
 }
 
 //create a default config with all parameters to set to defaults
@@ -101,6 +121,8 @@ func NewConfig() (c *Config) {
 		DeliverySkipCheck:    true,
 		SyncUpdateDelay:      15 * time.Second,
 		SwapAPI:              "",
+
+		SyncHighWatermarkRatio: 0.9,
 	}
 
 	return
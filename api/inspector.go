@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethersphere/swarm/chunk"
 	"github.com/ethersphere/swarm/log"
 	"github.com/ethersphere/swarm/network"
 	"github.com/ethersphere/swarm/storage"
@@ -82,3 +83,26 @@ func (inspector *Inspector) Has(chunkAddresses []storage.Address) string {
 
 	return strings.Join(hostChunks, "")
 }
+
+// TagInfo describes a tag and the progress of its chunks
+type TagInfo struct {
+	Uid      uint32          `json:"uid"`
+	Name     string          `json:"name"`
+	Address  storage.Address `json:"address"`
+	Progress chunk.Progress  `json:"progress"`
+}
+
+// Tags returns all tags known to the node with their progress,
+// so that the upload progress can be monitored over RPC as bzz_tags
+func (inspector *Inspector) Tags() []TagInfo {
+	res := []TagInfo{}
+	for _, t := range inspector.api.Tags.All() {
+		res = append(res, TagInfo{
+			Uid:      t.Uid,
+			Name:     t.Name,
+			Address:  t.Address,
+			Progress: t.Progress(),
+		})
+	}
+	return res
+}
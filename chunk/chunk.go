@@ -38,11 +38,14 @@ var (
 type Chunk interface {
 	Address() Address
 	Data() []byte
+	TagID() uint32
+	WithTagID(t uint32) Chunk
 }
 
 type chunk struct {
 	addr  Address
 	sdata []byte
+	tagID uint32
 }
 
 func NewChunk(addr Address, data []byte) Chunk {
@@ -60,6 +63,18 @@ func (c *chunk) Data() []byte {
 	return c.sdata
 }
 
+// TagID returns the uid of the tag the chunk was uploaded with,
+// or 0 if the chunk is not associated with a tag.
+func (c *chunk) TagID() uint32 {
+	return c.tagID
+}
+
+// WithTagID sets the tag uid on the chunk and returns it.
+func (c *chunk) WithTagID(t uint32) Chunk {
+	c.tagID = t
+	return c
+}
+
 func (self *chunk) String() string {
 	return fmt.Sprintf("Address: %v Chunksize: %v", self.addr.Log(), len(self.sdata))
 }
@@ -206,8 +221,9 @@ const (
 // Descriptor holds information required for Pull syncing. This struct
 // is provided by subscribing to pull index.
 type Descriptor struct {
-	Address Address
-	BinID   uint64
+	Address        Address
+	BinID          uint64
+	StoreTimestamp int64
 }
 
 func (d *Descriptor) String() string {
@@ -219,6 +235,12 @@ func (d *Descriptor) String() string {
 
 type Store interface {
 	Get(ctx context.Context, mode ModeGet, addr Address) (ch Chunk, err error)
+	// GetMulti returns chunks for all provided addresses, in the order of
+	// addresses. A chunk that is not found is a nil entry in the returned
+	// slice at the position of its address and it is not an error. An
+	// error is returned only if the chunks can not be retrieved, in which
+	// case no chunks are returned.
+	GetMulti(ctx context.Context, mode ModeGet, addrs ...Address) (chs []Chunk, err error)
 	Put(ctx context.Context, mode ModePut, ch Chunk) (exists bool, err error)
 	Has(ctx context.Context, addr Address) (yes bool, err error)
 	Set(ctx context.Context, mode ModeSet, addr Address) (err error)
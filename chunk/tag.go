@@ -19,6 +19,7 @@ package chunk
 import (
 	"encoding/binary"
 	"errors"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -28,6 +29,8 @@ var (
 	errNA          = errors.New("not available yet")
 	errNoETA       = errors.New("unable to calculate ETA")
 	errTagNotFound = errors.New("tag not found")
+	errUntracked   = errors.New("chunk already untracked")
+	errNotTracking = errors.New("chunks are not tracked")
 )
 
 // State is the enum type for chunk states
@@ -53,8 +56,18 @@ type Tag struct {
 	sent      int64     // number of chunks sent for push syncing
 	synced    int64     // number of chunks synced with proof
 	startedAt time.Time // tag started to calculate ETA
+	ttl       int64     // time in nanoseconds after which stored chunks expire, no expiry if zero
+	cancelled int32     // set to 1 when the upload is cancelled
+
+	tracking  bool                    // counts of individual chunks are kept if true
+	chunks    map[string]*chunkCounts // counts incremented with IncChunk, by chunk address
+	untracked map[string]struct{}     // addresses of chunks excluded from the tag
+	mu        sync.Mutex              // protects tracking, chunks and untracked
 }
 
+// chunkCounts holds the counts incremented for a single chunk, by state.
+type chunkCounts [StateSynced + 1]int64
+
 // New creates a new tag, stores it by the name and returns it
 // it returns an error if the tag with this name already exists
 func NewTag(uid uint32, s string, total int64) *Tag {
@@ -69,38 +82,105 @@ func NewTag(uid uint32, s string, total int64) *Tag {
 
 // Inc increments the count for a state
 func (t *Tag) Inc(state State) {
-	var v *int64
-	switch state {
-	case StateSplit:
-		v = &t.split
-	case StateStored:
-		v = &t.stored
-	case StateSeen:
-		v = &t.seen
-	case StateSent:
-		v = &t.sent
-	case StateSynced:
-		v = &t.synced
+	atomic.AddInt64(t.counter(state), 1)
+}
+
+// TrackChunks enables keeping the counts of individual chunks, which is
+// required by Untrack. It should be called before any chunk is counted
+// with IncChunk, as earlier counts can not be reverted.
+func (t *Tag) TrackChunks() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.tracking = true
+}
+
+// IncChunk increments the count for a state on behalf of the chunk
+// with the provided address. If tracking of chunks is enabled with
+// TrackChunks, the count can be reverted if the chunk is untracked,
+// and counts for untracked chunks are ignored.
+func (t *Tag) IncChunk(state State, addr Address) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.tracking {
+		t.Inc(state)
+		return
+	}
+	key := string(addr)
+	if _, ok := t.untracked[key]; ok {
+		return
+	}
+	if t.chunks == nil {
+		t.chunks = make(map[string]*chunkCounts)
+	}
+	c, ok := t.chunks[key]
+	if !ok {
+		c = new(chunkCounts)
+		t.chunks[key] = c
+	}
+	c[state]++
+	t.Inc(state)
+}
+
+// Untrack excludes the chunk with the provided address from the tag.
+// It reverts the counts that were incremented for the chunk with
+// IncChunk and decrements the total count by the number of times the
+// chunk was stored, as the same chunk can be a part of the content more
+// than once, but at least by one. The split count is not changed as it
+// reflects the work already done by the splitter. Untracking the same
+// chunk twice or untracking when tracking of chunks is not enabled
+// returns an error.
+func (t *Tag) Untrack(addr Address) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.tracking {
+		return errNotTracking
+	}
+	key := string(addr)
+	if _, ok := t.untracked[key]; ok {
+		return errUntracked
 	}
-	atomic.AddInt64(v, 1)
+	if t.untracked == nil {
+		t.untracked = make(map[string]struct{})
+	}
+	t.untracked[key] = struct{}{}
+
+	total := int64(1)
+	if c, ok := t.chunks[key]; ok {
+		delete(t.chunks, key)
+		for _, state := range []State{StateStored, StateSeen, StateSent, StateSynced} {
+			atomic.AddInt64(t.counter(state), -c[state])
+		}
+		if c[StateStored] > total {
+			total = c[StateStored]
+		}
+	}
+	atomic.AddInt64(&t.total, -total)
+	return nil
 }
 
 // Get returns the count for a state on a tag
 func (t *Tag) Get(state State) int64 {
-	var v *int64
+	return atomic.LoadInt64(t.counter(state))
+}
+
+// counter returns the pointer to the count for a state
+func (t *Tag) counter(state State) *int64 {
 	switch state {
 	case StateSplit:
-		v = &t.split
+		return &t.split
 	case StateStored:
-		v = &t.stored
+		return &t.stored
 	case StateSeen:
-		v = &t.seen
+		return &t.seen
 	case StateSent:
-		v = &t.sent
+		return &t.sent
 	case StateSynced:
-		v = &t.synced
+		return &t.synced
 	}
-	return atomic.LoadInt64(v)
+	return nil
 }
 
 // GetTotal returns the total count
@@ -108,6 +188,30 @@ func (t *Tag) Total() int64 {
 	return atomic.LoadInt64(&t.total)
 }
 
+// SetTTL sets the duration after which stored chunks of this tag
+// expire and are removed from the local store. Zero duration disables
+// expiry.
+func (t *Tag) SetTTL(ttl time.Duration) {
+	atomic.StoreInt64(&t.ttl, int64(ttl))
+}
+
+// TTL returns the duration after which stored chunks of this tag expire.
+func (t *Tag) TTL() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.ttl))
+}
+
+// Cancel marks the tag as cancelled, so that its chunks that are
+// not yet synced are no longer pushed or offered to other nodes.
+// Chunks that are already stored locally are not removed.
+func (t *Tag) Cancel() {
+	atomic.StoreInt32(&t.cancelled, 1)
+}
+
+// Cancelled returns true if the tag is cancelled.
+func (t *Tag) Cancelled() bool {
+	return atomic.LoadInt32(&t.cancelled) == 1
+}
+
 // DoneSplit sets total count to SPLIT count and sets the associated swarm hash for this tag
 // is meant to be called when splitter finishes for input streams of unknown size
 func (t *Tag) DoneSplit(address Address) int64 {
@@ -136,6 +240,29 @@ func (t *Tag) Status(state State) (int64, int64, error) {
 	return count, total, errNA
 }
 
+// Progress holds a snapshot of the counts of a tag
+type Progress struct {
+	Total  int64 `json:"total"`
+	Split  int64 `json:"split"`
+	Seen   int64 `json:"seen"`
+	Stored int64 `json:"stored"`
+	Sent   int64 `json:"sent"`
+	Synced int64 `json:"synced"`
+}
+
+// Progress returns the current counts of all states and the total count.
+// Status is kept for a single state as it is used to calculate ETA.
+func (t *Tag) Progress() Progress {
+	return Progress{
+		Total:  atomic.LoadInt64(&t.total),
+		Split:  t.Get(StateSplit),
+		Seen:   t.Get(StateSeen),
+		Stored: t.Get(StateStored),
+		Sent:   t.Get(StateSent),
+		Synced: t.Get(StateSynced),
+	}
+}
+
 // ETA returns the time of completion estimated based on time passed and rate of completion
 func (t *Tag) ETA(state State) (time.Time, error) {
 	cnt, total, err := t.Status(state)
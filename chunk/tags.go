@@ -19,6 +19,7 @@ package chunk
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math/rand"
 	"sync"
 	"time"
@@ -26,6 +27,10 @@ import (
 	"github.com/ethersphere/swarm/sctx"
 )
 
+// waitSyncedInterval is the period in which WaitSynced checks
+// the tag counters.
+var waitSyncedInterval = 100 * time.Millisecond
+
 // Tags hold tag information indexed by a unique random uint32
 type Tags struct {
 	tags *sync.Map
@@ -76,6 +81,16 @@ func (ts *Tags) Get(uid uint32) (*Tag, error) {
 	return t.(*Tag), nil
 }
 
+// Cancel marks the tag with the provided uid as cancelled
+func (ts *Tags) Cancel(uid uint32) error {
+	t, err := ts.Get(uid)
+	if err != nil {
+		return err
+	}
+	t.Cancel()
+	return nil
+}
+
 // GetFromContext gets a tag from the tag uid stored in the context
 func (ts *Tags) GetFromContext(ctx context.Context) (*Tag, error) {
 	uid := sctx.GetTag(ctx)
@@ -86,6 +101,42 @@ func (ts *Tags) GetFromContext(ctx context.Context) (*Tag, error) {
 	return t.(*Tag), nil
 }
 
+// WaitSynced blocks until all chunks of the tag with the provided uid
+// that were not seen before are synced, or the context is done.
+// On context expiry the returned error reports the number of stored
+// and synced chunks.
+func (ts *Tags) WaitSynced(ctx context.Context, uid uint32) error {
+	t, err := ts.Get(uid)
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(waitSyncedInterval)
+	defer ticker.Stop()
+	for {
+		count, total, err := t.Status(StateSynced)
+		if err == nil && count >= total {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("tag %d not synced: stored %d, synced %d: %v", uid, t.Get(StateStored), t.Get(StateSynced), ctx.Err())
+		}
+	}
+}
+
+// Untrack excludes the chunk with the provided address from the
+// tag with the provided uid, so that it no longer counts towards
+// the tag totals. It returns an error if the tag is not found, if
+// the tag does not track chunks or the chunk is already untracked.
+func (ts *Tags) Untrack(uid uint32, addr Address) error {
+	t, err := ts.Get(uid)
+	if err != nil {
+		return err
+	}
+	return t.Untrack(addr)
+}
+
 // Range exposes sync.Map's iterator
 func (ts *Tags) Range(fn func(k, v interface{}) bool) {
 	ts.tags.Range(fn)
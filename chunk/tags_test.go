@@ -16,7 +16,13 @@
 
 package chunk
 
-import "testing"
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestAll(t *testing.T) {
 	ts := NewTags()
@@ -46,3 +52,193 @@ func TestAll(t *testing.T) {
 	}
 
 }
+
+// TestAllProgress validates that tags returned by All and Get
+// report the counts they were advanced by with Progress.
+func TestAllProgress(t *testing.T) {
+	ts := NewTags()
+
+	want := make(map[uint32]Progress)
+	for i := 1; i <= 3; i++ {
+		tg, err := ts.New(strconv.Itoa(i), int64(10*i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < i; j++ {
+			tg.Inc(StateStored)
+			tg.Inc(StateSent)
+		}
+		tg.Inc(StateSynced)
+		want[tg.Uid] = Progress{
+			Total:  int64(10 * i),
+			Stored: int64(i),
+			Sent:   int64(i),
+			Synced: 1,
+		}
+	}
+
+	all := ts.All()
+	if len(all) != len(want) {
+		t.Fatalf("got %d tags, want %d", len(all), len(want))
+	}
+	for _, tg := range all {
+		w, ok := want[tg.Uid]
+		if !ok {
+			t.Fatalf("unexpected tag %d", tg.Uid)
+		}
+		if got := tg.Progress(); got != w {
+			t.Errorf("tag %d: got progress %+v, want %+v", tg.Uid, got, w)
+		}
+		g, err := ts.Get(tg.Uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g != tg {
+			t.Errorf("tag %d: got different tag from Get", tg.Uid)
+		}
+	}
+
+	if _, err := ts.Get(0); err == nil {
+		t.Error("expected error for unknown tag")
+	}
+}
+
+// TestWaitSynced validates that WaitSynced returns when all
+// not previously seen chunks of a tag are synced, and that it
+// reports the stored and synced counts on context expiry.
+func TestWaitSynced(t *testing.T) {
+	ts := NewTags()
+	tg, err := ts.New("wait", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		tg.Inc(StateStored)
+	}
+	tg.Inc(StateSeen)
+	tg.Inc(StateSynced)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err = ts.WaitSynced(ctx, tg.Uid)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "stored 3, synced 1") {
+		t.Fatalf("got error %q, expected it to contain stored and synced counts", err)
+	}
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- ts.WaitSynced(context.Background(), tg.Uid)
+	}()
+	tg.Inc(StateSynced)
+	select {
+	case err := <-errC:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for tag to be synced")
+	}
+
+	if err := ts.WaitSynced(context.Background(), tg.Uid+1); err == nil {
+		t.Fatal("expected error for unknown tag, got nil")
+	}
+}
+
+// TestUntrack validates that untracked chunks no longer count towards
+// the tag totals, regardless of the state they had progressed to, and
+// that WaitSynced waits only for the chunks that remain tracked.
+func TestUntrack(t *testing.T) {
+	ts := NewTags()
+	tg, err := ts.New("untrack", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tg.Untrack(Address{0}); err != errNotTracking {
+		t.Fatalf("got error %v, want %v", err, errNotTracking)
+	}
+	tg.TrackChunks()
+	addrs := make([]Address, 10)
+	for i := range addrs {
+		addrs[i] = Address{byte(i)}
+		tg.IncChunk(StateStored, addrs[i])
+	}
+	tg.IncChunk(StateSynced, addrs[0])
+	tg.IncChunk(StateSynced, addrs[1])
+
+	for _, i := range []int{0, 5, 6} {
+		if err := ts.Untrack(tg.Uid, addrs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ts.Untrack(tg.Uid, addrs[5]); err != errUntracked {
+		t.Fatalf("got error %v, want %v", err, errUntracked)
+	}
+	if err := ts.Untrack(tg.Uid+1, addrs[1]); err == nil {
+		t.Fatal("expected error for unknown tag, got nil")
+	}
+
+	if n := tg.Total(); n != 7 {
+		t.Fatalf("got total %d, want 7", n)
+	}
+	if n := tg.Get(StateStored); n != 7 {
+		t.Fatalf("got stored %d, want 7", n)
+	}
+	if n := tg.Get(StateSynced); n != 1 {
+		t.Fatalf("got synced %d, want 1", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := ts.WaitSynced(ctx, tg.Uid); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- ts.WaitSynced(context.Background(), tg.Uid)
+	}()
+	// syncing untracked chunks is not counted
+	for _, addr := range addrs[2:] {
+		tg.IncChunk(StateSynced, addr)
+	}
+	select {
+	case err := <-errC:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for tag to be synced")
+	}
+	if n := tg.Get(StateSynced); n != 7 {
+		t.Fatalf("got synced %d, want 7", n)
+	}
+}
+
+// TestUntrackDuplicate validates that untracking a chunk that is
+// counted more than once reverts all of its counts.
+func TestUntrackDuplicate(t *testing.T) {
+	tg := NewTag(1, "duplicate", 3)
+	tg.TrackChunks()
+	addr := Address{1}
+	for i := 0; i < 2; i++ {
+		tg.IncChunk(StateStored, addr)
+	}
+	tg.IncChunk(StateSeen, addr)
+	tg.IncChunk(StateStored, Address{2})
+
+	if err := tg.Untrack(addr); err != nil {
+		t.Fatal(err)
+	}
+	if n := tg.Total(); n != 1 {
+		t.Fatalf("got total %d, want 1", n)
+	}
+	if n := tg.Get(StateStored); n != 1 {
+		t.Fatalf("got stored %d, want 1", n)
+	}
+	if n := tg.Get(StateSeen); n != 0 {
+		t.Fatalf("got seen %d, want 0", n)
+	}
+}
@@ -76,7 +76,7 @@ func createSnapshot(filename string, nodes int, services []string) (err error) {
 			}
 			return network.NewBzz(config, kad, nil, nil, nil), nil, nil
 		},
-	})
+	}, nil)
 	defer sim.Close()
 
 	ids, err := sim.AddNodes(nodes)
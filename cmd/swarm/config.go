@@ -79,10 +79,12 @@ const (
 	SwarmEnvStorePath            = "SWARM_STORE_PATH"
 	SwarmEnvStoreCapacity        = "SWARM_STORE_CAPACITY"
 	SwarmEnvStoreCacheCapacity   = "SWARM_STORE_CACHE_CAPACITY"
+	SwarmEnvChunkCacheSize       = "SWARM_CHUNK_CACHE_SIZE"
 	SwarmEnvBootnodeMode         = "SWARM_BOOTNODE_MODE"
 	SwarmAccessPassword          = "SWARM_ACCESS_PASSWORD"
 	SwarmAutoDefaultPath         = "SWARM_AUTO_DEFAULTPATH"
 	SwarmGlobalstoreAPI          = "SWARM_GLOBALSTORE_API"
+	SwarmEnvFallbackGateway      = "SWARM_FALLBACK_GATEWAY"
 	GethEnvDataDir               = "GETH_DATADIR"
 )
 
@@ -263,6 +265,10 @@ func cmdLineOverride(currentConfig *bzzapi.Config, ctx *cli.Context) *bzzapi.Con
 		currentConfig.CacheCapacity = ctx.GlobalUint(SwarmStoreCacheCapacity.Name)
 	}
 
+	if ctx.GlobalIsSet(SwarmChunkCacheSize.Name) {
+		currentConfig.ChunkCacheSize = ctx.GlobalUint64(SwarmChunkCacheSize.Name)
+	}
+
 	if ctx.GlobalIsSet(SwarmBootnodeModeFlag.Name) {
 		currentConfig.BootnodeMode = ctx.GlobalBool(SwarmBootnodeModeFlag.Name)
 	}
@@ -271,6 +277,10 @@ func cmdLineOverride(currentConfig *bzzapi.Config, ctx *cli.Context) *bzzapi.Con
 		currentConfig.GlobalStoreAPI = ctx.GlobalString(SwarmGlobalStoreAPIFlag.Name)
 	}
 
+	if gateway := ctx.GlobalString(SwarmFallbackGatewayFlag.Name); gateway != "" {
+		currentConfig.FallbackGateway = gateway
+	}
+
 	return currentConfig
 
 }
@@ -153,6 +153,16 @@ var (
 		EnvVar: SwarmEnvStoreCacheCapacity,
 		Value:  10000,
 	}
+	SwarmChunkCacheSize = cli.Uint64Flag{
+		Name:   "chunk.cache.size",
+		Usage:  "Size in bytes of the in-memory cache of retrieved chunks (0 disables the cache)",
+		EnvVar: SwarmEnvChunkCacheSize,
+	}
+	SwarmFallbackGatewayFlag = cli.StringFlag{
+		Name:   "fallback.gateway",
+		Usage:  "URL of the HTTP gateway to retrieve chunks from if peers do not deliver them in time",
+		EnvVar: SwarmEnvFallbackGateway,
+	}
 	SwarmCompressedFlag = cli.BoolFlag{
 		Name:  "compressed",
 		Usage: "Prints encryption keys in compressed form",
@@ -199,7 +199,10 @@ func init() {
 		SwarmStorePath,
 		SwarmStoreCapacity,
 		SwarmStoreCacheCapacity,
+		SwarmChunkCacheSize,
 		SwarmGlobalStoreAPIFlag,
+		// retrieval flags
+		SwarmFallbackGatewayFlag,
 	}
 	rpcFlags := []cli.Flag{
 		utils.WSEnabledFlag,
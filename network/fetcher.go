@@ -18,12 +18,22 @@ package network
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethersphere/swarm/chunk"
 	"github.com/ethersphere/swarm/storage"
 	"github.com/ethersphere/swarm/tracing"
 	olog "github.com/opentracing/opentracing-go/log"
@@ -40,7 +50,16 @@ const (
 // Also used in stream delivery.
 var RequestTimeout = 10 * time.Second
 
-type RequestFunc func(context.Context, *Request) (*enode.ID, chan struct{}, error)
+// FallbackTimeout is the time a Fetcher with a gateway fallback waits
+// for the chunk to be delivered by peers before retrieving it from the gateway.
+var FallbackTimeout = 5 * time.Second
+
+var errInvalidGatewayChunk = errors.New("invalid chunk from gateway")
+
+// RequestFunc issues a retrieve request for a chunk. Peers in skipPeers
+// must not be selected to request the chunk from, unless the request
+// explicitly defines its source.
+type RequestFunc func(ctx context.Context, req *Request, skipPeers ...enode.ID) (*enode.ID, chan struct{}, error)
 
 // Fetcher is created when a chunk is not found locally. It starts a request handler loop once and
 // keeps it alive until all active requests are completed. This can happen:
@@ -49,13 +68,24 @@ type RequestFunc func(context.Context, *Request) (*enode.ID, chan struct{}, erro
 // Fetcher self destroys itself after it is completed.
 // TODO: cancel all forward requests after termination
 type Fetcher struct {
-	protoRequestFunc RequestFunc     // request function fetcher calls to issue retrieve request for a chunk
-	addr             storage.Address // the address of the chunk to be fetched
-	offerC           chan *enode.ID  // channel of sources (peer node id strings)
-	requestC         chan uint8      // channel for incoming requests (with the hopCount value in it)
-	searchTimeout    time.Duration
+	protoRequestFunc RequestFunc        // request function fetcher calls to issue retrieve request for a chunk
+	addr             storage.Address    // the address of the chunk to be fetched
+	offerC           chan *enode.ID     // channel of sources (peer node id strings)
+	requestC         chan uint8         // channel for incoming requests (with the hopCount value in it)
+	searchTimeout    time.Duration      // time to wait after the first request
+	retryPolicy      FetcherRetryPolicy // how to request the chunk again
+	attempts         int                // number of requests issued, accessed only in run loop
+	attemptTimeout   time.Duration      // time a peer has to deliver the chunk, overrides retry policy delay if not zero
 	skipCheck        bool
 	ctx              context.Context
+	skipPeers        []enode.ID // peers that failed to deliver or timed out, accessed only in run loop
+	lastRequested    *enode.ID  // the peer the last request was sent to, accessed only in run loop
+	requestedPeers   int32      // number of peers the chunk was requested from, accessed atomically
+	noPeers          int32      // set to 1 if the last request failed as no peer was found, accessed atomically
+
+	gatewayURL string                           // url of the fallback gateway, fallback is disabled if empty
+	store      *storage.NetStore                // store that chunks retrieved from the gateway are put to
+	validator  *storage.ContentAddressValidator // verifies chunks retrieved from the gateway
 }
 
 type Request struct {
@@ -94,18 +124,100 @@ func (r *Request) SkipPeer(nodeID string) bool {
 	return true
 }
 
+// RequestOpts holds options of a chunk request that are
+// set on the request context with WithRequestOpts.
+type RequestOpts struct {
+	// PerAttemptTimeout is the time a peer has to deliver the chunk
+	// before it is requested from another peer, while the chunk is
+	// fetched until the request context is done. If it is zero, the
+	// retry policy of the FetcherFactory defines the time.
+	PerAttemptTimeout time.Duration
+}
+
+type requestOptsKey struct{}
+
+// WithRequestOpts returns a context with request options for chunks
+// that are requested with it. Options of the request that starts
+// fetching a chunk apply to all concurrent requests for that chunk.
+func WithRequestOpts(ctx context.Context, opts RequestOpts) context.Context {
+	return context.WithValue(ctx, requestOptsKey{}, opts)
+}
+
+// RequestOptsFromContext returns request options set on
+// the context with WithRequestOpts.
+func RequestOptsFromContext(ctx context.Context) (opts RequestOpts, ok bool) {
+	opts, ok = ctx.Value(requestOptsKey{}).(RequestOpts)
+	return opts, ok
+}
+
+// FetcherRetryPolicy defines how a Fetcher requests a chunk from
+// other peers when the previous request fails or times out.
+type FetcherRetryPolicy struct {
+	// MaxAttempts is the maximal number of requests for a chunk.
+	// If it is 0, requests are limited only by the request context.
+	MaxAttempts int
+	// Backoff is the time to wait after the first request.
+	// If it is 0, the default of one second is used.
+	Backoff time.Duration
+	// Multiplier scales the wait time after every subsequent request.
+	// Values lower than 1 are treated as 1.
+	Multiplier float64
+	// Jitter is the fraction, between 0 and 1, by which the wait time
+	// is randomly increased or decreased.
+	Jitter float64
+}
+
+// NewFetcherRetryPolicy returns the default retry policy which requests
+// a chunk from a new peer every second until the request context is done.
+func NewFetcherRetryPolicy() *FetcherRetryPolicy {
+	return &FetcherRetryPolicy{
+		Backoff:    defaultSearchTimeout,
+		Multiplier: 1,
+	}
+}
+
 // FetcherFactory is initialised with a request function and can create fetchers
 type FetcherFactory struct {
-	request   RequestFunc
-	skipCheck bool
+	request     RequestFunc
+	skipCheck   bool
+	retryPolicy FetcherRetryPolicy
+	gatewayURL  string
+	store       *storage.NetStore
+	validator   *storage.ContentAddressValidator
 }
 
-// NewFetcherFactory takes a request function and skip check parameter and creates a FetcherFactory
-func NewFetcherFactory(request RequestFunc, skipCheck bool) *FetcherFactory {
-	return &FetcherFactory{
-		request:   request,
-		skipCheck: skipCheck,
+// NewFetcherFactory takes a request function, skip check parameter and
+// a retry policy and creates a FetcherFactory. If the retry policy is nil,
+// the default one is used.
+func NewFetcherFactory(request RequestFunc, skipCheck bool, retryPolicy *FetcherRetryPolicy) *FetcherFactory {
+	if retryPolicy == nil {
+		retryPolicy = NewFetcherRetryPolicy()
+	}
+	f := &FetcherFactory{
+		request:     request,
+		skipCheck:   skipCheck,
+		retryPolicy: *retryPolicy,
 	}
+	// zero backoff would make fetchers request the chunk again without waiting
+	if f.retryPolicy.Backoff <= 0 {
+		f.retryPolicy.Backoff = defaultSearchTimeout
+	}
+	return f
+}
+
+// NewFetcherFactoryWithFallback creates a FetcherFactory with the default
+// retry policy whose fetchers retrieve the chunk from the gateway at
+// gatewayURL if it is not delivered by peers within FallbackTimeout.
+// The content of the chunk is requested with GET <gatewayURL>/bzz-raw:/<hex address>,
+// so only data chunks, whose content is not larger than the chunk size,
+// can be retrieved. Retrieved chunks are verified with the validator for
+// the hasher and chunk size from params and put to the store.
+func NewFetcherFactoryWithFallback(request RequestFunc, skipCheck bool, gatewayURL string, store *storage.NetStore, params *storage.FileStoreParams) *FetcherFactory {
+	f := NewFetcherFactory(request, skipCheck, nil)
+	f.gatewayURL = strings.TrimRight(gatewayURL, "/")
+	f.store = store
+	f.validator = params.Validator()
+	return f
 }
 
 // New constructs a new Fetcher, for the given chunk. All peers in peersToSkip
@@ -115,23 +227,51 @@ func NewFetcherFactory(request RequestFunc, skipCheck bool) *FetcherFactory {
 // The created Fetcher is started and returned.
 func (f *FetcherFactory) New(ctx context.Context, source storage.Address, peers *sync.Map) storage.NetFetcher {
 	fetcher := NewFetcher(ctx, source, f.request, f.skipCheck)
+	fetcher.retryPolicy = f.retryPolicy
+	fetcher.searchTimeout = f.retryPolicy.Backoff
+	if f.gatewayURL != "" {
+		fetcher.gatewayURL = f.gatewayURL
+		fetcher.store = f.store
+		fetcher.validator = f.validator
+	}
 	go fetcher.run(peers)
 	return fetcher
 }
 
 // NewFetcher creates a new Fetcher for the given chunk address using the given request function.
+// The Fetcher uses the default retry policy and request options from the context.
 func NewFetcher(ctx context.Context, addr storage.Address, rf RequestFunc, skipCheck bool) *Fetcher {
+	retryPolicy := NewFetcherRetryPolicy()
+	opts, _ := RequestOptsFromContext(ctx)
 	return &Fetcher{
+		attemptTimeout:   opts.PerAttemptTimeout,
 		addr:             addr,
 		protoRequestFunc: rf,
 		offerC:           make(chan *enode.ID),
 		requestC:         make(chan uint8),
-		searchTimeout:    defaultSearchTimeout,
+		searchTimeout:    retryPolicy.Backoff,
+		retryPolicy:      *retryPolicy,
 		skipCheck:        skipCheck,
 		ctx:              ctx,
 	}
 }
 
+// RequestedPeers returns the number of peers
+// that the chunk was requested from.
+func (f *Fetcher) RequestedPeers() int {
+	return int(atomic.LoadInt32(&f.requestedPeers))
+}
+
+// Err returns storage.ErrNoPeers if the last request for the chunk
+// failed as there were no peers to request it from.
+// It implements the optional NetFetcher error reporting.
+func (f *Fetcher) Err() error {
+	if atomic.LoadInt32(&f.noPeers) == 1 {
+		return storage.ErrNoPeers
+	}
+	return nil
+}
+
 // Offer is called when an upstream peer offers the chunk via syncing as part of `OfferedHashesMsg` and the node does not have the chunk locally.
 func (f *Fetcher) Offer(source *enode.ID) {
 	// First we need to have this select to make sure that we return if context is done
@@ -181,6 +321,8 @@ func (f *Fetcher) run(peers *sync.Map) {
 		sources   []*enode.ID      // known sources, ie. peers that offered the chunk
 		requested bool             // true if the chunk was actually requested
 		hopCount  uint8
+		fallback  *time.Timer      // timer for retrieval from the gateway
+		fallbackC <-chan time.Time // fallback timer channel
 	)
 	gone := make(chan *enode.ID) // channel to signal that a peer we requested from disconnected
 
@@ -214,15 +356,28 @@ func (f *Fetcher) run(peers *sync.Map) {
 			// and remove the peer from the peers map
 		case id := <-gone:
 			peers.Delete(id.String())
+			f.addSkipPeer(*id)
 			doRequest = requested
 			log.Trace("peer gone", "peer id", id.String(), "request addr", f.addr, "doRequest", doRequest)
 
 		// search timeout: too much time passed since the last request,
 		// extend the search to a new peer if we can find one
 		case <-waitC:
+			// the last requested peer did not deliver in time,
+			// do not request from it again
+			if f.lastRequested != nil {
+				f.addSkipPeer(*f.lastRequested)
+			}
 			doRequest = requested
 			log.Trace("search timed out: requesting", "request addr", f.addr, "doRequest", doRequest)
 
+		// peers did not deliver the chunk in time, retrieve it from the gateway
+		case <-fallbackC:
+			fallbackC = nil
+			log.Trace("fallback timed out: requesting from gateway", "request addr", f.addr)
+			go f.fetchFromGateway()
+			continue
+
 			// all Fetcher context closed, can quit
 		case <-f.ctx.Done():
 			log.Trace("terminate fetcher", "request addr", f.addr)
@@ -230,19 +385,37 @@ func (f *Fetcher) run(peers *sync.Map) {
 			return
 		}
 
+		// do not issue new requests if the retry policy limit is reached
+		if doRequest && f.retryPolicy.MaxAttempts > 0 && f.attempts >= f.retryPolicy.MaxAttempts {
+			log.Trace("fetcher max attempts reached", "request addr", f.addr, "attempts", f.attempts)
+			doRequest = false
+		}
+
 		// need to issue a new request
 		if doRequest {
+			f.attempts++
 			var err error
 			sources, err = f.doRequest(gone, peers, sources, hopCount)
 			if err != nil {
 				log.Info("unable to request", "request addr", f.addr, "err", err)
+				atomic.StoreInt32(&f.noPeers, 1)
+			} else {
+				atomic.StoreInt32(&f.noPeers, 0)
 			}
 		}
 
+		// start the fallback timer on the first request
+		if requested && fallback == nil && f.gatewayURL != "" {
+			fallback = time.NewTimer(FallbackTimeout)
+			defer fallback.Stop()
+			fallbackC = fallback.C
+		}
+
 		// if wait channel is not set, set it to a timer
 		if requested {
+			delay := f.retryDelay()
 			if wait == nil {
-				wait = time.NewTimer(f.searchTimeout)
+				wait = time.NewTimer(delay)
 				defer wait.Stop()
 				waitC = wait.C
 			} else {
@@ -253,19 +426,110 @@ func (f *Fetcher) run(peers *sync.Map) {
 					default:
 					}
 				}
-				// reset the timer to go off after defaultSearchTimeout
-				wait.Reset(f.searchTimeout)
+				// reset the timer to go off after the retry policy delay
+				wait.Reset(delay)
 			}
 		}
 		doRequest = false
 	}
 }
 
+// retryDelay returns the time to wait after the last request before
+// requesting the chunk again, as defined by the retry policy or by the
+// per attempt timeout from request options. The delay does not exceed
+// the time left until the fetcher context deadline.
+func (f *Fetcher) retryDelay() time.Duration {
+	delay := f.searchTimeout
+	if f.attemptTimeout > 0 {
+		delay = f.attemptTimeout
+	} else {
+		if m := f.retryPolicy.Multiplier; m > 1 && f.attempts > 1 {
+			delay = time.Duration(float64(delay) * math.Pow(m, float64(f.attempts-1)))
+		}
+		if j := f.retryPolicy.Jitter; j > 0 {
+			delay += time.Duration(float64(delay) * j * (2*rand.Float64() - 1))
+		}
+	}
+	if deadline, ok := f.ctx.Deadline(); ok {
+		if left := time.Until(deadline); left < delay {
+			delay = left
+		}
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return delay
+}
+
+// fetchFromGateway retrieves the chunk from the fallback gateway and puts
+// it to the store, which delivers it to all pending requests.
+func (f *Fetcher) fetchFromGateway() {
+	ch, err := f.gatewayChunk()
+	if err != nil {
+		log.Debug("fetcher gateway fallback", "request addr", f.addr, "err", err)
+		return
+	}
+	if _, err := f.store.Put(f.ctx, chunk.ModePutRequest, ch); err != nil {
+		log.Error("fetcher gateway fallback: put chunk", "request addr", f.addr, "err", err)
+	}
+}
+
+// gatewayChunk requests the chunk from the fallback gateway and
+// verifies that its content matches the address.
+func (f *Fetcher) gatewayChunk() (storage.Chunk, error) {
+	req, err := http.NewRequest(http.MethodGet, f.gatewayURL+"/bzz-raw:/"+f.addr.Hex(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(f.ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gateway response status %s", resp.Status)
+	}
+	// the gateway responds with the content joined from all chunks under the
+	// address, which is the chunk payload only if it fits into a single chunk
+	chunkSize := f.validator.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = chunk.DefaultSize
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(chunkSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > chunkSize {
+		return nil, errInvalidGatewayChunk
+	}
+	data := make([]byte, 8+len(content))
+	binary.LittleEndian.PutUint64(data, uint64(len(content)))
+	copy(data[8:], content)
+	ch := storage.NewChunk(f.addr, data)
+	if !f.validator.Validate(ch) {
+		return nil, errInvalidGatewayChunk
+	}
+	return ch, nil
+}
+
+// addSkipPeer adds the peer to the list of peers that are passed to
+// the request function to be skipped on the next request.
+func (f *Fetcher) addSkipPeer(id enode.ID) {
+	for _, p := range f.skipPeers {
+		if p == id {
+			return
+		}
+	}
+	f.skipPeers = append(f.skipPeers, id)
+}
+
 // doRequest attempts at finding a peer to request the chunk from
 // * first it tries to request explicitly from peers that are known to have offered the chunk
 // * if there are no such peers (available) it tries to request it from a peer closest to the chunk address
 //   excluding those in the peersToSkip map
 // * if no such peer is found an error is returned
+// * peers that failed to deliver or timed out are skipped, until there are
+//   no other peers to request from, when they are all requested again
 //
 // if a request is successful,
 // * the peer's address is added to the set of peers to skip
@@ -289,7 +553,7 @@ func (f *Fetcher) doRequest(gone chan *enode.ID, peersToSkip *sync.Map, sources
 		req.Source = sources[i]
 		var err error
 		log.Trace("fetcher.doRequest", "request addr", f.addr, "peer", req.Source.String())
-		sourceID, quit, err = f.protoRequestFunc(f.ctx, req)
+		sourceID, quit, err = f.protoRequestFunc(f.ctx, req, f.skipPeers...)
 		if err == nil {
 			// remove the peer from known sources
 			// Note: we can modify the source although we are looping on it, because we break from the loop immediately
@@ -297,18 +561,28 @@ func (f *Fetcher) doRequest(gone chan *enode.ID, peersToSkip *sync.Map, sources
 			foundSource = true
 			break
 		}
+		f.addSkipPeer(*req.Source)
 	}
 
 	// if there are no known sources, or none available, we try request from a closest node
 	if !foundSource {
 		req.Source = nil
 		var err error
-		sourceID, quit, err = f.protoRequestFunc(f.ctx, req)
+		sourceID, quit, err = f.protoRequestFunc(f.ctx, req, f.skipPeers...)
+		if err != nil && len(f.skipPeers) > 0 {
+			// all available peers failed once, start a new round
+			// where peers that failed before can be requested again
+			log.Trace("fetcher.doRequest: reset skip peers", "request addr", f.addr, "skipped", len(f.skipPeers))
+			f.skipPeers = nil
+			sourceID, quit, err = f.protoRequestFunc(f.ctx, req)
+		}
 		if err != nil {
 			// if no peers found to request from
 			return sources, err
 		}
 	}
+	f.lastRequested = sourceID
+	atomic.AddInt32(&f.requestedPeers, 1)
 	// add peer to the set of peers to skip from now
 	peersToSkip.Store(sourceID.String(), time.Now())
 
@@ -17,12 +17,22 @@
 package network
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/storage"
+	"github.com/ethersphere/swarm/storage/localstore"
+	"github.com/ethersphere/swarm/testutil"
 )
 
 var requestedPeerID = enode.HexID("3431c3939e1ee2a6345e976a8234f9870152d64879f30bc272a074f6859e75e8")
@@ -35,6 +45,7 @@ type mockRequester struct {
 	waitTimes []time.Duration // with waitTimes[i] you can define how much to wait on the ith request (optional)
 	count     int             //counts the number of requests
 	quitC     chan struct{}
+	skipPeers []enode.ID // skip peers of the last request, set before the request is pushed to requestC
 }
 
 func newMockRequester(waitTimes ...time.Duration) *mockRequester {
@@ -45,13 +56,14 @@ func newMockRequester(waitTimes ...time.Duration) *mockRequester {
 	}
 }
 
-func (m *mockRequester) doRequest(ctx context.Context, request *Request) (*enode.ID, chan struct{}, error) {
+func (m *mockRequester) doRequest(ctx context.Context, request *Request, skipPeers ...enode.ID) (*enode.ID, chan struct{}, error) {
 	waitTime := time.Duration(0)
 	if m.count < len(m.waitTimes) {
 		waitTime = m.waitTimes[m.count]
 		m.count++
 	}
 	time.Sleep(waitTime)
+	m.skipPeers = skipPeers
 	m.requestC <- request
 
 	// if there is a Source in the request use that, if not use the global requestedPeerId
@@ -324,13 +336,89 @@ func TestFetcherRetryOnTimeout(t *testing.T) {
 	}
 }
 
+// TestFetcherRetryOnTimeoutSkipsPeer tests that the peer which did not deliver
+// the chunk before the search timeout is passed as a peer to skip on retry.
+func TestFetcherRetryOnTimeoutSkipsPeer(t *testing.T) {
+	requester := newMockRequester()
+	addr := make([]byte, 32)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fetcher := NewFetcher(ctx, addr, requester.doRequest, true)
+	// set searchTimeOut to low value so the test is quicker
+	fetcher.searchTimeout = 100 * time.Millisecond
+
+	go fetcher.run(&sync.Map{})
+
+	fetcher.Request(0)
+
+	select {
+	case <-requester.requestC:
+		if len(requester.skipPeers) != 0 {
+			t.Fatalf("expected no peers to skip on the first request, got %v", requester.skipPeers)
+		}
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("fetch did not initiate request")
+	}
+
+	select {
+	case <-requester.requestC:
+		if len(requester.skipPeers) != 1 || requester.skipPeers[0] != requestedPeerID {
+			t.Fatalf("expected peer %v to be skipped on retry, got %v", requestedPeerID, requester.skipPeers)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("fetch did not retry request")
+	}
+}
+
+// TestFetcherRetryResetsSkipPeers tests that the peers that failed to deliver
+// the chunk are requested again once there are no other peers to request from.
+func TestFetcherRetryResetsSkipPeers(t *testing.T) {
+	skipPeersC := make(chan []enode.ID)
+	quitC := make(chan struct{})
+	// the only available peer is requestedPeerID, so requests that skip it fail
+	request := func(ctx context.Context, req *Request, skipPeers ...enode.ID) (*enode.ID, chan struct{}, error) {
+		for _, id := range skipPeers {
+			if id == requestedPeerID {
+				return nil, nil, errors.New("no peer")
+			}
+		}
+		skipPeersC <- skipPeers
+		return &requestedPeerID, quitC, nil
+	}
+	addr := make([]byte, 32)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fetcher := NewFetcher(ctx, addr, request, true)
+	// set searchTimeOut to low value so the test is quicker
+	fetcher.searchTimeout = 100 * time.Millisecond
+
+	go fetcher.run(&sync.Map{})
+
+	fetcher.Request(0)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case skipPeers := <-skipPeersC:
+			if len(skipPeers) != 0 {
+				t.Fatalf("request %d: expected no peers to skip, got %v", i, skipPeers)
+			}
+		case <-time.After(500 * time.Millisecond):
+			t.Fatalf("request %d was not initiated", i)
+		}
+	}
+}
+
 // TestFetcherFactory creates a FetcherFactory and checks if the factory really creates and starts
 // a Fetcher when it return a fetch function. We test the fetching functionality just by checking if
 // a request is initiated when the fetch function is called
 func TestFetcherFactory(t *testing.T) {
 	requester := newMockRequester(100 * time.Millisecond)
 	addr := make([]byte, 32)
-	fetcherFactory := NewFetcherFactory(requester.doRequest, false)
+	fetcherFactory := NewFetcherFactory(requester.doRequest, false, nil)
 
 	peersToSkip := &sync.Map{}
 
@@ -347,6 +435,143 @@ func TestFetcherFactory(t *testing.T) {
 
 }
 
+// TestFetcherGatewayFallback validates that a chunk which no peer
+// delivers is retrieved from the fallback gateway, and that a chunk
+// with content not matching its address is not accepted from it.
+func TestFetcherGatewayFallback(t *testing.T) {
+	defer func(timeout time.Duration) { FallbackTimeout = timeout }(FallbackTimeout)
+	FallbackTimeout = 100 * time.Millisecond
+
+	chunks := testutil.DeterministicChunks(1, 2)
+	ch := chunks[0]
+	// the gateway responds with the content of the first chunk
+	// for both chunk addresses
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bzz-raw:/" + chunks[0].Address().Hex(), "/bzz-raw:/" + chunks[1].Address().Hex():
+			w.Write(ch.Data()[8:])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "swarm-network-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localStore, err := localstore.New(dir, make([]byte, 32), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localStore.Close()
+
+	// no peer is able to deliver the chunk
+	request := func(ctx context.Context, req *Request, skipPeers ...enode.ID) (*enode.ID, chan struct{}, error) {
+		return nil, nil, errors.New("no peer")
+	}
+	netStore, err := storage.NewNetStore(localStore, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	netStore.NewNetFetcherFunc = NewFetcherFactoryWithFallback(request, false, server.URL, netStore, storage.NewFileStoreParams()).New
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := netStore.Get(ctx, chunk.ModeGetRequest, ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Data(), ch.Data()) {
+		t.Fatal("got chunk data not equal to the served data")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, err = netStore.Get(ctx, chunk.ModeGetRequest, chunks[1].Address())
+	if err != storage.ErrNoPeers {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNoPeers)
+	}
+	has, err := localStore.Has(context.Background(), chunks[1].Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has {
+		t.Fatal("invalid chunk from gateway is stored")
+	}
+}
+
+// TestFetcherRetryPolicy injects transient request failures and checks
+// that the number of requests and the time between them are within
+// the retry policy of the FetcherFactory.
+func TestFetcherRetryPolicy(t *testing.T) {
+	policy := &FetcherRetryPolicy{
+		MaxAttempts: 4,
+		Backoff:     50 * time.Millisecond,
+		Multiplier:  2,
+		Jitter:      0.1,
+	}
+
+	requestTimes := make(chan time.Time, 10)
+	request := func(ctx context.Context, req *Request, skipPeers ...enode.ID) (*enode.ID, chan struct{}, error) {
+		requestTimes <- time.Now()
+		return nil, nil, errors.New("no peer available")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fetcher := NewFetcherFactory(request, true, policy).New(ctx, make([]byte, 32), &sync.Map{})
+	fetcher.Request(0)
+
+	// allow enough time for more requests than the policy allows
+	time.Sleep(time.Second)
+
+	if got := len(requestTimes); got != policy.MaxAttempts {
+		t.Fatalf("got %v requests, want %v", got, policy.MaxAttempts)
+	}
+
+	last := <-requestTimes
+	backoff := policy.Backoff
+	for i := 1; i < policy.MaxAttempts; i++ {
+		tm := <-requestTimes
+		min := time.Duration(float64(backoff) * (1 - policy.Jitter))
+		max := time.Duration(float64(backoff)*(1+policy.Jitter)) + 50*time.Millisecond
+		if d := tm.Sub(last); d < min || d > max {
+			t.Errorf("request %v: got backoff %v, want between %v and %v", i+1, d, min, max)
+		}
+		last = tm
+		backoff *= 2
+	}
+
+	t.Run("context deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		fetcher := NewFetcher(ctx, make([]byte, 32), request, true)
+		fetcher.searchTimeout = 10 * time.Second
+
+		if d := fetcher.retryDelay(); d > 200*time.Millisecond {
+			t.Errorf("got retry delay %v, want not more than the context deadline", d)
+		}
+	})
+}
+
+// TestFetcherFactoryZeroBackoff checks that a retry policy without
+// backoff gets the default one, so that fetchers do not request
+// the chunk again without waiting.
+func TestFetcherFactoryZeroBackoff(t *testing.T) {
+	requester := newMockRequester()
+	f := NewFetcherFactory(requester.doRequest, true, &FetcherRetryPolicy{MaxAttempts: 3})
+	if f.retryPolicy.Backoff != defaultSearchTimeout {
+		t.Fatalf("got backoff %v, want %v", f.retryPolicy.Backoff, defaultSearchTimeout)
+	}
+	if f.retryPolicy.MaxAttempts != 3 {
+		t.Fatalf("got max attempts %v, want 3", f.retryPolicy.MaxAttempts)
+	}
+}
+
 func TestFetcherRequestQuitRetriesRequest(t *testing.T) {
 	requester := newMockRequester()
 	addr := make([]byte, 32)
@@ -474,3 +699,92 @@ func TestFetcherMaxHopCount(t *testing.T) {
 	case <-time.After(200 * time.Millisecond):
 	}
 }
+
+// TestFetcherPerAttemptTimeout requests a chunk first from a slow peer
+// that does not deliver it and then from a fast peer, and validates that
+// with the per attempt timeout from request options the chunk is retrieved
+// from the fast peer well before the retry policy delay and the context
+// deadline.
+func TestFetcherPerAttemptTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-network-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localStore, err := localstore.New(dir, make([]byte, 32), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localStore.Close()
+
+	ch := testutil.DeterministicChunks(1, 1)[0]
+
+	var (
+		netStore *storage.NetStore
+		mu       sync.Mutex
+		// peers in the order the chunk is requested from them
+		requested []enode.ID
+	)
+	slowPeer, fastPeer := requestedPeerID, sourcePeerID
+	request := func(ctx context.Context, req *Request, skipPeers ...enode.ID) (*enode.ID, chan struct{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		peer := slowPeer
+		for _, p := range skipPeers {
+			if p == slowPeer {
+				peer = fastPeer
+			}
+		}
+		requested = append(requested, peer)
+		if peer == fastPeer {
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				if _, err := netStore.Put(context.Background(), chunk.ModePutRequest, ch); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		return &peer, make(chan struct{}), nil
+	}
+	// without the per attempt timeout the fast peer
+	// would be requested only after the backoff
+	fetcherFactory := NewFetcherFactory(request, false, &FetcherRetryPolicy{
+		Backoff:    10 * time.Second,
+		Multiplier: 1,
+	})
+	netStore, err = storage.NewNetStore(localStore, fetcherFactory.New, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	ctx = WithRequestOpts(ctx, RequestOpts{
+		PerAttemptTimeout: 200 * time.Millisecond,
+	})
+
+	start := time.Now()
+	got, err := netStore.Get(ctx, chunk.ModeGetRequest, ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Data(), ch.Data()) {
+		t.Fatal("got chunk data not equal to the delivered data")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("chunk retrieved after %v, want failover to the fast peer before 2s", d)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []enode.ID{slowPeer, fastPeer}
+	if len(requested) != len(want) {
+		t.Fatalf("got requested peers %v, want %v", requested, want)
+	}
+	for i := range want {
+		if requested[i] != want[i] {
+			t.Fatalf("got requested peers %v, want %v", requested, want)
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"fmt"
 	"math/rand"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -65,6 +66,9 @@ type KadParams struct {
 	MaxRetries        int   // maximum number of redial attempts
 	// function to sanction or prevent suggesting a peer
 	Reachable func(*BzzAddr) bool `json:"-"`
+	// delivery scores to order connected peers with the same
+	// proximity order by, if nil peers are not reordered
+	PeerScore *PeerScore `json:"-"`
 }
 
 // NewKadParams returns a params struct with default values
@@ -83,14 +87,15 @@ func NewKadParams() *KadParams {
 // Kademlia is a table of live peers and a db of known peers (node records)
 type Kademlia struct {
 	lock       sync.RWMutex
-	*KadParams                 // Kademlia configuration parameters
-	base       []byte          // immutable baseaddress of the table
-	addrs      *pot.Pot        // pots container for known peer addresses
-	conns      *pot.Pot        // pots container for live peer connections
-	depth      uint8           // stores the last current depth of saturation
-	nDepth     int             // stores the last neighbourhood depth
-	nDepthMu   sync.RWMutex    // protects neighbourhood depth nDepth
-	nDepthSig  []chan struct{} // signals when neighbourhood depth nDepth is changed
+	*KadParams                      // Kademlia configuration parameters
+	base       []byte               // immutable baseaddress of the table
+	addrs      *pot.Pot             // pots container for known peer addresses
+	conns      *pot.Pot             // pots container for live peer connections
+	depth      uint8                // stores the last current depth of saturation
+	nDepth     int                  // stores the last neighbourhood depth
+	nDepthMu   sync.RWMutex         // protects neighbourhood depth nDepth
+	nDepthSig  []chan struct{}      // signals when neighbourhood depth nDepth is changed
+	topoSubs   []chan TopologyEvent // topology change event subscriptions
 }
 
 // NewKademlia creates a Kademlia table for base address addr
@@ -328,6 +333,9 @@ func (k *Kademlia) On(p *Peer) (uint8, bool) {
 		k.depth = depth
 	}
 	k.setNeighbourhoodDepth()
+	if ins {
+		k.sendTopologyEvent(p, true)
+	}
 	return k.depth, changed
 }
 
@@ -419,6 +427,92 @@ func (k *Kademlia) Off(p *Peer) {
 			return nil
 		})
 		k.setNeighbourhoodDepth()
+		k.sendTopologyEvent(p, false)
+	}
+}
+
+// topologyEventsBufferSize is the number of topology events
+// buffered for every subscription before the oldest are dropped.
+var topologyEventsBufferSize = 100
+
+// TopologyEvent describes a peer that is connected to or
+// disconnected from a Kademlia bin.
+type TopologyEvent struct {
+	Addr      []byte // overlay address of the peer
+	Connected bool   // true if the peer is added to the bin, false if removed
+	Bin       int    // proximity order of the peer bin
+	BinSize   int    // number of connected peers in the bin after the change
+	Depth     int    // neighbourhood depth after the change
+}
+
+// SubscribeTopology returns the channel that receives events when
+// connected peers are added to or removed from Kademlia bins. Events are
+// buffered and, if the receiver is not fast enough, the oldest events are
+// dropped and counted by the kad.topology.dropped metrics counter.
+// Returned function unsubscribes the channel and releases the resources.
+// Returned function is safe to be called multiple times.
+func (k *Kademlia) SubscribeTopology() (c <-chan TopologyEvent, unsubscribe func()) {
+	channel := make(chan TopologyEvent, topologyEventsBufferSize)
+	var closeOnce sync.Once
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	k.topoSubs = append(k.topoSubs, channel)
+
+	unsubscribe = func() {
+		k.lock.Lock()
+		defer k.lock.Unlock()
+
+		for i, c := range k.topoSubs {
+			if c == channel {
+				k.topoSubs = append(k.topoSubs[:i], k.topoSubs[i+1:]...)
+				break
+			}
+		}
+
+		closeOnce.Do(func() { close(channel) })
+	}
+
+	return channel, unsubscribe
+}
+
+// sendTopologyEvent sends the topology event for the peer to all
+// subscriptions without blocking, dropping the oldest buffered events
+// if needed. Caller must hold the lock.
+func (k *Kademlia) sendTopologyEvent(p *Peer, connected bool) {
+	if len(k.topoSubs) == 0 {
+		return
+	}
+	bin, _ := Pof(p, k.base, 0)
+	var binSize int
+	k.conns.EachBin(k.base, Pof, bin, func(po, size int, _ func(func(val pot.Val) bool) bool) bool {
+		if po == bin {
+			binSize = size
+		}
+		return po < bin
+	})
+	e := TopologyEvent{
+		Addr:      p.Address(),
+		Connected: connected,
+		Bin:       bin,
+		BinSize:   binSize,
+		Depth:     k.NeighbourhoodDepth(),
+	}
+	for _, c := range k.topoSubs {
+		for sent := false; !sent; {
+			select {
+			case c <- e:
+				sent = true
+			default:
+				// drop the oldest event to make space for the new one
+				select {
+				case <-c:
+					metrics.GetOrRegisterCounter("kad.topology.dropped", nil).Inc(1)
+				default:
+				}
+			}
+		}
 	}
 }
 
@@ -447,6 +541,10 @@ func (k *Kademlia) eachConn(base []byte, o int, f func(*Peer, int) bool) {
 	if len(base) == 0 {
 		base = k.base
 	}
+	if k.PeerScore != nil {
+		k.eachConnByScore(base, o, f)
+		return
+	}
 	k.conns.EachNeighbour(base, Pof, func(val pot.Val, po int) bool {
 		if po > o {
 			return true
@@ -455,6 +553,68 @@ func (k *Kademlia) eachConn(base []byte, o int, f func(*Peer, int) bool) {
 	})
 }
 
+// eachConnByScore is the same as eachConn, but peers with the same
+// proximity order are ordered by their PeerScore, higher first.
+func (k *Kademlia) eachConnByScore(base []byte, o int, f func(*Peer, int) bool) {
+	var peers []*Peer // peers with the same proximity order
+	groupPO := -1
+	// flush calls f on collected peers ordered by score
+	// and returns false if iteration should stop
+	flush := func() bool {
+		sort.SliceStable(peers, func(i, j int) bool {
+			return k.PeerScore.Score(peers[i].ID()) > k.PeerScore.Score(peers[j].ID())
+		})
+		for _, p := range peers {
+			if !f(p, groupPO) {
+				return false
+			}
+		}
+		peers = peers[:0]
+		return true
+	}
+	next := true
+	k.conns.EachNeighbour(base, Pof, func(val pot.Val, po int) bool {
+		if po > o {
+			return true
+		}
+		if po != groupPO {
+			if next = flush(); !next {
+				return false
+			}
+			groupPO = po
+		}
+		peers = append(peers, val.(*Peer))
+		return true
+	})
+	if next {
+		flush()
+	}
+}
+
+// ClosestConnected returns up to n connected peers that are closest to
+// the address, ordered by XOR distance, closest first. All connected
+// peers are returned if there are fewer than n.
+func (k *Kademlia) ClosestConnected(addr []byte, n int) []*Peer {
+	if n <= 0 {
+		return nil
+	}
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	var peers []*Peer
+	k.conns.Each(func(val pot.Val) bool {
+		peers = append(peers, val.(*Peer))
+		return true
+	})
+	sort.Slice(peers, func(i, j int) bool {
+		return pot.ProxCmp(addr, peers[i].Address(), peers[j].Address()) < 0
+	})
+	if len(peers) > n {
+		peers = peers[:n]
+	}
+	return peers
+}
+
 // EachAddr called with (base, po, f) is an iterator applying f to each known peer
 // that has proximity order o or less as measured from the base
 // if base is nil, kademlia base address is used
@@ -576,6 +736,14 @@ func (k *Kademlia) BaseAddr() []byte {
 	return k.base
 }
 
+// ProximityOrder returns the proximity order of the address relative
+// to the kademlia base address. The address must be of the same length
+// as the base address.
+func (k *Kademlia) ProximityOrder(addr []byte) int {
+	po, _ := Pof(k.base, addr, 0)
+	return po
+}
+
 // String returns kademlia table + kaddb table displayed with ascii
 func (k *Kademlia) String() string {
 	k.lock.RLock()
@@ -745,6 +913,44 @@ func (k *Kademlia) saturation() int {
 	return prev
 }
 
+// KademliaSaturation describes the neighbourhood depth and
+// the connected peers in kademlia bins.
+type KademliaSaturation struct {
+	// Depth is the neighbourhood depth.
+	Depth int
+	// SaturationDepth is the smallest po of a bin with less than
+	// MinBinSize connected peers, as returned by Saturation.
+	SaturationDepth int
+	// Bins holds the number of connected peers for every po
+	// up to the deepest bin with a connected peer.
+	Bins []int
+	// Saturated holds for every po in Bins whether the bin
+	// has at least MinBinSize connected peers.
+	Saturated []bool
+}
+
+// SaturationInfo returns the neighbourhood depth and the number
+// of connected peers in bins, and whether the bins are saturated.
+func (k *Kademlia) SaturationInfo() KademliaSaturation {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	info := KademliaSaturation{
+		Depth:           depthForPot(k.conns, k.NeighbourhoodSize, k.base),
+		SaturationDepth: k.saturation(),
+	}
+	k.conns.EachBin(k.base, Pof, 0, func(po, size int, f func(func(val pot.Val) bool) bool) bool {
+		for len(info.Bins) <= po {
+			info.Bins = append(info.Bins, 0)
+			info.Saturated = append(info.Saturated, false)
+		}
+		info.Bins[po] = size
+		info.Saturated[po] = size >= k.MinBinSize
+		return true
+	})
+	return info
+}
+
 // isSaturated returns true if the kademlia is considered saturated, or false if not.
 // It checks this by checking an array of ints called unsaturatedBins; each item in that array corresponds
 // to the bin which is unsaturated (number of connections < k.MinBinSize).
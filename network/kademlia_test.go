@@ -17,6 +17,7 @@
 package network
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"testing"
@@ -24,6 +25,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/p2p/protocols"
@@ -670,3 +672,224 @@ func TestKademlia_SubscribeToNeighbourhoodDepthChange(t *testing.T) {
 		}
 	})
 }
+
+// TestEachConnPeerScore validates that EachConn orders connected
+// peers with the same proximity order by their delivery scores.
+func TestEachConnPeerScore(t *testing.T) {
+	params := NewKadParams()
+	params.PeerScore = NewPeerScore()
+	baseAddressBytes := RandomAddr().OAddr
+	kad := NewKademlia(baseAddressBytes, params)
+	baseAddress := pot.NewAddressFromBytes(baseAddressBytes)
+
+	newPeer := func(id enode.ID, po int) *Peer {
+		addr := pot.RandomAddressAt(baseAddress, po)
+		p := p2p.NewPeer(id, "foo", []p2p.Cap{})
+		return NewPeer(&BzzPeer{
+			Peer: protocols.NewPeer(p, &p2p.MsgPipeRW{}, &protocols.Spec{}),
+			BzzAddr: &BzzAddr{
+				OAddr: addr.Bytes(),
+				UAddr: []byte(fmt.Sprintf("%x", addr[:])),
+			},
+		}, kad)
+	}
+
+	// three equally proximate peers and one closer peer
+	failing := newPeer(enode.ID{1}, 3)
+	unknown := newPeer(enode.ID{2}, 3)
+	successful := newPeer(enode.ID{3}, 3)
+	closer := newPeer(enode.ID{4}, 5)
+	for _, p := range []*Peer{failing, unknown, successful, closer} {
+		kad.On(p)
+	}
+
+	for i := 0; i < 3; i++ {
+		params.PeerScore.Failure(failing.ID())
+	}
+	params.PeerScore.Success(successful.ID())
+	// a single success does not outweigh multiple failures
+	params.PeerScore.Success(failing.ID())
+
+	var got []enode.ID
+	kad.EachConn(nil, 255, func(p *Peer, po int) bool {
+		got = append(got, p.ID())
+		return true
+	})
+	want := []enode.ID{closer.ID(), successful.ID(), unknown.ID(), failing.ID()}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got peers %v, want %v", got, want)
+	}
+
+	// iteration stops when the function returns false
+	var count int
+	kad.EachConn(nil, 255, func(p *Peer, po int) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Errorf("got %v iterations, want 2", count)
+	}
+
+	scores := params.PeerScore.Scores()
+	if s := scores[failing.ID()]; s.Successes != 1 || s.Failures != 3 || s.Value() != -2 {
+		t.Errorf("got failing peer score %+v, want 1 success and 3 failures", s)
+	}
+}
+
+// TestClosestConnected validates that ClosestConnected returns
+// connected peers ordered by distance to the address, limited in count.
+func TestClosestConnected(t *testing.T) {
+	tk := newTestKademlia(t, "00000000")
+	tk.On("10000000", "01000000", "01110000", "00100000", "01101000")
+	tk.Register("01100001")
+
+	addr := pot.NewAddressFromString("01100000")
+	for _, tc := range []struct {
+		n    int
+		want []string
+	}{
+		{
+			n:    3,
+			want: []string{"01101000", "01110000", "01000000"},
+		},
+		{
+			n:    10,
+			want: []string{"01101000", "01110000", "01000000", "00100000", "10000000"},
+		},
+		{
+			n:    0,
+			want: nil,
+		},
+	} {
+		var got []string
+		for _, p := range tk.ClosestConnected(addr, tc.n) {
+			got = append(got, binStr(p.BzzAddr))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("n %v: got peers %v, want %v", tc.n, got, tc.want)
+		}
+	}
+}
+
+// TestSaturationInfo validates neighbourhood depth, saturation depth
+// and bin counts returned by SaturationInfo for a known set of peers.
+func TestSaturationInfo(t *testing.T) {
+	tk := newTestKademlia(t, "00000000")
+
+	info := tk.SaturationInfo()
+	if info.Depth != 0 || info.SaturationDepth != 0 || len(info.Bins) != 0 {
+		t.Errorf("got saturation info %+v for empty kademlia", info)
+	}
+
+	tk.On("10000000", "11000000", "01000000", "00100000", "00110000", "00010000", "00011000")
+	tk.Register("01100000")
+
+	info = tk.SaturationInfo()
+	if info.Depth != 3 {
+		t.Errorf("got depth %v, want %v", info.Depth, 3)
+	}
+	if info.SaturationDepth != 1 {
+		t.Errorf("got saturation depth %v, want %v", info.SaturationDepth, 1)
+	}
+	wantBins := []int{2, 1, 2, 2}
+	if fmt.Sprint(info.Bins) != fmt.Sprint(wantBins) {
+		t.Errorf("got bins %v, want %v", info.Bins, wantBins)
+	}
+	wantSaturated := []bool{true, false, true, true}
+	if fmt.Sprint(info.Saturated) != fmt.Sprint(wantSaturated) {
+		t.Errorf("got saturated %v, want %v", info.Saturated, wantSaturated)
+	}
+}
+
+// TestProximityOrder validates proximity orders of known
+// addresses relative to the kademlia base address.
+func TestProximityOrder(t *testing.T) {
+	tk := newTestKademlia(t, "00000000")
+
+	for _, tc := range []struct {
+		addr string
+		po   int
+	}{
+		{addr: "10000000", po: 0},
+		{addr: "01000000", po: 1},
+		{addr: "00010000", po: 3},
+		{addr: "00000001", po: 7},
+		{addr: "000000000100", po: 9},
+		{addr: "00000000", po: 256},
+	} {
+		if got := tk.ProximityOrder(pot.NewAddressFromString(tc.addr)); got != tc.po {
+			t.Errorf("got proximity order %v for address %s, want %v", got, tc.addr, tc.po)
+		}
+	}
+}
+
+// TestKademlia_SubscribeTopology validates that topology events are
+// sent when peers are connected and disconnected.
+func TestKademlia_SubscribeTopology(t *testing.T) {
+	k := newTestKademlia(t, "00000000")
+
+	c, u := k.SubscribeTopology()
+	defer u()
+
+	k.On("10000000", "11000000", "01000000", "00000010")
+	k.Off("11000000")
+
+	for i, want := range []TopologyEvent{
+		{Addr: testKadPeerAddr("10000000").Address(), Connected: true, Bin: 0, BinSize: 1, Depth: 0},
+		{Addr: testKadPeerAddr("11000000").Address(), Connected: true, Bin: 0, BinSize: 2, Depth: 0},
+		{Addr: testKadPeerAddr("01000000").Address(), Connected: true, Bin: 1, BinSize: 1, Depth: 0},
+		{Addr: testKadPeerAddr("00000010").Address(), Connected: true, Bin: 6, BinSize: 1, Depth: 1},
+		{Addr: testKadPeerAddr("11000000").Address(), Connected: false, Bin: 0, BinSize: 1, Depth: 1},
+	} {
+		select {
+		case got, ok := <-c:
+			if !ok {
+				t.Fatal("closed topology channel")
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("event %v: got %+v, want %+v", i, got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("event %v: timeout", i)
+		}
+	}
+
+	u()
+	if _, ok := <-c; ok {
+		t.Error("topology channel not closed")
+	}
+}
+
+// TestKademlia_SubscribeTopologyDropped validates that the oldest
+// topology events are dropped if the subscription buffer is full.
+func TestKademlia_SubscribeTopologyDropped(t *testing.T) {
+	defer func(enabled bool) { metrics.Enabled = enabled }(metrics.Enabled)
+	metrics.Enabled = true
+	metrics.DefaultRegistry.Unregister("kad.topology.dropped")
+
+	defer func(s int) { topologyEventsBufferSize = s }(topologyEventsBufferSize)
+	topologyEventsBufferSize = 2
+
+	k := newTestKademlia(t, "00000000")
+
+	c, u := k.SubscribeTopology()
+	defer u()
+
+	k.On("10000000", "01000000", "00100000")
+
+	for _, want := range []string{"01000000", "00100000"} {
+		got := <-c
+		if !bytes.Equal(got.Addr, testKadPeerAddr(want).Address()) {
+			t.Errorf("got event for peer %x, want %s", got.Addr, want)
+		}
+	}
+	select {
+	case e := <-c:
+		t.Errorf("got unexpected event %+v", e)
+	default:
+	}
+
+	if dropped := metrics.GetOrRegisterCounter("kad.topology.dropped", nil).Count(); dropped != 1 {
+		t.Errorf("got %v dropped events, want 1", dropped)
+	}
+}
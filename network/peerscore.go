@@ -0,0 +1,93 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// Score holds the number of successful and failed
+// chunk deliveries of a single peer.
+type Score struct {
+	Successes uint64
+	Failures  uint64
+}
+
+// Value returns the score as the difference between
+// successful and failed deliveries.
+func (s Score) Value() int64 {
+	return int64(s.Successes) - int64(s.Failures)
+}
+
+// PeerScore keeps delivery scores for peers. It is safe for
+// concurrent use. If it is set in KadParams, Kademlia orders
+// connected peers with the same proximity order by their scores.
+type PeerScore struct {
+	scores map[enode.ID]Score
+	mu     sync.RWMutex
+}
+
+// NewPeerScore creates a new PeerScore with no scores.
+func NewPeerScore() *PeerScore {
+	return &PeerScore{
+		scores: make(map[enode.ID]Score),
+	}
+}
+
+// Success records a successful delivery from the peer.
+func (s *PeerScore) Success(id enode.ID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	score := s.scores[id]
+	score.Successes++
+	s.scores[id] = score
+}
+
+// Failure records a failed delivery from the peer.
+func (s *PeerScore) Failure(id enode.ID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	score := s.scores[id]
+	score.Failures++
+	s.scores[id] = score
+}
+
+// Score returns the score value of the peer.
+// Peers without recorded deliveries have score 0.
+func (s *PeerScore) Score(id enode.ID) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.scores[id].Value()
+}
+
+// Scores returns a copy of scores of all peers with recorded
+// deliveries. It is intended for diagnostics.
+func (s *PeerScore) Scores() map[enode.ID]Score {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	scores := make(map[enode.ID]Score, len(s.scores))
+	for id, score := range s.scores {
+		scores[id] = score
+	}
+	return scores
+}
@@ -92,6 +92,27 @@ READ:
 	}
 }
 
+// Drain removes all items currently in the queues without blocking
+// and applies the function to them. It is meant to release the items
+// that are left in the queues after Run returns.
+func (pq *PriorityQueue) Drain(f func(interface{})) {
+	for _, q := range pq.Queues {
+	DRAIN:
+		for {
+			select {
+			case x := <-q:
+				val := x.(struct {
+					v interface{}
+					t time.Time
+				})
+				f(val.v)
+			default:
+				break DRAIN
+			}
+		}
+	}
+}
+
 // Push pushes an item to the appropriate queue specified in the priority argument
 // if context is given it waits until either the item is pushed or the Context aborts
 func (pq *PriorityQueue) Push(x interface{}, p int) error {
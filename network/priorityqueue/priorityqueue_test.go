@@ -95,3 +95,26 @@ Loop:
 		}
 	}
 }
+
+// TestDrain validates that Drain applies the function to all items
+// left in the queues and that it returns when the queues are empty.
+func TestDrain(t *testing.T) {
+	pq := New(3, 2)
+	for i, p := range []int{0, 2, 1, 2} {
+		if err := pq.Push(i, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var count int
+	pq.Drain(func(v interface{}) {
+		count++
+	})
+	if count != 4 {
+		t.Fatalf("got %d drained items, want 4", count)
+	}
+	for i, q := range pq.Queues {
+		if n := len(q); n != 0 {
+			t.Errorf("queue %d: got %d items, want 0", i, n)
+		}
+	}
+}
@@ -19,9 +19,11 @@ package simulation
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/ethersphere/swarm/chunk"
 )
 
 // PeerEvent is the type of the channel returned by Simulation.PeerEvents.
@@ -215,3 +217,65 @@ func (s *Simulation) PeerEvents(ctx context.Context, ids []enode.ID, filters ...
 	subsWG.Wait()
 	return eventC
 }
+
+// DeliveryEvent is the type of the channel returned by Simulation.DeliveryEvents.
+type DeliveryEvent struct {
+	// Source is the ID of the node that delivered the chunk.
+	Source enode.ID
+	// Destination is the ID of the node that received the chunk.
+	Destination enode.ID
+	// Addr is the address of the delivered chunk.
+	Addr chunk.Address
+	// Time is when the delivery message is received.
+	Time time.Time
+}
+
+// DeliveryNotifier is implemented by node services that report chunk
+// delivery messages received from their peers, such as stream.Registry.
+// Message events of the simulated network do not carry message payloads,
+// so chunk addresses must be provided by the service.
+type DeliveryNotifier interface {
+	SubscribeDeliveries(f func(peer enode.ID, addr chunk.Address)) (unsubscribe func())
+}
+
+// DeliveryEvents returns a channel of events for every chunk delivery
+// message received by a service implementing DeliveryNotifier on nodes
+// that are up when this function is called. Subscriptions are terminated
+// when the context is done or the simulation is closed.
+func (s *Simulation) DeliveryEvents(ctx context.Context) <-chan DeliveryEvent {
+	eventC := make(chan DeliveryEvent)
+
+	for _, id := range s.UpNodeIDs() {
+		for _, name := range s.serviceNames {
+			n, ok := s.Service(name, id).(DeliveryNotifier)
+			if !ok {
+				continue
+			}
+			id := id
+			unsubscribe := n.SubscribeDeliveries(func(peer enode.ID, addr chunk.Address) {
+				e := DeliveryEvent{
+					Source:      peer,
+					Destination: id,
+					Addr:        addr,
+					Time:        time.Now(),
+				}
+				select {
+				case eventC <- e:
+				case <-ctx.Done():
+				case <-s.Done():
+				}
+			})
+			s.shutdownWG.Add(1)
+			go func() {
+				defer s.shutdownWG.Done()
+				defer unsubscribe()
+
+				select {
+				case <-ctx.Done():
+				case <-s.Done():
+				}
+			}()
+		}
+	}
+	return eventC
+}
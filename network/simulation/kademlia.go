@@ -37,6 +37,19 @@ var BucketKeyKademlia BucketKey = "kademlia"
 // If error is not nil, a map of kademlia that was found not healthy is returned.
 // TODO: Check correctness since change in kademlia depth calculation logic
 func (s *Simulation) WaitTillHealthy(ctx context.Context) (ill map[enode.ID]*network.Kademlia, err error) {
+	return s.waitTillHealthy(ctx, nil)
+}
+
+// WaitTillHealthyWithCallback is blocking until the health of all kademlias is true,
+// the same as WaitTillHealthy, but it also calls function f once for every node
+// when its kademlia is found healthy for the first time.
+func (s *Simulation) WaitTillHealthyWithCallback(ctx context.Context, f func(nodeID enode.ID)) (ill map[enode.ID]*network.Kademlia, err error) {
+	return s.waitTillHealthy(ctx, f)
+}
+
+// waitTillHealthy implements WaitTillHealthy and WaitTillHealthyWithCallback.
+// Function f is optional and it is called only once for every node.
+func (s *Simulation) waitTillHealthy(ctx context.Context, f func(nodeID enode.ID)) (ill map[enode.ID]*network.Kademlia, err error) {
 	// Prepare PeerPot map for checking Kademlia health
 	var ppmap map[string]*network.PeerPot
 	kademlias := s.kademlias()
@@ -52,6 +65,8 @@ func (s *Simulation) WaitTillHealthy(ctx context.Context) (ill map[enode.ID]*net
 	defer ticker.Stop()
 
 	ill = make(map[enode.ID]*network.Kademlia)
+	// nodes for which function f is already called
+	notified := make(map[enode.ID]struct{})
 	for {
 		select {
 		case <-ctx.Done():
@@ -74,6 +89,14 @@ func (s *Simulation) WaitTillHealthy(ctx context.Context) (ill map[enode.ID]*net
 				log.Debug("kademlia", "ill condition", !h.ConnectNN, "addr", hex.EncodeToString(k.BaseAddr()), "node", id)
 				if !h.Healthy() {
 					ill[id] = k
+					continue
+				}
+				if f == nil {
+					continue
+				}
+				if _, ok := notified[id]; !ok {
+					notified[id] = struct{}{}
+					f(id)
 				}
 			}
 			if len(ill) == 0 {
@@ -25,6 +25,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
 	"github.com/ethersphere/swarm/network"
 )
@@ -46,7 +47,7 @@ func TestWaitTillHealthy(t *testing.T) {
 	testNodesNum := 10
 
 	// create the first simulation
-	sim := New(createSimServiceMap(true))
+	sim := New(createSimServiceMap(true), nil)
 
 	// connect and...
 	nodeIDs, err := sim.AddNodesAndConnectRing(testNodesNum)
@@ -88,7 +89,7 @@ func TestWaitTillHealthy(t *testing.T) {
 	// close the initial simulation
 	sim.Close()
 	// create a control simulation
-	controlSim := New(createSimServiceMap(false))
+	controlSim := New(createSimServiceMap(false), nil)
 	defer controlSim.Close()
 
 	// load the snapshot into this control simulation
@@ -122,6 +123,50 @@ func TestWaitTillHealthy(t *testing.T) {
 	}
 }
 
+// TestWaitTillHealthyWithCallback tests that the callback function passed to
+// WaitTillHealthyWithCallback is called exactly once for every node
+// and that all nodes are healthy when it returns.
+func TestWaitTillHealthyWithCallback(t *testing.T) {
+	testNodesNum := 10
+
+	sim := New(createSimServiceMap(true), nil)
+	defer sim.Close()
+
+	nodeIDs, err := sim.AddNodesAndConnectRing(testNodesNum)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	calls := make(map[enode.ID]int)
+	ill, err := sim.WaitTillHealthyWithCallback(ctx, func(nodeID enode.ID) {
+		mu.Lock()
+		calls[nodeID]++
+		mu.Unlock()
+	})
+	if err != nil {
+		for id, kad := range ill {
+			t.Log("Node", id)
+			t.Log(kad.String())
+		}
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != testNodesNum {
+		t.Fatalf("got callback for %v nodes, want %v", len(calls), testNodesNum)
+	}
+	for _, id := range nodeIDs {
+		if c := calls[id]; c != 1 {
+			t.Errorf("node %s: got %v callback calls, want 1", id, c)
+		}
+	}
+}
+
 // createSimServiceMap returns the services map
 // this function will create the sim services with or without discovery enabled
 // based on the flag passed
@@ -158,7 +203,7 @@ func createSimServiceMap(discovery bool) map[string]ServiceFunc {
 func TestWaitTillSnapshotRecreated(t *testing.T) {
 	t.Skip("test is flaky. disabling until underlying problem is addressed")
 	var err error
-	sim := New(createSimServiceMap(true))
+	sim := New(createSimServiceMap(true), nil)
 	_, err = sim.AddNodesAndConnectRing(16)
 	if err != nil {
 		t.Fatal(err)
@@ -177,7 +222,7 @@ func TestWaitTillSnapshotRecreated(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	controlSim := New(createSimServiceMap(false))
+	controlSim := New(createSimServiceMap(false), nil)
 	defer controlSim.Close()
 	err = controlSim.Net.Load(snap)
 	if err != nil {
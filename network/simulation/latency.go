@@ -0,0 +1,155 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulation
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// latencyService wraps a node.Service to delay all protocol messages
+// that the node sends to its peers by the duration returned by the
+// latency function.
+type latencyService struct {
+	node.Service
+	id      enode.ID
+	latency func(from, to enode.ID) time.Duration
+}
+
+// Protocols returns protocols of the wrapped service with message
+// writers that apply the latency.
+func (s *latencyService) Protocols() []p2p.Protocol {
+	protocols := s.Service.Protocols()
+	wrapped := make([]p2p.Protocol, len(protocols))
+	for i, p := range protocols {
+		run := p.Run
+		p.Run = func(peer *p2p.Peer, rw p2p.MsgReadWriter) error {
+			to := peer.ID()
+			lrw := newLatencyMsgReadWriter(rw, func() time.Duration {
+				return s.latency(s.id, to)
+			})
+			defer lrw.close()
+			return run(peer, lrw)
+		}
+		wrapped[i] = p
+	}
+	return wrapped
+}
+
+// unwrapService returns the service wrapped by latencyService,
+// or the provided service if it is not wrapped.
+func unwrapService(s node.Service) node.Service {
+	if ls, ok := s.(*latencyService); ok {
+		return ls.Service
+	}
+	return s
+}
+
+// delayedMsg is a message that is written to the connection
+// not before the sendAt time.
+type delayedMsg struct {
+	msg    p2p.Msg
+	sendAt time.Time
+}
+
+// latencyMsgReadWriter is a p2p.MsgReadWriter that writes messages
+// asynchronously in a separate goroutine, each after a latency returned
+// by the latency function, preserving the order of messages.
+type latencyMsgReadWriter struct {
+	p2p.MsgReadWriter
+	latency   func() time.Duration
+	queue     chan delayedMsg
+	quit      chan struct{}
+	closeOnce sync.Once
+	err       error // the first error returned by the wrapped writer
+	mu        sync.Mutex
+}
+
+func newLatencyMsgReadWriter(rw p2p.MsgReadWriter, latency func() time.Duration) *latencyMsgReadWriter {
+	lrw := &latencyMsgReadWriter{
+		MsgReadWriter: rw,
+		latency:       latency,
+		queue:         make(chan delayedMsg, 1000),
+		quit:          make(chan struct{}),
+	}
+	go lrw.run()
+	return lrw
+}
+
+// WriteMsg queues the message to be written after the latency. It does not
+// wait for the message to be consumed by the other end. An error from
+// writing a previous message to the connection is returned, if any.
+func (rw *latencyMsgReadWriter) WriteMsg(msg p2p.Msg) error {
+	rw.mu.Lock()
+	err := rw.err
+	rw.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// the payload must be read as the caller expects it to be
+	// drained when WriteMsg returns
+	payload, err := ioutil.ReadAll(msg.Payload)
+	if err != nil {
+		return err
+	}
+	msg.Payload = bytes.NewReader(payload)
+	select {
+	case rw.queue <- delayedMsg{msg: msg, sendAt: time.Now().Add(rw.latency())}:
+		return nil
+	case <-rw.quit:
+		return p2p.ErrShuttingDown
+	}
+}
+
+// run writes queued messages to the wrapped writer when
+// their latency expires.
+func (rw *latencyMsgReadWriter) run() {
+	for {
+		select {
+		case m := <-rw.queue:
+			if d := time.Until(m.sendAt); d > 0 {
+				select {
+				case <-time.After(d):
+				case <-rw.quit:
+					return
+				}
+			}
+			if err := rw.MsgReadWriter.WriteMsg(m.msg); err != nil {
+				log.Debug("simulation: delayed message write", "code", m.msg.Code, "err", err)
+				rw.mu.Lock()
+				if rw.err == nil {
+					rw.err = err
+				}
+				rw.mu.Unlock()
+			}
+		case <-rw.quit:
+			return
+		}
+	}
+}
+
+// close stops writing queued messages.
+func (rw *latencyMsgReadWriter) close() {
+	rw.closeOnce.Do(func() { close(rw.quit) })
+}
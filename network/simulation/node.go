@@ -22,6 +22,7 @@ import (
 	"crypto/ecdsa"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
@@ -226,6 +227,97 @@ func (s *Simulation) AddNodesAndConnectStar(count int, opts ...AddNodeOption) (i
 	return ids, nil
 }
 
+// connectNodesTimeout is the maximal duration that ConnectNodesByEnode
+// waits for all connections to be established.
+var connectNodesTimeout = time.Minute
+
+// ConnectNodesByEnode connects every pair of nodes, allowing arbitrary
+// topologies that are not provided by ConnectNodes helpers. It blocks
+// until all connections are established and, for nodes that store their
+// kademlia under BucketKeyKademlia, until the peers are added to it.
+func (s *Simulation) ConnectNodesByEnode(pairs [][2]enode.ID) (err error) {
+	events := make(chan *simulations.Event)
+	sub := s.Net.Events().Subscribe(events)
+	defer sub.Unsubscribe()
+
+	// connections that are not yet established, in both directions
+	pending := make(map[[2]enode.ID]struct{}, len(pairs))
+	for _, p := range pairs {
+		pending[p] = struct{}{}
+	}
+
+	timeout := time.NewTimer(connectNodesTimeout)
+	defer timeout.Stop()
+
+	// connect in a separate goroutine, as connecting
+	// sends events that are received in the loop below
+	errC := make(chan error, 1)
+	go func() {
+		for _, p := range pairs {
+			if err := s.Net.Connect(p[0], p[1]); err != nil {
+				errC <- err
+				return
+			}
+		}
+	}()
+
+	for len(pending) > 0 {
+		select {
+		case err := <-errC:
+			return err
+		case e := <-events:
+			if e.Type != simulations.EventTypeConn || e.Control || !e.Conn.Up {
+				continue
+			}
+			delete(pending, [2]enode.ID{e.Conn.One, e.Conn.Other})
+			delete(pending, [2]enode.ID{e.Conn.Other, e.Conn.One})
+		case err := <-sub.Err():
+			return err
+		case <-timeout.C:
+			return fmt.Errorf("%v connections not established", len(pending))
+		case <-s.Done():
+			return ErrSimulationClosed
+		}
+	}
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for _, p := range pairs {
+		for !s.kademliaConnected(p[0], p[1]) || !s.kademliaConnected(p[1], p[0]) {
+			select {
+			case <-ticker.C:
+			case <-timeout.C:
+				return fmt.Errorf("peer %s not added to kademlia of node %s", p[1], p[0])
+			case <-s.Done():
+				return ErrSimulationClosed
+			}
+		}
+	}
+	return nil
+}
+
+// kademliaConnected returns true if the node with the provided id does
+// not store a kademlia in its bucket, or if the peer is connected in it.
+func (s *Simulation) kademliaConnected(id, peer enode.ID) (connected bool) {
+	item, ok := s.NodeItem(id, BucketKeyKademlia)
+	if !ok {
+		return true
+	}
+	k, ok := item.(*network.Kademlia)
+	if !ok {
+		return true
+	}
+	k.EachConn(nil, 255, func(p *network.Peer, _ int) bool {
+		if p.ID() == peer {
+			connected = true
+			return false
+		}
+		return true
+	})
+	return connected
+}
+
 // UploadSnapshot uploads a snapshot to the simulation
 // This method tries to open the json file provided, applies the config to all nodes
 // and then loads the snapshot into the Simulation network
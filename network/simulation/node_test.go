@@ -32,7 +32,7 @@ import (
 )
 
 func TestUpDownNodeIDs(t *testing.T) {
-	sim := New(noopServiceFuncMap)
+	sim := New(noopServiceFuncMap, nil)
 	defer sim.Close()
 
 	ids, err := sim.AddNodes(10)
@@ -99,7 +99,7 @@ func equalNodeIDs(one, other []enode.ID) bool {
 }
 
 func TestAddNode(t *testing.T) {
-	sim := New(noopServiceFuncMap)
+	sim := New(noopServiceFuncMap, nil)
 	defer sim.Close()
 
 	id, err := sim.AddNode()
@@ -118,7 +118,7 @@ func TestAddNode(t *testing.T) {
 }
 
 func TestAddNodeWithMsgEvents(t *testing.T) {
-	sim := New(noopServiceFuncMap)
+	sim := New(noopServiceFuncMap, nil)
 	defer sim.Close()
 
 	id, err := sim.AddNode(AddNodeWithMsgEvents(true))
@@ -144,7 +144,7 @@ func TestAddNodeWithService(t *testing.T) {
 	sim := New(map[string]ServiceFunc{
 		"noop1": noopServiceFunc,
 		"noop2": noopServiceFunc,
-	})
+	}, nil)
 	defer sim.Close()
 
 	id, err := sim.AddNode(AddNodeWithService("noop1"))
@@ -165,7 +165,7 @@ func TestAddNodeMultipleServices(t *testing.T) {
 	sim := New(map[string]ServiceFunc{
 		"noop1": noopServiceFunc,
 		"noop2": noopService2Func,
-	})
+	}, nil)
 	defer sim.Close()
 
 	id, err := sim.AddNode()
@@ -186,7 +186,7 @@ func TestAddNodeDuplicateServiceError(t *testing.T) {
 	sim := New(map[string]ServiceFunc{
 		"noop1": noopServiceFunc,
 		"noop2": noopServiceFunc,
-	})
+	}, nil)
 	defer sim.Close()
 
 	wantErr := "duplicate service: *simulation.noopService"
@@ -197,7 +197,7 @@ func TestAddNodeDuplicateServiceError(t *testing.T) {
 }
 
 func TestAddNodes(t *testing.T) {
-	sim := New(noopServiceFuncMap)
+	sim := New(noopServiceFuncMap, nil)
 	defer sim.Close()
 
 	nodesCount := 12
@@ -219,7 +219,7 @@ func TestAddNodes(t *testing.T) {
 }
 
 func TestAddNodesAndConnectFull(t *testing.T) {
-	sim := New(noopServiceFuncMap)
+	sim := New(noopServiceFuncMap, nil)
 	defer sim.Close()
 
 	n := 12
@@ -233,7 +233,7 @@ func TestAddNodesAndConnectFull(t *testing.T) {
 }
 
 func TestAddNodesAndConnectChain(t *testing.T) {
-	sim := New(noopServiceFuncMap)
+	sim := New(noopServiceFuncMap, nil)
 	defer sim.Close()
 
 	_, err := sim.AddNodesAndConnectChain(12)
@@ -252,7 +252,7 @@ func TestAddNodesAndConnectChain(t *testing.T) {
 }
 
 func TestAddNodesAndConnectRing(t *testing.T) {
-	sim := New(noopServiceFuncMap)
+	sim := New(noopServiceFuncMap, nil)
 	defer sim.Close()
 
 	ids, err := sim.AddNodesAndConnectRing(12)
@@ -264,7 +264,7 @@ func TestAddNodesAndConnectRing(t *testing.T) {
 }
 
 func TestAddNodesAndConnectStar(t *testing.T) {
-	sim := New(noopServiceFuncMap)
+	sim := New(noopServiceFuncMap, nil)
 	defer sim.Close()
 
 	ids, err := sim.AddNodesAndConnectStar(12)
@@ -275,6 +275,61 @@ func TestAddNodesAndConnectStar(t *testing.T) {
 	simulations.VerifyStar(t, sim.Net, ids, 0)
 }
 
+// TestConnectNodesByEnode builds two triangles joined by a bridge
+// connection and validates that kademlia of every node has exactly
+// the connected nodes as peers.
+func TestConnectNodesByEnode(t *testing.T) {
+	sim := New(createSimServiceMap(false), nil)
+	defer sim.Close()
+
+	ids, err := sim.AddNodes(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pairs := [][2]enode.ID{
+		{ids[0], ids[1]},
+		{ids[1], ids[2]},
+		{ids[2], ids[0]},
+		{ids[2], ids[3]},
+		{ids[3], ids[4]},
+		{ids[4], ids[5]},
+		{ids[5], ids[3]},
+	}
+	if err := sim.ConnectNodesByEnode(pairs); err != nil {
+		t.Fatal(err)
+	}
+
+	want := make(map[enode.ID]map[enode.ID]bool)
+	for _, id := range ids {
+		want[id] = make(map[enode.ID]bool)
+	}
+	for _, p := range pairs {
+		want[p[0]][p[1]] = true
+		want[p[1]][p[0]] = true
+	}
+
+	for _, id := range ids {
+		item, ok := sim.NodeItem(id, BucketKeyKademlia)
+		if !ok {
+			t.Fatal("no kademlia")
+		}
+		got := make(map[enode.ID]bool)
+		item.(*network.Kademlia).EachConn(nil, 255, func(p *network.Peer, _ int) bool {
+			got[p.ID()] = true
+			return true
+		})
+		if len(got) != len(want[id]) {
+			t.Errorf("node %s: got %v peers, want %v", id, len(got), len(want[id]))
+		}
+		for peer := range want[id] {
+			if !got[peer] {
+				t.Errorf("node %s: peer %s not connected", id, peer)
+			}
+		}
+	}
+}
+
 //To test that uploading a snapshot works
 func TestUploadSnapshot(t *testing.T) {
 	log.Debug("Creating simulation")
@@ -292,7 +347,7 @@ func TestUploadSnapshot(t *testing.T) {
 			b.Store(BucketKeyKademlia, kad)
 			return network.NewBzz(config, kad, nil, nil, nil), nil, nil
 		},
-	})
+	}, nil)
 	defer s.Close()
 
 	nodeCount := 16
@@ -317,7 +372,7 @@ func TestUploadSnapshot(t *testing.T) {
 }
 
 func TestStartStopNode(t *testing.T) {
-	sim := New(noopServiceFuncMap)
+	sim := New(noopServiceFuncMap, nil)
 	defer sim.Close()
 
 	id, err := sim.AddNode()
@@ -353,7 +408,7 @@ func TestStartStopNode(t *testing.T) {
 }
 
 func TestStartStopRandomNode(t *testing.T) {
-	sim := New(noopServiceFuncMap)
+	sim := New(noopServiceFuncMap, nil)
 	defer sim.Close()
 
 	_, err := sim.AddNodes(3)
@@ -392,7 +447,7 @@ func TestStartStopRandomNode(t *testing.T) {
 }
 
 func TestStartStopRandomNodes(t *testing.T) {
-	sim := New(noopServiceFuncMap)
+	sim := New(noopServiceFuncMap, nil)
 	defer sim.Close()
 
 	_, err := sim.AddNodes(10)
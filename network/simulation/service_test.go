@@ -21,7 +21,7 @@ import (
 )
 
 func TestService(t *testing.T) {
-	sim := New(noopServiceFuncMap)
+	sim := New(noopServiceFuncMap, nil)
 	defer sim.Close()
 
 	id, err := sim.AddNode()
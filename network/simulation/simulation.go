@@ -33,7 +33,9 @@ import (
 
 // Common errors that are returned by functions in this package.
 var (
-	ErrNodeNotFound = errors.New("node not found")
+	ErrNodeNotFound     = errors.New("node not found")
+	ErrNoStore          = errors.New("node has no chunk store")
+	ErrSimulationClosed = errors.New("simulation closed")
 )
 
 // Simulation provides methods on network, nodes and services
@@ -66,12 +68,25 @@ type Simulation struct {
 // after network shutdown.
 type ServiceFunc func(ctx *adapters.ServiceContext, bucket *sync.Map) (s node.Service, cleanup func(), err error)
 
+// Options holds optional values for New constructor.
+type Options struct {
+	// MessageLatency returns the duration by which protocol messages
+	// sent from one node to another are delayed. Messages are written
+	// asynchronously and their order is preserved. If nil, messages
+	// are sent without additional latency.
+	MessageLatency func(from, to enode.ID) time.Duration
+}
+
 // New creates a new simulation instance
 // Services map must have unique keys as service names and
 // every ServiceFunc must return a node.Service of the unique type.
 // This restriction is required by node.Node.Start() function
 // which is used to start node.Service returned by ServiceFunc.
-func New(services map[string]ServiceFunc) (s *Simulation) {
+// Options are optional and can be nil.
+func New(services map[string]ServiceFunc, o *Options) (s *Simulation) {
+	if o == nil {
+		o = new(Options)
+	}
 	s = &Simulation{
 		buckets:           make(map[enode.ID]*sync.Map),
 		done:              make(chan struct{}),
@@ -99,6 +114,13 @@ func New(services map[string]ServiceFunc) (s *Simulation) {
 				s.cleanupFuncs = append(s.cleanupFuncs, cleanup)
 			}
 			s.buckets[ctx.Config.ID] = b
+			if o.MessageLatency != nil {
+				service = &latencyService{
+					Service: service,
+					id:      ctx.Config.ID,
+					latency: o.MessageLatency,
+				}
+			}
 			return service, nil
 		}
 	}
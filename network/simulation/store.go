@@ -0,0 +1,254 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/pot"
+	"github.com/ethersphere/swarm/storage"
+)
+
+// BucketKeyStore is the key to be used for storing the chunk.Store
+// instance for particular node, usually inside the ServiceFunc function.
+var BucketKeyStore BucketKey = "store"
+
+// GetChunk returns a chunk with the provided address from the chunk.Store
+// of the node with the provided NodeID. ErrNoStore is returned if the node
+// has no chunk.Store set under BucketKeyStore.
+func (s *Simulation) GetChunk(id enode.ID, addr storage.Address) (storage.Chunk, error) {
+	store, err := s.nodeStore(id)
+	if err != nil {
+		return nil, err
+	}
+	return store.Get(context.Background(), chunk.ModeGetRequest, addr)
+}
+
+// PutChunk stores the chunk in the chunk.Store of the node with
+// the provided NodeID. ErrNoStore is returned if the node
+// has no chunk.Store set under BucketKeyStore.
+func (s *Simulation) PutChunk(id enode.ID, ch storage.Chunk) error {
+	store, err := s.nodeStore(id)
+	if err != nil {
+		return err
+	}
+	_, err = store.Put(context.Background(), chunk.ModePutUpload, ch)
+	return err
+}
+
+// AddNodesWithStore adds count nodes to the network, applying provided
+// options as AddNodes does, and calls the seeder with the chunk.Store
+// of every new node, so that chunks can be placed in the node stores
+// before nodes are connected and the simulation is run. ErrNoStore is
+// returned if a node has no chunk.Store set under BucketKeyStore.
+func (s *Simulation) AddNodesWithStore(count int, seeder func(id enode.ID, store chunk.Store), opts ...AddNodeOption) (ids []enode.ID, err error) {
+	ids, err = s.AddNodes(count, opts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		store, err := s.nodeStore(id)
+		if err != nil {
+			return nil, err
+		}
+		seeder(id, store)
+	}
+	return ids, nil
+}
+
+// chunkSyncedCheckInterval is the time between two checks
+// of chunk presence in AssertChunkSynced.
+var chunkSyncedCheckInterval = 100 * time.Millisecond
+
+// AssertChunkSynced returns nil when the chunk with the provided address
+// can be retrieved from each of the minReplicas up nodes whose Kademlia
+// base addresses are closest to the chunk address. Only nodes that have
+// both Kademlia set under BucketKeyKademlia and chunk.Store set under
+// BucketKeyStore are considered. Chunk stores are checked repeatedly
+// until the chunk is found on all expected nodes or the context is done,
+// in which case the returned error lists the nodes without the chunk.
+func (s *Simulation) AssertChunkSynced(ctx context.Context, addr storage.Address, minReplicas int) error {
+	type node struct {
+		id    enode.ID
+		base  []byte
+		store chunk.Store
+	}
+	var nodes []node
+	for id, k := range s.kademlias() {
+		store, err := s.nodeStore(id)
+		if err != nil {
+			continue
+		}
+		nodes = append(nodes, node{id: id, base: k.BaseAddr(), store: store})
+	}
+	if len(nodes) < minReplicas {
+		return fmt.Errorf("chunk %s: %d nodes with kademlia and store, want at least %d", addr, len(nodes), minReplicas)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return pot.ProxCmp([]byte(addr), nodes[i].base, nodes[j].base) < 0
+	})
+	missing := nodes[:minReplicas]
+
+	ticker := time.NewTicker(chunkSyncedCheckInterval)
+	defer ticker.Stop()
+	for {
+		var stillMissing []node
+		for _, n := range missing {
+			has, err := n.store.Has(ctx, addr)
+			if err != nil {
+				return fmt.Errorf("chunk %s: node %s: %v", addr, n.id, err)
+			}
+			if !has {
+				stillMissing = append(stillMissing, n)
+			}
+		}
+		missing = stillMissing
+		if len(missing) == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			ids := make([]string, 0, len(missing))
+			for _, n := range missing {
+				ids = append(ids, fmt.Sprintf("%s (%x)", n.id.TerminalString(), n.base))
+			}
+			return fmt.Errorf("chunk %s missing on %d of %d closest nodes: %s: %v", addr, len(missing), minReplicas, strings.Join(ids, ", "), ctx.Err())
+		}
+	}
+}
+
+// nodeStore returns the chunk.Store set under BucketKeyStore
+// for the node with the provided NodeID.
+func (s *Simulation) nodeStore(id enode.ID) (chunk.Store, error) {
+	item, ok := s.NodeItem(id, BucketKeyStore)
+	if !ok {
+		return nil, ErrNoStore
+	}
+	store, ok := item.(chunk.Store)
+	if !ok {
+		return nil, ErrNoStore
+	}
+	return store, nil
+}
+
+// storesSnapshot is the serialized form of chunks
+// in node stores created by SnapshotStores.
+type storesSnapshot struct {
+	Nodes []nodeStoreSnapshot `json:"nodes"`
+}
+
+// nodeStoreSnapshot holds all chunks from a single node store.
+type nodeStoreSnapshot struct {
+	ID     enode.ID        `json:"id"`
+	Chunks []chunkSnapshot `json:"chunks"`
+}
+
+// chunkSnapshot holds address and data of a single chunk.
+type chunkSnapshot struct {
+	Address chunk.Address `json:"address"`
+	Data    []byte        `json:"data"`
+}
+
+// SnapshotStores serializes addresses and data of all chunks from the
+// chunk.Store of every node that is up. Nodes that are down or have no
+// chunk.Store set under BucketKeyStore are skipped. Chunks are found by
+// iterating over pull syncing subscriptions of all proximity order bins.
+// Returned data can be loaded with RestoreStores.
+func (s *Simulation) SnapshotStores() ([]byte, error) {
+	var snapshot storesSnapshot
+	for _, id := range s.UpNodeIDs() {
+		store, err := s.nodeStore(id)
+		if err != nil {
+			continue
+		}
+		chunks, err := storeChunks(store)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Nodes = append(snapshot.Nodes, nodeStoreSnapshot{
+			ID:     id,
+			Chunks: chunks,
+		})
+	}
+	return json.Marshal(snapshot)
+}
+
+// RestoreStores puts chunks serialized by SnapshotStores to stores of
+// the nodes with the same NodeIDs. Nodes that are not in the simulation,
+// are down or have no chunk.Store set under BucketKeyStore are skipped.
+func (s *Simulation) RestoreStores(data []byte) error {
+	var snapshot storesSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+	for _, n := range snapshot.Nodes {
+		if node := s.Net.GetNode(n.ID); node == nil || !node.Up() {
+			continue
+		}
+		store, err := s.nodeStore(n.ID)
+		if err != nil {
+			continue
+		}
+		for _, c := range n.Chunks {
+			_, err := store.Put(context.Background(), chunk.ModePutSync, chunk.NewChunk(c.Address, c.Data))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// storeChunks returns all chunks from the store that
+// are in its pull syncing index.
+func storeChunks(store chunk.Store) (chunks []chunkSnapshot, err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for bin := uint8(0); bin <= chunk.MaxPO; bin++ {
+		until, err := store.LastPullSubscriptionBinID(bin)
+		if err != nil {
+			return nil, err
+		}
+		if until == 0 {
+			// no chunks in this bin
+			continue
+		}
+		c, stop := store.SubscribePull(ctx, bin, 0, until)
+		for d := range c {
+			ch, err := store.Get(ctx, chunk.ModeGetSync, d.Address)
+			if err != nil {
+				stop()
+				return nil, err
+			}
+			chunks = append(chunks, chunkSnapshot{
+				Address: ch.Address(),
+				Data:    ch.Data(),
+			})
+		}
+		stop()
+	}
+	return chunks, nil
+}
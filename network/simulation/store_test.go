@@ -0,0 +1,242 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/network"
+	"github.com/ethersphere/swarm/pot"
+	"github.com/ethersphere/swarm/storage"
+	"github.com/ethersphere/swarm/storage/localstore"
+)
+
+// storeServiceFuncMap is a simulation services map with a noop service
+// that sets a localstore in the node bucket under BucketKeyStore
+// and kademlia under BucketKeyKademlia.
+var storeServiceFuncMap = map[string]ServiceFunc{
+	"noop": func(ctx *adapters.ServiceContext, b *sync.Map) (node.Service, func(), error) {
+		dir, err := ioutil.TempDir("", "swarm-simulation-store")
+		if err != nil {
+			return nil, nil, err
+		}
+		store, err := localstore.New(dir, ctx.Config.ID.Bytes(), nil)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, nil, err
+		}
+		b.Store(BucketKeyStore, store)
+		b.Store(BucketKeyKademlia, network.NewKademlia(ctx.Config.ID.Bytes(), network.NewKadParams()))
+		cleanup := func() {
+			store.Close()
+			os.RemoveAll(dir)
+		}
+		return newNoopService(), cleanup, nil
+	},
+}
+
+// TestStoreChunk validates that chunks can be put to and retrieved from
+// node stores with PutChunk and GetChunk, and that ErrNoStore is returned
+// for nodes without a store.
+func TestStoreChunk(t *testing.T) {
+	sim := New(storeServiceFuncMap, nil)
+	defer sim.Close()
+
+	ids, err := sim.AddNodes(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := storage.GenerateRandomChunk(chunk.DefaultSize)
+
+	if err := sim.PutChunk(ids[0], ch); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := sim.GetChunk(ids[0], ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Data(), ch.Data()) {
+		t.Fatal("got chunk data is not the same as put")
+	}
+
+	if _, err := sim.GetChunk(ids[1], ch.Address()); err != chunk.ErrChunkNotFound {
+		t.Fatalf("got error %v, want %v", err, chunk.ErrChunkNotFound)
+	}
+
+	// node that is not in the simulation has no store
+	var unknown enode.ID
+	if _, err := sim.GetChunk(unknown, ch.Address()); err != ErrNoStore {
+		t.Fatalf("got error %v, want %v", err, ErrNoStore)
+	}
+	if err := sim.PutChunk(unknown, ch); err != ErrNoStore {
+		t.Fatalf("got error %v, want %v", err, ErrNoStore)
+	}
+}
+
+// TestAssertChunkSynced validates that AssertChunkSynced checks
+// the presence of the chunk on the nodes closest to its address
+// and that the returned error lists the nodes without the chunk.
+func TestAssertChunkSynced(t *testing.T) {
+	sim := New(storeServiceFuncMap, nil)
+	defer sim.Close()
+
+	ids, err := sim.AddNodes(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := storage.GenerateRandomChunk(chunk.DefaultSize)
+	addr := ch.Address()
+
+	// order node ids by the proximity of their addresses to the chunk
+	sort.Slice(ids, func(i, j int) bool {
+		return pot.ProxCmp([]byte(addr), ids[i].Bytes(), ids[j].Bytes()) < 0
+	})
+
+	if err := sim.PutChunk(ids[0], ch); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := sim.AssertChunkSynced(ctx, addr, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	err = sim.AssertChunkSynced(ctx, addr, 2)
+	if err == nil {
+		t.Fatal("got no error for the chunk missing on the second closest node")
+	}
+	if !strings.Contains(err.Error(), ids[1].TerminalString()) {
+		t.Errorf("got error %q, want it to contain node %s", err, ids[1].TerminalString())
+	}
+	if strings.Contains(err.Error(), ids[0].TerminalString()) {
+		t.Errorf("got error %q, want it not to contain node %s", err, ids[0].TerminalString())
+	}
+
+	if err := sim.AssertChunkSynced(context.Background(), addr, 4); err == nil {
+		t.Fatal("got no error for more replicas than nodes")
+	}
+}
+
+// TestSnapshotAndRestoreStores validates that chunks from node stores
+// are retrievable after they are removed and restored from a snapshot
+// and that nodes that are down are skipped.
+func TestSnapshotAndRestoreStores(t *testing.T) {
+	sim := New(storeServiceFuncMap, nil)
+	defer sim.Close()
+
+	ids, err := sim.AddNodes(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunks := make(map[enode.ID][]storage.Chunk)
+	for _, id := range ids {
+		for i := 0; i < 10; i++ {
+			ch := storage.GenerateRandomChunk(chunk.DefaultSize)
+			if err := sim.PutChunk(id, ch); err != nil {
+				t.Fatal(err)
+			}
+			chunks[id] = append(chunks[id], ch)
+		}
+	}
+
+	down := ids[2]
+	if err := sim.StopNode(down); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := sim.SnapshotStores()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s storesSnapshot
+	if err := json.Unmarshal(snapshot, &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Nodes) != 2 {
+		t.Fatalf("got %v nodes in snapshot, want 2", len(s.Nodes))
+	}
+	for _, n := range s.Nodes {
+		if n.ID == down {
+			t.Fatal("node that is down is in snapshot")
+		}
+		if len(n.Chunks) != len(chunks[n.ID]) {
+			t.Errorf("got %v chunks in snapshot for node %s, want %v", len(n.Chunks), n.ID, len(chunks[n.ID]))
+		}
+	}
+
+	// remove all chunks from all stores
+	for id, chs := range chunks {
+		store, err := sim.nodeStore(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ch := range chs {
+			if err := store.Set(context.Background(), chunk.ModeSetRemove, ch.Address()); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := sim.GetChunk(id, ch.Address()); err != chunk.ErrChunkNotFound {
+				t.Fatalf("got error %v, want %v", err, chunk.ErrChunkNotFound)
+			}
+		}
+	}
+
+	if err := sim.StartNode(down); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sim.RestoreStores(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, chs := range chunks {
+		for _, ch := range chs {
+			got, err := sim.GetChunk(id, ch.Address())
+			if id == down {
+				// the node was down when the snapshot was created
+				if err != chunk.ErrChunkNotFound {
+					t.Fatalf("got error %v, want %v", err, chunk.ErrChunkNotFound)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Data(), ch.Data()) {
+				t.Fatal("got restored chunk data is not the same as put")
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulation
+
+import (
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// Stream identifies a stream of a node subscription. It has the same
+// fields as the stream.Stream type from the network/stream package,
+// which is not imported as its tests depend on this package, so that
+// stream.Stream values can be converted to it.
+type Stream struct {
+	Name string
+	Key  string
+	Live bool
+}
+
+// subscriptionCheckInterval is the interval between checks of node
+// subscriptions in WaitForSubscription.
+var subscriptionCheckInterval = 50 * time.Millisecond
+
+// WaitForSubscription blocks until the node with nodeID reports a
+// subscription to the stream on the peer with peerID, using the
+// stream_subscriptions RPC method of the node, or until the context is done.
+// Subscriptions are reported when they are requested, also before the
+// peer offers any hashes on the stream.
+func (s *Simulation) WaitForSubscription(ctx context.Context, nodeID, peerID enode.ID, stream Stream) error {
+	node := s.Net.GetNode(nodeID)
+	if node == nil {
+		return ErrNodeNotFound
+	}
+	client, err := node.Client()
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(subscriptionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		var subs map[string][]struct {
+			Stream Stream
+		}
+		if err := client.CallContext(ctx, &subs, "stream_subscriptions"); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		for _, sub := range subs[peerID.String()] {
+			if sub.Stream == stream {
+				return nil
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.Done():
+			return ErrSimulationClosed
+		}
+	}
+}
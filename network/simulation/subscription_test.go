@@ -0,0 +1,116 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulation
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// TestWaitForSubscription validates that WaitForSubscription returns
+// only after the node reports the subscription to the stream on the peer.
+func TestWaitForSubscription(t *testing.T) {
+	peerID := enode.HexID("3431c3939e1ee2a6345e976a8234f9870152d64879f30bc272a074f6859e75e8")
+	stream := Stream{Name: "SYNC", Key: "0", Live: true}
+
+	api := &subscriptionsAPI{
+		peerID:      peerID,
+		stream:      stream,
+		reportAfter: 5,
+	}
+	sim := New(map[string]ServiceFunc{
+		"stream": func(_ *adapters.ServiceContext, _ *sync.Map) (node.Service, func(), error) {
+			return &subscriptionsService{api: api}, nil, nil
+		},
+	}, nil)
+	defer sim.Close()
+
+	id, err := sim.AddNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := sim.WaitForSubscription(ctx, id, peerID, stream); err != nil {
+		t.Fatal(err)
+	}
+	if calls := atomic.LoadInt64(&api.calls); calls < api.reportAfter {
+		t.Errorf("got %v subscriptions calls, want at least %v", calls, api.reportAfter)
+	}
+
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer shortCancel()
+
+	other := Stream{Name: "SYNC", Key: "1", Live: true}
+	if err := sim.WaitForSubscription(shortCtx, id, peerID, other); err != context.DeadlineExceeded {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if err := sim.WaitForSubscription(ctx, enode.ID{}, peerID, stream); err != ErrNodeNotFound {
+		t.Errorf("got error %v, want %v", err, ErrNodeNotFound)
+	}
+}
+
+// subscriptionsService exposes subscriptionsAPI
+// in the same namespace as the stream package.
+type subscriptionsService struct {
+	simulations.NoopService
+	api *subscriptionsAPI
+}
+
+func (s *subscriptionsService) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "stream",
+			Version:   "3.0",
+			Service:   s.api,
+		},
+	}
+}
+
+// subscriptionsAPI reports the subscription to the stream on the peer
+// only after its Subscriptions method is called reportAfter times.
+type subscriptionsAPI struct {
+	peerID      enode.ID
+	stream      Stream
+	reportAfter int64
+	calls       int64
+}
+
+type subscription struct {
+	Stream Stream
+}
+
+func (a *subscriptionsAPI) Subscriptions() map[string][]subscription {
+	if atomic.AddInt64(&a.calls, 1) < a.reportAfter {
+		return nil
+	}
+	return map[string][]subscription{
+		a.peerID.String(): {{Stream: a.stream}},
+	}
+}
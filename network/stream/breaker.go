@@ -0,0 +1,87 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethersphere/swarm/log"
+)
+
+// defaultBreakerCooldown is the duration for which a peer is not sent
+// retrieve requests after its circuit breaker opens, if it is not set
+// in DeliveryOptions.
+var defaultBreakerCooldown = 30 * time.Second
+
+// peerBreaker counts consecutive failed retrieve requests to a peer.
+type peerBreaker struct {
+	failures int
+	openTill time.Time // no requests are sent to the peer before this time
+}
+
+// breakerAllows returns false if the circuit breaker for the peer is open.
+// When the cooldown after opening has passed, the breaker is half-open and
+// a single request is allowed to probe the peer, while further requests
+// are not allowed until the probe result is recorded or another cooldown
+// passes.
+func (d *Delivery) breakerAllows(id enode.ID) bool {
+	if d.breakerThreshold <= 0 {
+		return true
+	}
+	d.breakersMu.Lock()
+	defer d.breakersMu.Unlock()
+
+	b, ok := d.breakers[id]
+	if !ok || b.failures < d.breakerThreshold {
+		return true
+	}
+	now := time.Now()
+	if now.Before(b.openTill) {
+		return false
+	}
+	b.openTill = now.Add(d.breakerCooldown)
+	log.Debug("delivery: probing peer with open circuit breaker", "peer", id)
+	return true
+}
+
+// recordBreaker records the result of a retrieve request to the peer.
+// The circuit breaker opens when the number of consecutive failures
+// reaches the threshold and closes on the first successful delivery.
+func (d *Delivery) recordBreaker(id enode.ID, success bool) {
+	if d.breakerThreshold <= 0 {
+		return
+	}
+	d.breakersMu.Lock()
+	defer d.breakersMu.Unlock()
+
+	if success {
+		delete(d.breakers, id)
+		return
+	}
+	b, ok := d.breakers[id]
+	if !ok {
+		b = new(peerBreaker)
+		d.breakers[id] = b
+	}
+	b.failures++
+	if b.failures == d.breakerThreshold {
+		b.openTill = time.Now().Add(d.breakerCooldown)
+		breakerOpenCount.Inc(1)
+		log.Debug("delivery: circuit breaker opened", "peer", id, "failures", b.failures)
+	}
+}
@@ -27,7 +27,6 @@ import (
 	"os"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -54,11 +53,12 @@ var (
 	useMockStore = flag.Bool("mockstore", false, "disabled mock store (default: enabled)")
 	longrunning  = flag.Bool("longrunning", false, "do run long-running tests")
 
-	bucketKeyStore     = simulation.BucketKey("store")
+	bucketKeyStore     = simulation.BucketKeyStore
 	bucketKeyFileStore = simulation.BucketKey("filestore")
 	bucketKeyNetStore  = simulation.BucketKey("netstore")
 	bucketKeyDelivery  = simulation.BucketKey("delivery")
 	bucketKeyRegistry  = simulation.BucketKey("registry")
+	bucketKeyTags      = simulation.BucketKey("tags")
 
 	chunkSize = 4096
 	pof       = network.Pof
@@ -81,7 +81,7 @@ func newNetStoreAndDelivery(ctx *adapters.ServiceContext, bucket *sync.Map) (*ne
 		return nil, nil, nil, nil, err
 	}
 
-	netStore.NewNetFetcherFunc = network.NewFetcherFactory(delivery.RequestFromPeers, true).New
+	netStore.NewNetFetcherFunc = network.NewFetcherFactory(delivery.RequestFromPeers, true, nil).New
 
 	return addr, netStore, delivery, cleanup, nil
 }
@@ -93,7 +93,7 @@ func newNetStoreAndDeliveryWithBzzAddr(ctx *adapters.ServiceContext, bucket *syn
 		return nil, nil, nil, err
 	}
 
-	netStore.NewNetFetcherFunc = network.NewFetcherFactory(delivery.RequestFromPeers, true).New
+	netStore.NewNetFetcherFunc = network.NewFetcherFactory(delivery.RequestFromPeers, true, nil).New
 
 	return netStore, delivery, cleanup, nil
 }
@@ -107,7 +107,7 @@ func newNetStoreAndDeliveryWithRequestFunc(ctx *adapters.ServiceContext, bucket
 		return nil, nil, nil, nil, err
 	}
 
-	netStore.NewNetFetcherFunc = network.NewFetcherFactory(rf, true).New
+	netStore.NewNetFetcherFunc = network.NewFetcherFactory(rf, true, nil).New
 
 	return addr, netStore, delivery, cleanup, nil
 }
@@ -115,26 +115,31 @@ func newNetStoreAndDeliveryWithRequestFunc(ctx *adapters.ServiceContext, bucket
 func netStoreAndDeliveryWithAddr(ctx *adapters.ServiceContext, bucket *sync.Map, addr *network.BzzAddr) (*storage.NetStore, *Delivery, func(), error) {
 	n := ctx.Config.Node()
 
-	localStore, localStoreCleanup, err := newTestLocalStore(n.ID(), addr, nil)
+	tags := chunk.NewTags()
+
+	localStore, localStoreCleanup, err := newTestLocalStore(n.ID(), addr, nil, tags)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	netStore, err := storage.NewNetStore(localStore, nil)
+	netStore, err := storage.NewNetStore(localStore, nil, nil)
 	if err != nil {
 		localStore.Close()
 		localStoreCleanup()
 		return nil, nil, nil, err
 	}
 
-	fileStore := storage.NewFileStore(netStore, storage.NewFileStoreParams(), chunk.NewTags())
+	fileStoreParams := storage.NewFileStoreParams()
+	fileStoreParams.SyncStatusStore = localStore
+	fileStore := storage.NewFileStore(netStore, fileStoreParams, tags)
 
 	kad := network.NewKademlia(addr.Over(), network.NewKadParams())
-	delivery := NewDelivery(kad, netStore)
+	delivery := NewDelivery(kad, netStore, nil)
 
 	bucket.Store(bucketKeyStore, localStore)
 	bucket.Store(bucketKeyDelivery, delivery)
 	bucket.Store(bucketKeyFileStore, fileStore)
+	bucket.Store(bucketKeyTags, tags)
 	// for the kademlia object, we use the global key from the simulation package,
 	// as the simulation will try to access it in the WaitTillHealthy with that key
 	bucket.Store(simulation.BucketKeyKademlia, kad)
@@ -148,6 +153,19 @@ func netStoreAndDeliveryWithAddr(ctx *adapters.ServiceContext, bucket *sync.Map,
 }
 
 func newStreamerTester(registryOptions *RegistryOptions) (*p2ptest.ProtocolTester, *Registry, *localstore.DB, func(), error) {
+	return newStreamerTesterWithNodes(registryOptions, 1)
+}
+
+// newStreamerTesterWithNodes is the same as newStreamerTester,
+// but the protocol tester has the provided number of nodes.
+func newStreamerTesterWithNodes(registryOptions *RegistryOptions, nodeCount int) (*p2ptest.ProtocolTester, *Registry, *localstore.DB, func(), error) {
+	return newStreamerTesterWithRecorder(registryOptions, nodeCount, nil)
+}
+
+// newStreamerTesterWithRecorder is the same as newStreamerTesterWithNodes,
+// but if the writer is not nil, messages exchanged with the protocol tester
+// nodes are recorded to it by a Recorder.
+func newStreamerTesterWithRecorder(registryOptions *RegistryOptions, nodeCount int, w io.Writer) (*p2ptest.ProtocolTester, *Registry, *localstore.DB, func(), error) {
 	// setup
 	addr := network.RandomAddr() // tested peers peer address
 	to := network.NewKademlia(addr.OAddr, network.NewKadParams())
@@ -167,15 +185,15 @@ func newStreamerTester(registryOptions *RegistryOptions) (*p2ptest.ProtocolTeste
 		return nil, nil, nil, nil, err
 	}
 
-	netStore, err := storage.NewNetStore(localStore, nil)
+	netStore, err := storage.NewNetStore(localStore, nil, nil)
 	if err != nil {
 		localStore.Close()
 		removeDataDir()
 		return nil, nil, nil, nil, err
 	}
 
-	delivery := NewDelivery(to, netStore)
-	netStore.NewNetFetcherFunc = network.NewFetcherFactory(delivery.RequestFromPeers, true).New
+	delivery := NewDelivery(to, netStore, nil)
+	netStore.NewNetFetcherFunc = network.NewFetcherFactory(delivery.RequestFromPeers, true, nil).New
 	intervalsStore := state.NewInmemoryStore()
 	streamer := NewRegistry(addr.ID(), delivery, netStore, intervalsStore, registryOptions, nil)
 
@@ -185,7 +203,11 @@ func newStreamerTester(registryOptions *RegistryOptions) (*p2ptest.ProtocolTeste
 		return nil, nil, nil, nil, err
 	}
 
-	protocolTester := p2ptest.NewProtocolTester(prvkey, 1, streamer.runProtocol)
+	run := streamer.runProtocol
+	if w != nil {
+		run = NewRecorder(w, run).Run
+	}
+	protocolTester := p2ptest.NewProtocolTester(prvkey, nodeCount, run)
 	teardown := func() {
 		protocolTester.Stop()
 		streamer.Close()
@@ -193,7 +215,7 @@ func newStreamerTester(registryOptions *RegistryOptions) (*p2ptest.ProtocolTeste
 		netStore.Close()
 		removeDataDir()
 	}
-	err = waitForPeers(streamer, 10*time.Second, 1)
+	err = waitForPeers(streamer, 10*time.Second, nodeCount)
 	if err != nil {
 		teardown()
 		return nil, nil, nil, nil, errors.New("timeout: peer is not created")
@@ -217,51 +239,6 @@ func waitForPeers(streamer *Registry, timeout time.Duration, expectedPeers int)
 	}
 }
 
-type roundRobinStore struct {
-	index  uint32
-	stores []storage.ChunkStore
-}
-
-func newRoundRobinStore(stores ...storage.ChunkStore) *roundRobinStore {
-	return &roundRobinStore{
-		stores: stores,
-	}
-}
-
-// not used in this context, only to fulfill ChunkStore interface
-func (rrs *roundRobinStore) Has(_ context.Context, _ storage.Address) (bool, error) {
-	return false, errors.New("roundRobinStore doesn't support Has")
-}
-
-func (rrs *roundRobinStore) Get(_ context.Context, _ chunk.ModeGet, _ storage.Address) (storage.Chunk, error) {
-	return nil, errors.New("roundRobinStore doesn't support Get")
-}
-
-func (rrs *roundRobinStore) Put(ctx context.Context, mode chunk.ModePut, ch storage.Chunk) (bool, error) {
-	i := atomic.AddUint32(&rrs.index, 1)
-	idx := int(i) % len(rrs.stores)
-	return rrs.stores[idx].Put(ctx, mode, ch)
-}
-
-func (rrs *roundRobinStore) Set(ctx context.Context, mode chunk.ModeSet, addr chunk.Address) (err error) {
-	return errors.New("roundRobinStore doesn't support Set")
-}
-
-func (rrs *roundRobinStore) LastPullSubscriptionBinID(bin uint8) (id uint64, err error) {
-	return 0, errors.New("roundRobinStore doesn't support LastPullSubscriptionBinID")
-}
-
-func (rrs *roundRobinStore) SubscribePull(ctx context.Context, bin uint8, since, until uint64) (c <-chan chunk.Descriptor, stop func()) {
-	return nil, nil
-}
-
-func (rrs *roundRobinStore) Close() error {
-	for _, store := range rrs.stores {
-		store.Close()
-	}
-	return nil
-}
-
 func readAll(fileStore *storage.FileStore, hash []byte) (int64, error) {
 	r, _ := fileStore.Retrieve(context.TODO(), hash)
 	buf := make([]byte, 1024)
@@ -325,7 +302,7 @@ func generateRandomFile() (string, error) {
 	return string(b), nil
 }
 
-func newTestLocalStore(id enode.ID, addr *network.BzzAddr, globalStore mock.GlobalStorer) (localStore *localstore.DB, cleanup func(), err error) {
+func newTestLocalStore(id enode.ID, addr *network.BzzAddr, globalStore mock.GlobalStorer, tags *chunk.Tags) (localStore *localstore.DB, cleanup func(), err error) {
 	dir, err := ioutil.TempDir("", "swarm-stream-")
 	if err != nil {
 		return nil, nil, err
@@ -341,6 +318,7 @@ func newTestLocalStore(id enode.ID, addr *network.BzzAddr, globalStore mock.Glob
 
 	localStore, err = localstore.New(dir, addr.Over(), &localstore.Options{
 		MockStore: mockStore,
+		Tags:      tags,
 	})
 	if err != nil {
 		cleanup()
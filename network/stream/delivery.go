@@ -18,8 +18,10 @@ package stream
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/metrics"
@@ -30,6 +32,8 @@ import (
 	"github.com/ethersphere/swarm/spancontext"
 	"github.com/ethersphere/swarm/storage"
 	"github.com/ethersphere/swarm/tracing"
+	"github.com/golang/snappy"
+	lru "github.com/hashicorp/golang-lru"
 	opentracing "github.com/opentracing/opentracing-go"
 	olog "github.com/opentracing/opentracing-go/log"
 )
@@ -41,23 +45,197 @@ var (
 
 	requestFromPeersCount     = metrics.NewRegisteredCounter("network.stream.request_from_peers.count", nil)
 	requestFromPeersEachCount = metrics.NewRegisteredCounter("network.stream.request_from_peers_each.count", nil)
+	requestFromPeersCoalesced = metrics.NewRegisteredCounter("network.stream.request_from_peers_coalesced.count", nil)
+	breakerOpenCount          = metrics.NewRegisteredCounter("network.stream.breaker_open.count", nil)
 
 	lastReceivedChunksMsg = metrics.GetOrRegisterGauge("network.stream.received_chunks", nil)
 )
 
+// requestCacheCapacity is the maximal number of chunk addresses
+// kept in the Delivery recent requests cache.
+const requestCacheCapacity = 10000
+
 type Delivery struct {
 	netStore *storage.NetStore
 	kad      *network.Kademlia
 	getPeer  func(enode.ID) *Peer
 	quit     chan struct{}
+
+	requests        *lru.Cache // recent requests by chunk address, nil if disabled
+	requestsMu      sync.Mutex // serializes lookups and additions to requests cache
+	requestCacheTTL time.Duration
+
+	requested   *lru.Cache // ids of the last requested peers by chunk address, nil if peer scoring and circuit breakers are disabled
+	requestedMu sync.Mutex // ensures that every request in requested cache is scored once
+
+	breakerThreshold int                       // consecutive failed requests that open a peer circuit breaker, disabled if zero
+	breakerCooldown  time.Duration             // time for which an open circuit breaker skips the peer
+	breakers         map[enode.ID]*peerBreaker // circuit breakers of peers with failed requests
+	breakersMu       sync.Mutex
+
+	wanted   *lru.Cache // expiry times of chunks wanted from syncing peers by chunk address
+	wantedMu sync.Mutex // serializes lookups and additions to wanted cache
+
+	pushSyncReplication int
+	pushSyncQuorum      int
+	pushes              map[string]*pushSyncRequest // pending push-sync requests by chunk address
+	receiptKey          *ecdsa.PrivateKey           // node private key that receipts are signed with, set on Registry start
+	pushesMu            sync.Mutex                  // protects pushes and receiptKey
+
+	deliveryFuncs   map[uint64]func(peer enode.ID, addr chunk.Address) // functions called on received chunk deliveries
+	deliveryFuncsID uint64                                             // last assigned key in deliveryFuncs
+	deliveryFuncsMu sync.RWMutex
+
+	peerSelector PeerSelector // chooses the peer that a retrieve request is sent to
+	chunkSize    int          // maximal size of delivered chunk data without the span
+}
+
+// DeliveryOptions holds optional values for NewDelivery constructor.
+type DeliveryOptions struct {
+	// RequestCacheTTL is the duration for which RequestFromPeers calls
+	// for the same chunk address are coalesced into a single retrieve
+	// request, unless the chunk is delivered earlier. Zero disables it.
+	RequestCacheTTL time.Duration
+	// PushSyncReplication is the number of connected peers closest to
+	// the chunk address that chunks are pushed to by PushSync.
+	// Zero disables push-sync.
+	PushSyncReplication int
+	// PushSyncQuorum is the number of receipts that PushSync waits for.
+	// If zero or larger than PushSyncReplication, a majority of
+	// PushSyncReplication peers is used.
+	PushSyncQuorum int
+	// BreakerThreshold is the number of consecutive retrieve requests
+	// to a peer that are not delivered after which RequestFromPeers
+	// skips the peer for BreakerCooldown, before a single request is
+	// sent to probe it again. Zero disables circuit breakers.
+	BreakerThreshold int
+	// BreakerCooldown is the duration for which a peer is skipped after
+	// its circuit breaker opens. If zero, 30 seconds is used.
+	BreakerCooldown time.Duration
+	// PeerSelector chooses the peer that a retrieve request is sent to
+	// among the eligible connected peers. If nil, ClosestPeerSelector
+	// is used.
+	PeerSelector PeerSelector
+	// ChunkSize is the maximal size of chunk data without the span that
+	// is accepted in compressed deliveries. It must match the chunk size
+	// of the FileStore. If zero, chunk.DefaultSize is used.
+	ChunkSize int
+}
+
+func NewDelivery(kad *network.Kademlia, netStore *storage.NetStore, o *DeliveryOptions) *Delivery {
+	if o == nil {
+		o = new(DeliveryOptions)
+	}
+	d := &Delivery{
+		netStore:        netStore,
+		kad:             kad,
+		quit:            make(chan struct{}),
+		requestCacheTTL: o.RequestCacheTTL,
+		peerSelector:    o.PeerSelector,
+		chunkSize:       o.ChunkSize,
+	}
+	if d.peerSelector == nil {
+		d.peerSelector = ClosestPeerSelector{}
+	}
+	if d.chunkSize <= 0 {
+		d.chunkSize = chunk.DefaultSize
+	}
+	if o.RequestCacheTTL > 0 {
+		// error is returned only for non-positive capacity
+		d.requests, _ = lru.New(requestCacheCapacity)
+	}
+	if o.BreakerThreshold > 0 {
+		d.breakerThreshold = o.BreakerThreshold
+		d.breakerCooldown = o.BreakerCooldown
+		if d.breakerCooldown <= 0 {
+			d.breakerCooldown = defaultBreakerCooldown
+		}
+		d.breakers = make(map[enode.ID]*peerBreaker)
+	}
+	if kad.PeerScore != nil || d.breakers != nil {
+		d.requested, _ = lru.New(requestCacheCapacity)
+	}
+	d.wanted, _ = lru.New(requestCacheCapacity)
+	if o.PushSyncReplication > 0 {
+		d.pushSyncReplication = o.PushSyncReplication
+		d.pushSyncQuorum = o.PushSyncQuorum
+		if d.pushSyncQuorum <= 0 || d.pushSyncQuorum > o.PushSyncReplication {
+			d.pushSyncQuorum = o.PushSyncReplication/2 + 1
+		}
+		d.pushes = make(map[string]*pushSyncRequest)
+	}
+	return d
+}
+
+// SubscribeDeliveries calls f with the peer and the chunk address for every
+// received chunk delivery message until the returned unsubscribe function
+// is called.
+func (d *Delivery) SubscribeDeliveries(f func(peer enode.ID, addr chunk.Address)) (unsubscribe func()) {
+	d.deliveryFuncsMu.Lock()
+	defer d.deliveryFuncsMu.Unlock()
+
+	if d.deliveryFuncs == nil {
+		d.deliveryFuncs = make(map[uint64]func(peer enode.ID, addr chunk.Address))
+	}
+	d.deliveryFuncsID++
+	id := d.deliveryFuncsID
+	d.deliveryFuncs[id] = f
+	return func() {
+		d.deliveryFuncsMu.Lock()
+		delete(d.deliveryFuncs, id)
+		d.deliveryFuncsMu.Unlock()
+	}
+}
+
+// notifyDelivery calls all functions subscribed with SubscribeDeliveries.
+func (d *Delivery) notifyDelivery(peer enode.ID, addr chunk.Address) {
+	d.deliveryFuncsMu.RLock()
+	funcs := make([]func(peer enode.ID, addr chunk.Address), 0, len(d.deliveryFuncs))
+	for _, f := range d.deliveryFuncs {
+		funcs = append(funcs, f)
+	}
+	d.deliveryFuncsMu.RUnlock()
+
+	for _, f := range funcs {
+		f(peer, addr)
+	}
 }
 
-func NewDelivery(kad *network.Kademlia, netStore *storage.NetStore) *Delivery {
-	return &Delivery{
-		netStore: netStore,
-		kad:      kad,
-		quit:     make(chan struct{}),
+// markWanted records that the chunk is wanted from a syncing peer and
+// returns true, or returns false if the chunk is already wanted from
+// another peer and it is not delivered or expired since. This prevents
+// setting the want bit in WantedHashesMsg for the same chunk to multiple
+// peers that offer it over overlapping streams.
+func (d *Delivery) markWanted(addr storage.Address) bool {
+	key := string(addr)
+	now := time.Now()
+
+	d.wantedMu.Lock()
+	defer d.wantedMu.Unlock()
+
+	if v, ok := d.wanted.Get(key); ok && now.Before(v.(time.Time)) {
+		return false
 	}
+	d.wanted.Add(key, now.Add(syncBatchTimeout))
+	return true
+}
+
+// unmarkWanted allows the chunk to be wanted again.
+func (d *Delivery) unmarkWanted(addr storage.Address) {
+	d.wantedMu.Lock()
+	d.wanted.Remove(string(addr))
+	d.wantedMu.Unlock()
+}
+
+// cachedRequest holds the result of a retrieve request that is
+// shared between all RequestFromPeers calls for the same chunk.
+type cachedRequest struct {
+	done      chan struct{} // closed when the request is sent
+	expires   time.Time
+	id        *enode.ID
+	quit      chan struct{}
+	err       error
+	cancelled bool // the request failed as the context of its caller is done
 }
 
 // RetrieveRequestMsg is the protocol msg for chunk retrieve requests
@@ -114,9 +292,10 @@ func (d *Delivery) handleRetrieveRequestMsg(ctx context.Context, sp *Peer, req *
 
 //Chunk delivery always uses the same message type....
 type ChunkDeliveryMsg struct {
-	Addr  storage.Address
-	SData []byte // the stored chunk Data (incl size)
-	peer  *Peer  // set in handleChunkDeliveryMsg
+	Addr       storage.Address
+	SData      []byte // the stored chunk Data (incl size)
+	Compressed bool   // SData is compressed with snappy
+	peer       *Peer  // set in handleChunkDeliveryMsg
 }
 
 //...but swap accounting needs to disambiguate if it is a delivery for syncing or for retrieval
@@ -142,9 +321,11 @@ func (d *Delivery) handleChunkDeliveryMsg(ctx context.Context, sp *Peer, req int
 
 	var msg *ChunkDeliveryMsg
 	var mode chunk.ModePut
+	var retrieval bool
 	switch r := req.(type) {
 	case *ChunkDeliveryMsgRetrieval:
 		msg = (*ChunkDeliveryMsg)(r)
+		retrieval = true
 		peerPO := chunk.Proximity(sp.BzzAddr.Over(), msg.Addr)
 		po := chunk.Proximity(d.kad.BaseAddr(), msg.Addr)
 		depth := d.kad.NeighbourhoodDepth()
@@ -166,12 +347,55 @@ func (d *Delivery) handleChunkDeliveryMsg(ctx context.Context, sp *Peer, req int
 
 	log.Trace("handle.chunk.delivery", "ref", msg.Addr, "from peer", sp.ID())
 
+	// the chunk content is validated against its address
+	// by the netstore when the decompressed data is put
+	if msg.Compressed {
+		data, err := decompressChunkData(msg.SData, d.chunkSize)
+		if err != nil {
+			osp.Finish()
+			return err
+		}
+		msg.SData = data
+	}
+
+	d.notifyDelivery(sp.ID(), msg.Addr)
+
+	// allow new requests for the delivered chunk
+	if d.requests != nil {
+		id := sp.ID()
+		d.requests.Remove(requestKey(msg.Addr, nil))
+		d.requests.Remove(requestKey(msg.Addr, &id))
+	}
+
+	// retrieval and syncing deliveries are handled as the same message type
+	StreamCounter("delivery", "chunks.received").Inc(1)
+	StreamCounter("delivery", "bytes.received").Inc(int64(len(msg.SData)))
+
+	// the delivering peer is recorded as the source of the chunk
+	// to be reported to the netstore fetcher
+	ctx = context.WithValue(ctx, "source", sp.ID().String())
+
 	go func() {
 		defer osp.Finish()
 
 		msg.peer = sp
+
+		// a chunk that is already stored, received from another stream
+		// or by retrieval, does not need to be validated and put again
+		if has, err := d.netStore.Has(ctx, msg.Addr); err == nil && has {
+			StreamCounter("delivery", "chunks.duplicate").Inc(1)
+			d.unmarkWanted(msg.Addr)
+			log.Trace("handle.chunk.delivery", "duplicate", msg.Addr)
+			return
+		}
+
 		log.Trace("handle.chunk.delivery", "put", msg.Addr)
 		_, err := d.netStore.Put(ctx, mode, storage.NewChunk(msg.Addr, msg.SData))
+		// the chunk is either stored or it can be wanted from other peers
+		d.unmarkWanted(msg.Addr)
+		if retrieval && (err == nil || err == storage.ErrChunkInvalid) {
+			d.scoreDelivery(msg.Addr, sp.ID(), err == nil)
+		}
 		if err != nil {
 			if err == storage.ErrChunkInvalid {
 				// we removed this log because it spams the logs
@@ -185,15 +409,151 @@ func (d *Delivery) handleChunkDeliveryMsg(ctx context.Context, sp *Peer, req int
 	return nil
 }
 
+// decompressChunkData returns decompressed chunk data from a delivery
+// message, rejecting data that decompresses to more than a chunk of
+// chunkSize with its span.
+func decompressChunkData(data []byte, chunkSize int) ([]byte, error) {
+	n, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+	if n > chunkSize+8 {
+		return nil, fmt.Errorf("decompressed chunk data length %v exceeds the maximal chunk size", n)
+	}
+	return snappy.Decode(nil, data)
+}
+
 func (d *Delivery) Close() {
 	close(d.quit)
 }
 
+// preferredPeerKey is the context key for the peer that
+// is requested first by RequestFromPeers.
+type preferredPeerKey struct{}
+
+// WithPreferredPeer returns a context with a hint to RequestFromPeers to send
+// the retrieve request to the peer with the provided id before falling back
+// to other peers, for example when it is known that the peer has the chunk.
+// The hint is ignored if the peer is not connected.
+func WithPreferredPeer(ctx context.Context, id enode.ID) context.Context {
+	return context.WithValue(ctx, preferredPeerKey{}, id)
+}
+
+// preferredPeer returns the peer id set by WithPreferredPeer.
+func preferredPeer(ctx context.Context) (id enode.ID, ok bool) {
+	id, ok = ctx.Value(preferredPeerKey{}).(enode.ID)
+	return id, ok
+}
+
+// requestPriorityKey is the context key for the priority
+// of retrieve requests sent by RequestFromPeers.
+type requestPriorityKey struct{}
+
+// WithRequestPriority returns a context that sets the priority with which
+// RequestFromPeers sends retrieve requests to the peer outgoing queue.
+// Interactive reads should use Top, the default, so that they are sent
+// before requests for background retrievals with lower priority.
+func WithRequestPriority(ctx context.Context, priority uint8) context.Context {
+	return context.WithValue(ctx, requestPriorityKey{}, priority)
+}
+
+// requestPriority returns the priority set by WithRequestPriority,
+// or Top if it is not set or it is not valid.
+func requestPriority(ctx context.Context) uint8 {
+	priority, ok := ctx.Value(requestPriorityKey{}).(uint8)
+	if !ok || priority > Top {
+		return Top
+	}
+	return priority
+}
+
 // RequestFromPeers sends a chunk retrieve request to a peer
-// The most eligible peer that hasn't already been sent to is chosen
+// with the priority from the context set by WithRequestPriority.
+// The preferred peer from the context is chosen if it is connected,
+// otherwise the Delivery PeerSelector chooses among eligible peers
+// that haven't already been sent to.
+// Peers in skipPeers are not selected, unless the request has a source.
+// Calls for the same chunk address and source are coalesced if the request
+// cache is enabled, and all callers get the result of a single retrieve
+// request. If that request is aborted by the context of its caller, other
+// callers send the request again.
+// Requests with peers to skip are retries and they are never coalesced.
 // TODO: define "eligible"
-func (d *Delivery) RequestFromPeers(ctx context.Context, req *network.Request) (*enode.ID, chan struct{}, error) {
+func (d *Delivery) RequestFromPeers(ctx context.Context, req *network.Request, skipPeers ...enode.ID) (*enode.ID, chan struct{}, error) {
 	requestFromPeersCount.Inc(1)
+	if d.requests == nil || len(skipPeers) > 0 {
+		return d.requestFromPeers(ctx, req, skipPeers...)
+	}
+
+	key := requestKey(req.Addr, req.Source)
+	for {
+		d.requestsMu.Lock()
+		if v, ok := d.requests.Get(key); ok {
+			if r := v.(*cachedRequest); time.Now().Before(r.expires) {
+				d.requestsMu.Unlock()
+				requestFromPeersCoalesced.Inc(1)
+				select {
+				case <-r.done:
+				case <-ctx.Done():
+					return nil, nil, ctx.Err()
+				}
+				if r.cancelled {
+					// the request was aborted by the context of
+					// its caller, not by a failure to send it
+					continue
+				}
+				return r.id, r.quit, r.err
+			}
+		}
+		r := &cachedRequest{
+			done:    make(chan struct{}),
+			expires: time.Now().Add(d.requestCacheTTL),
+		}
+		d.requests.Add(key, r)
+		d.requestsMu.Unlock()
+
+		r.id, r.quit, r.err = d.requestFromPeers(ctx, req)
+		if r.err != nil {
+			r.cancelled = ctx.Err() != nil
+			// do not keep failed requests, so that they can be retried
+			d.requestsMu.Lock()
+			if v, ok := d.requests.Peek(key); ok && v == r {
+				d.requests.Remove(key)
+			}
+			d.requestsMu.Unlock()
+		}
+		close(r.done)
+		return r.id, r.quit, r.err
+	}
+}
+
+// requestKey returns the key of the request cache for a chunk address
+// and the explicit source of the request, which can be nil.
+func requestKey(addr storage.Address, source *enode.ID) string {
+	if source == nil {
+		return string(addr)
+	}
+	return string(addr) + string(source.Bytes())
+}
+
+// requestFromPeers selects a peer and sends it a retrieve request.
+func (d *Delivery) requestFromPeers(ctx context.Context, req *network.Request, skipPeers ...enode.ID) (*enode.ID, chan struct{}, error) {
+	// do not send the request if the caller is no longer waiting for it
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	// the peer requested last time is skipped if it failed to deliver
+	if d.requested != nil && len(skipPeers) > 0 {
+		if v, ok := d.requested.Peek(string(req.Addr)); ok {
+			for _, id := range skipPeers {
+				if id == v.(enode.ID) {
+					d.scoreDelivery(req.Addr, id, false)
+					break
+				}
+			}
+		}
+	}
+
 	var sp *Peer
 	spID := req.Source
 
@@ -202,7 +562,11 @@ func (d *Delivery) RequestFromPeers(ctx context.Context, req *network.Request) (
 		if sp == nil {
 			return nil, nil, fmt.Errorf("source peer %v not found", spID.String())
 		}
+	} else if id, ok := preferredPeer(ctx); ok && d.isEligiblePeer(id, req, skipPeers) {
+		sp = d.getPeer(id)
+		spID = &id
 	} else {
+		var peers []*network.Peer
 		d.kad.EachConn(req.Addr[:], 255, func(p *network.Peer, po int) bool {
 			id := p.ID()
 			if p.LightNode {
@@ -213,14 +577,39 @@ func (d *Delivery) RequestFromPeers(ctx context.Context, req *network.Request) (
 				log.Trace("Delivery.RequestFromPeers: skip peer", "peer id", id)
 				return true
 			}
-			sp = d.getPeer(id)
-			// sp is nil, when we encounter a peer that is not registered for delivery, i.e. doesn't support the `stream` protocol
-			if sp == nil {
+			for _, skipID := range skipPeers {
+				if id == skipID {
+					log.Trace("Delivery.RequestFromPeers: skip failed peer", "peer id", id)
+					return true
+				}
+			}
+			// skip peers that are not registered for delivery, i.e. don't support the `stream` protocol
+			if d.getPeer(id) == nil {
 				return true
 			}
-			spID = &id
-			return false
+			peers = append(peers, p)
+			return true
 		})
+		// circuit breakers are checked only for selected peers,
+		// as a half-open breaker allows a single probe request
+		for len(peers) > 0 {
+			p := d.peerSelector.Select(req.Addr, peers)
+			if p == nil {
+				break
+			}
+			id := p.ID()
+			if sp = d.getPeer(id); sp != nil && d.breakerAllows(id) {
+				spID = &id
+				break
+			}
+			log.Trace("Delivery.RequestFromPeers: skip peer with open circuit breaker", "peer id", id)
+			sp = nil
+			var ok bool
+			if peers, ok = removePeer(peers, p); !ok {
+				// the selector returned a peer that is not eligible
+				break
+			}
+		}
 		if sp == nil {
 			return nil, nil, errors.New("no peer found")
 		}
@@ -235,11 +624,66 @@ func (d *Delivery) RequestFromPeers(ctx context.Context, req *network.Request) (
 		Addr:      req.Addr,
 		SkipCheck: req.SkipCheck,
 		HopCount:  req.HopCount,
-	}, Top)
+	}, requestPriority(ctx))
 	if err != nil {
 		return nil, nil, err
 	}
 	requestFromPeersEachCount.Inc(1)
+	if d.requested != nil {
+		d.requested.Add(string(req.Addr), *spID)
+	}
 
 	return spID, sp.quit, nil
 }
+
+// removePeer returns peers without the provided peer, preserving the order,
+// and false if the peer is not found.
+func removePeer(peers []*network.Peer, peer *network.Peer) ([]*network.Peer, bool) {
+	for i, p := range peers {
+		if p == peer {
+			return append(peers[:i], peers[i+1:]...), true
+		}
+	}
+	return peers, false
+}
+
+// scoreDelivery records a successful or failed delivery in the Kademlia
+// PeerScore and the peer circuit breaker, if the peer is the last one
+// requested to deliver the chunk. Every retrieve request is scored only once.
+func (d *Delivery) scoreDelivery(addr storage.Address, id enode.ID, success bool) {
+	if d.requested == nil {
+		return
+	}
+	key := string(addr)
+	d.requestedMu.Lock()
+	if v, ok := d.requested.Peek(key); !ok || v.(enode.ID) != id {
+		d.requestedMu.Unlock()
+		return
+	}
+	d.requested.Remove(key)
+	d.requestedMu.Unlock()
+	d.recordBreaker(id, success)
+	if d.kad.PeerScore == nil {
+		return
+	}
+	if success {
+		d.kad.PeerScore.Success(id)
+	} else {
+		d.kad.PeerScore.Failure(id)
+	}
+}
+
+// isEligiblePeer returns true if the peer with the provided id is connected
+// and can be sent a retrieve request.
+func (d *Delivery) isEligiblePeer(id enode.ID, req *network.Request, skipPeers []enode.ID) bool {
+	sp := d.getPeer(id)
+	if sp == nil || sp.LightNode || req.SkipPeer(id.String()) {
+		return false
+	}
+	for _, skipID := range skipPeers {
+		if id == skipID {
+			return false
+		}
+	}
+	return d.breakerAllows(id)
+}
@@ -19,12 +19,17 @@ package stream
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/enode"
@@ -36,9 +41,11 @@ import (
 	"github.com/ethersphere/swarm/network"
 	pq "github.com/ethersphere/swarm/network/priorityqueue"
 	"github.com/ethersphere/swarm/network/simulation"
+	"github.com/ethersphere/swarm/pot"
 	"github.com/ethersphere/swarm/state"
 	"github.com/ethersphere/swarm/storage"
 	"github.com/ethersphere/swarm/testutil"
+	"github.com/golang/snappy"
 )
 
 //Test requesting a chunk from a peer then issuing a "empty" OfferedHashesMsg (no hashes available yet)
@@ -144,7 +151,7 @@ func TestRequestFromPeers(t *testing.T) {
 
 	addr := network.RandomAddr()
 	to := network.NewKademlia(addr.OAddr, network.NewKadParams())
-	delivery := NewDelivery(to, nil)
+	delivery := NewDelivery(to, nil, nil)
 	protocolsPeer := protocols.NewPeer(p2p.NewPeer(dummyPeerID, "dummy", nil), nil, nil)
 	peer := network.NewPeer(&network.BzzPeer{
 		BzzAddr:   network.RandomAddr(),
@@ -177,13 +184,404 @@ func TestRequestFromPeers(t *testing.T) {
 	}
 }
 
+// RequestFromPeers should not return peers that are provided as peers to skip
+// and fall back to the next eligible peer
+func TestRequestFromPeersSkipPeers(t *testing.T) {
+	addr := network.RandomAddr()
+	to := network.NewKademlia(addr.OAddr, network.NewKadParams())
+	delivery := NewDelivery(to, nil, nil)
+	r := NewRegistry(addr.ID(), delivery, nil, nil, nil, nil)
+
+	peerIDs := []enode.ID{
+		enode.HexID("3431c3939e1ee2a6345e976a8234f9870152d64879f30bc272a074f6859e75e8"),
+		enode.HexID("99d8594b52298567d2ca3f4c441a5ba0140ee9245e26460d01102a52773c73b9"),
+	}
+	for _, id := range peerIDs {
+		protocolsPeer := protocols.NewPeer(p2p.NewPeer(id, "dummy", nil), nil, nil)
+		to.On(network.NewPeer(&network.BzzPeer{
+			BzzAddr:   network.RandomAddr(),
+			LightNode: false,
+			Peer:      protocolsPeer,
+		}, to))
+		// an empty priorityQueue has to be created to prevent a goroutine being called after the test has finished
+		r.setPeer(&Peer{
+			BzzPeer:  &network.BzzPeer{Peer: protocolsPeer, BzzAddr: addr},
+			pq:       pq.New(int(PriorityQueue), PriorityQueueCap),
+			streamer: r,
+		})
+	}
+
+	ctx := context.Background()
+	newRequest := func() *network.Request {
+		return network.NewRequest(storage.Address(hash0[:]), true, &sync.Map{})
+	}
+
+	// the first peer is requested from and it fails to deliver
+	failedID, _, err := delivery.RequestFromPeers(ctx, newRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, _, err := delivery.RequestFromPeers(ctx, newRequest(), *failedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *id == *failedID {
+		t.Fatalf("expected request to a different peer then %v", failedID)
+	}
+
+	_, _, err = delivery.RequestFromPeers(ctx, newRequest(), peerIDs...)
+	if err == nil || err.Error() != "no peer found" {
+		t.Fatalf("expected 'no peer found' error, got %v", err)
+	}
+}
+
+// RequestFromPeers should send the request to the peer set with
+// WithPreferredPeer and ignore the hint if the peer is not connected
+func TestRequestFromPeersPreferredPeer(t *testing.T) {
+	addr := network.RandomAddr()
+	to := network.NewKademlia(addr.OAddr, network.NewKadParams())
+	delivery := NewDelivery(to, nil, nil)
+	r := NewRegistry(addr.ID(), delivery, nil, nil, nil, nil)
+
+	peerIDs := []enode.ID{
+		enode.HexID("3431c3939e1ee2a6345e976a8234f9870152d64879f30bc272a074f6859e75e8"),
+		enode.HexID("99d8594b52298567d2ca3f4c441a5ba0140ee9245e26460d01102a52773c73b9"),
+		enode.HexID("c57f35b5a5c27cbd9e0bbc0a7b06bd1a1e1ce1d81b2b79fa20cba1f6e3c6e5a0"),
+	}
+	peers := make(map[enode.ID]*Peer)
+	for _, id := range peerIDs {
+		protocolsPeer := protocols.NewPeer(p2p.NewPeer(id, "dummy", nil), nil, nil)
+		to.On(network.NewPeer(&network.BzzPeer{
+			BzzAddr:   network.RandomAddr(),
+			LightNode: false,
+			Peer:      protocolsPeer,
+		}, to))
+		// the priority queue is not run, so that sent messages stay in it
+		sp := &Peer{
+			BzzPeer:  &network.BzzPeer{Peer: protocolsPeer, BzzAddr: addr},
+			pq:       pq.New(int(PriorityQueue), PriorityQueueCap),
+			streamer: r,
+		}
+		r.setPeer(sp)
+		peers[id] = sp
+	}
+
+	newRequest := func() *network.Request {
+		return network.NewRequest(storage.Address(hash0[:]), true, &sync.Map{})
+	}
+
+	for _, preferredID := range peerIDs {
+		ctx := WithPreferredPeer(context.Background(), preferredID)
+		want := len(peers[preferredID].pq.Queues[Top]) + 1
+
+		id, _, err := delivery.RequestFromPeers(ctx, newRequest())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *id != preferredID {
+			t.Errorf("got peer %v, want preferred peer %v", id, preferredID)
+		}
+		if n := len(peers[preferredID].pq.Queues[Top]); n != want {
+			t.Errorf("got %v retrieve requests sent to the preferred peer %v, want %v", n, preferredID, want)
+		}
+	}
+
+	// a request with not connected preferred peer is sent to another peer
+	ctx := WithPreferredPeer(context.Background(), enode.HexID("0000000000000000000000000000000000000000000000000000000000000001"))
+	id, _, err := delivery.RequestFromPeers(ctx, newRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := peers[*id]; !ok {
+		t.Errorf("got request to unknown peer %v", id)
+	}
+}
+
+// TestRequestFromPeersPeerScore validates that Delivery records failed
+// and successful deliveries of requested peers in Kademlia PeerScore.
+func TestRequestFromPeersPeerScore(t *testing.T) {
+	addr := network.RandomAddr()
+	params := network.NewKadParams()
+	params.PeerScore = network.NewPeerScore()
+	to := network.NewKademlia(addr.OAddr, params)
+	delivery := NewDelivery(to, nil, nil)
+	r := NewRegistry(addr.ID(), delivery, nil, nil, nil, nil)
+
+	peerIDs := []enode.ID{
+		enode.HexID("3431c3939e1ee2a6345e976a8234f9870152d64879f30bc272a074f6859e75e8"),
+		enode.HexID("99d8594b52298567d2ca3f4c441a5ba0140ee9245e26460d01102a52773c73b9"),
+	}
+	for _, id := range peerIDs {
+		protocolsPeer := protocols.NewPeer(p2p.NewPeer(id, "dummy", nil), nil, nil)
+		to.On(network.NewPeer(&network.BzzPeer{
+			BzzAddr:   network.RandomAddr(),
+			LightNode: false,
+			Peer:      protocolsPeer,
+		}, to))
+		// the priority queue is not run, so that sent messages stay in it
+		r.setPeer(&Peer{
+			BzzPeer:  &network.BzzPeer{Peer: protocolsPeer, BzzAddr: addr},
+			pq:       pq.New(int(PriorityQueue), PriorityQueueCap),
+			streamer: r,
+		})
+	}
+
+	newRequest := func() *network.Request {
+		return network.NewRequest(storage.Address(hash0[:]), true, &sync.Map{})
+	}
+
+	failedID, _, err := delivery.RequestFromPeers(context.Background(), newRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a retry skipping the requested peer marks its request as failed
+	id, _, err := delivery.RequestFromPeers(context.Background(), newRequest(), *failedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *id == *failedID {
+		t.Fatalf("got request to the skipped peer %v", id)
+	}
+	// delivery from a peer that was not requested is not scored
+	delivery.scoreDelivery(storage.Address(hash0[:]), *failedID, true)
+	// delivery from the requested peer
+	delivery.scoreDelivery(storage.Address(hash0[:]), *id, true)
+	// the failed request is not scored again
+	if _, _, err := delivery.RequestFromPeers(context.Background(), newRequest(), *failedID); err != nil {
+		t.Fatal(err)
+	}
+
+	scores := params.PeerScore.Scores()
+	if s := scores[*failedID]; s.Successes != 0 || s.Failures != 1 {
+		t.Errorf("got failed peer score %+v, want 1 failure", s)
+	}
+	if s := scores[*id]; s.Successes != 1 || s.Failures != 0 {
+		t.Errorf("got peer score %+v, want 1 success", s)
+	}
+}
+
+// TestRequestFromPeersCircuitBreaker validates that a peer is skipped by
+// RequestFromPeers after repeated failed requests until the breaker
+// cooldown passes, when a single request probes it, and that a successful
+// delivery closes the breaker.
+func TestRequestFromPeersCircuitBreaker(t *testing.T) {
+	const cooldown = 200 * time.Millisecond
+
+	addr := network.RandomAddr()
+	to := network.NewKademlia(addr.OAddr, network.NewKadParams())
+	delivery := NewDelivery(to, nil, &DeliveryOptions{
+		BreakerThreshold: 2,
+		BreakerCooldown:  cooldown,
+	})
+	r := NewRegistry(addr.ID(), delivery, nil, nil, nil, nil)
+
+	peerIDs := []enode.ID{
+		enode.HexID("3431c3939e1ee2a6345e976a8234f9870152d64879f30bc272a074f6859e75e8"),
+		enode.HexID("99d8594b52298567d2ca3f4c441a5ba0140ee9245e26460d01102a52773c73b9"),
+	}
+	for _, id := range peerIDs {
+		protocolsPeer := protocols.NewPeer(p2p.NewPeer(id, "dummy", nil), nil, nil)
+		to.On(network.NewPeer(&network.BzzPeer{
+			BzzAddr:   network.RandomAddr(),
+			LightNode: false,
+			Peer:      protocolsPeer,
+		}, to))
+		// the priority queue is not run, so that sent messages stay in it
+		r.setPeer(&Peer{
+			BzzPeer:  &network.BzzPeer{Peer: protocolsPeer, BzzAddr: addr},
+			pq:       pq.New(int(PriorityQueue), PriorityQueueCap),
+			streamer: r,
+		})
+	}
+
+	request := func(hash [32]byte, skipPeers ...enode.ID) enode.ID {
+		t.Helper()
+		req := network.NewRequest(storage.Address(hash[:]), true, &sync.Map{})
+		id, _, err := delivery.RequestFromPeers(context.Background(), req, skipPeers...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *id
+	}
+
+	// the closest peer is selected while its breaker is closed
+	failing := request(hash0)
+	var other enode.ID
+	for i := 0; i < 2; i++ {
+		if id := request(hash0); id != failing {
+			t.Fatalf("request %v: got peer %v, want %v", i, id, failing)
+		}
+		// a retry skipping the requested peer records a failure
+		other = request(hash0, failing)
+	}
+
+	// the breaker is open
+	for i := 0; i < 3; i++ {
+		if id := request(hash0); id != other {
+			t.Fatalf("request %v with open breaker: got peer %v, want %v", i, id, other)
+		}
+	}
+
+	time.Sleep(cooldown)
+
+	// a single request probes the peer after the cooldown
+	if id := request(hash0); id != failing {
+		t.Fatalf("got probe request to peer %v, want %v", id, failing)
+	}
+	if delivery.breakerAllows(failing) {
+		t.Fatal("breaker allows requests while probing")
+	}
+
+	// a delivery of the probed chunk closes the breaker
+	delivery.scoreDelivery(storage.Address(hash0[:]), failing, true)
+	if id := request(hash0); id != failing {
+		t.Fatalf("got request with closed breaker to peer %v, want %v", id, failing)
+	}
+}
+
+// RequestFromPeers should send a single retrieve request for concurrent
+// calls for the same chunk when the request cache is enabled
+func TestRequestFromPeersCoalesced(t *testing.T) {
+	dummyPeerID := enode.HexID("3431c3939e1ee2a6345e976a8234f9870152d64879f30bc272a074f6859e75e8")
+
+	addr := network.RandomAddr()
+	to := network.NewKademlia(addr.OAddr, network.NewKadParams())
+	delivery := NewDelivery(to, nil, &DeliveryOptions{
+		RequestCacheTTL: time.Minute,
+	})
+	protocolsPeer := protocols.NewPeer(p2p.NewPeer(dummyPeerID, "dummy", nil), nil, nil)
+	to.On(network.NewPeer(&network.BzzPeer{
+		BzzAddr:   network.RandomAddr(),
+		LightNode: false,
+		Peer:      protocolsPeer,
+	}, to))
+	r := NewRegistry(addr.ID(), delivery, nil, nil, nil, nil)
+
+	// the priority queue is not run, so that sent messages stay in it
+	sp := &Peer{
+		BzzPeer:  &network.BzzPeer{Peer: protocolsPeer, BzzAddr: addr},
+		pq:       pq.New(int(PriorityQueue), PriorityQueueCap),
+		streamer: r,
+	}
+	r.setPeer(sp)
+
+	count := 100
+	errC := make(chan error, count)
+	for i := 0; i < count; i++ {
+		go func() {
+			req := network.NewRequest(storage.Address(hash0[:]), true, &sync.Map{})
+			id, _, err := delivery.RequestFromPeers(context.Background(), req)
+			if err == nil && *id != dummyPeerID {
+				err = fmt.Errorf("got peer %v, want %v", id, dummyPeerID)
+			}
+			errC <- err
+		}()
+	}
+	for i := 0; i < count; i++ {
+		if err := <-errC; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := len(sp.pq.Queues[Top]); n != 1 {
+		t.Fatalf("got %v sent retrieve requests, want 1", n)
+	}
+
+	// requests with an explicit source are not coalesced with requests without it
+	req := network.NewRequest(storage.Address(hash0[:]), true, &sync.Map{})
+	req.Source = &dummyPeerID
+	if _, _, err := delivery.RequestFromPeers(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(sp.pq.Queues[Top]); n != 2 {
+		t.Fatalf("got %v sent retrieve requests, want 2", n)
+	}
+
+	// requests cancelled by the caller context are not cached
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req = network.NewRequest(storage.Address(hash1[:]), true, &sync.Map{})
+	if _, _, err := delivery.RequestFromPeers(ctx, req); err != context.Canceled {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	if _, _, err := delivery.RequestFromPeers(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(sp.pq.Queues[Top]); n != 3 {
+		t.Fatalf("got %v sent retrieve requests, want 3", n)
+	}
+}
+
+// RequestFromPeers should send retrieve requests with the priority set by
+// WithRequestPriority, so that a high priority request is dispatched
+// before previously queued low priority requests
+func TestRequestFromPeersPriority(t *testing.T) {
+	dummyPeerID := enode.HexID("3431c3939e1ee2a6345e976a8234f9870152d64879f30bc272a074f6859e75e8")
+
+	addr := network.RandomAddr()
+	to := network.NewKademlia(addr.OAddr, network.NewKadParams())
+	delivery := NewDelivery(to, nil, nil)
+	protocolsPeer := protocols.NewPeer(p2p.NewPeer(dummyPeerID, "dummy", nil), nil, nil)
+	to.On(network.NewPeer(&network.BzzPeer{
+		BzzAddr:   network.RandomAddr(),
+		LightNode: false,
+		Peer:      protocolsPeer,
+	}, to))
+	r := NewRegistry(addr.ID(), delivery, nil, nil, nil, nil)
+
+	// the priority queue is run only after all requests are sent
+	sp := &Peer{
+		BzzPeer:  &network.BzzPeer{Peer: protocolsPeer, BzzAddr: addr},
+		pq:       pq.New(int(PriorityQueue), PriorityQueueCap),
+		streamer: r,
+	}
+	r.setPeer(sp)
+
+	lowCtx := WithRequestPriority(context.Background(), Low)
+	for i := 0; i < 100; i++ {
+		req := network.NewRequest(storage.Address(testutil.RandomBytes(i, 32)), true, &sync.Map{})
+		if _, _, err := delivery.RequestFromPeers(lowCtx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	highAddr := storage.Address(hash0[:])
+	req := network.NewRequest(highAddr, true, &sync.Map{})
+	if _, _, err := delivery.RequestFromPeers(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(sp.pq.Queues[Low]); n != 100 {
+		t.Fatalf("got %v low priority requests, want 100", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	msgC := make(chan *RetrieveRequestMsg, 1)
+	go sp.pq.Run(ctx, func(i interface{}) {
+		select {
+		case msgC <- i.(WrappedPriorityMsg).Msg.(*RetrieveRequestMsg):
+		case <-ctx.Done():
+		}
+	})
+
+	select {
+	case msg := <-msgC:
+		if !bytes.Equal(msg.Addr, highAddr) {
+			t.Fatalf("got first request for %v, want %v", msg.Addr, highAddr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for request dispatch")
+	}
+}
+
 // RequestFromPeers should not return light nodes
 func TestRequestFromPeersWithLightNode(t *testing.T) {
 	dummyPeerID := enode.HexID("3431c3939e1ee2a6345e976a8234f9870152d64879f30bc272a074f6859e75e8")
 
 	addr := network.RandomAddr()
 	to := network.NewKademlia(addr.OAddr, network.NewKadParams())
-	delivery := NewDelivery(to, nil)
+	delivery := NewDelivery(to, nil, nil)
 
 	protocolsPeer := protocols.NewPeer(p2p.NewPeer(dummyPeerID, "dummy", nil), nil, nil)
 	// setting up a lightnode
@@ -202,104 +600,1082 @@ func TestRequestFromPeersWithLightNode(t *testing.T) {
 	}
 	r.setPeer(sp)
 
-	req := network.NewRequest(
-		storage.Address(hash0[:]),
-		true,
-		&sync.Map{},
-	)
+	req := network.NewRequest(
+		storage.Address(hash0[:]),
+		true,
+		&sync.Map{},
+	)
+
+	ctx := context.Background()
+	// making a request which should return with "no peer found"
+	_, _, err := delivery.RequestFromPeers(ctx, req)
+
+	expectedError := "no peer found"
+	if err.Error() != expectedError {
+		t.Fatalf("expected '%v', got %v", expectedError, err)
+	}
+}
+
+func TestStreamerDownstreamChunkDeliveryMsgExchange(t *testing.T) {
+	tester, streamer, localStore, teardown, err := newStreamerTester(&RegistryOptions{
+		Syncing: SyncingDisabled,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	streamer.RegisterClientFunc("foo", func(p *Peer, t string, live bool) (Client, error) {
+		return &testClient{
+			t: t,
+		}, nil
+	})
+
+	node := tester.Nodes[0]
+
+	//subscribe to custom stream
+	stream := NewStream("foo", "", true)
+	err = streamer.Subscribe(node.ID(), stream, NewRange(5, 8), Top)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	chunkKey := hash0[:]
+	chunkData := hash1[:]
+
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "Subscribe message",
+		Expects: []p2ptest.Expect{
+			{ //first expect subscription to the custom stream...
+				Code: 4,
+				Msg: &SubscribeMsg{
+					Stream:   stream,
+					History:  NewRange(5, 8),
+					Priority: Top,
+				},
+				Peer: node.ID(),
+			},
+		},
+	},
+		p2ptest.Exchange{
+			Label: "ChunkDelivery message",
+			Triggers: []p2ptest.Trigger{
+				{ //...then trigger a chunk delivery for the given chunk from peer in order for
+					//local node to get the chunk delivered
+					Code: 6,
+					Msg: &ChunkDeliveryMsg{
+						Addr:  chunkKey,
+						SData: chunkData,
+					},
+					Peer: node.ID(),
+				},
+			},
+		})
+
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// wait for the chunk to get stored
+	storedChunk, err := localStore.Get(ctx, chunk.ModeGetRequest, chunkKey)
+	for err != nil {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("Chunk is not in localstore after timeout, err: %v", err)
+		default:
+		}
+		storedChunk, err = localStore.Get(ctx, chunk.ModeGetRequest, chunkKey)
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if !bytes.Equal(storedChunk.Data(), chunkData) {
+		t.Fatal("Retrieved chunk has different data than original")
+	}
+
+}
+
+// TestStreamCountersReceived validates that chunks and bytes received
+// counters are incremented on chunk delivery.
+func TestStreamCountersReceived(t *testing.T) {
+	defer func(enabled bool) { metrics.Enabled = enabled }(metrics.Enabled)
+	metrics.Enabled = true
+
+	tester, _, _, teardown, err := newStreamerTester(&RegistryOptions{
+		Syncing: SyncingDisabled,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	// counters may be already registered as no-op by other tests
+	metrics.DefaultRegistry.Unregister("swarm/stream/delivery/chunks.received")
+	metrics.DefaultRegistry.Unregister("swarm/stream/delivery/bytes.received")
+
+	chunksReceived := StreamCounter("delivery", "chunks.received")
+	bytesReceived := StreamCounter("delivery", "bytes.received")
+	chunksReceivedBefore, bytesReceivedBefore := chunksReceived.Count(), bytesReceived.Count()
+
+	chunkData := hash1[:]
+
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "ChunkDelivery message",
+		Triggers: []p2ptest.Trigger{
+			{
+				Code: 6,
+				Msg: &ChunkDeliveryMsg{
+					Addr:  hash0[:],
+					SData: chunkData,
+				},
+				Peer: tester.Nodes[0].ID(),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100 && chunksReceived.Count() == chunksReceivedBefore; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := chunksReceived.Count() - chunksReceivedBefore; got != 1 {
+		t.Errorf("got %v chunks received, want 1", got)
+	}
+	if got := bytesReceived.Count() - bytesReceivedBefore; got != int64(len(chunkData)) {
+		t.Errorf("got %v bytes received, want %v", got, len(chunkData))
+	}
+}
+
+// TestDeliveryDuplicate delivers the same chunk twice and validates
+// that the second delivery is counted as a duplicate and that the
+// chunk is not put to the store again.
+func TestDeliveryDuplicate(t *testing.T) {
+	defer func(enabled bool) { metrics.Enabled = enabled }(metrics.Enabled)
+	metrics.Enabled = true
+
+	tester, streamer, _, teardown, err := newStreamerTester(&RegistryOptions{
+		Syncing: SyncingDisabled,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	store := &countingPutStore{Store: streamer.delivery.netStore.Store}
+	streamer.delivery.netStore.Store = store
+
+	// counter may be already registered as no-op by other tests
+	metrics.DefaultRegistry.Unregister("swarm/stream/delivery/chunks.duplicate")
+
+	duplicates := StreamCounter("delivery", "chunks.duplicate")
+	duplicatesBefore := duplicates.Count()
+
+	deliver := func() {
+		t.Helper()
+		err := tester.TestExchanges(p2ptest.Exchange{
+			Label: "ChunkDelivery message",
+			Triggers: []p2ptest.Trigger{
+				{
+					Code: 6,
+					Msg: &ChunkDeliveryMsg{
+						Addr:  hash0[:],
+						SData: hash1[:],
+					},
+					Peer: tester.Nodes[0].ID(),
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliver()
+	for i := 0; i < 100 && atomic.LoadInt32(&store.puts) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&store.puts); got != 1 {
+		t.Fatalf("got %v puts after the first delivery, want 1", got)
+	}
+
+	deliver()
+	for i := 0; i < 100 && duplicates.Count() == duplicatesBefore; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := duplicates.Count() - duplicatesBefore; got != 1 {
+		t.Errorf("got %v duplicate chunks, want 1", got)
+	}
+	if got := atomic.LoadInt32(&store.puts); got != 1 {
+		t.Errorf("got %v puts after the second delivery, want 1", got)
+	}
+}
+
+// countingPutStore counts Put calls on the wrapped store.
+type countingPutStore struct {
+	chunk.Store
+	puts int32
+}
+
+func (s *countingPutStore) Put(ctx context.Context, mode chunk.ModePut, ch chunk.Chunk) (bool, error) {
+	atomic.AddInt32(&s.puts, 1)
+	return s.Store.Put(ctx, mode, ch)
+}
+
+// TestRetrieveRequestMessageLatency validates that a retrieve request
+// round trip between two nodes in a simulation with message latency
+// takes at least the latency in both directions.
+func TestRetrieveRequestMessageLatency(t *testing.T) {
+	const latency = 300 * time.Millisecond
+
+	sim := simulation.New(map[string]simulation.ServiceFunc{
+		"streamer": func(ctx *adapters.ServiceContext, bucket *sync.Map) (s node.Service, cleanup func(), err error) {
+			addr, netStore, delivery, clean, err := newNetStoreAndDelivery(ctx, bucket)
+			if err != nil {
+				return nil, nil, err
+			}
+			bucket.Store(bucketKeyNetStore, netStore)
+
+			r := NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &RegistryOptions{
+				Syncing: SyncingDisabled,
+			}, nil)
+
+			cleanup = func() {
+				r.Close()
+				clean()
+			}
+
+			return r, cleanup, nil
+		},
+	}, &simulation.Options{
+		MessageLatency: func(from, to enode.ID) time.Duration {
+			return latency
+		},
+	})
+	defer sim.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) error {
+		ids, err := sim.AddNodesAndConnectChain(2)
+		if err != nil {
+			return err
+		}
+		storer, requester := ids[0], ids[1]
+
+		// wait for the stream peer to be registered on the requester node
+		item, ok := sim.NodeItem(requester, bucketKeyDelivery)
+		if !ok {
+			return errors.New("no delivery")
+		}
+		delivery := item.(*Delivery)
+		for delivery.getPeer(storer) == nil {
+			select {
+			case <-time.After(50 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		ch := storage.GenerateRandomChunk(chunk.DefaultSize)
+		if err := sim.PutChunk(storer, ch); err != nil {
+			return err
+		}
+
+		item, ok = sim.NodeItem(requester, bucketKeyNetStore)
+		if !ok {
+			return errors.New("no netstore")
+		}
+		start := time.Now()
+		got, err := item.(*storage.NetStore).Get(ctx, chunk.ModeGetRequest, ch.Address())
+		if err != nil {
+			return err
+		}
+		if d := time.Since(start); d < 2*latency {
+			return fmt.Errorf("retrieve request round trip took %v, want at least %v", d, 2*latency)
+		}
+		if !bytes.Equal(got.Data(), ch.Data()) {
+			return errors.New("got invalid chunk data")
+		}
+		return nil
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+}
+
+// TestNetStorePrefetch validates that chunks prefetched by NetStore.Prefetch
+// are retrieved faster than chunks that are not prefetched, when messages
+// between nodes have latency.
+func TestNetStorePrefetch(t *testing.T) {
+	const latency = 100 * time.Millisecond
+
+	sim := simulation.New(map[string]simulation.ServiceFunc{
+		"streamer": func(ctx *adapters.ServiceContext, bucket *sync.Map) (s node.Service, cleanup func(), err error) {
+			addr, netStore, delivery, clean, err := newNetStoreAndDelivery(ctx, bucket)
+			if err != nil {
+				return nil, nil, err
+			}
+			bucket.Store(bucketKeyNetStore, netStore)
+
+			r := NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &RegistryOptions{
+				Syncing: SyncingDisabled,
+			}, nil)
+
+			cleanup = func() {
+				r.Close()
+				clean()
+			}
+
+			return r, cleanup, nil
+		},
+	}, &simulation.Options{
+		MessageLatency: func(from, to enode.ID) time.Duration {
+			return latency
+		},
+	})
+	defer sim.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) error {
+		ids, err := sim.AddNodesAndConnectChain(2)
+		if err != nil {
+			return err
+		}
+		storer, requester := ids[0], ids[1]
+
+		// wait for the stream peer to be registered on the requester node
+		item, ok := sim.NodeItem(requester, bucketKeyDelivery)
+		if !ok {
+			return errors.New("no delivery")
+		}
+		delivery := item.(*Delivery)
+		for delivery.getPeer(storer) == nil {
+			select {
+			case <-time.After(50 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		prefetched := storage.GenerateRandomChunks(chunk.DefaultSize, 3)
+		cold := storage.GenerateRandomChunks(chunk.DefaultSize, 3)
+		for _, ch := range append(prefetched, cold...) {
+			if err := sim.PutChunk(storer, ch); err != nil {
+				return err
+			}
+		}
+
+		item, ok = sim.NodeItem(requester, bucketKeyNetStore)
+		if !ok {
+			return errors.New("no netstore")
+		}
+		netStore := item.(*storage.NetStore)
+
+		addrs := make([]storage.Address, len(prefetched))
+		for i, ch := range prefetched {
+			addrs[i] = ch.Address()
+		}
+		netStore.Prefetch(ctx, addrs)
+
+		// wait for prefetched chunks to be stored
+		for _, addr := range addrs {
+			for {
+				has, err := netStore.Has(ctx, addr)
+				if err != nil {
+					return err
+				}
+				if has {
+					break
+				}
+				select {
+				case <-time.After(10 * time.Millisecond):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+
+		// getAll returns the time it takes to get all chunks one by one
+		getAll := func(chunks []storage.Chunk) (time.Duration, error) {
+			start := time.Now()
+			for _, ch := range chunks {
+				got, err := netStore.Get(ctx, chunk.ModeGetRequest, ch.Address())
+				if err != nil {
+					return 0, err
+				}
+				if !bytes.Equal(got.Data(), ch.Data()) {
+					return 0, errors.New("got invalid chunk data")
+				}
+			}
+			return time.Since(start), nil
+		}
+		warmDuration, err := getAll(prefetched)
+		if err != nil {
+			return err
+		}
+		coldDuration, err := getAll(cold)
+		if err != nil {
+			return err
+		}
+		if warmDuration >= coldDuration {
+			return fmt.Errorf("got prefetched chunks in %v, not faster than other chunks in %v", warmDuration, coldDuration)
+		}
+		if warmDuration >= latency {
+			return fmt.Errorf("got prefetched chunks in %v, want less than message latency %v", warmDuration, latency)
+		}
+		return nil
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+}
+
+// TestNetStoreGetWithInfo validates that NetStore.GetWithInfo reports
+// the peer that delivered a chunk that is not in the local store and
+// a local hit for chunks that are already stored locally.
+func TestNetStoreGetWithInfo(t *testing.T) {
+	sim := simulation.New(map[string]simulation.ServiceFunc{
+		"streamer": func(ctx *adapters.ServiceContext, bucket *sync.Map) (s node.Service, cleanup func(), err error) {
+			addr, netStore, delivery, clean, err := newNetStoreAndDelivery(ctx, bucket)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			r := NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &RegistryOptions{
+				SkipCheck: true,
+				Syncing:   SyncingDisabled,
+			}, nil)
+			bucket.Store(bucketKeyRegistry, r)
+
+			cleanup = func() {
+				r.Close()
+				clean()
+			}
+
+			return r, cleanup, nil
+		},
+	}, nil)
+	defer sim.Close()
+
+	_, err := sim.AddNodesAndConnectChain(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) error {
+		nodeIDs := sim.UpNodeIDs()
+		pivot, other := nodeIDs[0], nodeIDs[1]
+
+		item, ok := sim.NodeItem(pivot, bucketKeyDelivery)
+		if !ok {
+			return errors.New("no delivery")
+		}
+		netStore := item.(*Delivery).netStore
+
+		// chunk in the local store
+		local := storage.GenerateRandomChunk(chunk.DefaultSize)
+		if err := sim.PutChunk(pivot, local); err != nil {
+			return err
+		}
+		_, info, err := netStore.GetWithInfo(ctx, chunk.ModeGetRequest, local.Address())
+		if err != nil {
+			return err
+		}
+		if !info.Local || info.Peer != nil || info.PeersTried != 0 {
+			return fmt.Errorf("got info %+v for a local chunk, want a local hit", info)
+		}
+
+		// chunk in the store of the other node
+		remote := storage.GenerateRandomChunk(chunk.DefaultSize)
+		if err := sim.PutChunk(other, remote); err != nil {
+			return err
+		}
+		ch, info, err := netStore.GetWithInfo(ctx, chunk.ModeGetRequest, remote.Address())
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(ch.Data(), remote.Data()) {
+			return errors.New("got chunk data is not the same as put")
+		}
+		if info.Local {
+			return fmt.Errorf("got info %+v for a remote chunk, want not a local hit", info)
+		}
+		if info.Peer == nil || *info.Peer != other {
+			return fmt.Errorf("got info %+v, want peer %s", info, other)
+		}
+		if info.PeersTried < 1 {
+			return fmt.Errorf("got %v peers tried, want at least 1", info.PeersTried)
+		}
+
+		// retrieved chunk is stored locally
+		_, info, err = netStore.GetWithInfo(ctx, chunk.ModeGetRequest, remote.Address())
+		if err != nil {
+			return err
+		}
+		if !info.Local || info.Peer != nil {
+			return fmt.Errorf("got info %+v for a retrieved chunk, want a local hit", info)
+		}
+		return nil
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+}
+
+// TestDeliveryFromSeededStore seeds the store of a single node with
+// a chunk before nodes are connected and validates that the chunk is
+// retrieved from that node by another node over the network.
+func TestDeliveryFromSeededStore(t *testing.T) {
+	sim := simulation.New(map[string]simulation.ServiceFunc{
+		"streamer": func(ctx *adapters.ServiceContext, bucket *sync.Map) (s node.Service, cleanup func(), err error) {
+			addr, netStore, delivery, clean, err := newNetStoreAndDelivery(ctx, bucket)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			r := NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &RegistryOptions{
+				SkipCheck: true,
+				Syncing:   SyncingDisabled,
+			}, nil)
+			bucket.Store(bucketKeyRegistry, r)
+
+			cleanup = func() {
+				r.Close()
+				clean()
+			}
+
+			return r, cleanup, nil
+		},
+	}, nil)
+	defer sim.Close()
+
+	ch := storage.GenerateRandomChunk(chunk.DefaultSize)
+
+	var (
+		seeded  enode.ID
+		seedErr error
+	)
+	ids, err := sim.AddNodesWithStore(2, func(id enode.ID, store chunk.Store) {
+		if seeded != (enode.ID{}) {
+			return
+		}
+		seeded = id
+		_, seedErr = store.Put(context.Background(), chunk.ModePutUpload, ch)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seedErr != nil {
+		t.Fatal(seedErr)
+	}
+	if err := sim.Net.ConnectNodesChain(ids); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) error {
+		other := ids[1]
+		if other == seeded {
+			other = ids[0]
+		}
+
+		if _, err := sim.GetChunk(other, ch.Address()); err != chunk.ErrChunkNotFound {
+			return fmt.Errorf("got error %v for a chunk not in the store, want %v", err, chunk.ErrChunkNotFound)
+		}
+
+		item, ok := sim.NodeItem(other, bucketKeyDelivery)
+		if !ok {
+			return errors.New("no delivery")
+		}
+		netStore := item.(*Delivery).netStore
+
+		got, err := netStore.Get(ctx, chunk.ModeGetRequest, ch.Address())
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got.Data(), ch.Data()) {
+			return errors.New("got chunk data is not the same as seeded")
+		}
+		return nil
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+}
+
+// TestDeliveryPushSync uploads a chunk with push-sync replication
+// of three and validates that the upload returns after signed receipts
+// are received from the three nodes closest to the chunk, which are
+// responsible for it, and that they stored it.
+func TestDeliveryPushSync(t *testing.T) {
+	nodeCount := 6
+
+	sim := simulation.New(map[string]simulation.ServiceFunc{
+		"streamer": func(ctx *adapters.ServiceContext, bucket *sync.Map) (s node.Service, cleanup func(), err error) {
+			addr, netStore, delivery, clean, err := newNetStoreAndDelivery(ctx, bucket)
+			if err != nil {
+				return nil, nil, err
+			}
+			// replace the delivery with the one with push-sync options
+			delivery = NewDelivery(delivery.kad, netStore, &DeliveryOptions{
+				PushSyncReplication: 3,
+				PushSyncQuorum:      3,
+			})
+			netStore.NewNetFetcherFunc = network.NewFetcherFactory(delivery.RequestFromPeers, true, nil).New
+			netStore.PushSyncFunc = delivery.PushSync
+			bucket.Store(bucketKeyDelivery, delivery)
+			// all nodes are in the nearest neighbourhood
+			// and they are responsible for all chunks
+			delivery.kad.NeighbourhoodSize = nodeCount
+
+			r := NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &RegistryOptions{
+				SkipCheck: true,
+				Syncing:   SyncingDisabled,
+			}, nil)
+			bucket.Store(bucketKeyRegistry, r)
+
+			cleanup = func() {
+				r.Close()
+				clean()
+			}
+
+			return r, cleanup, nil
+		},
+	}, nil)
+	defer sim.Close()
+
+	_, err := sim.AddNodesAndConnectFull(nodeCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) error {
+		nodeIDs := sim.UpNodeIDs()
+		pivot := nodeIDs[0]
+
+		item, ok := sim.NodeItem(pivot, bucketKeyRegistry)
+		if !ok {
+			return errors.New("no registry")
+		}
+		registry := item.(*Registry)
+		for registry.peersCount() < nodeCount-1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+
+		ch := storage.GenerateRandomChunk(chunk.DefaultSize)
 
-	ctx := context.Background()
-	// making a request which should return with "no peer found"
-	_, _, err := delivery.RequestFromPeers(ctx, req)
+		// the three nodes closest to the chunk
+		others := nodeIDs[1:]
+		overlay := func(id enode.ID) []byte {
+			item, _ := sim.NodeItem(id, simulation.BucketKeyKademlia)
+			return item.(*network.Kademlia).BaseAddr()
+		}
+		sort.Slice(others, func(i, j int) bool {
+			return pot.ProxCmp([]byte(ch.Address()), overlay(others[i]), overlay(others[j])) < 0
+		})
+		want := others[:3]
 
-	expectedError := "no peer found"
-	if err.Error() != expectedError {
-		t.Fatalf("expected '%v', got %v", expectedError, err)
+		// the upload returns when all three receipts are received
+		if _, err := registry.delivery.netStore.Put(ctx, chunk.ModePutUpload, ch); err != nil {
+			return err
+		}
+		for _, id := range want {
+			item, ok := sim.NodeItem(id, bucketKeyStore)
+			if !ok {
+				return errors.New("no store")
+			}
+			has, err := item.(chunk.Store).Has(ctx, ch.Address())
+			if err != nil {
+				return err
+			}
+			if !has {
+				return fmt.Errorf("chunk not stored on node %s", id)
+			}
+		}
+		return nil
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
 	}
 }
 
-func TestStreamerDownstreamChunkDeliveryMsgExchange(t *testing.T) {
-	tester, streamer, localStore, teardown, err := newStreamerTester(&RegistryOptions{
-		Syncing: SyncingDisabled,
-	})
+// TestVerifyReceipt validates that push-sync receipts are accepted only
+// if they are signed for the chunk by the node key of the peer.
+func TestVerifyReceipt(t *testing.T) {
+	key, err := crypto.GenerateKey()
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer teardown()
+	otherKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := enode.PubkeyToIDV4(&key.PublicKey)
+	addr := storage.Address(testutil.RandomBytes(1, 32))
 
-	streamer.RegisterClientFunc("foo", func(p *Peer, t string, live bool) (Client, error) {
-		return &testClient{
-			t: t,
-		}, nil
-	})
+	d := &Delivery{}
+	if _, err := d.signReceipt(addr); err != errNoReceiptKey {
+		t.Fatalf("got error %v, want %v", err, errNoReceiptKey)
+	}
+	d.setReceiptKey(key)
+	sig, err := d.signReceipt(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	node := tester.Nodes[0]
+	if err := verifyReceipt(id, &ReceiptMsg{Addr: addr, Sig: sig}); err != nil {
+		t.Errorf("valid receipt: %v", err)
+	}
+	if err := verifyReceipt(enode.PubkeyToIDV4(&otherKey.PublicKey), &ReceiptMsg{Addr: addr, Sig: sig}); err == nil {
+		t.Error("receipt accepted from other peer")
+	}
+	if err := verifyReceipt(id, &ReceiptMsg{Addr: storage.Address(testutil.RandomBytes(2, 32)), Sig: sig}); err == nil {
+		t.Error("receipt accepted for other chunk")
+	}
+	if err := verifyReceipt(id, &ReceiptMsg{Addr: addr}); err == nil {
+		t.Error("unsigned receipt accepted")
+	}
+}
 
-	//subscribe to custom stream
-	stream := NewStream("foo", "", true)
-	err = streamer.Subscribe(node.ID(), stream, NewRange(5, 8), Top)
+// TestDeliveryReplicate uploads a chunk to a node that is responsible
+// for it and validates that it is replicated to the two nodes closest
+// to the chunk and not to the others.
+func TestDeliveryReplicate(t *testing.T) {
+	replication := 2
+
+	sim := simulation.New(map[string]simulation.ServiceFunc{
+		"streamer": func(ctx *adapters.ServiceContext, bucket *sync.Map) (s node.Service, cleanup func(), err error) {
+			n := ctx.Config.Node()
+			addr := network.NewAddr(n)
+
+			localStore, localStoreCleanup, err := newTestLocalStore(n.ID(), addr, nil, chunk.NewTags())
+			if err != nil {
+				return nil, nil, err
+			}
+			netStore, err := storage.NewNetStore(localStore, nil, &storage.NetStoreOptions{
+				Replication: replication,
+			})
+			if err != nil {
+				localStore.Close()
+				localStoreCleanup()
+				return nil, nil, err
+			}
+			clean := func() {
+				netStore.Close()
+				localStoreCleanup()
+			}
+
+			kad := network.NewKademlia(addr.Over(), network.NewKadParams())
+			delivery := NewDelivery(kad, netStore, nil)
+			netStore.NewNetFetcherFunc = network.NewFetcherFactory(delivery.RequestFromPeers, true, nil).New
+			netStore.PushSyncFunc = delivery.PushSync
+
+			bucket.Store(bucketKeyStore, localStore)
+			bucket.Store(bucketKeyDelivery, delivery)
+			bucket.Store(simulation.BucketKeyKademlia, kad)
+
+			r := NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &RegistryOptions{
+				SkipCheck: true,
+				Syncing:   SyncingDisabled,
+			}, nil)
+			bucket.Store(bucketKeyRegistry, r)
+
+			cleanup = func() {
+				r.Close()
+				clean()
+			}
+
+			return r, cleanup, nil
+		},
+	}, nil)
+	defer sim.Close()
+
+	nodeCount := 6
+	_, err := sim.AddNodesAndConnectFull(nodeCount)
 	if err != nil {
-		t.Fatalf("Expected no error, got %v", err)
+		t.Fatal(err)
 	}
 
-	chunkKey := hash0[:]
-	chunkData := hash1[:]
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-	err = tester.TestExchanges(p2ptest.Exchange{
-		Label: "Subscribe message",
-		Expects: []p2ptest.Expect{
-			{ //first expect subscription to the custom stream...
-				Code: 4,
-				Msg: &SubscribeMsg{
-					Stream:   stream,
-					History:  NewRange(5, 8),
-					Priority: Top,
-				},
-				Peer: node.ID(),
-			},
-		},
-	},
-		p2ptest.Exchange{
-			Label: "ChunkDelivery message",
-			Triggers: []p2ptest.Trigger{
-				{ //...then trigger a chunk delivery for the given chunk from peer in order for
-					//local node to get the chunk delivered
-					Code: 6,
-					Msg: &ChunkDeliveryMsg{
-						Addr:  chunkKey,
-						SData: chunkData,
-					},
-					Peer: node.ID(),
-				},
-			},
+	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) error {
+		nodeIDs := sim.UpNodeIDs()
+		pivot := nodeIDs[0]
+
+		item, ok := sim.NodeItem(pivot, bucketKeyRegistry)
+		if !ok {
+			return errors.New("no registry")
+		}
+		registry := item.(*Registry)
+		for registry.peersCount() < nodeCount-1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+
+		overlay := func(id enode.ID) []byte {
+			item, _ := sim.NodeItem(id, simulation.BucketKeyKademlia)
+			return item.(*network.Kademlia).BaseAddr()
+		}
+		kad := registry.delivery.kad
+
+		// the chunk is in the nearest neighbourhood of the pivot node
+		ch := testutil.ChunksInBin(1, 1, kad.NeighbourhoodDepth(), kad.BaseAddr())[0]
+
+		others := nodeIDs[1:]
+		sort.Slice(others, func(i, j int) bool {
+			return pot.ProxCmp([]byte(ch.Address()), overlay(others[i]), overlay(others[j])) < 0
 		})
 
+		if _, err := registry.delivery.netStore.Put(ctx, chunk.ModePutUpload, ch); err != nil {
+			return err
+		}
+
+		has := func(id enode.ID) (bool, error) {
+			item, ok := sim.NodeItem(id, bucketKeyStore)
+			if !ok {
+				return false, errors.New("no store")
+			}
+			return item.(chunk.Store).Has(ctx, ch.Address())
+		}
+		for _, id := range others[:replication] {
+			for {
+				ok, err := has(id)
+				if err != nil {
+					return err
+				}
+				if ok {
+					break
+				}
+				select {
+				case <-ctx.Done():
+					return fmt.Errorf("chunk not replicated to node %s: %v", id, ctx.Err())
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+		}
+		for _, id := range others[replication:] {
+			ok, err := has(id)
+			if err != nil {
+				return err
+			}
+			if ok {
+				return fmt.Errorf("chunk replicated to node %s that is not one of the closest", id)
+			}
+		}
+		return nil
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+}
+
+// TestDeliveryCompression retrieves a chunk with compressible data between
+// two nodes that enable compression and validates that the delivered data
+// is correct and that less bytes than the chunk size are received.
+func TestDeliveryCompression(t *testing.T) {
+	sim := simulation.New(map[string]simulation.ServiceFunc{
+		"streamer": func(ctx *adapters.ServiceContext, bucket *sync.Map) (s node.Service, cleanup func(), err error) {
+			addr, netStore, delivery, clean, err := newNetStoreAndDelivery(ctx, bucket)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			r := NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &RegistryOptions{
+				SkipCheck:   true,
+				Syncing:     SyncingDisabled,
+				Compression: true,
+			}, nil)
+			bucket.Store(bucketKeyRegistry, r)
+
+			cleanup = func() {
+				r.Close()
+				clean()
+			}
+
+			return r, cleanup, nil
+		},
+	}, nil)
+	defer sim.Close()
+
+	_, err := sim.AddNodesAndConnectChain(2)
 	if err != nil {
-		t.Fatalf("Expected no error, got %v", err)
+		t.Fatal(err)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// wait for the chunk to get stored
-	storedChunk, err := localStore.Get(ctx, chunk.ModeGetRequest, chunkKey)
-	for err != nil {
-		select {
-		case <-ctx.Done():
-			t.Fatalf("Chunk is not in localstore after timeout, err: %v", err)
-		default:
+	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) error {
+		nodeIDs := sim.UpNodeIDs()
+		pivot, other := nodeIDs[0], nodeIDs[1]
+
+		registry := func(id enode.ID) *Registry {
+			item, _ := sim.NodeItem(id, bucketKeyRegistry)
+			return item.(*Registry)
 		}
-		storedChunk, err = localStore.Get(ctx, chunk.ModeGetRequest, chunkKey)
-		time.Sleep(50 * time.Millisecond)
+		// wait for both nodes to receive capabilities of each other
+		for _, ids := range [][2]enode.ID{{pivot, other}, {other, pivot}} {
+			for {
+				if p := registry(ids[0]).getPeer(ids[1]); p != nil && p.compress() {
+					break
+				}
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+		}
+
+		// chunk with compressible data
+		data := make([]byte, 8+chunk.DefaultSize)
+		binary.LittleEndian.PutUint64(data[:8], chunk.DefaultSize)
+		for i := 8; i < len(data); i++ {
+			data[i] = byte(i % 16)
+		}
+		hasher := storage.MakeHashFunc(storage.DefaultHash)()
+		hasher.ResetWithLength(data[:8])
+		hasher.Write(data[8:])
+		ch := storage.NewChunk(hasher.Sum(nil), data)
+		if err := sim.PutChunk(other, ch); err != nil {
+			return err
+		}
+
+		before := registry(pivot).PeersBandwidth()[other].BytesReceived
+		got, err := registry(pivot).delivery.netStore.Get(ctx, chunk.ModeGetRequest, ch.Address())
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got.Data(), ch.Data()) {
+			return errors.New("got chunk data is not the same as put")
+		}
+		received := registry(pivot).PeersBandwidth()[other].BytesReceived - before
+		if received == 0 || received >= uint64(len(data)) {
+			return fmt.Errorf("got %v bytes received, want less than chunk data length %v", received, len(data))
+		}
+		return nil
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
 	}
+}
+
+// TestDeliveryEvents retrieves a known number of chunks between two nodes
+// and validates that a simulation delivery event is emitted for every
+// delivered chunk.
+func TestDeliveryEvents(t *testing.T) {
+	sim := simulation.New(map[string]simulation.ServiceFunc{
+		"streamer": func(ctx *adapters.ServiceContext, bucket *sync.Map) (s node.Service, cleanup func(), err error) {
+			addr, netStore, delivery, clean, err := newNetStoreAndDelivery(ctx, bucket)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			r := NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &RegistryOptions{
+				SkipCheck: true,
+				Syncing:   SyncingDisabled,
+			}, nil)
+			bucket.Store(bucketKeyRegistry, r)
+
+			cleanup = func() {
+				r.Close()
+				clean()
+			}
+
+			return r, cleanup, nil
+		},
+	}, nil)
+	defer sim.Close()
 
+	_, err := sim.AddNodesAndConnectChain(2)
 	if err != nil {
-		t.Fatalf("Expected no error, got %v", err)
+		t.Fatal(err)
 	}
 
-	if !bytes.Equal(storedChunk.Data(), chunkData) {
-		t.Fatal("Retrieved chunk has different data than original")
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) error {
+		nodeIDs := sim.UpNodeIDs()
+		pivot, other := nodeIDs[0], nodeIDs[1]
+
+		chunks := testutil.DeterministicChunks(1, 10)
+		for _, ch := range chunks {
+			if err := sim.PutChunk(other, ch); err != nil {
+				return err
+			}
+		}
+
+		eventsCtx, cancelEvents := context.WithCancel(ctx)
+		defer cancelEvents()
+		events := sim.DeliveryEvents(eventsCtx)
+
+		item, _ := sim.NodeItem(pivot, bucketKeyRegistry)
+		netStore := item.(*Registry).delivery.netStore
+		errc := make(chan error, 1)
+		go func() {
+			for _, ch := range chunks {
+				if _, err := netStore.Get(ctx, chunk.ModeGetRequest, ch.Address()); err != nil {
+					errc <- err
+					return
+				}
+			}
+			errc <- nil
+		}()
 
+		delivered := make(map[string]bool)
+		for len(delivered) < len(chunks) {
+			select {
+			case e := <-events:
+				if e.Source != other {
+					return fmt.Errorf("got delivery event source %s, want %s", e.Source, other)
+				}
+				if e.Destination != pivot {
+					return fmt.Errorf("got delivery event destination %s, want %s", e.Destination, pivot)
+				}
+				if e.Time.IsZero() {
+					return errors.New("got delivery event without time")
+				}
+				delivered[string(e.Addr)] = true
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		for _, ch := range chunks {
+			if !delivered[string(ch.Address())] {
+				return fmt.Errorf("no delivery event for chunk %s", ch.Address())
+			}
+		}
+		return <-errc
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
 }
 
 func TestDeliveryFromNodes(t *testing.T) {
@@ -342,7 +1718,7 @@ func testDeliveryFromNodes(t *testing.T, nodes, chunkCount int, skipCheck bool)
 
 				return r, cleanup, nil
 			},
-		})
+		}, nil)
 		defer sim.Close()
 
 		log.Info("Adding nodes to simulation")
@@ -380,7 +1756,11 @@ func testDeliveryFromNodes(t *testing.T, nodes, chunkCount int, skipCheck bool)
 				i++
 			}
 			//...which then gets passed to the round-robin file store
-			roundRobinFileStore := storage.NewFileStore(newRoundRobinStore(stores...), storage.NewFileStoreParams(), chunk.NewTags())
+			roundRobinStore, err := storage.NewRoundRobinStore(stores...)
+			if err != nil {
+				return err
+			}
+			roundRobinFileStore := storage.NewFileStore(roundRobinStore, storage.NewFileStoreParams(), chunk.NewTags())
 			//now we can actually upload a (random) file to the round-robin store
 			size := chunkCount * chunkSize
 			log.Debug("Storing data to file store")
@@ -493,7 +1873,7 @@ func benchmarkDeliveryFromNodes(b *testing.B, nodes, chunkCount int, skipCheck b
 
 			return r, cleanup, nil
 		},
-	})
+	}, nil)
 	defer sim.Close()
 
 	log.Info("Initializing test config")
@@ -591,3 +1971,23 @@ func benchmarkDeliveryFromNodes(b *testing.B, nodes, chunkCount int, skipCheck b
 	}
 
 }
+
+// TestDecompressChunkData validates that compressed chunk data is accepted
+// up to the configured chunk size with the span and rejected above it.
+func TestDecompressChunkData(t *testing.T) {
+	const chunkSize = 8192
+
+	data := make([]byte, chunkSize+8)
+	got, err := decompressChunkData(snappy.Encode(nil, data), chunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("decompressed data not equal to the original")
+	}
+
+	data = make([]byte, chunkSize+9)
+	if _, err := decompressChunkData(snappy.Encode(nil, data), chunkSize); err == nil {
+		t.Fatal("expected error for data larger than the chunk size")
+	}
+}
@@ -149,6 +149,17 @@ func (i *Intervals) Last() (end uint64) {
 	return i.ranges[l-1][1]
 }
 
+// Ranges returns a copy of all stored ranges. Start and end values
+// of every range are both inclusive.
+func (i *Intervals) Ranges() (ranges [][2]uint64) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	ranges = make([][2]uint64, len(i.ranges))
+	copy(ranges, i.ranges)
+	return ranges
+}
+
 // String returns a descriptive representation of range intervals
 // in [] notation, as a list of two element vectors.
 func (i *Intervals) String() string {
@@ -16,7 +16,10 @@
 
 package intervals
 
-import "testing"
+import (
+	"fmt"
+	"testing"
+)
 
 // Test tests Interval methods Add, Next and Last for various
 // initial state.
@@ -393,3 +396,25 @@ func TestMerge(t *testing.T) {
 		}
 	}
 }
+
+// TestRanges validates that Ranges returns a copy of stored ranges
+// that is not affected by later changes to Intervals.
+func TestRanges(t *testing.T) {
+	intervals := NewIntervals(0)
+	intervals.Add(0, 10)
+	intervals.Add(20, 30)
+
+	ranges := intervals.Ranges()
+	if fmt.Sprint(ranges) != "[[0 10] [20 30]]" {
+		t.Fatalf("got ranges %v", ranges)
+	}
+
+	intervals.Add(11, 19)
+
+	if fmt.Sprint(ranges) != "[[0 10] [20 30]]" {
+		t.Fatalf("got changed ranges %v", ranges)
+	}
+	if got := fmt.Sprint(intervals.Ranges()); got != "[[0 30]]" {
+		t.Fatalf("got ranges %v", got)
+	}
+}
@@ -21,14 +21,20 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
 	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethersphere/swarm/network"
 	"github.com/ethersphere/swarm/network/simulation"
 	"github.com/ethersphere/swarm/state"
 	"github.com/ethersphere/swarm/storage"
@@ -85,7 +91,7 @@ func testIntervals(t *testing.T, live bool, history *Range, skipCheck bool) {
 
 			return r, cleanup, nil
 		},
-	})
+	}, nil)
 	defer sim.Close()
 
 	log.Info("Adding nodes to simulation")
@@ -255,6 +261,114 @@ func testIntervals(t *testing.T, live bool, history *Range, skipCheck bool) {
 	}
 }
 
+// TestIntervalsResumeAfterRestart validates that history intervals persisted
+// in the state.DBStore are not reset when the Registry is closed and created
+// again, and that the history subscription resumes from the first bin ID
+// that is not synced.
+func TestIntervalsResumeAfterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-stream-intervals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	peerID := enode.ID{1}
+	s := NewStream("foo", "", false)
+	synced := uint64(42)
+
+	// first session, sync the history stream up to synced bin ID
+	r, p, rw, cleanup := newIntervalsTestRegistry(t, dir, peerID)
+	sub := subscribeAndReadMsg(t, r, peerID, rw, s)
+	if sub.History.From != 0 {
+		t.Errorf("got history from %v in the first session, want 0", sub.History.From)
+	}
+	c, _, err := p.getOrSetClient(s, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddInterval(1, synced); err != nil {
+		t.Fatal(err)
+	}
+	cleanup()
+
+	// second session, with the intervals store opened again
+	r, p, rw, cleanup = newIntervalsTestRegistry(t, dir, peerID)
+	defer cleanup()
+	sub = subscribeAndReadMsg(t, r, peerID, rw, s)
+	if sub.History.From != synced+1 {
+		t.Errorf("got history from %v, want %v", sub.History.From, synced+1)
+	}
+	c, _, err = p.getOrSetClient(s, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, _, err := c.NextInterval()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != synced+1 {
+		t.Errorf("got next interval start %v, want %v", start, synced+1)
+	}
+}
+
+// newIntervalsTestRegistry creates a Registry with state.DBStore in dir as
+// intervals store and a peer with peerID which messages are written to
+// the returned MsgReadWriter.
+func newIntervalsTestRegistry(t *testing.T, dir string, peerID enode.ID) (r *Registry, p *Peer, rw p2p.MsgReadWriter, cleanup func()) {
+	t.Helper()
+
+	store, err := state.NewDBStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := network.RandomAddr()
+	kad := network.NewKademlia(addr.Over(), network.NewKadParams())
+	r = NewRegistry(addr.ID(), NewDelivery(kad, nil, nil), nil, store, nil, nil)
+	r.RegisterClientFunc("foo", func(p *Peer, t string, live bool) (Client, error) {
+		return newTestClient(t), nil
+	})
+
+	local, remote := p2p.MsgPipe()
+	p = NewPeer(network.NewBzzPeer(protocols.NewPeer(p2p.NewPeer(peerID, "test", nil), local, r.spec)), r)
+	r.setPeer(p)
+
+	return r, p, remote, func() {
+		close(p.quit)
+		local.Close()
+		r.Close()
+	}
+}
+
+// subscribeAndReadMsg subscribes to the stream s with peer and returns
+// the SubscribeMsg that is sent to it.
+func subscribeAndReadMsg(t *testing.T, r *Registry, peerID enode.ID, rw p2p.MsgReadWriter, s Stream) *SubscribeMsg {
+	t.Helper()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- r.Subscribe(peerID, s, NewRange(0, 0), Top)
+	}()
+	msg, err := rw.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := r.spec.GetCode(SubscribeMsg{}); msg.Code != code {
+		t.Fatalf("got message code %v, want %v", msg.Code, code)
+	}
+	var wmsg protocols.WrappedMsg
+	if err := msg.Decode(&wmsg); err != nil {
+		t.Fatal(err)
+	}
+	sub := new(SubscribeMsg)
+	if err := rlp.DecodeBytes(wmsg.Payload, sub); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
 func getHashes(ctx context.Context, r *Registry, peerID enode.ID, s Stream) (chan []byte, error) {
 	peer := r.getPeer(peerID)
 
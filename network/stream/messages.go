@@ -19,6 +19,7 @@ package stream
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/metrics"
@@ -62,6 +63,9 @@ type SubscribeMsg struct {
 	Stream   Stream
 	History  *Range `rlp:"nil"`
 	Priority uint8  // delivered on priority channel
+	// MaxChunks is the number of chunks after which the upstream
+	// peer completes the stream, no limit if zero
+	MaxChunks uint64
 }
 
 // RequestSubscriptionMsg is the protocol msg for a node to request subscription to a
@@ -91,6 +95,8 @@ func (p *Peer) handleSubscribeMsg(ctx context.Context, req *SubscribeMsg) (err e
 
 	defer func() {
 		if err != nil {
+			metrics.GetOrRegisterCounter("peer.handlesubscribemsg.rejected", nil).Inc(1)
+			log.Debug("subscription rejected", "peer", p.ID(), "stream", req.Stream, "err", err)
 			// The error will be sent as a subscribe error message
 			// and will not be returned as it will prevent any new message
 			// exchange between peers over p2p. Instead, error will be returned
@@ -103,6 +109,10 @@ func (p *Peer) handleSubscribeMsg(ctx context.Context, req *SubscribeMsg) (err e
 
 	log.Debug("received subscription", "from", p.streamer.addr, "peer", p.ID(), "stream", req.Stream, "history", req.History)
 
+	if p.streamer.isClosing() {
+		return ErrRegistryClosing
+	}
+
 	f, err := p.streamer.GetServerFunc(req.Stream.Name)
 	if err != nil {
 		return err
@@ -116,6 +126,7 @@ func (p *Peer) handleSubscribeMsg(ctx context.Context, req *SubscribeMsg) (err e
 	if err != nil {
 		return err
 	}
+	os.maxChunks = req.MaxChunks
 
 	var from uint64
 	var to uint64
@@ -165,7 +176,13 @@ type UnsubscribeMsg struct {
 }
 
 func (p *Peer) handleUnsubscribeMsg(req *UnsubscribeMsg) error {
-	return p.removeServer(req.Stream)
+	err := p.removeServer(req.Stream)
+	// server may be already removed, for example when
+	// a subscription with history is renewed
+	if _, ok := err.(*notFoundError); ok {
+		return nil
+	}
+	return err
 }
 
 type QuitMsg struct {
@@ -180,6 +197,65 @@ func (p *Peer) handleQuitMsg(req *QuitMsg) error {
 	return err
 }
 
+// StreamCompleteMsg is the protocol msg sent by the upstream peer when all
+// chunks in the bounded history range of a stream are offered.
+type StreamCompleteMsg struct {
+	Stream Stream
+}
+
+// handleStreamCompleteMsg waits for the last offered batch to be stored,
+// unsubscribes from the stream and calls the registry stream complete
+// callback.
+func (p *Peer) handleStreamCompleteMsg(req *StreamCompleteMsg) error {
+	p.clientMu.RLock()
+	c := p.clients[req.Stream]
+	p.clientMu.RUnlock()
+
+	if c != nil {
+		timer := time.NewTimer(syncBatchTimeout)
+		defer timer.Stop()
+
+		select {
+		case err := <-c.next:
+			if err != nil {
+				return err
+			}
+		case <-c.quit:
+			return nil
+		case <-timer.C:
+			log.Debug("client.handleStreamCompleteMsg() timeout waiting for the last batch", "peer", p.ID(), "stream", req.Stream)
+		}
+	}
+
+	// synced intervals are kept to resume
+	// syncing if the stream is requested again
+	if err := p.unsubscribe(req.Stream); err != nil {
+		return err
+	}
+
+	log.Debug("stream complete", "peer", p.ID(), "stream", req.Stream)
+	if f := p.streamer.streamCompleteFunc; f != nil {
+		f(p.ID(), req.Stream)
+	}
+	return nil
+}
+
+// CapabilitiesMsg is the protocol msg sent to a peer when it is connected
+// to announce optional protocol features that the node supports.
+type CapabilitiesMsg struct {
+	// Compression is true if chunk data in delivery
+	// messages can be compressed with snappy.
+	Compression bool
+}
+
+// handleCapabilitiesMsg records the features supported by the peer.
+func (p *Peer) handleCapabilitiesMsg(req *CapabilitiesMsg) error {
+	if req.Compression {
+		atomic.StoreUint32(&p.compression, 1)
+	}
+	return nil
+}
+
 // OfferedHashesMsg is the protocol msg for offering to hand over a
 // stream section
 type OfferedHashesMsg struct {
@@ -199,6 +275,8 @@ func (m OfferedHashesMsg) String() string {
 func (p *Peer) handleOfferedHashesMsg(ctx context.Context, req *OfferedHashesMsg) error {
 	metrics.GetOrRegisterCounter("peer.handleofferedhashes", nil).Inc(1)
 
+	p.markSubscriptionActive(req.Stream)
+
 	c, _, err := p.getOrSetClient(req.Stream, req.From, req.To)
 	if err != nil {
 		return err
@@ -226,9 +304,23 @@ func (p *Peer) handleOfferedHashesMsg(ctx context.Context, req *OfferedHashesMsg
 	for i := 0; i < lenHashes; i += HashSize {
 		hash := hashes[i : i+HashSize]
 
+		// the upstream peer does not deliver more chunks
+		// than the subscription limit
+		if c.limitReached() {
+			break
+		}
+
 		if wait := c.NeedData(ctx, hash); wait != nil {
 			ctr++
-			want.Set(i/HashSize, true)
+			// the chunk that is already wanted from another peer
+			// is not requested again, but the batch still waits
+			// for it to be delivered
+			if p.streamer.delivery.markWanted(hash) {
+				want.Set(i/HashSize, true)
+				c.addWanted()
+			} else {
+				metrics.GetOrRegisterCounter("peer.handleofferedhashes.inflight", nil).Inc(1)
+			}
 
 			// measure how long it takes before we mark chunks for retrieval, and actually send the request
 			if !wantDelaySet {
@@ -280,7 +372,12 @@ func (p *Peer) handleOfferedHashesMsg(ctx context.Context, req *OfferedHashesMsg
 	from, to := c.nextBatch(req.To + 1)
 	log.Trace("set next batch", "peer", p.ID(), "stream", req.Stream, "from", req.From, "to", req.To, "addr", p.streamer.addr)
 	if from == to {
-		return nil
+		if c.stream.Live || c.to == 0 {
+			return nil
+		}
+		// request the rest of the bounded history range, so that the
+		// upstream peer can send StreamCompleteMsg when it is exhausted
+		from, to = req.To+1, c.to
 	}
 
 	msg := &WantedHashesMsg{
@@ -305,6 +402,12 @@ func (p *Peer) handleOfferedHashesMsg(ctx context.Context, req *OfferedHashesMsg
 		log.Debug("client.handleOfferedHashesMsg() context done", "ctx.Err()", ctx.Err())
 		return nil
 	}
+	// delay requesting new chunks while the local store is nearly full,
+	// as they would be garbage collected soon after they are stored
+	if !p.streamer.waitBelowHighWatermark(c) {
+		log.Debug("client.handleOfferedHashesMsg() quit")
+		return nil
+	}
 	log.Trace("sending want batch", "peer", p.ID(), "stream", msg.Stream, "from", msg.From, "to", msg.To)
 
 	// record want delay
@@ -340,18 +443,23 @@ func (p *Peer) handleWantedHashesMsg(ctx context.Context, req *WantedHashesMsg)
 	metrics.GetOrRegisterCounter("peer.handlewantedhashesmsg", nil).Inc(1)
 
 	log.Trace("received wanted batch", "peer", p.ID(), "stream", req.Stream, "from", req.From, "to", req.To)
+	if !p.streamer.startDeliveries() {
+		log.Debug("wanted batch ignored, registry closing", "peer", p.ID(), "stream", req.Stream)
+		return nil
+	}
+	defer p.streamer.deliveries.Done()
+
 	s, err := p.getServer(req.Stream)
 	if err != nil {
 		return err
 	}
-	hashes := s.currentBatch
-	// launch in go routine since GetBatch blocks until new hashes arrive
-	go func() {
-		if err := p.SendOfferedHashes(s, req.From, req.To); err != nil {
-			log.Warn("SendOfferedHashes error", "peer", p.ID().TerminalString(), "err", err)
-		}
-	}()
-	// go p.SendOfferedHashes(s, req.From, req.To)
+	hashes := s.takeBatch()
+	if hashes == nil && p.streamer.offeredTimeout > 0 {
+		// the batch is dropped after the timeout and the next one
+		// is already offered
+		log.Debug("wanted batch ignored, offered batch timed out", "peer", p.ID(), "stream", req.Stream, "from", req.From, "to", req.To)
+		return nil
+	}
 	l := len(hashes) / HashSize
 
 	log.Trace("wanted batch length", "peer", p.ID(), "stream", req.Stream, "from", req.From, "to", req.To, "lenhashes", len(hashes), "l", l)
@@ -359,8 +467,26 @@ func (p *Peer) handleWantedHashesMsg(ctx context.Context, req *WantedHashesMsg)
 	if err != nil {
 		return fmt.Errorf("error initiaising bitvector of length %v: %v", l, err)
 	}
+	// wanted chunks are counted against the subscription limit
+	// before the next batch is offered, so that the stream is
+	// completed instead if the limit is reached
+	var wanted uint64
 	for i := 0; i < l; i++ {
 		if want.Get(i) {
+			wanted++
+		}
+	}
+	deliverable := s.reserveChunks(wanted)
+
+	// launch in go routine since GetBatch blocks until new hashes arrive
+	go func() {
+		if err := p.SendOfferedHashes(s, req.From, req.To); err != nil {
+			log.Warn("SendOfferedHashes error", "peer", p.ID().TerminalString(), "err", err)
+		}
+	}()
+	for i := 0; i < l && deliverable > 0; i++ {
+		if want.Get(i) {
+			deliverable--
 			metrics.GetOrRegisterCounter("peer.handlewantedhashesmsg.actualget", nil).Inc(1)
 
 			hash := hashes[i*HashSize : (i+1)*HashSize]
@@ -370,9 +496,13 @@ func (p *Peer) handleWantedHashesMsg(ctx context.Context, req *WantedHashesMsg)
 			}
 			chunk := storage.NewChunk(hash, data)
 			syncing := true
-			if err := p.Deliver(ctx, chunk, s.priority, syncing); err != nil {
+			// the delivery is done when the message is sent
+			p.streamer.deliveries.Add(1)
+			if err := p.deliver(ctx, chunk, s.priority, syncing, p.streamer.deliveries.Done); err != nil {
 				return err
 			}
+			StreamCounter(req.Stream.Name, "chunks.sent").Inc(1)
+			StreamCounter(req.Stream.Name, "bytes.sent").Inc(int64(len(data)))
 		}
 	}
 	return nil
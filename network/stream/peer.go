@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/metrics"
@@ -34,6 +35,7 @@ import (
 	"github.com/ethersphere/swarm/state"
 	"github.com/ethersphere/swarm/storage"
 	"github.com/ethersphere/swarm/tracing"
+	"github.com/golang/snappy"
 	opentracing "github.com/opentracing/opentracing-go"
 )
 
@@ -68,11 +70,52 @@ type Peer struct {
 	// on creating a new client in offered hashes handler.
 	clientParams map[Stream]*clientParams
 	quit         chan struct{}
+	// number of received messages in the current
+	// one second window for message rate limiting
+	msgCount       int
+	msgWindowStart time.Time
+	msgCountMu     sync.Mutex
+	// subscriptions made with Registry.Subscribe that
+	// are renewed if they are idle for too long
+	subscriptions   map[Stream]*subscription
+	subscriptionsMu sync.Mutex
+	// message payload bytes sent to and received
+	// from the peer on all streams, accessed atomically
+	bytesSent     uint64
+	bytesReceived uint64
+	// set to 1 when the peer announces that it supports
+	// compression with CapabilitiesMsg, accessed atomically
+	compression uint32
+}
+
+// Bandwidth holds the number of message payload
+// bytes sent to and received from a peer.
+type Bandwidth struct {
+	BytesSent     uint64
+	BytesReceived uint64
+}
+
+// Bandwidth returns the number of message payload bytes sent
+// to and received from the peer since it is connected.
+func (p *Peer) Bandwidth() Bandwidth {
+	return Bandwidth{
+		BytesSent:     atomic.LoadUint64(&p.bytesSent),
+		BytesReceived: atomic.LoadUint64(&p.bytesReceived),
+	}
+}
+
+// subscription holds the arguments of Registry.Subscribe call
+// and the time of the last offered hashes for the stream.
+type subscription struct {
+	history      *Range
+	priority     uint8
+	lastActivity time.Time
 }
 
 type WrappedPriorityMsg struct {
 	Context context.Context
 	Msg     interface{}
+	sent    func() // called after the message is sent, if not nil
 }
 
 // NewPeer is the constructor for Peer
@@ -85,16 +128,25 @@ func NewPeer(peer *network.BzzPeer, streamer *Registry) *Peer {
 		clients:      make(map[Stream]*client),
 		clientParams: make(map[Stream]*clientParams),
 		quit:         make(chan struct{}),
+
+		subscriptions: make(map[Stream]*subscription),
 	}
 	ctx, cancel := context.WithCancel(context.Background())
-	go p.pq.Run(ctx, func(i interface{}) {
-		wmsg := i.(WrappedPriorityMsg)
-		err := p.Send(wmsg.Context, wmsg.Msg)
-		if err != nil {
-			log.Error("Message send error, dropping peer", "peer", p.ID(), "err", err)
-			p.Drop()
-		}
-	})
+	go func() {
+		p.pq.Run(ctx, func(i interface{}) {
+			wmsg := i.(WrappedPriorityMsg)
+			err := p.Send(wmsg.Context, wmsg.Msg)
+			if wmsg.sent != nil {
+				wmsg.sent()
+			}
+			if err != nil {
+				log.Error("Message send error, dropping peer", "peer", p.ID(), "err", err)
+				p.Drop()
+			}
+		})
+		// the peer quit, messages left in the queue are not sent
+		p.dropQueued()
+	}()
 
 	// basic monitoring for pq contention
 	go func(pq *pq.PriorityQueue) {
@@ -131,9 +183,29 @@ func NewPeer(peer *network.BzzPeer, streamer *Registry) *Peer {
 	return p
 }
 
+// countMessage increments the number of messages received from the peer
+// in the one second window that includes the provided time and returns it.
+func (p *Peer) countMessage(now time.Time) int {
+	p.msgCountMu.Lock()
+	defer p.msgCountMu.Unlock()
+
+	if now.Sub(p.msgWindowStart) >= time.Second {
+		p.msgWindowStart = now
+		p.msgCount = 0
+	}
+	p.msgCount++
+	return p.msgCount
+}
+
 // Deliver sends a storeRequestMsg protocol message to the peer
 // Depending on the `syncing` parameter we send different message types
 func (p *Peer) Deliver(ctx context.Context, chunk storage.Chunk, priority uint8, syncing bool) error {
+	return p.deliver(ctx, chunk, priority, syncing, nil)
+}
+
+// deliver sends the chunk delivery message to the peer and calls
+// the sent function once the message is sent or fails to be queued.
+func (p *Peer) deliver(ctx context.Context, chunk storage.Chunk, priority uint8, syncing bool, sent func()) error {
 	var msg interface{}
 
 	metrics.GetOrRegisterCounter("peer.deliver", nil).Inc(1)
@@ -141,35 +213,74 @@ func (p *Peer) Deliver(ctx context.Context, chunk storage.Chunk, priority uint8,
 	//we send different types of messages if delivery is for syncing or retrievals,
 	//even if handling and content of the message are the same,
 	//because swap accounting decides which messages need accounting based on the message type
+	data := chunk.Data()
+	compressed := p.compress()
+	if compressed {
+		data = snappy.Encode(nil, data)
+	}
 	if syncing {
 		msg = &ChunkDeliveryMsgSyncing{
-			Addr:  chunk.Address(),
-			SData: chunk.Data(),
+			Addr:       chunk.Address(),
+			SData:      data,
+			Compressed: compressed,
 		}
 	} else {
 		msg = &ChunkDeliveryMsgRetrieval{
-			Addr:  chunk.Address(),
-			SData: chunk.Data(),
+			Addr:       chunk.Address(),
+			SData:      data,
+			Compressed: compressed,
 		}
 	}
 
-	return p.SendPriority(ctx, msg, priority)
+	return p.sendPriority(ctx, msg, priority, sent)
+}
+
+// compress returns true if chunk data in delivery messages to the
+// peer should be compressed, as both nodes support compression.
+func (p *Peer) compress() bool {
+	return p.streamer.compression && atomic.LoadUint32(&p.compression) == 1
 }
 
 // SendPriority sends message to the peer using the outgoing priority queue
 func (p *Peer) SendPriority(ctx context.Context, msg interface{}, priority uint8) error {
+	return p.sendPriority(ctx, msg, priority, nil)
+}
+
+func (p *Peer) sendPriority(ctx context.Context, msg interface{}, priority uint8, sent func()) error {
 	defer metrics.GetOrRegisterResettingTimer(fmt.Sprintf("peer.sendpriority_t.%d", priority), nil).UpdateSince(time.Now())
 	ctx = tracing.StartSaveSpan(ctx)
 	metrics.GetOrRegisterCounter(fmt.Sprintf("peer.sendpriority.%d", priority), nil).Inc(1)
 	wmsg := WrappedPriorityMsg{
 		Context: ctx,
 		Msg:     msg,
+		sent:    sent,
 	}
 	err := p.pq.Push(wmsg, int(priority))
 	if err != nil {
 		log.Error("err on p.pq.Push", "err", err, "peer", p.ID())
+		if sent != nil {
+			sent()
+		}
+		return err
+	}
+	select {
+	case <-p.quit:
+		// the queue may have already been drained
+		p.dropQueued()
+	default:
 	}
-	return err
+	return nil
+}
+
+// dropQueued removes the messages that are left in the priority queue
+// after the peer quit and calls their sent functions, so that waiting
+// for pending deliveries does not block on a disconnected peer.
+func (p *Peer) dropQueued() {
+	p.pq.Drain(func(i interface{}) {
+		if wmsg := i.(WrappedPriorityMsg); wmsg.sent != nil {
+			wmsg.sent()
+		}
+	})
 }
 
 // SendOfferedHashes sends OfferedHashesMsg protocol msg
@@ -183,6 +294,16 @@ func (p *Peer) SendOfferedHashes(s *server, f, t uint64) error {
 
 	defer metrics.GetOrRegisterResettingTimer("send.offered.hashes", nil).UpdateSince(time.Now())
 
+	if s.rangeComplete(f, t) || s.limitReached() {
+		return p.sendStreamComplete(ctx, s)
+	}
+
+	// syncing may be paused before or while the next batch is collected,
+	// in both cases the batch is not offered until syncing is resumed
+	syncing := isSyncStream(s.stream.Name)
+	if syncing && !p.streamer.waitSyncingResumed(p) {
+		return nil
+	}
 	hashes, from, to, proof, err := s.setNextBatch(f, t)
 	if err != nil {
 		return err
@@ -191,12 +312,15 @@ func (p *Peer) SendOfferedHashes(s *server, f, t uint64) error {
 	if len(hashes) == 0 {
 		return nil
 	}
+	if syncing && !p.streamer.waitSyncingResumed(p) {
+		return nil
+	}
 	if proof == nil {
 		proof = &HandoverProof{
 			Handover: &Handover{},
 		}
 	}
-	s.currentBatch = hashes
+	s.setBatch(p, hashes, to, t)
 	msg := &OfferedHashesMsg{
 		HandoverProof: proof,
 		Hashes:        hashes,
@@ -209,6 +333,14 @@ func (p *Peer) SendOfferedHashes(s *server, f, t uint64) error {
 	return p.SendPriority(ctx, msg, s.priority)
 }
 
+// sendStreamComplete sends StreamCompleteMsg to notify the downstream peer
+// that all chunks in the bounded history range of the stream are offered
+// or that the subscription limit of delivered chunks is reached.
+func (p *Peer) sendStreamComplete(ctx context.Context, s *server) error {
+	log.Debug("stream complete", "peer", p.ID(), "stream", s.stream)
+	return p.SendPriority(ctx, &StreamCompleteMsg{Stream: s.stream}, s.priority)
+}
+
 func (p *Peer) getServer(s Stream) (*server, error) {
 	p.serverMu.RLock()
 	defer p.serverMu.RUnlock()
@@ -349,8 +481,23 @@ func (p *Peer) getOrSetClient(s Stream, from, to uint64) (c *client, created boo
 		}
 	}
 
-	if err := p.streamer.intervalsStore.Put(intervalsKey, intervals.NewIntervals(from)); err != nil {
-		return nil, false, err
+	// live intervals start at the current session, but history intervals
+	// persisted in previous sessions must be kept to resume syncing
+	// from where it was left
+	resetIntervals := true
+	if !s.Live {
+		switch err := p.streamer.intervalsStore.Get(intervalsKey, &intervals.Intervals{}); err {
+		case nil:
+			resetIntervals = false
+		case state.ErrNotFound:
+		default:
+			log.Error("stream set client: get history intervals", "stream", s, "peer", p, "err", err)
+		}
+	}
+	if resetIntervals {
+		if err := p.streamer.intervalsStore.Put(intervalsKey, intervals.NewIntervals(from)); err != nil {
+			return nil, false, err
+		}
 	}
 
 	next := make(chan error, 1)
@@ -359,6 +506,7 @@ func (p *Peer) getOrSetClient(s Stream, from, to uint64) (c *client, created boo
 		stream:         s,
 		priority:       cp.priority,
 		to:             cp.to,
+		maxChunks:      cp.maxChunks,
 		next:           next,
 		quit:           make(chan struct{}),
 		intervalsStore: p.streamer.intervalsStore,
@@ -414,6 +562,22 @@ func (p *Peer) removeClientParams(s Stream) error {
 	return nil
 }
 
+// clientPriority returns the priority of the client for the stream,
+// or of its parameters if the client is not yet created, and
+// whether the stream is subscribed to at all.
+func (p *Peer) clientPriority(s Stream) (priority uint8, ok bool) {
+	p.clientMu.RLock()
+	defer p.clientMu.RUnlock()
+
+	if c, ok := p.clients[s]; ok {
+		return c.priority, true
+	}
+	if params, ok := p.clientParams[s]; ok {
+		return params.priority, true
+	}
+	return 0, false
+}
+
 func (p *Peer) close() {
 	p.serverMu.Lock()
 	defer p.serverMu.Unlock()
@@ -425,6 +589,122 @@ func (p *Peer) close() {
 	p.servers = nil
 }
 
+// setSubscription records the subscription to the stream
+// with the provided history range and priority.
+func (p *Peer) setSubscription(s Stream, h *Range, priority uint8) {
+	p.subscriptionsMu.Lock()
+	defer p.subscriptionsMu.Unlock()
+
+	p.subscriptions[s] = &subscription{
+		history:      h,
+		priority:     priority,
+		lastActivity: time.Now(),
+	}
+}
+
+// removeSubscription removes the record of the subscription to the stream.
+func (p *Peer) removeSubscription(s Stream) {
+	p.subscriptionsMu.Lock()
+	defer p.subscriptionsMu.Unlock()
+
+	delete(p.subscriptions, s)
+}
+
+// markSubscriptionActive updates the time of the last activity of the
+// subscription to the stream. Activity on a history stream is also
+// the activity of the live stream subscription that requested it.
+func (p *Peer) markSubscriptionActive(s Stream) {
+	p.subscriptionsMu.Lock()
+	defer p.subscriptionsMu.Unlock()
+
+	now := time.Now()
+	if sub, ok := p.subscriptions[s]; ok {
+		sub.lastActivity = now
+	}
+	if !s.Live {
+		if sub, ok := p.subscriptions[NewStream(s.Name, s.Key, true)]; ok && sub.history != nil {
+			sub.lastActivity = now
+		}
+	}
+}
+
+// idleSubscriptions returns subscriptions that had
+// no activity for at least the timeout duration.
+func (p *Peer) idleSubscriptions(now time.Time, timeout time.Duration) map[Stream]subscription {
+	p.subscriptionsMu.Lock()
+	defer p.subscriptionsMu.Unlock()
+
+	idle := make(map[Stream]subscription)
+	for s, sub := range p.subscriptions {
+		if now.Sub(sub.lastActivity) >= timeout {
+			idle[s] = *sub
+		}
+	}
+	return idle
+}
+
+// runSubscriptionWatchdog is a long running function that periodically
+// renews subscriptions that had no activity for the timeout duration,
+// as the stream server on the peer may stop offering hashes without
+// disconnecting. It returns when the peer or the registry is closed.
+func (p *Peer) runSubscriptionWatchdog(timeout time.Duration) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			for s, sub := range p.idleSubscriptions(now, timeout) {
+				metrics.GetOrRegisterCounter("peer.resubscribe", nil).Inc(1)
+				log.Warn("renewing idle subscription", "peer", p.ID(), "stream", s, "idle", now.Sub(sub.lastActivity))
+				if err := p.resubscribe(s, sub); err != nil {
+					log.Warn("renew idle subscription", "peer", p.ID(), "stream", s, "err", err)
+				}
+			}
+		case <-p.quit:
+			return
+		case <-p.streamer.quit:
+			return
+		}
+	}
+}
+
+// resubscribe unsubscribes from the stream, including its history
+// stream if it was requested, removes local clients and subscribes
+// to the stream again with the same arguments.
+func (p *Peer) resubscribe(s Stream, sub subscription) error {
+	streams := []Stream{s}
+	if s.Live && sub.history != nil {
+		streams = append(streams, getHistoryStream(s))
+	}
+	for _, stream := range streams {
+		if err := p.unsubscribe(stream); err != nil {
+			return err
+		}
+	}
+	return p.streamer.Subscribe(p.ID(), s, sub.history, sub.priority)
+}
+
+// unsubscribe requests the peer to stop the server for the stream and
+// removes the client, its parameters and the subscription record, if
+// they exist. Persisted intervals of the stream are not removed.
+func (p *Peer) unsubscribe(s Stream) error {
+	if err := p.Send(context.TODO(), &UnsubscribeMsg{Stream: s}); err != nil {
+		return err
+	}
+	p.clientMu.Lock()
+	if c, ok := p.clients[s]; ok {
+		c.close()
+		delete(p.clients, s)
+	}
+	// client may not be created if no hashes are offered
+	delete(p.clientParams, s)
+	p.clientMu.Unlock()
+
+	p.removeSubscription(s)
+	return nil
+}
+
 // runUpdateSyncing is a long running function that creates the initial
 // syncing subscriptions to the peer and waits for neighbourhood depth change
 // to create new ones or quit existing ones based on the new neighbourhood depth
@@ -147,7 +147,7 @@ func TestUpdateSyncingSubscriptions(t *testing.T) {
 			bucket.Store("bzz-address", addr)
 			return r, cleanup, nil
 		},
-	})
+	}, nil)
 	defer sim.Close()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
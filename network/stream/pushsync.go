@@ -0,0 +1,284 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/log"
+	"github.com/ethersphere/swarm/storage"
+)
+
+// ErrPushSyncQuorum is returned by Delivery.PushSync when the chunk can not
+// be pushed to enough peers to receive the required number of receipts.
+var ErrPushSyncQuorum = errors.New("not enough peers for push-sync quorum")
+
+// errNoReceiptKey is returned when a receipt is signed before the
+// Registry is started with the node private key.
+var errNoReceiptKey = errors.New("no push-sync receipt key")
+
+// PushSyncMsg is the protocol msg for pushing a locally uploaded chunk
+// to a peer that should store it.
+type PushSyncMsg struct {
+	Addr  storage.Address
+	SData []byte
+}
+
+// ReceiptMsg is the protocol msg sent by a peer that stored a chunk
+// received with PushSyncMsg, as a statement of custody. It is signed
+// with the node private key of the peer.
+type ReceiptMsg struct {
+	Addr storage.Address
+	Sig  []byte
+}
+
+// pushSyncRequest tracks receipts for a chunk that is pushed to peers.
+// All fields are protected by Delivery.pushesMu.
+type pushSyncRequest struct {
+	peers    map[enode.ID]struct{} // peers the chunk is pushed to that did not send a receipt
+	receipts []enode.ID            // peers that sent a receipt
+	quorum   int                   // number of receipts after which done is closed
+	done     chan struct{}
+}
+
+// PushSync pushes a locally uploaded chunk, if push-sync is enabled with
+// DeliveryOptions.PushSyncReplication, to that many connected peers
+// closest to its address and returns after receipts are received from
+// a quorum of them. If the chunk is within the node's nearest
+// neighbourhood, it is also replicated to the provided number of closest
+// peers, without waiting for receipts. It is meant to be set as
+// NetStore.PushSyncFunc, so that uploads return only after their chunks
+// are sent to other nodes, which also limits the number of chunks that
+// are pushed at the same time to the number of concurrent uploads.
+func (d *Delivery) PushSync(ctx context.Context, ch storage.Chunk, replication int) error {
+	if d.pushSyncReplication > 0 {
+		receipts, err := d.pushSync(ctx, ch)
+		if err != nil {
+			return err
+		}
+		log.Trace("push-sync receipts", "addr", ch.Address(), "peers", receipts)
+	}
+	if replication > d.pushSyncReplication {
+		d.replicate(ctx, ch, replication)
+	}
+	return nil
+}
+
+// pushSync sends the chunk to the closest peers and waits for their
+// receipts. Concurrent calls for the same chunk wait for the same receipts.
+func (d *Delivery) pushSync(ctx context.Context, ch storage.Chunk) (receipts []enode.ID, err error) {
+	key := string(ch.Address())
+
+	d.pushesMu.Lock()
+	req, ok := d.pushes[key]
+	if ok {
+		d.pushesMu.Unlock()
+		return d.waitReceipts(ctx, req)
+	}
+	var peers []*Peer
+	for _, p := range d.kad.ClosestConnected(ch.Address(), d.pushSyncReplication) {
+		if sp := d.getPeer(p.ID()); sp != nil {
+			peers = append(peers, sp)
+		}
+	}
+	if len(peers) < d.pushSyncQuorum {
+		d.pushesMu.Unlock()
+		return nil, ErrPushSyncQuorum
+	}
+	req = &pushSyncRequest{
+		peers:  make(map[enode.ID]struct{}),
+		quorum: d.pushSyncQuorum,
+		done:   make(chan struct{}),
+	}
+	for _, sp := range peers {
+		req.peers[sp.ID()] = struct{}{}
+	}
+	d.pushes[key] = req
+	d.pushesMu.Unlock()
+
+	defer func() {
+		d.pushesMu.Lock()
+		delete(d.pushes, key)
+		d.pushesMu.Unlock()
+	}()
+
+	var sent int
+	for _, sp := range peers {
+		err := sp.SendPriority(ctx, &PushSyncMsg{
+			Addr:  ch.Address(),
+			SData: ch.Data(),
+		}, Top)
+		if err != nil {
+			log.Debug("push-sync send", "peer", sp.ID(), "addr", ch.Address(), "err", err)
+			continue
+		}
+		sent++
+	}
+	if sent < req.quorum {
+		return nil, ErrPushSyncQuorum
+	}
+	metrics.GetOrRegisterCounter("network.stream.push_sync.count", nil).Inc(1)
+	return d.waitReceipts(ctx, req)
+}
+
+// replicate sends the chunk to the replication number of connected peers
+// closest to its address, if the chunk is within the nearest neighbourhood
+// of the node, for which the node is responsible. The closest peers that
+// the chunk is already pushed to by push-sync are skipped. Receipts are
+// not awaited.
+func (d *Delivery) replicate(ctx context.Context, ch storage.Chunk, replication int) {
+	if chunk.Proximity(d.kad.BaseAddr(), ch.Address()) < d.kad.NeighbourhoodDepth() {
+		return
+	}
+	peers := d.kad.ClosestConnected(ch.Address(), replication)
+	if len(peers) <= d.pushSyncReplication {
+		return
+	}
+	var sent int
+	for _, p := range peers[d.pushSyncReplication:] {
+		sp := d.getPeer(p.ID())
+		if sp == nil {
+			continue
+		}
+		err := sp.SendPriority(ctx, &PushSyncMsg{
+			Addr:  ch.Address(),
+			SData: ch.Data(),
+		}, Top)
+		if err != nil {
+			log.Debug("replicate send", "peer", sp.ID(), "addr", ch.Address(), "err", err)
+			continue
+		}
+		sent++
+	}
+	metrics.GetOrRegisterCounter("network.stream.replicate.count", nil).Inc(int64(sent))
+}
+
+// waitReceipts waits for the quorum of receipts and returns ids of peers
+// that sent them. Receipts received until the context is done are
+// returned with the context error.
+func (d *Delivery) waitReceipts(ctx context.Context, req *pushSyncRequest) (receipts []enode.ID, err error) {
+	select {
+	case <-req.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	d.pushesMu.Lock()
+	receipts = append(receipts, req.receipts...)
+	d.pushesMu.Unlock()
+	return receipts, err
+}
+
+// handlePushSyncMsg stores the pushed chunk and sends a signed receipt
+// to the peer when it is stored, if the chunk is within the nearest
+// neighbourhood of the node, for which the node is responsible.
+func (d *Delivery) handlePushSyncMsg(ctx context.Context, sp *Peer, req *PushSyncMsg) error {
+	go func() {
+		_, err := d.netStore.Put(ctx, chunk.ModePutSync, storage.NewChunk(req.Addr, req.SData))
+		if err != nil {
+			log.Debug("push-sync put", "peer", sp.ID(), "addr", req.Addr, "err", err)
+			if err == storage.ErrChunkInvalid {
+				sp.Drop()
+			}
+			return
+		}
+		if chunk.Proximity(d.kad.BaseAddr(), req.Addr) < d.kad.NeighbourhoodDepth() {
+			log.Trace("push-sync chunk not in neighbourhood", "peer", sp.ID(), "addr", req.Addr)
+			return
+		}
+		sig, err := d.signReceipt(req.Addr)
+		if err != nil {
+			log.Debug("push-sync receipt sign", "peer", sp.ID(), "addr", req.Addr, "err", err)
+			return
+		}
+		if err := sp.SendPriority(ctx, &ReceiptMsg{Addr: req.Addr, Sig: sig}, Top); err != nil {
+			log.Debug("push-sync receipt send", "peer", sp.ID(), "addr", req.Addr, "err", err)
+		}
+	}()
+	return nil
+}
+
+// setReceiptKey sets the node private key that receipts are signed with.
+func (d *Delivery) setReceiptKey(key *ecdsa.PrivateKey) {
+	d.pushesMu.Lock()
+	d.receiptKey = key
+	d.pushesMu.Unlock()
+}
+
+// receiptDigest returns the hash that is signed in a receipt for
+// the chunk with the provided address.
+func receiptDigest(addr storage.Address) []byte {
+	return crypto.Keccak256([]byte("swarm push-sync receipt"), addr)
+}
+
+// signReceipt signs the receipt for the chunk with the node private key.
+func (d *Delivery) signReceipt(addr storage.Address) ([]byte, error) {
+	d.pushesMu.Lock()
+	key := d.receiptKey
+	d.pushesMu.Unlock()
+
+	if key == nil {
+		return nil, errNoReceiptKey
+	}
+	return crypto.Sign(receiptDigest(addr), key)
+}
+
+// verifyReceipt returns an error if the receipt is not signed
+// by the node private key of the peer with the provided id.
+func verifyReceipt(id enode.ID, req *ReceiptMsg) error {
+	pub, err := crypto.SigToPub(receiptDigest(req.Addr), req.Sig)
+	if err != nil {
+		return fmt.Errorf("invalid receipt signature for chunk %s: %v", req.Addr, err)
+	}
+	if enode.PubkeyToIDV4(pub) != id {
+		return fmt.Errorf("receipt for chunk %s not signed by peer %s", req.Addr, id)
+	}
+	return nil
+}
+
+// handleReceiptMsg records the receipt if the chunk is pushed to the peer.
+// Receipts for chunks that are not pushed, or that are received after
+// PushSync returned, are ignored. A receipt that is not signed by the
+// peer is a protocol error.
+func (d *Delivery) handleReceiptMsg(sp *Peer, req *ReceiptMsg) error {
+	if err := verifyReceipt(sp.ID(), req); err != nil {
+		return err
+	}
+
+	d.pushesMu.Lock()
+	defer d.pushesMu.Unlock()
+
+	r, ok := d.pushes[string(req.Addr)]
+	if !ok {
+		return nil
+	}
+	if _, ok := r.peers[sp.ID()]; !ok {
+		return nil
+	}
+	delete(r.peers, sp.ID())
+	r.receipts = append(r.receipts, sp.ID())
+	if len(r.receipts) == r.quorum {
+		close(r.done)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethersphere/swarm/log"
+)
+
+// RecordedMsg is a stream protocol message exchanged with a peer,
+// as written by Recorder and read by Replay.
+type RecordedMsg struct {
+	Peer     enode.ID // peer that the message is exchanged with
+	Incoming bool     // true if the message is received from the peer
+	Code     uint64   // protocol message code
+	Payload  []byte   // RLP encoded message payload
+}
+
+// Recorder wraps a stream protocol run function, such as the one of
+// a Registry used with the p2p testing protocol tester, and writes all
+// messages exchanged with peers to a writer, one JSON encoded
+// RecordedMsg per line. Recorded messages can be fed back to a fresh
+// Registry with Replay to debug stream protocol issues.
+type Recorder struct {
+	run func(*p2p.Peer, p2p.MsgReadWriter) error
+	enc *json.Encoder
+	mu  sync.Mutex // serializes writes from different peers
+}
+
+// NewRecorder creates a new Recorder that writes messages
+// exchanged by the run function to the writer.
+func NewRecorder(w io.Writer, run func(*p2p.Peer, p2p.MsgReadWriter) error) *Recorder {
+	return &Recorder{
+		run: run,
+		enc: json.NewEncoder(w),
+	}
+}
+
+// Run runs the wrapped protocol run function with the peer, recording
+// messages read from and written to the message read writer.
+func (r *Recorder) Run(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+	return r.run(p, &recordingMsgReadWriter{
+		MsgReadWriter: rw,
+		recorder:      r,
+		peer:          p.ID(),
+	})
+}
+
+// record writes the message and returns it with the payload
+// that can be read again.
+func (r *Recorder) record(peer enode.ID, incoming bool, msg p2p.Msg) (p2p.Msg, error) {
+	payload, err := ioutil.ReadAll(msg.Payload)
+	if err != nil {
+		return msg, err
+	}
+	msg.Payload = bytes.NewReader(payload)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return msg, r.enc.Encode(RecordedMsg{
+		Peer:     peer,
+		Incoming: incoming,
+		Code:     msg.Code,
+		Payload:  payload,
+	})
+}
+
+// recordingMsgReadWriter records all messages that are read and written.
+type recordingMsgReadWriter struct {
+	p2p.MsgReadWriter
+	recorder *Recorder
+	peer     enode.ID
+}
+
+func (rw *recordingMsgReadWriter) ReadMsg() (p2p.Msg, error) {
+	msg, err := rw.MsgReadWriter.ReadMsg()
+	if err != nil {
+		return msg, err
+	}
+	return rw.recorder.record(rw.peer, true, msg)
+}
+
+func (rw *recordingMsgReadWriter) WriteMsg(msg p2p.Msg) (err error) {
+	msg, err = rw.recorder.record(rw.peer, false, msg)
+	if err != nil {
+		return err
+	}
+	return rw.MsgReadWriter.WriteMsg(msg)
+}
+
+// Replay reads messages recorded by Recorder and sends the ones that are
+// received from peers to the registry, in the recorded order, as if they
+// are sent by the same peers. Messages that the registry sends are
+// discarded. It returns when all messages are handled and the replayed
+// peers are disconnected.
+func Replay(r io.Reader, registry *Registry) (err error) {
+	pipes := make(map[enode.ID]*p2p.MsgPipeRW)
+	var wg sync.WaitGroup
+	defer func() {
+		for _, rw := range pipes {
+			rw.Close()
+		}
+		wg.Wait()
+	}()
+
+	dec := json.NewDecoder(r)
+	for {
+		var m RecordedMsg
+		if err := dec.Decode(&m); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if !m.Incoming {
+			continue
+		}
+		rw, ok := pipes[m.Peer]
+		if !ok {
+			var local *p2p.MsgPipeRW
+			local, rw = p2p.MsgPipe()
+			pipes[m.Peer] = rw
+
+			wg.Add(2)
+			go func(id enode.ID) {
+				defer wg.Done()
+				if err := registry.runProtocol(p2p.NewPeer(id, "replay", nil), local); err != nil {
+					log.Debug("replay protocol", "peer", id, "err", err)
+				}
+			}(m.Peer)
+			go func() {
+				defer wg.Done()
+				// discard messages sent by the registry
+				for {
+					msg, err := rw.ReadMsg()
+					if err != nil {
+						return
+					}
+					msg.Discard()
+				}
+			}()
+		}
+		err := rw.WriteMsg(p2p.Msg{
+			Code:    m.Code,
+			Size:    uint32(len(m.Payload)),
+			Payload: bytes.NewReader(m.Payload),
+		})
+		if err != nil {
+			return fmt.Errorf("replay message %d from peer %s: %v", m.Code, m.Peer, err)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	p2ptest "github.com/ethereum/go-ethereum/p2p/testing"
+	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/storage"
+	"github.com/ethersphere/swarm/storage/localstore"
+)
+
+// TestRecorderReplay records messages of a subscription and chunk
+// deliveries exchanged with a protocol tester node, replays them to a
+// fresh registry and validates that the same chunks are stored.
+func TestRecorderReplay(t *testing.T) {
+	f, err := ioutil.TempFile("", "swarm-stream-recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	tester, streamer, localStore, teardown, err := newStreamerTesterWithRecorder(&RegistryOptions{
+		Syncing: SyncingDisabled,
+	}, 1, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	newServer := func(p *Peer, t string, live bool) (Server, error) {
+		return newTestServer(t, 10), nil
+	}
+	streamer.RegisterServerFunc("foo", newServer)
+
+	node := tester.Nodes[0]
+	stream := NewStream("foo", "", false)
+	chunks := storage.GenerateRandomChunks(chunk.DefaultSize, 3)
+
+	exchanges := []p2ptest.Exchange{
+		{
+			Label: "Subscribe message",
+			Triggers: []p2ptest.Trigger{
+				{
+					Code: 4,
+					Msg: &SubscribeMsg{
+						Stream:   stream,
+						History:  NewRange(5, 8),
+						Priority: Top,
+					},
+					Peer: node.ID(),
+				},
+			},
+			Expects: []p2ptest.Expect{
+				{
+					Code: 1,
+					Msg: &OfferedHashesMsg{
+						Stream: stream,
+						HandoverProof: &HandoverProof{
+							Handover: &Handover{},
+						},
+						Hashes: make([]byte, HashSize),
+						From:   6,
+						To:     9,
+					},
+					Peer: node.ID(),
+				},
+			},
+		},
+	}
+	for _, ch := range chunks {
+		exchanges = append(exchanges, p2ptest.Exchange{
+			Label: "ChunkDelivery message",
+			Triggers: []p2ptest.Trigger{
+				{
+					Code: 6,
+					Msg: &ChunkDeliveryMsg{
+						Addr:  ch.Address(),
+						SData: ch.Data(),
+					},
+					Peer: node.ID(),
+				},
+			},
+		})
+	}
+	if err := tester.TestExchanges(exchanges...); err != nil {
+		t.Fatal(err)
+	}
+	waitChunks(t, localStore, chunks)
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	var incoming, outgoing int
+	dec := json.NewDecoder(f)
+	for {
+		var m RecordedMsg
+		if err := dec.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if m.Peer != node.ID() {
+			t.Errorf("got recorded message peer %s, want %s", m.Peer, node.ID())
+		}
+		if m.Incoming {
+			incoming++
+		} else {
+			outgoing++
+		}
+	}
+	if incoming != 1+len(chunks) {
+		t.Errorf("got %v recorded incoming messages, want %v", incoming, 1+len(chunks))
+	}
+	if outgoing != 1 {
+		t.Errorf("got %v recorded outgoing messages, want 1", outgoing)
+	}
+
+	_, replayStreamer, replayStore, replayTeardown, err := newStreamerTesterWithNodes(&RegistryOptions{
+		Syncing: SyncingDisabled,
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayTeardown()
+	replayStreamer.RegisterServerFunc("foo", newServer)
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if err := Replay(f, replayStreamer); err != nil {
+		t.Fatal(err)
+	}
+	waitChunks(t, replayStore, chunks)
+}
+
+// waitChunks waits for chunks to be stored in the local store
+// and validates their data.
+func waitChunks(t *testing.T, localStore *localstore.DB, chunks []storage.Chunk) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, ch := range chunks {
+		for {
+			got, err := localStore.Get(ctx, chunk.ModeGetLookup, ch.Address())
+			if err == nil {
+				if !bytes.Equal(got.Data(), ch.Data()) {
+					t.Errorf("got chunk %s data %x, want %x", ch.Address(), got.Data(), ch.Data())
+				}
+				break
+			}
+			select {
+			case <-ctx.Done():
+				t.Fatalf("chunk %s not stored: %v", ch.Address(), err)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"math/rand"
+	"sync/atomic"
+
+	"github.com/ethersphere/swarm/network"
+	"github.com/ethersphere/swarm/storage"
+)
+
+// PeerSelector chooses the peer that a retrieve request for the chunk
+// with the provided address is sent to. Peers are eligible to receive
+// the request and they are ordered by proximity to the chunk address,
+// the closest first. The slice is never empty. If nil is returned, the
+// request is not sent.
+type PeerSelector interface {
+	Select(addr storage.Address, peers []*network.Peer) *network.Peer
+}
+
+// ClosestPeerSelector selects the peer closest to the chunk address.
+// It is the default PeerSelector of Delivery.
+type ClosestPeerSelector struct{}
+
+// Select returns the first peer.
+func (ClosestPeerSelector) Select(_ storage.Address, peers []*network.Peer) *network.Peer {
+	return peers[0]
+}
+
+// RandomPeerSelector selects a random peer among the K peers closest
+// to the chunk address, spreading the requests between them.
+type RandomPeerSelector struct {
+	K int // number of the closest peers to select from, all peers if not positive
+}
+
+// Select returns a random peer among the first K peers.
+func (s RandomPeerSelector) Select(_ storage.Address, peers []*network.Peer) *network.Peer {
+	n := len(peers)
+	if s.K > 0 && s.K < n {
+		n = s.K
+	}
+	return peers[rand.Intn(n)]
+}
+
+// RoundRobinPeerSelector selects eligible peers in turns, regardless
+// of their proximity to the chunk address. It must not be copied
+// after the first use.
+type RoundRobinPeerSelector struct {
+	next uint64
+}
+
+// Select returns the next peer in turn.
+func (s *RoundRobinPeerSelector) Select(_ storage.Address, peers []*network.Peer) *network.Peer {
+	i := atomic.AddUint64(&s.next, 1) - 1
+	return peers[i%uint64(len(peers))]
+}
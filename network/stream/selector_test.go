@@ -0,0 +1,137 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+	"github.com/ethersphere/swarm/network"
+	pq "github.com/ethersphere/swarm/network/priorityqueue"
+	"github.com/ethersphere/swarm/storage"
+)
+
+// newSelectorTestPeers returns peers that are only used
+// to be compared with the ones chosen by selectors.
+func newSelectorTestPeers(count int) (peers []*network.Peer) {
+	for i := 0; i < count; i++ {
+		peers = append(peers, network.NewPeer(&network.BzzPeer{BzzAddr: network.RandomAddr()}, nil))
+	}
+	return peers
+}
+
+// TestClosestPeerSelector validates that the closest peer is selected.
+func TestClosestPeerSelector(t *testing.T) {
+	peers := newSelectorTestPeers(3)
+
+	for i := 0; i < 10; i++ {
+		if got := (ClosestPeerSelector{}).Select(storage.Address(hash0[:]), peers); got != peers[0] {
+			t.Fatalf("got peer %v, want the closest one", got)
+		}
+	}
+}
+
+// TestRandomPeerSelector validates that only the K closest peers
+// are selected and that all of them are selected eventually.
+func TestRandomPeerSelector(t *testing.T) {
+	peers := newSelectorTestPeers(5)
+
+	for _, tc := range []struct {
+		k    int
+		want int
+	}{
+		{k: 2, want: 2},
+		{k: 10, want: 5},
+		{k: 0, want: 5},
+	} {
+		s := RandomPeerSelector{K: tc.k}
+		selected := make(map[*network.Peer]int)
+		for i := 0; i < 1000; i++ {
+			p := s.Select(storage.Address(hash0[:]), peers)
+			selected[p]++
+		}
+		if len(selected) != tc.want {
+			t.Errorf("k %v: got %v selected peers, want %v", tc.k, len(selected), tc.want)
+		}
+		for _, p := range peers[:tc.want] {
+			if selected[p] == 0 {
+				t.Errorf("k %v: peer %v not selected", tc.k, p)
+			}
+		}
+	}
+}
+
+// TestRoundRobinPeerSelector validates that peers are selected in turns.
+func TestRoundRobinPeerSelector(t *testing.T) {
+	peers := newSelectorTestPeers(3)
+
+	s := new(RoundRobinPeerSelector)
+	for i := 0; i < 7; i++ {
+		if got, want := s.Select(storage.Address(hash0[:]), peers), peers[i%len(peers)]; got != want {
+			t.Fatalf("selection %v: got peer %v, want %v", i, got, want)
+		}
+	}
+}
+
+// TestRequestFromPeersPeerSelector validates that RequestFromPeers
+// sends requests to peers chosen by the PeerSelector from DeliveryOptions.
+func TestRequestFromPeersPeerSelector(t *testing.T) {
+	addr := network.RandomAddr()
+	to := network.NewKademlia(addr.OAddr, network.NewKadParams())
+	delivery := NewDelivery(to, nil, &DeliveryOptions{
+		PeerSelector: new(RoundRobinPeerSelector),
+	})
+	r := NewRegistry(addr.ID(), delivery, nil, nil, nil, nil)
+
+	peerIDs := []enode.ID{
+		enode.HexID("3431c3939e1ee2a6345e976a8234f9870152d64879f30bc272a074f6859e75e8"),
+		enode.HexID("99d8594b52298567d2ca3f4c441a5ba0140ee9245e26460d01102a52773c73b9"),
+	}
+	for _, id := range peerIDs {
+		protocolsPeer := protocols.NewPeer(p2p.NewPeer(id, "dummy", nil), nil, nil)
+		to.On(network.NewPeer(&network.BzzPeer{
+			BzzAddr:   network.RandomAddr(),
+			LightNode: false,
+			Peer:      protocolsPeer,
+		}, to))
+		// an empty priorityQueue has to be created to prevent a goroutine being called after the test has finished
+		r.setPeer(&Peer{
+			BzzPeer:  &network.BzzPeer{Peer: protocolsPeer, BzzAddr: addr},
+			pq:       pq.New(int(PriorityQueue), PriorityQueueCap),
+			streamer: r,
+		})
+	}
+
+	requested := make(map[enode.ID]int)
+	for i := 0; i < 4; i++ {
+		req := network.NewRequest(storage.Address(hash0[:]), true, &sync.Map{})
+		id, _, err := delivery.RequestFromPeers(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		requested[*id]++
+	}
+	for _, id := range peerIDs {
+		if requested[id] != 2 {
+			t.Errorf("peer %v: got %v requests, want 2", id, requested[id])
+		}
+	}
+}
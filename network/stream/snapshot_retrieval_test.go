@@ -189,8 +189,7 @@ func runPureRetrievalTest(t *testing.T, nodeCount int, chunkCount int) {
 
 			return r, cleanup, nil
 		},
-	},
-	)
+	}, nil)
 	defer sim.Close()
 
 	log.Info("Initializing test config", "node count", nodeCount)
@@ -245,16 +244,11 @@ func runPureRetrievalTest(t *testing.T, nodeCount int, chunkCount int) {
 		for _, id := range nodeIDs {
 			// for every chunk for this node (which are only indexes)...
 			for _, ch := range conf.idToChunksMap[id] {
-				item, ok := sim.NodeItem(id, bucketKeyStore)
-				if !ok {
-					return fmt.Errorf("Error accessing localstore")
-				}
-				lstore := item.(chunk.Store)
 				// ...get the actual chunk
 				for _, chnk := range chunks {
 					if bytes.Equal(chnk.Address(), conf.hashes[ch]) {
 						// ...and store it in the localstore
-						if _, err = lstore.Put(ctx, chunk.ModePutUpload, chnk); err != nil {
+						if err = sim.PutChunk(id, chnk); err != nil {
 							return err
 						}
 					}
@@ -316,7 +310,7 @@ func runFileRetrievalTest(t *testing.T, nodeCount int) {
 
 	t.Helper()
 
-	sim := simulation.New(retrievalSimServiceMap)
+	sim := simulation.New(retrievalSimServiceMap, nil)
 	defer sim.Close()
 
 	log.Info("Initializing test config", "node count", nodeCount)
@@ -404,7 +398,7 @@ func runRetrievalTest(t *testing.T, chunkCount int, nodeCount int) {
 
 	t.Helper()
 
-	sim := simulation.New(retrievalSimServiceMap)
+	sim := simulation.New(retrievalSimServiceMap, nil)
 	defer sim.Close()
 
 	conf := &synctestConfig{}
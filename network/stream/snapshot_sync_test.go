@@ -63,7 +63,7 @@ const (
 
 // Tests in this file should not request chunks from peers.
 // This function will panic indicating that there is a problem if request has been made.
-func dummyRequestFromPeers(_ context.Context, req *network.Request) (*enode.ID, chan struct{}, error) {
+func dummyRequestFromPeers(_ context.Context, req *network.Request, _ ...enode.ID) (*enode.ID, chan struct{}, error) {
 	panic(fmt.Sprintf("unexpected request: address %s, source %s", req.Addr.String(), req.Source.String()))
 }
 
@@ -134,7 +134,7 @@ var simServiceMap = map[string]simulation.ServiceFunc{
 }
 
 func testSyncingViaGlobalSync(t *testing.T, chunkCount int, nodeCount int) {
-	sim := simulation.New(simServiceMap)
+	sim := simulation.New(simServiceMap, nil)
 	defer sim.Close()
 
 	log.Info("Initializing test config")
@@ -17,11 +17,17 @@
 package stream
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"math"
 	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/metrics"
@@ -29,6 +35,7 @@ import (
 	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/p2p/protocols"
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethersphere/swarm/chunk"
 	"github.com/ethersphere/swarm/log"
 	"github.com/ethersphere/swarm/network"
 	"github.com/ethersphere/swarm/network/stream/intervals"
@@ -46,6 +53,14 @@ const (
 	HashSize         = 32
 )
 
+// StreamCounter returns a counter for the stream with the provided
+// name, registered under swarm/stream/<stream>/<name> metrics name,
+// for example swarm/stream/sync/chunks.sent. Counters are registered
+// on the first call and they are no-op if metrics are disabled.
+func StreamCounter(stream, name string) metrics.Counter {
+	return metrics.GetOrRegisterCounter(fmt.Sprintf("swarm/stream/%s/%s", strings.ToLower(stream), name), nil)
+}
+
 // Enumerate options for syncing and retrieval
 type SyncingOption int
 
@@ -64,6 +79,18 @@ const (
 // (see TestRequestPeerSubscriptions in streamer_test.go)
 var subscriptionFunc = doRequestSubscription
 
+// ErrRegistryClosing is returned to peers that request
+// a subscription while the registry is closing.
+var ErrRegistryClosing = errors.New("registry closing")
+
+// ErrLimitedLiveHistory is returned by SubscribeLimit when a limit
+// of delivered chunks is requested for a live stream with history.
+var ErrLimitedLiveHistory = errors.New("chunk limit for live stream with history")
+
+// ErrMessageFlood is returned from the message handler when a peer sends
+// more limited messages per second than RegistryOptions.MaxMessagesPerSecond.
+var ErrMessageFlood = errors.New("message flood")
+
 // Registry registry for outgoing and incoming streamer constructors
 type Registry struct {
 	addr            enode.ID
@@ -74,6 +101,7 @@ type Registry struct {
 	peersMu         sync.RWMutex
 	serverFuncs     map[string]func(*Peer, string, bool) (Server, error)
 	clientFuncs     map[string]func(*Peer, string, bool) (Client, error)
+	syncFilters     map[string]SyncFilter // sync stream filters by name, protected by serverMu
 	peers           map[enode.ID]*Peer
 	delivery        *Delivery
 	intervalsStore  state.Store
@@ -82,8 +110,34 @@ type Registry struct {
 	balance         protocols.Balance //implements protocols.Balance, for accounting
 	prices          protocols.Prices  //implements protocols.Prices, provides prices to accounting
 	quit            chan struct{}     // terminates registry goroutines
+	closeOnce       sync.Once         // guards closing quit channel
 	syncMode        SyncingOption
 	syncUpdateDelay time.Duration
+	syncBatchSize   int            // maximal number of chunk hashes in a syncing batch
+	syncProximity   bool           // order hashes in syncing batches by proximity to the peer
+	syncPaused      uint32         // set to 1 when outgoing syncing is paused, accessed atomically
+	syncResumeMu    sync.Mutex     // protects syncResumeC
+	syncResumeC     chan struct{}  // closed when paused syncing is resumed
+	capacityStore   CapacityStore  // local store that reports its free capacity, nil if not set
+	cancelledStore  CancelledStore // local store that reports chunks of cancelled uploads, nil if not set
+	highWatermark   float64        // ratio of filled store capacity above which wanted hashes are delayed
+	maxMessageRate  int            // maximal number of limited messages per second received from a peer, no limit if zero
+	idleTimeout     time.Duration  // time without offered hashes after which a subscription is renewed, disabled if zero
+	offeredTimeout  time.Duration  // time after which an unanswered offered hashes batch is dropped, disabled if zero
+	compression     bool           // compress chunk data in deliveries to peers that support it
+	closing         bool           // set by CloseContext, no new subscriptions and deliveries are accepted
+	deliveries      sync.WaitGroup // in-flight deliveries of wanted hashes
+	deliveriesMu    sync.Mutex     // protects closing and adding the first delivery
+	// called when a bounded history stream is complete and unsubscribed
+	streamCompleteFunc func(peerID enode.ID, s Stream)
+}
+
+// CapacityStore is implemented by chunk stores that are able to
+// report how many chunks can be stored before their garbage
+// collection target is reached, such as localstore.DB.
+type CapacityStore interface {
+	GCTarget() uint64
+	FreeCapacity() (uint64, error)
 }
 
 // RegistryOptions holds optional values for NewRegistry constructor.
@@ -91,7 +145,56 @@ type RegistryOptions struct {
 	SkipCheck       bool
 	Syncing         SyncingOption // Defines syncing behavior
 	SyncUpdateDelay time.Duration
-	MaxPeerServers  int // The limit of servers for each peer in registry
+	MaxPeerServers  int // The limit of servers for each peer in registry, subscriptions above it are rejected with ErrMaxPeerServers, no limit if zero
+	SyncBatchSize   int // Maximal number of chunk hashes offered in a single syncing batch, BatchSize if not positive
+	// HighWatermarkRatio is the ratio of the local store garbage
+	// collection target above which requesting of new chunks from
+	// syncing streams is delayed, for example 0.9. Zero disables it.
+	HighWatermarkRatio float64
+	// CapacityStore reports the free capacity of the local store for
+	// HighWatermarkRatio. It must be set explicitly, as the store of
+	// the NetStore is usually wrapped. Nil disables the high watermark.
+	CapacityStore CapacityStore
+	// StreamCompleteFunc is called when the upstream peer reports that all
+	// chunks in a bounded history range are offered and the stream is
+	// unsubscribed.
+	StreamCompleteFunc func(peerID enode.ID, s Stream)
+	// MaxMessagesPerSecond is the number of offered hashes, subscribe and
+	// unsubscribe messages that a peer is allowed to send in one second.
+	// A peer that sends more of them is dropped. Zero value means no limit.
+	MaxMessagesPerSecond int
+	// ProximityOrderedSync orders chunk hashes in every syncing batch
+	// by their proximity to the subscribed peer, so that the chunks
+	// that are most relevant to the peer are offered first.
+	ProximityOrderedSync bool
+	// SubscriptionIdleTimeout is the time after which a subscription
+	// to a connected peer is renewed, by unsubscribing and subscribing
+	// again, if no hashes are offered for it. Zero value disables it.
+	SubscriptionIdleTimeout time.Duration
+	// Compression enables compression of chunk data in delivery
+	// messages to peers that enable it too.
+	Compression bool
+	// SharedIntervalsStore, if set, provides the store for stream
+	// intervals in the database of the local chunk store, such as
+	// localstore.DB, instead of the intervals store passed to NewRegistry,
+	// which can be nil in that case. It avoids opening a separate
+	// database for intervals.
+	SharedIntervalsStore StateStoreProvider
+	// OfferedHashesTimeout is the time after which a batch of offered
+	// hashes that the downstream peer did not answer with wanted hashes
+	// is dropped and the next batch is offered, so that the stream does
+	// not stall. Zero value disables it.
+	OfferedHashesTimeout time.Duration
+	// CancelledStore reports chunks of cancelled uploads, which are
+	// not offered by syncing servers. It is usually the local store
+	// wrapped by the NetStore, such as localstore.DB.
+	CancelledStore CancelledStore
+}
+
+// StateStoreProvider is implemented by chunk stores that can store
+// other values in the same database, such as localstore.DB.
+type StateStoreProvider interface {
+	StateStore() state.Store
 }
 
 // NewRegistry is Streamer constructor
@@ -102,6 +205,12 @@ func NewRegistry(localID enode.ID, delivery *Delivery, netStore *storage.NetStor
 	if options.SyncUpdateDelay <= 0 {
 		options.SyncUpdateDelay = 15 * time.Second
 	}
+	if options.SyncBatchSize <= 0 {
+		options.SyncBatchSize = BatchSize
+	}
+	if options.SharedIntervalsStore != nil {
+		intervalsStore = options.SharedIntervalsStore.StateStore()
+	}
 
 	quit := make(chan struct{})
 
@@ -110,6 +219,7 @@ func NewRegistry(localID enode.ID, delivery *Delivery, netStore *storage.NetStor
 		skipCheck:       options.SkipCheck,
 		serverFuncs:     make(map[string]func(*Peer, string, bool) (Server, error)),
 		clientFuncs:     make(map[string]func(*Peer, string, bool) (Client, error)),
+		syncFilters:     make(map[string]SyncFilter),
 		peers:           make(map[enode.ID]*Peer),
 		delivery:        delivery,
 		intervalsStore:  intervalsStore,
@@ -117,7 +227,20 @@ func NewRegistry(localID enode.ID, delivery *Delivery, netStore *storage.NetStor
 		balance:         balance,
 		quit:            quit,
 		syncUpdateDelay: options.SyncUpdateDelay,
+		syncBatchSize:   options.SyncBatchSize,
+		syncProximity:   options.ProximityOrderedSync,
 		syncMode:        options.Syncing,
+		highWatermark:   options.HighWatermarkRatio,
+		maxMessageRate:  options.MaxMessagesPerSecond,
+		idleTimeout:     options.SubscriptionIdleTimeout,
+		offeredTimeout:  options.OfferedHashesTimeout,
+		compression:     options.Compression,
+		cancelledStore:  options.CancelledStore,
+
+		streamCompleteFunc: options.StreamCompleteFunc,
+	}
+	if options.HighWatermarkRatio > 0 {
+		streamer.capacityStore = options.CapacityStore
 	}
 
 	streamer.setupSpec()
@@ -142,11 +265,59 @@ func (r *Registry) setupSpec() {
 	r.createSpec()
 	// now create the pricing object
 	r.createPriceOracle()
+	bandwidth := &bandwidthHook{registry: r}
 	// if balance is nil, this node has been started without swap support (swapEnabled flag is false)
 	if r.balance != nil && !reflect.ValueOf(r.balance).IsNil() {
 		// swap is enabled, so setup the hook
-		r.spec.Hook = protocols.NewAccounting(r.balance, r.prices)
+		bandwidth.next = protocols.NewAccounting(r.balance, r.prices)
+	}
+	r.spec.Hook = bandwidth
+}
+
+// bandwidthHook is a protocols.Hook that counts message payload bytes
+// sent to and received from every peer, before calling the next hook,
+// if it is set.
+type bandwidthHook struct {
+	registry *Registry
+	next     protocols.Hook
+}
+
+// Send counts the bytes of the message sent to the peer.
+func (h *bandwidthHook) Send(peer *protocols.Peer, size uint32, msg interface{}) error {
+	if p := h.registry.getPeer(peer.ID()); p != nil {
+		atomic.AddUint64(&p.bytesSent, uint64(size))
+	}
+	metrics.GetOrRegisterCounter("stream.bytes.sent", nil).Inc(int64(size))
+	if h.next != nil {
+		return h.next.Send(peer, size, msg)
+	}
+	return nil
+}
+
+// Receive counts the bytes of the message received from the peer.
+func (h *bandwidthHook) Receive(peer *protocols.Peer, size uint32, msg interface{}) error {
+	if p := h.registry.getPeer(peer.ID()); p != nil {
+		atomic.AddUint64(&p.bytesReceived, uint64(size))
+	}
+	metrics.GetOrRegisterCounter("stream.bytes.received", nil).Inc(int64(size))
+	if h.next != nil {
+		return h.next.Receive(peer, size, msg)
+	}
+	return nil
+}
+
+// PeersBandwidth returns the number of message payload bytes sent to
+// and received from every connected peer on all streams. Counters are
+// reset when a peer disconnects.
+func (r *Registry) PeersBandwidth() map[enode.ID]Bandwidth {
+	r.peersMu.RLock()
+	defer r.peersMu.RUnlock()
+
+	bandwidth := make(map[enode.ID]Bandwidth, len(r.peers))
+	for id, p := range r.peers {
+		bandwidth[id] = p.Bandwidth()
 	}
+	return bandwidth
 }
 
 // RegisterClient registers an incoming streamer constructor
@@ -218,6 +389,20 @@ func (r *Registry) RequestSubscription(peerId enode.ID, s Stream, h *Range, prio
 
 // Subscribe initiates the streamer
 func (r *Registry) Subscribe(peerId enode.ID, s Stream, h *Range, priority uint8) error {
+	return r.SubscribeLimit(peerId, s, h, priority, 0)
+}
+
+// SubscribeLimit subscribes to the stream on the peer as Subscribe does,
+// but the peer completes the stream after maxChunks chunks are delivered,
+// when the subscription is removed. There is no limit if maxChunks is zero.
+// Limited subscriptions do not record synced intervals, as not all offered
+// chunks are retrieved, and they can not be requested for live streams
+// with history.
+func (r *Registry) SubscribeLimit(peerId enode.ID, s Stream, h *Range, priority uint8, maxChunks uint64) error {
+	if maxChunks > 0 && s.Live && h != nil {
+		return ErrLimitedLiveHistory
+	}
+
 	// check if the stream is registered
 	if _, err := r.GetClientFunc(s.Name); err != nil {
 		return err
@@ -228,12 +413,28 @@ func (r *Registry) Subscribe(peerId enode.ID, s Stream, h *Range, priority uint8
 		return fmt.Errorf("peer not found %v", peerId)
 	}
 
+	// keep the requested range for renewing the subscription
+	requested := h
+	if h != nil {
+		// resume history syncing from the first range that is not
+		// yet synced, as persisted in the intervals store
+		history := s
+		if s.Live {
+			history = getHistoryStream(s)
+		}
+		if from := r.historyFrom(peer, history, h.From); from != h.From {
+			h = NewRange(from, h.To)
+		}
+	}
+
 	var to uint64
 	if !s.Live && h != nil {
 		to = h.To
 	}
 
-	err := peer.setClientParams(s, newClientParams(priority, to))
+	cp := newClientParams(priority, to)
+	cp.maxChunks = maxChunks
+	err := peer.setClientParams(s, cp)
 	if err != nil {
 		return err
 	}
@@ -247,30 +448,232 @@ func (r *Registry) Subscribe(peerId enode.ID, s Stream, h *Range, priority uint8
 	}
 
 	msg := &SubscribeMsg{
-		Stream:   s,
-		History:  h,
-		Priority: priority,
+		Stream:    s,
+		History:   h,
+		Priority:  priority,
+		MaxChunks: maxChunks,
 	}
-	log.Debug("Subscribe ", "peer", peerId, "stream", s, "history", h)
+	log.Debug("Subscribe ", "peer", peerId, "stream", s, "history", h, "max chunks", maxChunks)
 
-	return peer.Send(context.TODO(), msg)
+	if err := peer.Send(context.TODO(), msg); err != nil {
+		return err
+	}
+	// limited subscriptions are not renewed
+	if r.idleTimeout > 0 && maxChunks == 0 {
+		peer.setSubscription(s, requested, priority)
+	}
+	return nil
 }
 
+// Unsubscribe cancels the subscription to the stream on the peer. The peer
+// is requested to stop its server for the stream, and the local client
+// and persisted intervals of the stream are removed, so that a new
+// subscription syncs the stream from the beginning. Unsubscribing from
+// a stream that is not subscribed to is not an error.
 func (r *Registry) Unsubscribe(peerId enode.ID, s Stream) error {
 	peer := r.getPeer(peerId)
 	if peer == nil {
 		return fmt.Errorf("peer not found %v", peerId)
 	}
 
-	msg := &UnsubscribeMsg{
-		Stream: s,
-	}
 	log.Debug("Unsubscribe ", "peer", peerId, "stream", s)
 
-	if err := peer.Send(context.TODO(), msg); err != nil {
+	if err := peer.unsubscribe(s); err != nil {
 		return err
 	}
-	return peer.removeClient(s)
+	return r.intervalsStore.Delete(peerStreamIntervalsKey(peer, s))
+}
+
+// ResyncBin syncs the proximity order bin po again from the beginning on
+// every peer that the Registry has a SYNC stream subscription for the bin
+// with. Live and history streams of the bin are unsubscribed, removing
+// their persisted intervals, and the live stream is subscribed again with
+// the full history, pulling all chunks in the bin that are missing locally.
+func (r *Registry) ResyncBin(po uint8) error {
+	live := NewStream("SYNC", FormatSyncBinKey(po), true)
+	history := getHistoryStream(live)
+
+	r.peersMu.RLock()
+	peers := make([]*Peer, 0, len(r.peers))
+	for _, p := range r.peers {
+		peers = append(peers, p)
+	}
+	r.peersMu.RUnlock()
+
+	for _, p := range peers {
+		priority, ok := p.clientPriority(live)
+		if !ok {
+			if _, ok := p.clientPriority(history); !ok {
+				continue
+			}
+			priority = High
+		}
+		log.Debug("Resync bin", "peer", p.ID(), "bin", po)
+		for _, s := range []Stream{live, history} {
+			if err := r.Unsubscribe(p.ID(), s); err != nil {
+				return err
+			}
+		}
+		if err := r.Subscribe(p.ID(), live, NewRange(0, 0), priority); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Subscription describes a stream that the Registry is subscribed to
+// on a peer, with its priority and the ranges of intervals that are
+// already synced. Subscription is pending if it is requested, but the
+// peer did not yet offer any hashes on the stream.
+type Subscription struct {
+	Stream    Stream
+	Live      bool
+	Priority  uint8
+	Intervals [][2]uint64
+	Pending   bool
+}
+
+// Subscriptions returns all client streams for every connected peer
+// together with their current interval ranges, including subscriptions
+// that are requested and for which clients are not yet created.
+func (r *Registry) Subscriptions() map[enode.ID][]Subscription {
+	subs := make(map[enode.ID][]Subscription)
+
+	r.peersMu.RLock()
+	defer r.peersMu.RUnlock()
+
+	for id, p := range r.peers {
+		p.clientMu.RLock()
+		for s, c := range p.clients {
+			subs[id] = append(subs[id], Subscription{
+				Stream:    s,
+				Live:      s.Live,
+				Priority:  c.priority,
+				Intervals: r.subscriptionIntervals(id, s, c.intervalsKey),
+			})
+		}
+		for s, params := range p.clientParams {
+			subs[id] = append(subs[id], Subscription{
+				Stream:    s,
+				Live:      s.Live,
+				Priority:  params.priority,
+				Intervals: r.subscriptionIntervals(id, s, peerStreamIntervalsKey(p, s)),
+				Pending:   true,
+			})
+		}
+		p.clientMu.RUnlock()
+	}
+	return subs
+}
+
+// subscriptionIntervals returns the synced interval ranges stored
+// under the key, or nil if there are none.
+func (r *Registry) subscriptionIntervals(id enode.ID, s Stream, key string) [][2]uint64 {
+	i := &intervals.Intervals{}
+	switch err := r.intervalsStore.Get(key, i); err {
+	case nil:
+		return i.Ranges()
+	case state.ErrNotFound:
+	default:
+		log.Error("stream subscriptions: get intervals", "peer", id, "stream", s, "err", err)
+	}
+	return nil
+}
+
+// StreamPeerInfo holds the protocol version and streams
+// of a connected stream peer.
+type StreamPeerInfo struct {
+	ID      enode.ID
+	Version uint     // negotiated stream protocol version
+	Servers []Stream // streams that we serve to the peer
+	Clients []Stream // streams that we request from the peer
+}
+
+// PeerInfo returns information about every connected stream peer,
+// sorted by node ID.
+func (r *Registry) PeerInfo() []StreamPeerInfo {
+	r.peersMu.RLock()
+	defer r.peersMu.RUnlock()
+
+	infos := make([]StreamPeerInfo, 0, len(r.peers))
+	for id, p := range r.peers {
+		info := StreamPeerInfo{
+			ID:      id,
+			Version: r.spec.Version,
+		}
+		p.serverMu.RLock()
+		for s := range p.servers {
+			info.Servers = append(info.Servers, s)
+		}
+		p.serverMu.RUnlock()
+		p.clientMu.RLock()
+		for s := range p.clients {
+			info.Clients = append(info.Clients, s)
+		}
+		p.clientMu.RUnlock()
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return bytes.Compare(infos[i].ID[:], infos[j].ID[:]) < 0
+	})
+	return infos
+}
+
+// Health holds the connectivity, storage and syncing
+// status of the node, as returned by Registry.Health.
+type Health struct {
+	KademliaHealthy bool   // connected to all known nearest neighbours and bins saturated
+	Depth           int    // kademlia neighbourhood depth
+	Peers           int    // number of connected stream peers
+	StoreSize       uint64 // number of chunks in the store garbage collection index
+	StoreCapacity   uint64 // store capacity, zero if the store does not report it
+	Subscriptions   int    // number of client stream subscriptions of all peers, including requested ones
+}
+
+// storeUsage is implemented by chunk stores that report their
+// size and capacity, such as localstore.DB.
+type storeUsage interface {
+	GCSize() (uint64, error)
+	Capacity() uint64
+}
+
+// Health returns the health report of the node. Kademlia is healthy
+// if the node is connected to all nearest neighbours and bins are
+// saturated with respect to the peers that the node knows about.
+func (r *Registry) Health() (h Health, err error) {
+	kad := r.delivery.kad
+	h.Depth = kad.NeighbourhoodDepth()
+
+	base := kad.BaseAddr()
+	addrs := [][]byte{base}
+	for _, a := range kad.ListKnown() {
+		addrs = append(addrs, a.Address())
+	}
+	pp := network.NewPeerPotMap(kad.NeighbourhoodSize, addrs)[hex.EncodeToString(base)]
+	h.KademliaHealthy = kad.GetHealthInfo(pp).Healthy()
+
+	var store chunk.Store
+	if r.delivery.netStore != nil {
+		store = r.delivery.netStore.Store
+	}
+	if s, ok := store.(storeUsage); ok {
+		h.StoreSize, err = s.GCSize()
+		if err != nil {
+			return h, err
+		}
+		h.StoreCapacity = s.Capacity()
+	}
+
+	r.peersMu.RLock()
+	defer r.peersMu.RUnlock()
+
+	h.Peers = len(r.peers)
+	for _, p := range r.peers {
+		p.clientMu.RLock()
+		h.Subscriptions += len(p.clients) + len(p.clientParams)
+		p.clientMu.RUnlock()
+	}
+	return h, nil
 }
 
 // Quit sends the QuitMsg to the peer to remove the
@@ -291,12 +694,178 @@ func (r *Registry) Quit(peerId enode.ID, s Stream) error {
 	return peer.Send(context.TODO(), msg)
 }
 
-func (r *Registry) Close() error {
-	// Stop sending neighborhood depth change and address count
-	// change from Kademlia that were initiated in NewRegistry constructor.
-	r.delivery.Close()
-	close(r.quit)
-	return r.intervalsStore.Close()
+// PauseSyncing stops sending new batches of offered hashes on all
+// outgoing SYNC streams, without removing subscriptions or dropping peers.
+// Chunks that are already requested by peers are still delivered.
+func (r *Registry) PauseSyncing() {
+	r.syncResumeMu.Lock()
+	defer r.syncResumeMu.Unlock()
+
+	if atomic.CompareAndSwapUint32(&r.syncPaused, 0, 1) {
+		r.syncResumeC = make(chan struct{})
+		log.Info("syncing paused")
+	}
+}
+
+// ResumeSyncing continues sending offered hashes on outgoing SYNC streams
+// paused by PauseSyncing. Streams continue from the intervals requested by
+// peers before the pause.
+func (r *Registry) ResumeSyncing() {
+	r.syncResumeMu.Lock()
+	defer r.syncResumeMu.Unlock()
+
+	if atomic.CompareAndSwapUint32(&r.syncPaused, 1, 0) {
+		close(r.syncResumeC)
+		log.Info("syncing resumed")
+	}
+}
+
+// SyncingPaused returns true if syncing is paused by PauseSyncing.
+func (r *Registry) SyncingPaused() bool {
+	return atomic.LoadUint32(&r.syncPaused) == 1
+}
+
+// waitSyncingResumed blocks while syncing is paused. It returns false if
+// the peer or the registry quit before syncing is resumed.
+func (r *Registry) waitSyncingResumed(p *Peer) bool {
+	if !r.SyncingPaused() {
+		return true
+	}
+	r.syncResumeMu.Lock()
+	resumeC := r.syncResumeC
+	r.syncResumeMu.Unlock()
+
+	select {
+	case <-resumeC:
+		return true
+	case <-p.quit:
+	case <-r.quit:
+	}
+	return false
+}
+
+// highWatermarkCheckInterval is the time between two checks of the
+// local store capacity while requesting new chunks is delayed.
+var highWatermarkCheckInterval = time.Second
+
+// aboveHighWatermark returns true if the local store is filled above
+// the configured high watermark ratio of its garbage collection target.
+func (r *Registry) aboveHighWatermark() bool {
+	if r.capacityStore == nil {
+		return false
+	}
+	target := r.capacityStore.GCTarget()
+	if target == 0 {
+		return false
+	}
+	free, err := r.capacityStore.FreeCapacity()
+	if err != nil {
+		log.Error("stream: get store free capacity", "err", err)
+		return false
+	}
+	return float64(target-free) >= float64(target)*r.highWatermark
+}
+
+// waitBelowHighWatermark blocks while the local store is filled above
+// the high watermark, but not longer than syncBatchTimeout, so that the
+// stream is only slowed down and not stalled. It returns false if the
+// client or the registry quit in the meantime.
+func (r *Registry) waitBelowHighWatermark(c *client) bool {
+	if !r.aboveHighWatermark() {
+		return true
+	}
+	metrics.GetOrRegisterCounter("stream.highwatermark.delayed", nil).Inc(1)
+	defer metrics.GetOrRegisterResettingTimer("stream.highwatermark.delay", nil).UpdateSince(time.Now())
+
+	ticker := time.NewTicker(highWatermarkCheckInterval)
+	defer ticker.Stop()
+	timeout := time.NewTimer(syncBatchTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !r.aboveHighWatermark() {
+				return true
+			}
+		case <-timeout.C:
+			return true
+		case <-c.quit:
+			return false
+		case <-r.quit:
+			return false
+		}
+	}
+}
+
+// CloseContext stops accepting new subscriptions and wanted hashes
+// and waits for the deliveries of already wanted chunks to be sent
+// before closing the registry. If the context is done before all
+// deliveries are sent, the registry is closed and the context error
+// is returned.
+func (r *Registry) CloseContext(ctx context.Context) error {
+	r.deliveriesMu.Lock()
+	r.closing = true
+	r.deliveriesMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.deliveries.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Warn("stream registry closed with pending deliveries", "err", ctx.Err())
+		err = ctx.Err()
+	}
+	if cerr := r.Close(); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// isClosing returns true if CloseContext has been called.
+func (r *Registry) isClosing() bool {
+	r.deliveriesMu.Lock()
+	defer r.deliveriesMu.Unlock()
+
+	return r.closing
+}
+
+// startDeliveries registers deliveries for a wanted hashes batch.
+// It returns false if the registry is closing. Every call that
+// returns true must be followed by the call to r.deliveries.Done.
+func (r *Registry) startDeliveries() bool {
+	r.deliveriesMu.Lock()
+	defer r.deliveriesMu.Unlock()
+
+	if r.closing {
+		return false
+	}
+	r.deliveries.Add(1)
+	return true
+}
+
+// Close terminates registry goroutines and closes the intervals store.
+// It is safe to call it more than once.
+func (r *Registry) Close() (err error) {
+	r.closeOnce.Do(func() {
+		// Stop sending neighborhood depth change and address count
+		// change from Kademlia that were initiated in NewRegistry constructor.
+		r.delivery.Close()
+		close(r.quit)
+		err = r.intervalsStore.Close()
+	})
+	return err
+}
+
+// SubscribeDeliveries calls f with the peer and the chunk address for every
+// chunk delivery message received from peers until the returned unsubscribe
+// function is called. It makes Registry a simulation.DeliveryNotifier.
+func (r *Registry) SubscribeDeliveries(f func(peer enode.ID, addr chunk.Address)) (unsubscribe func()) {
+	return r.delivery.SubscribeDeliveries(f)
 }
 
 func (r *Registry) getPeer(peerId enode.ID) *Peer {
@@ -333,18 +902,39 @@ func (r *Registry) peersCount() (c int) {
 func (r *Registry) Run(p *network.BzzPeer) error {
 	sp := NewPeer(p, r)
 	r.setPeer(sp)
+	defer r.deletePeer(sp)
+	defer close(sp.quit)
+	defer sp.close()
+
+	if r.compression {
+		if err := sp.SendPriority(context.TODO(), &CapabilitiesMsg{Compression: true}, Top); err != nil {
+			return err
+		}
+	}
 
 	if r.syncMode == SyncingAutoSubscribe {
 		go sp.runUpdateSyncing()
 	}
-
-	defer r.deletePeer(sp)
-	defer close(sp.quit)
-	defer sp.close()
+	if r.idleTimeout > 0 {
+		go sp.runSubscriptionWatchdog(r.idleTimeout)
+	}
 
 	return sp.Run(sp.HandleMsg)
 }
 
+// floodableMsg returns true for the messages that are counted against
+// RegistryOptions.MaxMessagesPerSecond. Only the messages that a peer
+// can send unsolicited in large numbers and that are expensive to
+// handle are counted, so that deliveries and other replies to the
+// requests of this node are not limited.
+func floodableMsg(msg interface{}) bool {
+	switch msg.(type) {
+	case *OfferedHashesMsg, *SubscribeMsg, *UnsubscribeMsg:
+		return true
+	}
+	return false
+}
+
 // doRequestSubscription sends the actual RequestSubscription to the peer
 func doRequestSubscription(r *Registry, id enode.ID, bin uint8) error {
 	log.Debug("Requesting subscription by registry:", "registry", r.addr, "peer", id, "bin", bin)
@@ -379,6 +969,14 @@ func (p *Peer) HandleMsg(ctx context.Context, msg interface{}) error {
 	default:
 	}
 
+	if max := p.streamer.maxMessageRate; max > 0 && floodableMsg(msg) {
+		if count := p.countMessage(time.Now()); count > max {
+			metrics.GetOrRegisterCounter("peer.handlemsg.flood", nil).Inc(1)
+			log.Warn("stream message flood, dropping peer", "peer", p.ID(), "messages", count, "limit", max)
+			return ErrMessageFlood
+		}
+	}
+
 	switch msg := msg.(type) {
 
 	case *SubscribeMsg:
@@ -458,6 +1056,25 @@ func (p *Peer) HandleMsg(ctx context.Context, msg interface{}) error {
 	case *QuitMsg:
 		return p.handleQuitMsg(msg)
 
+	case *StreamCompleteMsg:
+		go func() {
+			err := p.handleStreamCompleteMsg(msg)
+			if err != nil {
+				log.Error(err.Error())
+				p.Drop()
+			}
+		}()
+		return nil
+
+	case *CapabilitiesMsg:
+		return p.handleCapabilitiesMsg(msg)
+
+	case *PushSyncMsg:
+		return p.streamer.delivery.handlePushSyncMsg(ctx, p, msg)
+
+	case *ReceiptMsg:
+		return p.streamer.delivery.handleReceiptMsg(p, msg)
+
 	default:
 		return fmt.Errorf("unknown message type: %T", msg)
 	}
@@ -469,6 +1086,110 @@ type server struct {
 	priority     uint8
 	currentBatch []byte
 	sessionIndex uint64
+	maxChunks    uint64      // number of chunks to deliver before the stream is complete, no limit if zero
+	chunks       uint64      // number of chunks to deliver counted against maxChunks, accessed atomically
+	batchTimer   *time.Timer // drops the current batch if it is not answered, nil if not offered or disabled
+	batchID      uint64      // incremented for every timed batch, so that stale timers are ignored
+	closed       bool        // set when the server is closed
+	batchMu      sync.Mutex  // protects currentBatch, batchTimer, batchID and closed
+}
+
+// setBatch records the batch of hashes offered to the peer. If the
+// offered hashes timeout is set, the batch is dropped after it and
+// the hashes from the batch end to the rangeTo are offered.
+func (s *server) setBatch(p *Peer, hashes []byte, to, rangeTo uint64) {
+	s.batchMu.Lock()
+	defer s.batchMu.Unlock()
+
+	s.currentBatch = hashes
+	if s.batchTimer != nil {
+		s.batchTimer.Stop()
+		s.batchTimer = nil
+	}
+	timeout := p.streamer.offeredTimeout
+	if timeout <= 0 || s.closed {
+		return
+	}
+	s.batchID++
+	id := s.batchID
+	s.batchTimer = time.AfterFunc(timeout, func() {
+		s.dropBatch(p, id, to+1, rangeTo)
+	})
+}
+
+// takeBatch returns the offered batch of hashes that a wanted hashes
+// message answers and stops its timeout. If the batch is timed, it is
+// not returned again, and nil is returned if it is already dropped.
+func (s *server) takeBatch() []byte {
+	s.batchMu.Lock()
+	defer s.batchMu.Unlock()
+
+	hashes := s.currentBatch
+	if s.batchTimer != nil {
+		s.batchTimer.Stop()
+		s.batchTimer = nil
+		s.batchID++
+		s.currentBatch = nil
+	}
+	return hashes
+}
+
+// dropBatch drops the offered batch with the id if it is not answered
+// and offers the next batch.
+func (s *server) dropBatch(p *Peer, id, from, to uint64) {
+	s.batchMu.Lock()
+	if s.closed || s.batchID != id {
+		s.batchMu.Unlock()
+		return
+	}
+	s.currentBatch = nil
+	s.batchTimer = nil
+	s.batchMu.Unlock()
+
+	metrics.GetOrRegisterCounter("stream.offeredhashes.timeout", nil).Inc(1)
+	log.Debug("offered hashes timeout", "peer", p.ID(), "stream", s.stream, "from", from, "to", to)
+	if err := p.SendOfferedHashes(s, from, to); err != nil {
+		log.Warn("SendOfferedHashes error", "peer", p.ID().TerminalString(), "err", err)
+	}
+}
+
+// Close stops the offered batch timeout and closes the Server.
+func (s *server) Close() {
+	s.batchMu.Lock()
+	s.closed = true
+	if s.batchTimer != nil {
+		s.batchTimer.Stop()
+		s.batchTimer = nil
+	}
+	s.batchMu.Unlock()
+
+	s.Server.Close()
+}
+
+// reserveChunks counts n wanted chunks against the subscription limit and
+// returns how many of them can be delivered.
+func (s *server) reserveChunks(n uint64) uint64 {
+	if s.maxChunks == 0 {
+		return n
+	}
+	for {
+		chunks := atomic.LoadUint64(&s.chunks)
+		if chunks >= s.maxChunks {
+			return 0
+		}
+		if chunks+n > s.maxChunks {
+			n = s.maxChunks - chunks
+		}
+		if atomic.CompareAndSwapUint64(&s.chunks, chunks, chunks+n) {
+			return n
+		}
+	}
+}
+
+// limitReached returns true if no more chunks can be delivered
+// within the subscription limit.
+func (s *server) limitReached() bool {
+	return s.maxChunks > 0 && atomic.LoadUint64(&s.chunks) >= s.maxChunks
 }
 
 // setNextBatch adjusts passed interval based on session index and whether
@@ -493,6 +1214,17 @@ func (s *server) setNextBatch(from, to uint64) ([]byte, uint64, uint64, *Handove
 	return s.SetNextBatch(from, to)
 }
 
+// rangeComplete returns true if the history stream is requested with a
+// bounded range and there are no more chunks to offer in it, either
+// because the range end is passed or because the last bin ID at the
+// time of subscription is reached.
+func (s *server) rangeComplete(from, to uint64) bool {
+	if s.stream.Live || to == 0 || to == math.MaxUint64 {
+		return false
+	}
+	return from > to || from > s.sessionIndex || s.sessionIndex == 0
+}
+
 // Server interface for outgoing peer Streamer
 type Server interface {
 	// SessionIndex is called when a server is initialized
@@ -513,15 +1245,49 @@ type client struct {
 	to        uint64
 	next      chan error
 	quit      chan struct{}
+	maxChunks uint64 // subscription limit of delivered chunks, no limit if zero
+	wanted    uint64 // number of wanted chunks counted against maxChunks, accessed atomically
 
 	intervalsKey   string
 	intervalsStore state.Store
 }
 
+// peerStreamIntervalsKey returns the key under which the intervals of
+// the stream s with peer p are persisted. It is derived only from the
+// peer ID and the stream name, key and live flag, so that the same
+// intervals are loaded when the peer connects again after a restart.
 func peerStreamIntervalsKey(p *Peer, s Stream) string {
 	return p.ID().String() + s.String()
 }
 
+// historyFrom returns the bin ID from which the history stream s should
+// be requested from the peer p. It is the start of the first range that
+// is not synced in persisted history and live intervals, or from if it
+// is greater.
+func (r *Registry) historyFrom(p *Peer, s Stream, from uint64) uint64 {
+	i := &intervals.Intervals{}
+	switch err := r.intervalsStore.Get(peerStreamIntervalsKey(p, s), i); err {
+	case nil:
+	case state.ErrNotFound:
+		return from
+	default:
+		log.Error("stream history from: get history intervals", "stream", s, "peer", p, "err", err)
+		return from
+	}
+	live := &intervals.Intervals{}
+	switch err := r.intervalsStore.Get(peerStreamIntervalsKey(p, NewStream(s.Name, s.Key, true)), live); err {
+	case nil:
+		i.Merge(live)
+	case state.ErrNotFound:
+	default:
+		log.Error("stream history from: get live intervals", "stream", s, "peer", p, "err", err)
+	}
+	if start, _ := i.Next(); start > from {
+		return start
+	}
+	return from
+}
+
 func (c *client) AddInterval(start, end uint64) (err error) {
 	i := &intervals.Intervals{}
 	if err = c.intervalsStore.Get(c.intervalsKey, i); err != nil {
@@ -585,13 +1351,31 @@ func (c *client) batchDone(p *Peer, req *OfferedHashesMsg, hashes []byte) error
 			return err
 		}
 		if c.to > 0 && tp.Takeover.End >= c.to {
-			return p.streamer.Unsubscribe(p.Peer.ID(), req.Stream)
+			return p.unsubscribe(req.Stream)
 		}
 		return nil
 	}
+	// not all offered chunks are retrieved
+	// within the subscription limit
+	if c.maxChunks > 0 {
+		return nil
+	}
 	return c.AddInterval(req.From, req.To)
 }
 
+// addWanted counts a wanted chunk against the subscription limit.
+func (c *client) addWanted() {
+	if c.maxChunks > 0 {
+		atomic.AddUint64(&c.wanted, 1)
+	}
+}
+
+// limitReached returns true if the upstream peer does not deliver
+// more chunks within the subscription limit.
+func (c *client) limitReached() bool {
+	return c.maxChunks > 0 && atomic.LoadUint64(&c.wanted) >= c.maxChunks
+}
+
 func (c *client) close() {
 	select {
 	case <-c.quit:
@@ -604,8 +1388,9 @@ func (c *client) close() {
 // clientParams store parameters for the new client
 // between a subscription and initial offered hashes request handling.
 type clientParams struct {
-	priority uint8
-	to       uint64
+	priority  uint8
+	to        uint64
+	maxChunks uint64
 	// signal when the client is created
 	clientCreatedC chan struct{}
 }
@@ -642,7 +1427,7 @@ func (r *Registry) createSpec() {
 	// Spec is the spec of the streamer protocol
 	var spec = &protocols.Spec{
 		Name:       "stream",
-		Version:    8,
+		Version:    12,
 		MaxMsgSize: 10 * 1024 * 1024,
 		Messages: []interface{}{
 			UnsubscribeMsg{},
@@ -656,6 +1441,10 @@ func (r *Registry) createSpec() {
 			RequestSubscriptionMsg{},
 			QuitMsg{},
 			ChunkDeliveryMsgSyncing{},
+			StreamCompleteMsg{},
+			PushSyncMsg{},
+			ReceiptMsg{},
+			CapabilitiesMsg{},
 		},
 	}
 	r.spec = spec
@@ -730,6 +1519,9 @@ func (r *Registry) APIs() []rpc.API {
 }
 
 func (r *Registry) Start(server *p2p.Server) error {
+	// push-sync receipts are signed with the node key,
+	// so that they can be verified with the peer id
+	r.delivery.setReceiptKey(server.PrivateKey)
 	log.Info("Streamer started")
 	return nil
 }
@@ -774,14 +1566,81 @@ func NewAPI(r *Registry) *API {
 	}
 }
 
-func (api *API) SubscribeStream(peerId enode.ID, s Stream, history *Range, priority uint8) error {
-	return api.streamer.Subscribe(peerId, s, history, priority)
+// SubscribeStream subscribes to the stream on the peer. The optional
+// maxChunks argument limits the number of chunks that the peer delivers
+// before the stream is complete and the subscription is removed.
+func (api *API) SubscribeStream(peerId enode.ID, s Stream, history *Range, priority uint8, maxChunks *uint64) error {
+	var limit uint64
+	if maxChunks != nil {
+		limit = *maxChunks
+	}
+	return api.streamer.SubscribeLimit(peerId, s, history, priority, limit)
 }
 
+// UnsubscribeStream cancels the subscription to the stream on the peer.
+// It can be called via RPC as stream_unsubscribeStream.
 func (api *API) UnsubscribeStream(peerId enode.ID, s Stream) error {
 	return api.streamer.Unsubscribe(peerId, s)
 }
 
+// ResyncBin syncs the proximity order bin po again from the beginning
+// on all peers that the bin is synced from.
+// It can be called via RPC as stream_resyncBin.
+func (api *API) ResyncBin(po uint8) error {
+	return api.streamer.ResyncBin(po)
+}
+
+// PauseSyncing stops offering new chunks on all outgoing syncing streams.
+// It can be called via RPC as stream_pauseSyncing.
+func (api *API) PauseSyncing() {
+	api.streamer.PauseSyncing()
+}
+
+// ResumeSyncing continues offering chunks on outgoing syncing streams
+// paused by PauseSyncing.
+// It can be called via RPC as stream_resumeSyncing.
+func (api *API) ResumeSyncing() {
+	api.streamer.ResumeSyncing()
+}
+
+// Subscriptions returns client stream subscriptions for every
+// connected peer, keyed by peer node ID. It can be called via RPC
+// as stream_subscriptions.
+func (api *API) Subscriptions() map[string][]Subscription {
+	subs := make(map[string][]Subscription)
+	for id, s := range api.streamer.Subscriptions() {
+		subs[id.String()] = s
+	}
+	return subs
+}
+
+// PeerInfo returns the negotiated protocol version and streams of
+// every connected peer. It can be called via RPC as stream_peerInfo.
+func (api *API) PeerInfo() []StreamPeerInfo {
+	return api.streamer.PeerInfo()
+}
+
+// Health returns the kademlia, store and subscriptions status
+// of the node. It can be called via RPC as stream_health.
+func (api *API) Health() (Health, error) {
+	return api.streamer.Health()
+}
+
+// ProximityOrder returns the proximity order of the hex encoded address,
+// for example of a chunk, relative to the kademlia base address of the
+// node. It can be called via RPC as stream_proximityOrder.
+func (api *API) ProximityOrder(addr string) (int, error) {
+	a, err := hex.DecodeString(strings.TrimPrefix(addr, "0x"))
+	if err != nil {
+		return 0, err
+	}
+	kad := api.streamer.delivery.kad
+	if len(a) != len(kad.BaseAddr()) {
+		return 0, fmt.Errorf("invalid address length %d, want %d", len(a), len(kad.BaseAddr()))
+	}
+	return kad.ProximityOrder(a), nil
+}
+
 /*
 GetPeerServerSubscriptions is a API function which allows to query a peer for stream subscriptions it has.
 It can be called via RPC.
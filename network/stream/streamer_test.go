@@ -19,8 +19,10 @@ package stream
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
@@ -29,13 +31,18 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
 	p2ptest "github.com/ethereum/go-ethereum/p2p/testing"
+	"github.com/ethersphere/swarm/chunk"
 	"github.com/ethersphere/swarm/network"
 	"github.com/ethersphere/swarm/network/simulation"
+	"github.com/ethersphere/swarm/network/stream/intervals"
 	"github.com/ethersphere/swarm/state"
+	"github.com/ethersphere/swarm/storage"
+	"github.com/ethersphere/swarm/storage/localstore"
 	"github.com/ethersphere/swarm/testutil"
 	"golang.org/x/crypto/sha3"
 )
@@ -238,6 +245,741 @@ func TestStreamerDownstreamSubscribeUnsubscribeMsgExchange(t *testing.T) {
 	}
 }
 
+// TestStreamerSubscriptionIdleTimeout validates that a subscription
+// to a stream that has no offered hashes for SubscriptionIdleTimeout
+// is renewed by unsubscribing from the live and history streams and
+// subscribing again with the same arguments.
+func TestStreamerSubscriptionIdleTimeout(t *testing.T) {
+	tester, streamer, _, teardown, err := newStreamerTester(&RegistryOptions{
+		SubscriptionIdleTimeout: 500 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	streamer.RegisterClientFunc("foo", func(p *Peer, t string, live bool) (Client, error) {
+		return newTestClient(t), nil
+	})
+
+	node := tester.Nodes[0]
+
+	stream := NewStream("foo", "", true)
+	err = streamer.Subscribe(node.ID(), stream, NewRange(5, 8), Top)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	subscribe := p2ptest.Expect{
+		Code: 4,
+		Msg: &SubscribeMsg{
+			Stream:   stream,
+			History:  NewRange(5, 8),
+			Priority: Top,
+		},
+		Peer: node.ID(),
+	}
+
+	// the stream server on the peer does not offer any hashes
+	err = tester.TestExchanges(
+		p2ptest.Exchange{
+			Label:   "Subscribe message",
+			Expects: []p2ptest.Expect{subscribe},
+		},
+		p2ptest.Exchange{
+			Label: "Renewed subscription",
+			Expects: []p2ptest.Expect{
+				{
+					Code: 0,
+					Msg: &UnsubscribeMsg{
+						Stream: stream,
+					},
+					Peer: node.ID(),
+				},
+				{
+					Code: 0,
+					Msg: &UnsubscribeMsg{
+						Stream: getHistoryStream(stream),
+					},
+					Peer: node.ID(),
+				},
+				subscribe,
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestStreamerDownstreamOfferedHashesInFlight validates that hashes
+// offered by two peers are wanted only from the peer that offered
+// them first, while the chunks are not yet delivered.
+func TestStreamerDownstreamOfferedHashesInFlight(t *testing.T) {
+	tester, streamer, _, teardown, err := newStreamerTesterWithNodes(nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	streamer.RegisterClientFunc("foo", func(p *Peer, t string, live bool) (Client, error) {
+		return newTestClient(t), nil
+	})
+
+	stream := NewStream("foo", "", true)
+
+	for i, want := range [][]byte{{5}, {0}} {
+		node := tester.Nodes[i]
+
+		err = streamer.Subscribe(node.ID(), stream, NewRange(5, 8), Top)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		err = tester.TestExchanges(
+			p2ptest.Exchange{
+				Label: "Subscribe message",
+				Expects: []p2ptest.Expect{
+					{
+						Code: 4,
+						Msg: &SubscribeMsg{
+							Stream:   stream,
+							History:  NewRange(5, 8),
+							Priority: Top,
+						},
+						Peer: node.ID(),
+					},
+				},
+			},
+			p2ptest.Exchange{
+				Label: "OfferedHashes message",
+				Triggers: []p2ptest.Trigger{
+					{
+						Code: 1,
+						Msg: &OfferedHashesMsg{
+							HandoverProof: &HandoverProof{
+								Handover: &Handover{},
+							},
+							Hashes: hashes,
+							From:   5,
+							To:     8,
+							Stream: stream,
+						},
+						Peer: node.ID(),
+					},
+				},
+				Expects: []p2ptest.Expect{
+					{
+						Code: 2,
+						Msg: &WantedHashesMsg{
+							Stream: stream,
+							Want:   want,
+							From:   9,
+							To:     0,
+						},
+						Peer: node.ID(),
+					},
+				},
+			},
+		)
+		if err != nil {
+			t.Fatalf("node %v: %v", i, err)
+		}
+	}
+}
+
+// TestRegistrySubscriptions validates that Registry.Subscriptions
+// and API.Subscriptions return client streams with their priority
+// and intervals, as pending until the client is created by the
+// offered hashes.
+func TestRegistrySubscriptions(t *testing.T) {
+	tester, streamer, _, teardown, err := newStreamerTester(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	streamer.RegisterClientFunc("foo", func(p *Peer, t string, live bool) (Client, error) {
+		return newTestClient(t), nil
+	})
+
+	node := tester.Nodes[0]
+
+	if subs := streamer.Subscriptions(); len(subs[node.ID()]) != 0 {
+		t.Fatalf("got subscriptions %v, want none", subs)
+	}
+
+	stream := NewStream("foo", "", true)
+	err = streamer.Subscribe(node.ID(), stream, NewRange(5, 8), Top)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// live and history subscriptions are requested
+	subs := streamer.Subscriptions()[node.ID()]
+	if len(subs) != 2 {
+		t.Fatalf("got %v subscriptions, want 2", len(subs))
+	}
+	for _, sub := range subs {
+		if !sub.Pending {
+			t.Errorf("got subscription %v not pending before hashes are offered", sub.Stream)
+		}
+	}
+
+	err = tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "Subscribe message",
+			Expects: []p2ptest.Expect{
+				{
+					Code: 4,
+					Msg: &SubscribeMsg{
+						Stream:   stream,
+						History:  NewRange(5, 8),
+						Priority: Top,
+					},
+					Peer: node.ID(),
+				},
+			},
+		},
+		p2ptest.Exchange{
+			Label: "OfferedHashes message",
+			Triggers: []p2ptest.Trigger{
+				{
+					Code: 1,
+					Msg: &OfferedHashesMsg{
+						HandoverProof: &HandoverProof{
+							Handover: &Handover{},
+						},
+						Hashes: hashes,
+						From:   5,
+						To:     8,
+						Stream: stream,
+					},
+					Peer: node.ID(),
+				},
+			},
+			Expects: []p2ptest.Expect{
+				{
+					Code: 2,
+					Msg: &WantedHashesMsg{
+						Stream: stream,
+						Want:   []byte{5},
+						From:   9,
+						To:     0,
+					},
+					Peer: node.ID(),
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the live subscription is offered hashes
+	subs = streamer.Subscriptions()[node.ID()]
+	if len(subs) != 2 {
+		t.Fatalf("got %v subscriptions, want 2", len(subs))
+	}
+	var sub Subscription
+	for _, s := range subs {
+		if s.Stream == stream {
+			sub = s
+		} else if !s.Pending {
+			t.Errorf("got history subscription %v not pending", s.Stream)
+		}
+	}
+	if sub.Stream != stream {
+		t.Fatalf("no subscription to stream %v in %v", stream, subs)
+	}
+	if sub.Pending {
+		t.Error("got live subscription pending after hashes are offered")
+	}
+	if !sub.Live {
+		t.Error("got history subscription, want live")
+	}
+	if sub.Priority != Top {
+		t.Errorf("got priority %v, want %v", sub.Priority, Top)
+	}
+	if len(sub.Intervals) != 0 {
+		t.Errorf("got intervals %v, want none", sub.Intervals)
+	}
+
+	apiSubs := NewAPI(streamer).Subscriptions()
+	if len(apiSubs[node.ID().String()]) != 2 {
+		t.Errorf("got api subscriptions %v", apiSubs)
+	}
+}
+
+// TestRegistryUnsubscribe validates that Unsubscribe removes the client
+// and intervals of the stream, stops the server on the upstream peer so
+// that no more hashes are offered, and that it can be called repeatedly.
+func TestRegistryUnsubscribe(t *testing.T) {
+	sim := simulation.New(map[string]simulation.ServiceFunc{
+		"streamer": func(ctx *adapters.ServiceContext, bucket *sync.Map) (s node.Service, cleanup func(), err error) {
+			addr, netStore, delivery, clean, err := newNetStoreAndDelivery(ctx, bucket)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			r := NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &RegistryOptions{
+				Syncing:   SyncingRegisterOnly,
+				SkipCheck: true,
+			}, nil)
+			bucket.Store(bucketKeyRegistry, r)
+
+			cleanup = func() {
+				r.Close()
+				clean()
+			}
+
+			return r, cleanup, nil
+		},
+	}, nil)
+	defer sim.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := sim.AddNodesAndConnectChain(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) error {
+		nodeIDs := sim.UpNodeIDs()
+		clientID, serverID := nodeIDs[0], nodeIDs[1]
+
+		item, ok := sim.NodeItem(clientID, bucketKeyRegistry)
+		if !ok {
+			return errors.New("no client registry")
+		}
+		clientRegistry := item.(*Registry)
+		item, ok = sim.NodeItem(serverID, bucketKeyRegistry)
+		if !ok {
+			return errors.New("no server registry")
+		}
+		serverRegistry := item.(*Registry)
+		item, ok = sim.NodeItem(serverID, bucketKeyStore)
+		if !ok {
+			return errors.New("no server store")
+		}
+		serverStore := item.(chunk.Store)
+
+		offeredHashesMsgCode, ok := clientRegistry.GetSpec().GetCode(OfferedHashesMsg{})
+		if !ok {
+			return errors.New("no offered hashes message code")
+		}
+		eventsFilter := simulation.NewPeerEventsFilter().ReceivedMessages().Protocol("stream").MsgCode(offeredHashesMsgCode)
+		eventsCtx, eventsCancel := context.WithCancel(ctx)
+		defer eventsCancel()
+		offered := sim.PeerEvents(eventsCtx, []enode.ID{clientID}, eventsFilter)
+
+		// bin 0 holds about a half of random chunks
+		putChunks := func() error {
+			for _, ch := range storage.GenerateRandomChunks(chunk.DefaultSize, 10) {
+				if _, err := serverStore.Put(ctx, chunk.ModePutUpload, ch); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		// wait for the stream peer to be registered
+		for clientRegistry.getPeer(serverID) == nil {
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		peer := clientRegistry.getPeer(serverID)
+
+		stream := NewStream("SYNC", FormatSyncBinKey(0), true)
+		if err := clientRegistry.Subscribe(serverID, stream, nil, Top); err != nil {
+			return err
+		}
+		// wait for the server to be created before putting chunks,
+		// as the live stream offers only chunks put after it
+		for {
+			p := serverRegistry.getPeer(clientID)
+			if p != nil {
+				if _, err := p.getServer(stream); err == nil {
+					break
+				}
+			}
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if err := putChunks(); err != nil {
+			return err
+		}
+		select {
+		case e := <-offered:
+			if e.Error != nil {
+				return e.Error
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		eventsCancel()
+
+		// the client is created by offered hashes, wait for
+		// the batch to be stored and its interval persisted
+		intervalsKey := peerStreamIntervalsKey(peer, stream)
+		for {
+			err := clientRegistry.intervalsStore.Get(intervalsKey, &intervals.Intervals{})
+			if err == nil {
+				break
+			}
+			if err != state.ErrNotFound {
+				return err
+			}
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if subs := clientRegistry.Subscriptions()[serverID]; len(subs) != 1 || subs[0].Stream != stream {
+			return fmt.Errorf("got subscriptions %v, want %v", subs, stream)
+		}
+
+		for i := 0; i < 2; i++ {
+			if err := clientRegistry.Unsubscribe(serverID, stream); err != nil {
+				return fmt.Errorf("unsubscribe %v: %v", i, err)
+			}
+		}
+
+		if subs := clientRegistry.Subscriptions()[serverID]; len(subs) != 0 {
+			return fmt.Errorf("got subscriptions %v, want none", subs)
+		}
+		if err := clientRegistry.intervalsStore.Get(intervalsKey, &intervals.Intervals{}); err != state.ErrNotFound {
+			return fmt.Errorf("got intervals error %v, want %v", err, state.ErrNotFound)
+		}
+
+		// wait for the server to be removed
+		for {
+			if _, err := serverRegistry.getPeer(clientID).getServer(stream); err != nil {
+				break
+			}
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		// do not count hashes offered before the server is removed
+		time.Sleep(100 * time.Millisecond)
+
+		offered = sim.PeerEvents(ctx, []enode.ID{clientID}, eventsFilter)
+		if err := putChunks(); err != nil {
+			return err
+		}
+		select {
+		case e := <-offered:
+			return fmt.Errorf("got offered hashes after unsubscribe: %+v", e)
+		case <-time.After(time.Second):
+		}
+		return nil
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+}
+
+// TestRegistryPeerInfo validates that PeerInfo reports the protocol
+// version and the served streams of a connected peer.
+func TestRegistryPeerInfo(t *testing.T) {
+	tester, streamer, _, teardown, err := newStreamerTester(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	stream := NewStream("foo", "", false)
+
+	streamer.RegisterServerFunc("foo", func(p *Peer, t string, live bool) (Server, error) {
+		return newTestServer(t, 10), nil
+	})
+
+	node := tester.Nodes[0]
+
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "Subscribe message",
+		Triggers: []p2ptest.Trigger{
+			{
+				Code: 4,
+				Msg: &SubscribeMsg{
+					Stream:   stream,
+					History:  NewRange(5, 8),
+					Priority: Top,
+				},
+				Peer: node.ID(),
+			},
+		},
+		Expects: []p2ptest.Expect{
+			{
+				Code: 1,
+				Msg: &OfferedHashesMsg{
+					Stream: stream,
+					HandoverProof: &HandoverProof{
+						Handover: &Handover{},
+					},
+					Hashes: make([]byte, HashSize),
+					From:   6,
+					To:     9,
+				},
+				Peer: node.ID(),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos := NewAPI(streamer).PeerInfo()
+	if len(infos) != 1 {
+		t.Fatalf("got %v peers, want 1", len(infos))
+	}
+	info := infos[0]
+	if info.ID != node.ID() {
+		t.Errorf("got peer id %v, want %v", info.ID, node.ID())
+	}
+	if info.Version != streamer.spec.Version {
+		t.Errorf("got version %v, want %v", info.Version, streamer.spec.Version)
+	}
+	if len(info.Servers) != 1 || info.Servers[0] != stream {
+		t.Errorf("got servers %v, want [%v]", info.Servers, stream)
+	}
+	if len(info.Clients) != 0 {
+		t.Errorf("got clients %v, want none", info.Clients)
+	}
+}
+
+// TestStreamerUpstreamOfferedHashesTimeout validates that a batch of
+// offered hashes that the downstream peer does not answer is dropped
+// after the offered hashes timeout and that the next batch is offered.
+func TestStreamerUpstreamOfferedHashesTimeout(t *testing.T) {
+	tester, streamer, _, teardown, err := newStreamerTester(&RegistryOptions{
+		OfferedHashesTimeout: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	stream := NewStream("foo", "", false)
+
+	streamer.RegisterServerFunc("foo", func(p *Peer, t string, live bool) (Server, error) {
+		return &singleHashServer{testServer: newTestServer(t, 100)}, nil
+	})
+
+	node := tester.Nodes[0]
+
+	offered := func(from uint64) p2ptest.Expect {
+		return p2ptest.Expect{
+			Code: 1,
+			Msg: &OfferedHashesMsg{
+				Stream: stream,
+				HandoverProof: &HandoverProof{
+					Handover: &Handover{},
+				},
+				Hashes: hash0[:],
+				From:   from,
+				To:     from,
+			},
+			Peer: node.ID(),
+		}
+	}
+
+	// the offered batches are not answered with wanted hashes
+	err = tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "Subscribe message",
+			Triggers: []p2ptest.Trigger{
+				{
+					Code: 4,
+					Msg: &SubscribeMsg{
+						Stream:   stream,
+						History:  NewRange(5, 0),
+						Priority: Top,
+					},
+					Peer: node.ID(),
+				},
+			},
+			Expects: []p2ptest.Expect{offered(5)},
+		},
+		p2ptest.Exchange{
+			Label:   "Offered hashes after timeout",
+			Expects: []p2ptest.Expect{offered(6)},
+		},
+		p2ptest.Exchange{
+			Label:   "Offered hashes after second timeout",
+			Expects: []p2ptest.Expect{offered(7)},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// singleHashServer offers one hash in every batch.
+type singleHashServer struct {
+	*testServer
+}
+
+func (s *singleHashServer) SetNextBatch(from uint64, to uint64) ([]byte, uint64, uint64, *HandoverProof, error) {
+	return hash0[:], from, from, nil, nil
+}
+
+// TestAPIProximityOrder validates proximity orders returned by the
+// stream_proximityOrder RPC method for addresses derived from the
+// kademlia base address.
+func TestAPIProximityOrder(t *testing.T) {
+	_, streamer, _, teardown, err := newStreamerTester(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	api := NewAPI(streamer)
+	base := streamer.delivery.kad.BaseAddr()
+
+	// addr returns the base address with the bit at position i flipped
+	addr := func(i int) string {
+		a := make([]byte, len(base))
+		copy(a, base)
+		a[i/8] ^= 0x80 >> uint(i%8)
+		return hex.EncodeToString(a)
+	}
+
+	for _, tc := range []struct {
+		addr string
+		po   int
+	}{
+		{addr: addr(0), po: 0},
+		{addr: addr(10), po: 10},
+		{addr: "0x" + addr(255), po: 255},
+		{addr: hex.EncodeToString(base), po: 256},
+	} {
+		po, err := api.ProximityOrder(tc.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if po != tc.po {
+			t.Errorf("got proximity order %v for address %s, want %v", po, tc.addr, tc.po)
+		}
+	}
+
+	if _, err := api.ProximityOrder("0102"); err == nil {
+		t.Error("expected error for short address")
+	}
+	if _, err := api.ProximityOrder("not hex"); err == nil {
+		t.Error("expected error for invalid hex")
+	}
+}
+
+// TestAPIHealth validates that the health report returned by the
+// stream_health RPC method in a simulation of two connected syncing
+// nodes is consistent with kademlia, subscriptions and store state.
+func TestAPIHealth(t *testing.T) {
+	sim := simulation.New(map[string]simulation.ServiceFunc{
+		"streamer": func(ctx *adapters.ServiceContext, bucket *sync.Map) (s node.Service, cleanup func(), err error) {
+			addr, netStore, delivery, clean, err := newNetStoreAndDelivery(ctx, bucket)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			r := NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &RegistryOptions{
+				Syncing:   SyncingAutoSubscribe,
+				SkipCheck: true,
+			}, nil)
+			bucket.Store(bucketKeyRegistry, r)
+
+			cleanup = func() {
+				r.Close()
+				clean()
+			}
+
+			return r, cleanup, nil
+		},
+	}, nil)
+	defer sim.Close()
+
+	ids, err := sim.AddNodesAndConnectFull(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) error {
+		id := ids[0]
+
+		item, ok := sim.NodeItem(id, bucketKeyStore)
+		if !ok {
+			return errors.New("no store")
+		}
+		store := item.(*localstore.DB)
+		if _, err := store.Put(ctx, chunk.ModePutRequest, storage.GenerateRandomChunk(chunk.DefaultSize)); err != nil {
+			return err
+		}
+
+		item, ok = sim.NodeItem(id, bucketKeyRegistry)
+		if !ok {
+			return errors.New("no registry")
+		}
+		registry := item.(*Registry)
+
+		client, err := sim.Net.GetNode(id).Client()
+		if err != nil {
+			return err
+		}
+
+		// wait for the connection and sync subscriptions to be established
+		var h Health
+		for {
+			if err := client.CallContext(ctx, &h, "stream_health"); err != nil {
+				return err
+			}
+			if h.KademliaHealthy && h.Peers == 1 && h.Subscriptions > 0 {
+				break
+			}
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-ctx.Done():
+				return fmt.Errorf("got health %+v: %v", h, ctx.Err())
+			}
+		}
+
+		if depth := registry.delivery.kad.NeighbourhoodDepth(); h.Depth != depth {
+			return fmt.Errorf("got depth %v, want %v", h.Depth, depth)
+		}
+		if capacity := store.Capacity(); h.StoreCapacity != capacity {
+			return fmt.Errorf("got store capacity %v, want %v", h.StoreCapacity, capacity)
+		}
+		if h.StoreSize < 1 || h.StoreSize > h.StoreCapacity {
+			return fmt.Errorf("got store size %v, want at least 1 and at most capacity %v", h.StoreSize, h.StoreCapacity)
+		}
+		var subscriptions int
+		for _, subs := range registry.Subscriptions() {
+			subscriptions += len(subs)
+		}
+		if h.Subscriptions > subscriptions {
+			return fmt.Errorf("got %v subscriptions, want at most %v", h.Subscriptions, subscriptions)
+		}
+		return nil
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+}
+
 func TestStreamerUpstreamSubscribeUnsubscribeMsgExchange(t *testing.T) {
 	tester, streamer, _, teardown, err := newStreamerTester(nil)
 	if err != nil {
@@ -263,46 +1005,314 @@ func TestStreamerUpstreamSubscribeUnsubscribeMsgExchange(t *testing.T) {
 					History:  NewRange(5, 8),
 					Priority: Top,
 				},
-				Peer: node.ID(),
+				Peer: node.ID(),
+			},
+		},
+		Expects: []p2ptest.Expect{
+			{
+				Code: 1,
+				Msg: &OfferedHashesMsg{
+					Stream: stream,
+					HandoverProof: &HandoverProof{
+						Handover: &Handover{},
+					},
+					Hashes: make([]byte, HashSize),
+					From:   6,
+					To:     9,
+				},
+				Peer: node.ID(),
+			},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "unsubscribe message",
+		Triggers: []p2ptest.Trigger{
+			{
+				Code: 0,
+				Msg: &UnsubscribeMsg{
+					Stream: stream,
+				},
+				Peer: node.ID(),
+			},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// chunkServer is a test Server that offers a single batch with one
+// hash and serves the same data for every requested hash.
+type chunkServer struct {
+	*testServer
+	data    []byte
+	offered bool
+	quit    chan struct{}
+}
+
+func (s *chunkServer) SetNextBatch(from uint64, to uint64) ([]byte, uint64, uint64, *HandoverProof, error) {
+	if !s.offered {
+		s.offered = true
+		return s.testServer.SetNextBatch(from, to)
+	}
+	<-s.quit
+	return nil, 0, 0, nil, nil
+}
+
+func (s *chunkServer) GetData(context.Context, []byte) ([]byte, error) {
+	return s.data, nil
+}
+
+func (s *chunkServer) Close() {
+	close(s.quit)
+}
+
+// blockingChunkServer is a chunkServer that signals when chunk data
+// is requested and returns it only after the release channel is closed.
+type blockingChunkServer struct {
+	*chunkServer
+	getDataC chan struct{}
+	release  chan struct{}
+}
+
+func (s *blockingChunkServer) GetData(ctx context.Context, addr []byte) ([]byte, error) {
+	close(s.getDataC)
+	<-s.release
+	return s.chunkServer.GetData(ctx, addr)
+}
+
+// TestRegistryCloseContext validates that CloseContext waits for
+// the in-flight delivery of a wanted chunk before closing the registry.
+func TestRegistryCloseContext(t *testing.T) {
+	tester, streamer, _, teardown, err := newStreamerTester(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	stream := NewStream("foo", "", false)
+	data := []byte("in-flight chunk data")
+	getDataC := make(chan struct{})
+	release := make(chan struct{})
+
+	streamer.RegisterServerFunc(stream.Name, func(p *Peer, t string, live bool) (Server, error) {
+		return &blockingChunkServer{
+			chunkServer: &chunkServer{
+				testServer: newTestServer(t, 10),
+				data:       data,
+				quit:       make(chan struct{}),
+			},
+			getDataC: getDataC,
+			release:  release,
+		}, nil
+	})
+
+	node := tester.Nodes[0]
+
+	err = tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "Subscribe message",
+			Triggers: []p2ptest.Trigger{
+				{
+					Code: 4,
+					Msg: &SubscribeMsg{
+						Stream:   stream,
+						History:  NewRange(5, 8),
+						Priority: Top,
+					},
+					Peer: node.ID(),
+				},
+			},
+			Expects: []p2ptest.Expect{
+				{
+					Code: 1,
+					Msg: &OfferedHashesMsg{
+						Stream: stream,
+						HandoverProof: &HandoverProof{
+							Handover: &Handover{},
+						},
+						Hashes: make([]byte, HashSize),
+						From:   6,
+						To:     9,
+					},
+					Peer: node.ID(),
+				},
+			},
+		},
+		p2ptest.Exchange{
+			Label: "WantedHashes message",
+			Triggers: []p2ptest.Trigger{
+				{
+					Code: 2,
+					Msg: &WantedHashesMsg{
+						Stream: stream,
+						Want:   []byte{1},
+						From:   10,
+						To:     12,
+					},
+					Peer: node.ID(),
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-getDataC:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the delivery to start")
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- streamer.CloseContext(context.Background())
+	}()
+
+	select {
+	case err := <-closed:
+		t.Fatalf("registry closed before the in-flight delivery is sent: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "ChunkDelivery message",
+		Expects: []p2ptest.Expect{
+			{
+				Code: 10,
+				Msg: &ChunkDeliveryMsgSyncing{
+					Addr:  make([]byte, HashSize),
+					SData: data,
+				},
+				Peer: node.ID(),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the registry to close")
+	}
+}
+
+// TestStreamCountersSent validates that chunks and bytes sent counters
+// are incremented for the stream when wanted chunks are delivered.
+func TestStreamCountersSent(t *testing.T) {
+	defer func(enabled bool) { metrics.Enabled = enabled }(metrics.Enabled)
+	metrics.Enabled = true
+
+	tester, streamer, _, teardown, err := newStreamerTester(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	stream := NewStream("counted", "", false)
+	data := []byte("counted stream chunk data")
+
+	streamer.RegisterServerFunc(stream.Name, func(p *Peer, t string, live bool) (Server, error) {
+		return &chunkServer{
+			testServer: newTestServer(t, 10),
+			data:       data,
+			quit:       make(chan struct{}),
+		}, nil
+	})
+
+	chunksSent := StreamCounter(stream.Name, "chunks.sent")
+	bytesSent := StreamCounter(stream.Name, "bytes.sent")
+	chunksSentBefore, bytesSentBefore := chunksSent.Count(), bytesSent.Count()
+
+	node := tester.Nodes[0]
+
+	err = tester.TestExchanges(
+		p2ptest.Exchange{
+			Label: "Subscribe message",
+			Triggers: []p2ptest.Trigger{
+				{
+					Code: 4,
+					Msg: &SubscribeMsg{
+						Stream:   stream,
+						History:  NewRange(5, 8),
+						Priority: Top,
+					},
+					Peer: node.ID(),
+				},
 			},
-		},
-		Expects: []p2ptest.Expect{
-			{
-				Code: 1,
-				Msg: &OfferedHashesMsg{
-					Stream: stream,
-					HandoverProof: &HandoverProof{
-						Handover: &Handover{},
+			Expects: []p2ptest.Expect{
+				{
+					Code: 1,
+					Msg: &OfferedHashesMsg{
+						Stream: stream,
+						HandoverProof: &HandoverProof{
+							Handover: &Handover{},
+						},
+						Hashes: make([]byte, HashSize),
+						From:   6,
+						To:     9,
 					},
-					Hashes: make([]byte, HashSize),
-					From:   6,
-					To:     9,
+					Peer: node.ID(),
 				},
-				Peer: node.ID(),
 			},
 		},
-	})
-
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	err = tester.TestExchanges(p2ptest.Exchange{
-		Label: "unsubscribe message",
-		Triggers: []p2ptest.Trigger{
-			{
-				Code: 0,
-				Msg: &UnsubscribeMsg{
-					Stream: stream,
+		p2ptest.Exchange{
+			Label: "WantedHashes message",
+			Triggers: []p2ptest.Trigger{
+				{
+					Code: 2,
+					Msg: &WantedHashesMsg{
+						Stream: stream,
+						Want:   []byte{1},
+						From:   10,
+						To:     12,
+					},
+					Peer: node.ID(),
+				},
+			},
+			Expects: []p2ptest.Expect{
+				{
+					Code: 10,
+					Msg: &ChunkDeliveryMsgSyncing{
+						Addr:  make([]byte, HashSize),
+						SData: data,
+					},
+					Peer: node.ID(),
 				},
-				Peer: node.ID(),
 			},
 		},
-	})
-
+	)
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	// counters are incremented after the message is queued for sending
+	for i := 0; i < 100 && chunksSent.Count() == chunksSentBefore; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := chunksSent.Count() - chunksSentBefore; got != 1 {
+		t.Errorf("got %v chunks sent, want 1", got)
+	}
+	if got := bytesSent.Count() - bytesSentBefore; got != int64(len(data)) {
+		t.Errorf("got %v bytes sent, want %v", got, len(data))
+	}
+	if metrics.DefaultRegistry.Get("swarm/stream/counted/chunks.sent") == nil {
+		t.Error("chunks sent counter is not registered")
+	}
 }
 
 func TestStreamerUpstreamSubscribeUnsubscribeMsgExchangeLive(t *testing.T) {
@@ -473,24 +1483,258 @@ func TestStreamerUpstreamSubscribeLiveAndHistory(t *testing.T) {
 		},
 	})
 
-	if err != nil {
-		t.Fatal(err)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamerDownstreamCorruptHashesMsgExchange(t *testing.T) {
+	tester, streamer, _, teardown, err := newStreamerTester(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	stream := NewStream("foo", "", true)
+
+	var tc *testClient
+
+	streamer.RegisterClientFunc("foo", func(p *Peer, t string, live bool) (Client, error) {
+		tc = newTestClient(t)
+		return tc, nil
+	})
+
+	node := tester.Nodes[0]
+
+	err = streamer.Subscribe(node.ID(), stream, NewRange(5, 8), Top)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "Subscribe message",
+		Expects: []p2ptest.Expect{
+			{
+				Code: 4,
+				Msg: &SubscribeMsg{
+					Stream:   stream,
+					History:  NewRange(5, 8),
+					Priority: Top,
+				},
+				Peer: node.ID(),
+			},
+		},
+	},
+		p2ptest.Exchange{
+			Label: "Corrupt offered hash message",
+			Triggers: []p2ptest.Trigger{
+				{
+					Code: 1,
+					Msg: &OfferedHashesMsg{
+						HandoverProof: &HandoverProof{
+							Handover: &Handover{},
+						},
+						Hashes: corruptHashes,
+						From:   5,
+						To:     8,
+						Stream: stream,
+					},
+					Peer: node.ID(),
+				},
+			},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedError := errors.New("subprotocol error")
+	if err := tester.TestDisconnected(&p2ptest.Disconnect{Peer: node.ID(), Error: expectedError}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestStreamerMessageFlood validates that a peer sending more limited
+// messages per second than RegistryOptions.MaxMessagesPerSecond is
+// dropped and that other messages are not counted.
+func TestStreamerMessageFlood(t *testing.T) {
+	maxMessages := 5
+
+	tester, streamer, _, teardown, err := newStreamerTester(&RegistryOptions{
+		MaxMessagesPerSecond: maxMessages,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	node := tester.Nodes[0]
+
+	send := func(code uint64, msg interface{}, count int) error {
+		triggers := make([]p2ptest.Trigger, count)
+		for i := range triggers {
+			triggers[i] = p2ptest.Trigger{
+				Code: code,
+				Msg:  msg,
+				Peer: node.ID(),
+			}
+		}
+		return tester.TestExchanges(p2ptest.Exchange{
+			Label:    "Flood messages",
+			Triggers: triggers,
+		})
+	}
+	quitMsg := &QuitMsg{
+		Stream: NewStream("foo", "", true),
+	}
+	unsubscribeMsg := &UnsubscribeMsg{
+		Stream: NewStream("foo", "", true),
+	}
+
+	// messages that are not limited are not counted
+	if err := send(9, quitMsg, 2*maxMessages); err != nil {
+		t.Fatal(err)
+	}
+	// messages within the limit are handled
+	if err := send(0, unsubscribeMsg, maxMessages); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if streamer.getPeer(node.ID()) == nil {
+		t.Fatal("peer dropped before exceeding the message limit")
+	}
+
+	// the peer is dropped on the first message over the limit
+	if err := send(0, unsubscribeMsg, 1); err != nil {
+		t.Fatal(err)
+	}
+	expectedError := fmt.Errorf("Message handler error: (msg code 0): %v", ErrMessageFlood)
+	if err := tester.TestDisconnected(&p2ptest.Disconnect{Peer: node.ID(), Error: expectedError}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamerDownstreamOfferedHashesMsgExchange(t *testing.T) {
+	tester, streamer, _, teardown, err := newStreamerTester(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	stream := NewStream("foo", "", true)
+
+	var tc *testClient
+
+	streamer.RegisterClientFunc("foo", func(p *Peer, t string, live bool) (Client, error) {
+		tc = newTestClient(t)
+		return tc, nil
+	})
+
+	node := tester.Nodes[0]
+
+	err = streamer.Subscribe(node.ID(), stream, NewRange(5, 8), Top)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "Subscribe message",
+		Expects: []p2ptest.Expect{
+			{
+				Code: 4,
+				Msg: &SubscribeMsg{
+					Stream:   stream,
+					History:  NewRange(5, 8),
+					Priority: Top,
+				},
+				Peer: node.ID(),
+			},
+		},
+	},
+		p2ptest.Exchange{
+			Label: "WantedHashes message",
+			Triggers: []p2ptest.Trigger{
+				{
+					Code: 1,
+					Msg: &OfferedHashesMsg{
+						HandoverProof: &HandoverProof{
+							Handover: &Handover{},
+						},
+						Hashes: hashes,
+						From:   5,
+						To:     8,
+						Stream: stream,
+					},
+					Peer: node.ID(),
+				},
+			},
+			Expects: []p2ptest.Expect{
+				{
+					Code: 2,
+					Msg: &WantedHashesMsg{
+						Stream: stream,
+						Want:   []byte{5},
+						From:   9,
+						To:     0,
+					},
+					Peer: node.ID(),
+				},
+			},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tc.receivedHashes) != 3 {
+		t.Fatalf("Expected number of received hashes %v, got %v", 3, len(tc.receivedHashes))
+	}
+
+	close(tc.wait0)
+
+	timeout := time.NewTimer(100 * time.Millisecond)
+	defer timeout.Stop()
+
+	select {
+	case <-tc.batchDone:
+		t.Fatal("batch done early")
+	case <-timeout.C:
+	}
+
+	close(tc.wait2)
+
+	timeout2 := time.NewTimer(10000 * time.Millisecond)
+	defer timeout2.Stop()
+
+	select {
+	case <-tc.batchDone:
+	case <-timeout2.C:
+		t.Fatal("timeout waiting batchdone call")
 	}
+
 }
 
-func TestStreamerDownstreamCorruptHashesMsgExchange(t *testing.T) {
-	tester, streamer, _, teardown, err := newStreamerTester(nil)
+// TestStreamerDownstreamStreamComplete validates that the client
+// unsubscribes from a bounded history stream and calls the stream
+// complete callback when the upstream peer reports that the range
+// is exhausted.
+func TestStreamerDownstreamStreamComplete(t *testing.T) {
+	completeC := make(chan Stream, 1)
+	tester, streamer, _, teardown, err := newStreamerTester(&RegistryOptions{
+		StreamCompleteFunc: func(_ enode.ID, s Stream) {
+			completeC <- s
+		},
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer teardown()
 
-	stream := NewStream("foo", "", true)
+	stream := NewStream("foo", "", false)
 
-	var tc *testClient
+	tc := newTestClient("")
+	close(tc.wait0)
+	close(tc.wait2)
 
 	streamer.RegisterClientFunc("foo", func(p *Peer, t string, live bool) (Client, error) {
-		tc = newTestClient(t)
 		return tc, nil
 	})
 
@@ -516,7 +1760,7 @@ func TestStreamerDownstreamCorruptHashesMsgExchange(t *testing.T) {
 		},
 	},
 		p2ptest.Exchange{
-			Label: "Corrupt offered hash message",
+			Label: "WantedHashes message",
 			Triggers: []p2ptest.Trigger{
 				{
 					Code: 1,
@@ -524,7 +1768,7 @@ func TestStreamerDownstreamCorruptHashesMsgExchange(t *testing.T) {
 						HandoverProof: &HandoverProof{
 							Handover: &Handover{},
 						},
-						Hashes: corruptHashes,
+						Hashes: hashes,
 						From:   5,
 						To:     8,
 						Stream: stream,
@@ -532,43 +1776,79 @@ func TestStreamerDownstreamCorruptHashesMsgExchange(t *testing.T) {
 					Peer: node.ID(),
 				},
 			},
+			Expects: []p2ptest.Expect{
+				{
+					Code: 2,
+					Msg: &WantedHashesMsg{
+						Stream: stream,
+						Want:   []byte{5},
+						From:   9,
+						To:     8,
+					},
+					Peer: node.ID(),
+				},
+			},
+		},
+		p2ptest.Exchange{
+			Label: "Unsubscribe message",
+			Triggers: []p2ptest.Trigger{
+				{
+					Code: 11,
+					Msg: &StreamCompleteMsg{
+						Stream: stream,
+					},
+					Peer: node.ID(),
+				},
+			},
+			Expects: []p2ptest.Expect{
+				{
+					Code: 0,
+					Msg: &UnsubscribeMsg{
+						Stream: stream,
+					},
+					Peer: node.ID(),
+				},
+			},
 		})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	expectedError := errors.New("subprotocol error")
-	if err := tester.TestDisconnected(&p2ptest.Disconnect{Peer: node.ID(), Error: expectedError}); err != nil {
-		t.Fatal(err)
+	select {
+	case s := <-completeC:
+		if s != stream {
+			t.Errorf("got completed stream %v, want %v", s, stream)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for stream complete callback")
+	}
+
+	if subs := streamer.Subscriptions()[node.ID()]; len(subs) != 0 {
+		t.Errorf("got subscriptions %v, want none", subs)
 	}
 }
 
-func TestStreamerDownstreamOfferedHashesMsgExchange(t *testing.T) {
+// TestStreamerUpstreamStreamComplete validates that the server sends
+// StreamCompleteMsg when the next batch is requested beyond the bounded
+// history range.
+func TestStreamerUpstreamStreamComplete(t *testing.T) {
 	tester, streamer, _, teardown, err := newStreamerTester(nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer teardown()
 
-	stream := NewStream("foo", "", true)
-
-	var tc *testClient
+	stream := NewStream("foo", "", false)
 
-	streamer.RegisterClientFunc("foo", func(p *Peer, t string, live bool) (Client, error) {
-		tc = newTestClient(t)
-		return tc, nil
+	streamer.RegisterServerFunc("foo", func(p *Peer, t string, live bool) (Server, error) {
+		return newTestServer(t, 10), nil
 	})
 
 	node := tester.Nodes[0]
 
-	err = streamer.Subscribe(node.ID(), stream, NewRange(5, 8), Top)
-	if err != nil {
-		t.Fatalf("Expected no error, got %v", err)
-	}
-
 	err = tester.TestExchanges(p2ptest.Exchange{
 		Label: "Subscribe message",
-		Expects: []p2ptest.Expect{
+		Triggers: []p2ptest.Trigger{
 			{
 				Code: 4,
 				Msg: &SubscribeMsg{
@@ -579,32 +1859,41 @@ func TestStreamerDownstreamOfferedHashesMsgExchange(t *testing.T) {
 				Peer: node.ID(),
 			},
 		},
+		Expects: []p2ptest.Expect{
+			{
+				Code: 1,
+				Msg: &OfferedHashesMsg{
+					Stream: stream,
+					HandoverProof: &HandoverProof{
+						Handover: &Handover{},
+					},
+					Hashes: make([]byte, HashSize),
+					From:   6,
+					To:     9,
+				},
+				Peer: node.ID(),
+			},
+		},
 	},
 		p2ptest.Exchange{
-			Label: "WantedHashes message",
+			Label: "StreamComplete message",
 			Triggers: []p2ptest.Trigger{
 				{
-					Code: 1,
-					Msg: &OfferedHashesMsg{
-						HandoverProof: &HandoverProof{
-							Handover: &Handover{},
-						},
-						Hashes: hashes,
-						From:   5,
-						To:     8,
+					Code: 2,
+					Msg: &WantedHashesMsg{
 						Stream: stream,
+						Want:   []byte{0},
+						From:   10,
+						To:     8,
 					},
 					Peer: node.ID(),
 				},
 			},
 			Expects: []p2ptest.Expect{
 				{
-					Code: 2,
-					Msg: &WantedHashesMsg{
+					Code: 11,
+					Msg: &StreamCompleteMsg{
 						Stream: stream,
-						Want:   []byte{5},
-						From:   9,
-						To:     0,
 					},
 					Peer: node.ID(),
 				},
@@ -613,33 +1902,148 @@ func TestStreamerDownstreamOfferedHashesMsgExchange(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+}
 
-	if len(tc.receivedHashes) != 3 {
-		t.Fatalf("Expected number of received hashes %v, got %v", 3, len(tc.receivedHashes))
+// TestStreamerHighWatermark validates that wanted hashes are not sent
+// while the local store is filled above the high watermark and that
+// they are sent once the store gets below it.
+func TestStreamerHighWatermark(t *testing.T) {
+	defer func(i time.Duration) { highWatermarkCheckInterval = i }(highWatermarkCheckInterval)
+	highWatermarkCheckInterval = 50 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "streamer-high-watermark")
+	if err != nil {
+		t.Fatal(err)
 	}
+	defer os.RemoveAll(dir)
 
-	close(tc.wait0)
+	store, err := localstore.New(dir, network.RandomAddr().Over(), &localstore.Options{
+		Capacity: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
 
-	timeout := time.NewTimer(100 * time.Millisecond)
-	defer timeout.Stop()
+	tester, streamer, _, teardown, err := newStreamerTester(&RegistryOptions{
+		HighWatermarkRatio: 0.9,
+		CapacityStore:      store,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
 
-	select {
-	case <-tc.batchDone:
-		t.Fatal("batch done early")
-	case <-timeout.C:
+	// fill the store up to its garbage collection target
+	var addrs []chunk.Address
+	for _, ch := range storage.GenerateRandomChunks(chunk.DefaultSize, int(store.GCTarget())) {
+		if _, err := store.Put(context.Background(), chunk.ModePutUpload, ch); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Set(context.Background(), chunk.ModeSetSync, ch.Address()); err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, ch.Address())
+	}
+
+	if !streamer.aboveHighWatermark() {
+		t.Fatal("expected store to be above high watermark")
 	}
 
+	stream := NewStream("foo", "", true)
+
+	tc := newTestClient("")
+	close(tc.wait0)
 	close(tc.wait2)
 
-	timeout2 := time.NewTimer(10000 * time.Millisecond)
-	defer timeout2.Stop()
+	streamer.RegisterClientFunc("foo", func(p *Peer, t string, live bool) (Client, error) {
+		return tc, nil
+	})
 
-	select {
-	case <-tc.batchDone:
-	case <-timeout2.C:
-		t.Fatal("timeout waiting batchdone call")
+	node := tester.Nodes[0]
+
+	err = streamer.Subscribe(node.ID(), stream, NewRange(5, 8), Top)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "Subscribe message",
+		Expects: []p2ptest.Expect{
+			{
+				Code: 4,
+				Msg: &SubscribeMsg{
+					Stream:   stream,
+					History:  NewRange(5, 8),
+					Priority: Top,
+				},
+				Peer: node.ID(),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	freeDelay := 500 * time.Millisecond
+
+	// free some space in the store after a delay
+	errC := make(chan error, 1)
+	go func() {
+		time.Sleep(freeDelay)
+		for _, addr := range addrs[:20] {
+			if err := store.Set(context.Background(), chunk.ModeSetRemove, addr); err != nil {
+				errC <- err
+				return
+			}
+		}
+		errC <- nil
+	}()
+
+	start := time.Now()
+
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "WantedHashes message",
+		Triggers: []p2ptest.Trigger{
+			{
+				Code: 1,
+				Msg: &OfferedHashesMsg{
+					HandoverProof: &HandoverProof{
+						Handover: &Handover{},
+					},
+					Hashes: hashes,
+					From:   5,
+					To:     8,
+					Stream: stream,
+				},
+				Peer: node.ID(),
+			},
+		},
+		Expects: []p2ptest.Expect{
+			{
+				Code: 2,
+				Msg: &WantedHashesMsg{
+					Stream: stream,
+					Want:   []byte{5},
+					From:   9,
+					To:     0,
+				},
+				Peer:    node.ID(),
+				Timeout: 5 * time.Second,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
 
+	if d := time.Since(start); d < freeDelay {
+		t.Errorf("wanted hashes sent after %v, before the store got below high watermark", d)
+	}
+
+	if err := <-errC; err != nil {
+		t.Fatal(err)
+	}
 }
 
 func TestStreamerRequestSubscriptionQuitMsgExchange(t *testing.T) {
@@ -1081,7 +2485,7 @@ func TestGetServerSubscriptionsRPC(t *testing.T) {
 
 			return r, cleanup, nil
 		},
-	})
+	}, nil)
 	defer sim.Close()
 
 	ctx, cancelSimRun := context.WithTimeout(context.Background(), 3*time.Minute)
@@ -1212,3 +2616,74 @@ func (c *counter) count() int {
 
 	return c.v
 }
+
+// TestRegistrySharedIntervalsStore validates that with the SharedIntervalsStore
+// option stream intervals are stored in the local store database and that
+// they are available after the registry and the local store are restarted.
+func TestRegistrySharedIntervalsStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-stream-shared-intervals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	addr := network.RandomAddr()
+	key := "intervals-key"
+
+	newRegistry := func() *Registry {
+		t.Helper()
+
+		localStore, err := localstore.New(dir, addr.Over(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the local store is wrapped as in a swarm node
+		netStore, err := storage.NewNetStore(chunk.NewValidatorStore(localStore), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kad := network.NewKademlia(addr.Over(), network.NewKadParams())
+		delivery := NewDelivery(kad, netStore, nil)
+		r := NewRegistry(addr.ID(), delivery, netStore, nil, &RegistryOptions{
+			Syncing:              SyncingDisabled,
+			SharedIntervalsStore: localStore,
+		}, nil)
+		if r.intervalsStore == nil {
+			t.Fatal("intervals store is not set")
+		}
+		return r
+	}
+	closeRegistry := func(r *Registry) {
+		t.Helper()
+
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.delivery.netStore.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := newRegistry()
+	if err := r.intervalsStore.Get(key, &intervals.Intervals{}); err != state.ErrNotFound {
+		t.Fatalf("got error %v, want %v", err, state.ErrNotFound)
+	}
+	want := intervals.NewIntervals(0)
+	want.Add(10, 20)
+	want.Add(30, 40)
+	if err := r.intervalsStore.Put(key, want); err != nil {
+		t.Fatal(err)
+	}
+	closeRegistry(r)
+
+	r = newRegistry()
+	defer closeRegistry(r)
+
+	got := &intervals.Intervals{}
+	if err := r.intervalsStore.Get(key, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != want.String() {
+		t.Errorf("got intervals %v, want %v", got, want)
+	}
+}
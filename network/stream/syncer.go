@@ -18,17 +18,23 @@ package stream
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethersphere/swarm/chunk"
 	"github.com/ethersphere/swarm/log"
+	"github.com/ethersphere/swarm/pot"
 	"github.com/ethersphere/swarm/storage"
 )
 
 const (
+	// BatchSize is the default maximal number of chunk hashes
+	// in a single syncing OfferedHashesMsg.
 	BatchSize = 128
 )
 
@@ -40,26 +46,51 @@ type SwarmSyncerServer struct {
 	correlateId string //used for logging
 	po          uint8
 	netStore    *storage.NetStore
+	batchSize   int            // maximal number of chunk hashes in a batch
+	peerAddr    []byte         // if set, hashes in a batch are ordered by proximity to it
+	filter      SyncFilter     // if set, only chunks accepted by it are offered
+	cancelStore CancelledStore // if set, chunks of cancelled uploads reported by it are not offered
 	quit        chan struct{}
 }
 
-// NewSwarmSyncerServer is constructor for SwarmSyncerServer
-func NewSwarmSyncerServer(po uint8, netStore *storage.NetStore, correlateId string) (*SwarmSyncerServer, error) {
+// NewSwarmSyncerServer is constructor for SwarmSyncerServer.
+// If batchSize is not positive, BatchSize is used.
+func NewSwarmSyncerServer(po uint8, netStore *storage.NetStore, correlateId string, batchSize int) (*SwarmSyncerServer, error) {
+	if batchSize <= 0 {
+		batchSize = BatchSize
+	}
 	return &SwarmSyncerServer{
 		correlateId: correlateId,
 		po:          po,
 		netStore:    netStore,
+		batchSize:   batchSize,
 		quit:        make(chan struct{}),
 	}, nil
 }
 
 func RegisterSwarmSyncerServer(streamer *Registry, netStore *storage.NetStore) {
 	streamer.RegisterServerFunc("SYNC", func(p *Peer, t string, _ bool) (Server, error) {
-		po, err := ParseSyncBinKey(t)
+		po, filterName, err := ParseFilteredSyncBinKey(t)
+		if err != nil {
+			return nil, err
+		}
+		var filter SyncFilter
+		if filterName != "" {
+			filter, err = streamer.getSyncFilter(filterName)
+			if err != nil {
+				return nil, err
+			}
+		}
+		s, err := NewSwarmSyncerServer(po, netStore, fmt.Sprintf("%s|%d", p.ID(), po), streamer.syncBatchSize)
 		if err != nil {
 			return nil, err
 		}
-		return NewSwarmSyncerServer(po, netStore, fmt.Sprintf("%s|%d", p.ID(), po))
+		s.filter = filter
+		s.cancelStore = streamer.cancelledStore
+		if streamer.syncProximity {
+			s.peerAddr = p.BzzAddr.Over()
+		}
+		return s, nil
 	})
 	// streamer.RegisterServerFunc(stream, func(p *Peer) (Server, error) {
 	// 	return NewOutgoingProvableSwarmSyncer(po, db)
@@ -88,13 +119,18 @@ func (s *SwarmSyncerServer) SessionIndex() (uint64, error) {
 // SetNextBatch retrieves the next batch of hashes from the localstore.
 // It expects a range of bin IDs, both ends inclusive in syncing, and returns
 // concatenated byte slice of chunk addresses and bin IDs of the first and
-// the last one in that slice. The batch may have up to BatchSize number of
+// the last one in that slice. The batch may have up to batchSize number of
 // chunk addresses. If at least one chunk is added to the batch and no new chunks
 // are added in batchTimeout period, the batch will be returned. This function
 // will block until new chunks are received from localstore pull subscription.
 func (s *SwarmSyncerServer) SetNextBatch(from, to uint64) ([]byte, uint64, uint64, *HandoverProof, error) {
 	batchStart := time.Now()
-	descriptors, stop := s.netStore.SubscribePull(context.Background(), s.po, from, to)
+	// the pull subscription is terminated on every return path, by both
+	// canceling its context and calling stop, so that it is not leaked
+	// in the local store when the batch is returned or the server quits
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	descriptors, stop := s.netStore.SubscribePull(ctx, s.po, from, to)
 	defer stop()
 
 	const batchTimeout = 2 * time.Second
@@ -123,24 +159,43 @@ func (s *SwarmSyncerServer) SetNextBatch(from, to uint64) ([]byte, uint64, uint6
 				iterate = false
 				break
 			}
+			if batchStartID == nil {
+				// set batch start id only if
+				// this is the first iteration
+				batchStartID = &d.BinID
+			}
+			// chunks that are not accepted by the filter are not offered,
+			// but they are in the range of the batch
+			batchEndID = d.BinID
+			if s.filter != nil {
+				accepted, err := s.accepted(d.Address)
+				if err != nil {
+					return nil, 0, 0, nil, err
+				}
+				if !accepted {
+					continue
+				}
+			}
+			// chunks of cancelled uploads are not offered
+			cancelled, err := s.cancelled(d.Address)
+			if err != nil {
+				return nil, 0, 0, nil, err
+			}
+			if cancelled {
+				continue
+			}
 			batch = append(batch, d.Address[:]...)
 			// This is the most naive approach to label the chunk as synced
 			// allowing it to be garbage collected. A proper way requires
 			// validating that the chunk is successfully stored by the peer.
-			err := s.netStore.Set(context.Background(), chunk.ModeSetSync, d.Address)
+			err = s.netStore.Set(context.Background(), chunk.ModeSetSync, d.Address)
 			if err != nil {
 				metrics.GetOrRegisterCounter("syncer.set-next-batch.set-sync-err", nil).Inc(1)
 				log.Debug("syncer pull subscription - err setting chunk as synced", "correlateId", s.correlateId, "err", err)
 				return nil, 0, 0, nil, err
 			}
 			batchSize++
-			if batchStartID == nil {
-				// set batch start id only if
-				// this is the first iteration
-				batchStartID = &d.BinID
-			}
-			batchEndID = d.BinID
-			if batchSize >= BatchSize {
+			if batchSize >= s.batchSize {
 				iterate = false
 				metrics.GetOrRegisterCounter("syncer.set-next-batch.full-batch", nil).Inc(1)
 				log.Trace("syncer pull subscription - batch size reached", "correlateId", s.correlateId, "batchSize", batchSize, "batchStartID", batchStartID, "batchEndID", batchEndID)
@@ -171,9 +226,58 @@ func (s *SwarmSyncerServer) SetNextBatch(from, to uint64) ([]byte, uint64, uint6
 		// if batch start id is not set, return 0
 		batchStartID = new(uint64)
 	}
+	if s.peerAddr != nil {
+		sortByProximity(batch, s.peerAddr)
+	}
 	return batch, *batchStartID, batchEndID, nil, nil
 }
 
+// accepted returns true if the chunk with the provided address is
+// accepted by the server filter. Chunks that are not found in the
+// local store, for example because they are garbage collected, are
+// not accepted.
+func (s *SwarmSyncerServer) accepted(addr chunk.Address) (bool, error) {
+	ch, err := s.netStore.Store.Get(context.Background(), chunk.ModeGetLookup, addr)
+	if err != nil {
+		if err == chunk.ErrChunkNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return s.filter(ch), nil
+}
+
+// CancelledStore is implemented by local stores that keep the tags
+// of uploaded chunks, such as localstore.DB.
+type CancelledStore interface {
+	Cancelled(addr chunk.Address) (bool, error)
+}
+
+// cancelled returns true if the chunk with the provided address
+// belongs to a cancelled upload and it should not be offered.
+func (s *SwarmSyncerServer) cancelled(addr chunk.Address) (bool, error) {
+	if s.cancelStore == nil {
+		return false, nil
+	}
+	return s.cancelStore.Cancelled(addr)
+}
+
+// sortByProximity sorts concatenated chunk addresses in the batch
+// in place, from the closest to the furthest one from the address.
+// Addresses with the same proximity keep their order.
+func sortByProximity(batch []byte, addr []byte) {
+	hashes := make([][]byte, 0, len(batch)/HashSize)
+	for i := 0; i+HashSize <= len(batch); i += HashSize {
+		hashes = append(hashes, append([]byte(nil), batch[i:i+HashSize]...))
+	}
+	sort.SliceStable(hashes, func(i, j int) bool {
+		return pot.ProxCmp(addr, hashes[i], hashes[j]) < 0
+	})
+	for i, h := range hashes {
+		copy(batch[i*HashSize:], h)
+	}
+}
+
 // SwarmSyncerClient
 type SwarmSyncerClient struct {
 	netStore *storage.NetStore
@@ -233,3 +337,106 @@ func ParseSyncBinKey(s string) (uint8, error) {
 	}
 	return uint8(bin), nil
 }
+
+// syncFilterSeparator separates the bin number and
+// the filter name in filtered sync stream keys.
+const syncFilterSeparator = ":"
+
+// SyncFilter reports whether the chunk should be offered
+// on a filtered sync stream.
+type SyncFilter func(ch chunk.Chunk) bool
+
+// ErrSyncFilterNotFound is returned when a subscription is requested
+// for a sync stream with a filter that is not registered.
+var ErrSyncFilterNotFound = errors.New("sync filter not found")
+
+// RegisterSyncFilter registers the filter under the provided name.
+// Peers can subscribe to sync streams that offer only chunks accepted
+// by the filter using keys constructed with FormatFilteredSyncBinKey.
+func (r *Registry) RegisterSyncFilter(name string, f SyncFilter) {
+	r.serverMu.Lock()
+	defer r.serverMu.Unlock()
+
+	r.syncFilters[name] = f
+}
+
+// getSyncFilter returns the sync filter registered under the name.
+func (r *Registry) getSyncFilter(name string) (SyncFilter, error) {
+	r.serverMu.RLock()
+	defer r.serverMu.RUnlock()
+
+	f, ok := r.syncFilters[name]
+	if !ok {
+		return nil, ErrSyncFilterNotFound
+	}
+	return f, nil
+}
+
+// FormatFilteredSyncBinKey returns a sync stream key for the Kademlia bin
+// that offers only chunks accepted by the sync filter with the provided
+// name. Filtered and unfiltered streams for the same bin are distinct
+// streams with separately persisted intervals.
+func FormatFilteredSyncBinKey(bin uint8, filter string) string {
+	return FormatSyncBinKey(bin) + syncFilterSeparator + filter
+}
+
+// ParseFilteredSyncBinKey parses the sync stream key and returns the
+// Kademlia bin number and the sync filter name, which is empty if the
+// key is not filtered.
+func ParseFilteredSyncBinKey(s string) (bin uint8, filter string, err error) {
+	binKey := s
+	if i := strings.Index(s, syncFilterSeparator); i >= 0 {
+		binKey, filter = s[:i], s[i+len(syncFilterSeparator):]
+		if filter == "" {
+			return 0, "", fmt.Errorf("empty sync filter name in key %q", s)
+		}
+	}
+	bin, err = ParseSyncBinKey(binKey)
+	if err != nil {
+		return 0, "", err
+	}
+	return bin, filter, nil
+}
+
+// syncClassPrefix prefixes names of sync streams of content classes.
+const syncClassPrefix = "SYNC-"
+
+// SyncClassStreamName returns the name of sync streams
+// that offer only chunks of the content class.
+func SyncClassStreamName(class string) string {
+	return syncClassPrefix + class
+}
+
+// isSyncStream returns true for the SYNC stream and
+// for sync streams of content classes.
+func isSyncStream(name string) bool {
+	return name == "SYNC" || strings.HasPrefix(name, syncClassPrefix)
+}
+
+// RegisterSwarmSyncClass registers server and client constructor functions
+// for sync streams of the content class, named with SyncClassStreamName and
+// keyed by Kademlia bins as the SYNC stream, that offer only chunks accepted
+// by the filter. Streams of every class have their own intervals, so that
+// syncing of one class does not wait for syncing of another.
+func RegisterSwarmSyncClass(streamer *Registry, netStore *storage.NetStore, class string, filter SyncFilter) {
+	name := SyncClassStreamName(class)
+	streamer.RegisterServerFunc(name, func(p *Peer, t string, _ bool) (Server, error) {
+		po, err := ParseSyncBinKey(t)
+		if err != nil {
+			return nil, err
+		}
+		s, err := NewSwarmSyncerServer(po, netStore, fmt.Sprintf("%s|%d|%s", p.ID(), po, class), streamer.syncBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		s.filter = filter
+		s.cancelStore = streamer.cancelledStore
+		if streamer.syncProximity {
+			s.peerAddr = p.BzzAddr.Over()
+		}
+		return s, nil
+	})
+	streamer.RegisterClientFunc(name, func(p *Peer, t string, live bool) (Client, error) {
+		return NewSwarmSyncerClient(p, netStore, NewStream(name, t, live))
+	})
+}
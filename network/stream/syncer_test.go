@@ -17,10 +17,12 @@
 package stream
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"sync"
 	"testing"
@@ -33,6 +35,9 @@ import (
 	"github.com/ethersphere/swarm/log"
 	"github.com/ethersphere/swarm/network"
 	"github.com/ethersphere/swarm/network/simulation"
+	"github.com/ethersphere/swarm/network/stream/intervals"
+	"github.com/ethersphere/swarm/pot"
+	"github.com/ethersphere/swarm/sctx"
 	"github.com/ethersphere/swarm/state"
 	"github.com/ethersphere/swarm/storage"
 	"github.com/ethersphere/swarm/testutil"
@@ -97,7 +102,7 @@ func testSyncBetweenNodes(t *testing.T, nodes, chunkCount int, skipCheck bool, p
 
 			return r, cleanup, nil
 		},
-	})
+	}, nil)
 	defer sim.Close()
 
 	// create context for simulation run
@@ -194,12 +199,10 @@ func testSyncBetweenNodes(t *testing.T, nodes, chunkCount int, skipCheck bool, p
 			for j := i; j < nodes; j++ {
 				total += len(hashes[j])
 				for _, key := range hashes[j] {
-					item, ok := sim.NodeItem(nodeIDs[j], bucketKeyStore)
-					if !ok {
-						return fmt.Errorf("No DB")
+					_, err := sim.GetChunk(nodeIDs[j], key)
+					if err == simulation.ErrNoStore {
+						return err
 					}
-					db := item.(chunk.Store)
-					_, err := db.Get(ctx, chunk.ModeGetRequest, key)
 					if err == nil {
 						found++
 					}
@@ -218,6 +221,51 @@ func testSyncBetweenNodes(t *testing.T, nodes, chunkCount int, skipCheck bool, p
 	}
 }
 
+// TestSyncerSimulationChunkSynced uploads chunks to a random node of
+// a kademlia connected network and validates that syncing delivers
+// them to the nodes that are closest to the chunk addresses.
+func TestSyncerSimulationChunkSynced(t *testing.T) {
+	sim := simulation.New(simServiceMap, nil)
+	defer sim.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	if err := sim.UploadSnapshot(ctx, "testing/snapshot_16.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) (err error) {
+		disconnected := watchDisconnections(ctx, sim)
+		defer func() {
+			if err != nil && disconnected.bool() {
+				err = errors.New("disconnect events received")
+			}
+		}()
+
+		node := sim.Net.GetRandomUpNode()
+		item, ok := sim.NodeItem(node.ID(), bucketKeyStore)
+		if !ok {
+			return errors.New("no store in simulation bucket")
+		}
+		hashes, err := uploadFileToSingleNodeStore(node.ID(), 32, item.(chunk.Store))
+		if err != nil {
+			return err
+		}
+
+		minReplicas := network.NewKadParams().NeighbourhoodSize
+		for _, addr := range hashes {
+			if err := sim.AssertChunkSynced(ctx, addr, minReplicas); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+}
+
 //TestSameVersionID just checks that if the version is not changed,
 //then streamer peers see each other
 func TestSameVersionID(t *testing.T) {
@@ -232,6 +280,8 @@ func TestSameVersionID(t *testing.T) {
 
 			r := NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &RegistryOptions{
 				Syncing: SyncingAutoSubscribe,
+				// request syncing subscriptions well before the test timeout
+				SyncUpdateDelay: 100 * time.Millisecond,
 			}, nil)
 			bucket.Store(bucketKeyRegistry, r)
 
@@ -245,24 +295,27 @@ func TestSameVersionID(t *testing.T) {
 
 			return r, cleanup, nil
 		},
-	})
+	}, nil)
 	defer sim.Close()
 
 	//connect just two nodes
 	log.Info("Adding nodes to simulation")
-	_, err := sim.AddNodesAndConnectChain(2)
+	nodes, err := sim.AddNodesAndConnectChain(2)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	log.Info("Starting simulation")
-	ctx := context.Background()
-	//make sure they have time to connect
-	time.Sleep(200 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	//wait for the peers to connect and subscribe to the bin 0 syncing
+	//stream, which is requested for peers in the nearest neighbourhood
+	err = sim.WaitForSubscription(ctx, nodes[0], nodes[1], simulation.Stream(NewStream("SYNC", FormatSyncBinKey(0), true)))
+	if err != nil {
+		t.Fatal(err)
+	}
 	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) error {
 		//get the pivot node's filestore
-		nodes := sim.UpNodeIDs()
-
 		item, ok := sim.NodeItem(nodes[0], bucketKeyRegistry)
 		if !ok {
 			return fmt.Errorf("No filestore")
@@ -309,7 +362,7 @@ func TestDifferentVersionID(t *testing.T) {
 
 			return r, cleanup, nil
 		},
-	})
+	}, nil)
 	defer sim.Close()
 
 	//connect the nodes
@@ -345,3 +398,1331 @@ func TestDifferentVersionID(t *testing.T) {
 	log.Info("Simulation ended")
 
 }
+
+// TestSyncingPauseResume validates that no offered hashes messages are
+// sent by the node which has syncing paused and that syncing continues
+// after it is resumed.
+func TestSyncingPauseResume(t *testing.T) {
+	sim := simulation.New(map[string]simulation.ServiceFunc{
+		"streamer": func(ctx *adapters.ServiceContext, bucket *sync.Map) (s node.Service, cleanup func(), err error) {
+			addr, netStore, delivery, clean, err := newNetStoreAndDelivery(ctx, bucket)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			r := NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &RegistryOptions{
+				Syncing:   SyncingRegisterOnly,
+				SkipCheck: true,
+			}, nil)
+			bucket.Store(bucketKeyRegistry, r)
+
+			cleanup = func() {
+				r.Close()
+				clean()
+			}
+
+			return r, cleanup, nil
+		},
+	}, nil)
+	defer sim.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := sim.AddNodesAndConnectChain(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) error {
+		nodeIDs := sim.UpNodeIDs()
+		clientID, serverID := nodeIDs[0], nodeIDs[1]
+
+		item, ok := sim.NodeItem(clientID, bucketKeyRegistry)
+		if !ok {
+			return errors.New("no client registry")
+		}
+		clientRegistry := item.(*Registry)
+		item, ok = sim.NodeItem(serverID, bucketKeyRegistry)
+		if !ok {
+			return errors.New("no server registry")
+		}
+		serverRegistry := item.(*Registry)
+		item, ok = sim.NodeItem(serverID, bucketKeyStore)
+		if !ok {
+			return errors.New("no server store")
+		}
+		serverStore := item.(chunk.Store)
+
+		offeredHashesMsgCode, ok := clientRegistry.GetSpec().GetCode(OfferedHashesMsg{})
+		if !ok {
+			return errors.New("no offered hashes message code")
+		}
+		offered := sim.PeerEvents(ctx, []enode.ID{clientID}, simulation.NewPeerEventsFilter().ReceivedMessages().Protocol("stream").MsgCode(offeredHashesMsgCode))
+
+		// wait for the stream peer to be registered
+		for clientRegistry.getPeer(serverID) == nil {
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		// bin 0 holds about a half of random chunks
+		if err := clientRegistry.Subscribe(serverID, NewStream("SYNC", FormatSyncBinKey(0), true), nil, Top); err != nil {
+			return err
+		}
+
+		putChunks := func(count int) error {
+			for _, ch := range storage.GenerateRandomChunks(chunk.DefaultSize, count) {
+				if _, err := serverStore.Put(ctx, chunk.ModePutUpload, ch); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		if err := putChunks(20); err != nil {
+			return err
+		}
+		select {
+		case e := <-offered:
+			if e.Error != nil {
+				return e.Error
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		serverRegistry.PauseSyncing()
+		// drain offered hashes that were sent before the pause
+		for drained := false; !drained; {
+			select {
+			case <-offered:
+			case <-time.After(500 * time.Millisecond):
+				drained = true
+			}
+		}
+
+		if err := putChunks(20); err != nil {
+			return err
+		}
+		// wait longer then the syncer batch timeout
+		select {
+		case e := <-offered:
+			return fmt.Errorf("got offered hashes while syncing is paused: %v", e)
+		case <-time.After(3 * time.Second):
+		}
+
+		serverRegistry.ResumeSyncing()
+		select {
+		case e := <-offered:
+			return e.Error
+		case <-ctx.Done():
+			return errors.New("no offered hashes after syncing is resumed")
+		}
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+}
+
+// TestSyncMaxChunks validates that exactly the requested number of chunks
+// is delivered to a subscription with a chunk limit before the stream is
+// completed, when the upstream peer has more chunks in the stream.
+func TestSyncMaxChunks(t *testing.T) {
+	const (
+		chunkCount = 200
+		maxChunks  = 50
+	)
+
+	streamComplete := make(chan Stream, 1)
+	sim := simulation.New(map[string]simulation.ServiceFunc{
+		"streamer": func(ctx *adapters.ServiceContext, bucket *sync.Map) (s node.Service, cleanup func(), err error) {
+			addr, netStore, delivery, clean, err := newNetStoreAndDelivery(ctx, bucket)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			r := NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &RegistryOptions{
+				Syncing:       SyncingRegisterOnly,
+				SkipCheck:     true,
+				SyncBatchSize: 16,
+				StreamCompleteFunc: func(_ enode.ID, s Stream) {
+					streamComplete <- s
+				},
+			}, nil)
+			bucket.Store(bucketKeyRegistry, r)
+
+			cleanup = func() {
+				r.Close()
+				clean()
+			}
+
+			return r, cleanup, nil
+		},
+	}, nil)
+	defer sim.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := sim.AddNodesAndConnectChain(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) error {
+		nodeIDs := sim.UpNodeIDs()
+		clientID, serverID := nodeIDs[0], nodeIDs[1]
+
+		registry := func(id enode.ID) *Registry {
+			item, _ := sim.NodeItem(id, bucketKeyRegistry)
+			return item.(*Registry)
+		}
+		clientRegistry, serverRegistry := registry(clientID), registry(serverID)
+
+		for _, ch := range testutil.ChunksInBin(1, chunkCount, 0, serverRegistry.delivery.kad.BaseAddr()) {
+			if err := sim.PutChunk(serverID, ch); err != nil {
+				return err
+			}
+		}
+
+		deliveries := sim.DeliveryEvents(ctx)
+
+		// wait for the stream peer to be registered
+		for clientRegistry.getPeer(serverID) == nil {
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if err := clientRegistry.SubscribeLimit(serverID, NewStream("SYNC", FormatSyncBinKey(0), false), NewRange(1, chunkCount), Top, maxChunks); err != nil {
+			return err
+		}
+
+		var count int
+		for complete := false; !complete; {
+			select {
+			case e := <-deliveries:
+				if e.Destination == clientID {
+					count++
+				}
+			case <-streamComplete:
+				complete = true
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		// no deliveries are expected after the stream is complete
+		for drained := false; !drained; {
+			select {
+			case e := <-deliveries:
+				if e.Destination == clientID {
+					count++
+				}
+			case <-time.After(500 * time.Millisecond):
+				drained = true
+			}
+		}
+
+		if count != maxChunks {
+			return fmt.Errorf("got %v delivered chunks, want %v", count, maxChunks)
+		}
+		p := clientRegistry.getPeer(serverID)
+		p.clientMu.RLock()
+		clients := len(p.clients)
+		p.clientMu.RUnlock()
+		if clients != 0 {
+			return fmt.Errorf("got %v clients after the stream is complete, want none", clients)
+		}
+		return nil
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+}
+
+// TestSyncBatchSize validates that the number of offered hashes messages
+// for a history syncing stream is the number of chunks in the bin divided
+// by SyncBatchSize, rounded up.
+func TestSyncBatchSize(t *testing.T) {
+	const batchSize = 4
+
+	streamComplete := make(chan Stream, 1)
+	sim := simulation.New(map[string]simulation.ServiceFunc{
+		"streamer": func(ctx *adapters.ServiceContext, bucket *sync.Map) (s node.Service, cleanup func(), err error) {
+			addr, netStore, delivery, clean, err := newNetStoreAndDelivery(ctx, bucket)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			r := NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &RegistryOptions{
+				Syncing:       SyncingRegisterOnly,
+				SkipCheck:     true,
+				SyncBatchSize: batchSize,
+				StreamCompleteFunc: func(_ enode.ID, s Stream) {
+					streamComplete <- s
+				},
+			}, nil)
+			bucket.Store(bucketKeyRegistry, r)
+
+			cleanup = func() {
+				r.Close()
+				clean()
+			}
+
+			return r, cleanup, nil
+		},
+	}, nil)
+	defer sim.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := sim.AddNodesAndConnectChain(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) error {
+		nodeIDs := sim.UpNodeIDs()
+		clientID, serverID := nodeIDs[0], nodeIDs[1]
+
+		item, ok := sim.NodeItem(clientID, bucketKeyRegistry)
+		if !ok {
+			return errors.New("no client registry")
+		}
+		clientRegistry := item.(*Registry)
+		item, ok = sim.NodeItem(serverID, bucketKeyStore)
+		if !ok {
+			return errors.New("no server store")
+		}
+		serverStore := item.(chunk.Store)
+
+		// bin 0 holds about a half of random chunks
+		for _, ch := range storage.GenerateRandomChunks(chunk.DefaultSize, 30) {
+			if _, err := serverStore.Put(ctx, chunk.ModePutUpload, ch); err != nil {
+				return err
+			}
+		}
+		chunkCount, err := serverStore.LastPullSubscriptionBinID(0)
+		if err != nil {
+			return err
+		}
+		if chunkCount == 0 {
+			return errors.New("no chunks in bin 0")
+		}
+
+		offeredHashesMsgCode, ok := clientRegistry.GetSpec().GetCode(OfferedHashesMsg{})
+		if !ok {
+			return errors.New("no offered hashes message code")
+		}
+		offered := sim.PeerEvents(ctx, []enode.ID{clientID}, simulation.NewPeerEventsFilter().ReceivedMessages().Protocol("stream").MsgCode(offeredHashesMsgCode))
+
+		// wait for the stream peer to be registered
+		for clientRegistry.getPeer(serverID) == nil {
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if err := clientRegistry.Subscribe(serverID, NewStream("SYNC", FormatSyncBinKey(0), false), NewRange(1, chunkCount), Top); err != nil {
+			return err
+		}
+
+		var count int
+		for complete := false; !complete; {
+			select {
+			case e := <-offered:
+				if e.Error != nil {
+					return e.Error
+				}
+				count++
+			case <-streamComplete:
+				complete = true
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		// offered hashes events may be received after the stream is complete
+		for drained := false; !drained; {
+			select {
+			case e := <-offered:
+				if e.Error != nil {
+					return e.Error
+				}
+				count++
+			case <-time.After(500 * time.Millisecond):
+				drained = true
+			}
+		}
+
+		want := int((chunkCount + batchSize - 1) / batchSize)
+		if count != want {
+			return fmt.Errorf("got %v offered hashes messages for %v chunks, want %v", count, chunkCount, want)
+		}
+		return nil
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+}
+
+// TestSyncFilter validates that a sync stream with a registered filter
+// offers only chunks accepted by the filter.
+func TestSyncFilter(t *testing.T) {
+	// addresses of even-indexed chunks accepted by the filter
+	var even sync.Map
+
+	streamComplete := make(chan Stream, 1)
+	sim := simulation.New(map[string]simulation.ServiceFunc{
+		"streamer": func(ctx *adapters.ServiceContext, bucket *sync.Map) (s node.Service, cleanup func(), err error) {
+			addr, netStore, delivery, clean, err := newNetStoreAndDelivery(ctx, bucket)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			r := NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &RegistryOptions{
+				Syncing:   SyncingRegisterOnly,
+				SkipCheck: true,
+				StreamCompleteFunc: func(_ enode.ID, s Stream) {
+					streamComplete <- s
+				},
+			}, nil)
+			r.RegisterSyncFilter("even", func(ch chunk.Chunk) bool {
+				_, ok := even.Load(string(ch.Address()))
+				return ok
+			})
+			bucket.Store(bucketKeyRegistry, r)
+
+			cleanup = func() {
+				r.Close()
+				clean()
+			}
+
+			return r, cleanup, nil
+		},
+	}, nil)
+	defer sim.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := sim.AddNodesAndConnectChain(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) error {
+		nodeIDs := sim.UpNodeIDs()
+		clientID, serverID := nodeIDs[0], nodeIDs[1]
+
+		item, ok := sim.NodeItem(clientID, bucketKeyRegistry)
+		if !ok {
+			return errors.New("no client registry")
+		}
+		clientRegistry := item.(*Registry)
+		item, ok = sim.NodeItem(clientID, bucketKeyStore)
+		if !ok {
+			return errors.New("no client store")
+		}
+		clientStore := item.(chunk.Store)
+		item, ok = sim.NodeItem(serverID, bucketKeyStore)
+		if !ok {
+			return errors.New("no server store")
+		}
+		serverStore := item.(chunk.Store)
+
+		// bin 0 holds about a half of random chunks
+		chunks := storage.GenerateRandomChunks(chunk.DefaultSize, 40)
+		for i, ch := range chunks {
+			if i%2 == 0 {
+				even.Store(string(ch.Address()), struct{}{})
+			}
+			if _, err := serverStore.Put(ctx, chunk.ModePutUpload, ch); err != nil {
+				return err
+			}
+		}
+		chunkCount, err := serverStore.LastPullSubscriptionBinID(0)
+		if err != nil {
+			return err
+		}
+		if chunkCount == 0 {
+			return errors.New("no chunks in bin 0")
+		}
+
+		// wait for the stream peer to be registered
+		for clientRegistry.getPeer(serverID) == nil {
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if err := clientRegistry.Subscribe(serverID, NewStream("SYNC", FormatFilteredSyncBinKey(0, "even"), false), NewRange(1, chunkCount), Top); err != nil {
+			return err
+		}
+		select {
+		case <-streamComplete:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		// collect chunks in bin 0 of the server
+		var wanted, unwanted []chunk.Address
+		descriptors, stop := serverStore.SubscribePull(ctx, 0, 0, chunkCount)
+		defer stop()
+		for d := range descriptors {
+			if _, ok := even.Load(string(d.Address)); ok {
+				wanted = append(wanted, d.Address)
+			} else {
+				unwanted = append(unwanted, d.Address)
+			}
+		}
+		if len(wanted) == 0 || len(unwanted) == 0 {
+			return fmt.Errorf("got %v even and %v odd chunks in bin 0, want at least one of each", len(wanted), len(unwanted))
+		}
+
+		// deliveries of offered chunks may not be complete yet
+		for _, addr := range wanted {
+			for {
+				has, err := clientStore.Has(ctx, addr)
+				if err != nil {
+					return err
+				}
+				if has {
+					break
+				}
+				select {
+				case <-time.After(10 * time.Millisecond):
+				case <-ctx.Done():
+					return fmt.Errorf("even chunk %s not synced: %v", addr, ctx.Err())
+				}
+			}
+		}
+		for _, addr := range unwanted {
+			has, err := clientStore.Has(ctx, addr)
+			if err != nil {
+				return err
+			}
+			if has {
+				return fmt.Errorf("odd chunk %s synced", addr)
+			}
+		}
+		return nil
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+}
+
+// TestSyncClasses validates that sync streams of two content classes
+// offer only chunks of their class and that their intervals advance
+// independently of each other.
+func TestSyncClasses(t *testing.T) {
+	// addresses of even-indexed chunks that belong to the even class
+	var even sync.Map
+
+	streamComplete := make(chan Stream, 1)
+	sim := simulation.New(map[string]simulation.ServiceFunc{
+		"streamer": func(ctx *adapters.ServiceContext, bucket *sync.Map) (s node.Service, cleanup func(), err error) {
+			addr, netStore, delivery, clean, err := newNetStoreAndDelivery(ctx, bucket)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			r := NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &RegistryOptions{
+				Syncing:   SyncingRegisterOnly,
+				SkipCheck: true,
+				StreamCompleteFunc: func(_ enode.ID, s Stream) {
+					streamComplete <- s
+				},
+			}, nil)
+			RegisterSwarmSyncClass(r, netStore, "even", func(ch chunk.Chunk) bool {
+				_, ok := even.Load(string(ch.Address()))
+				return ok
+			})
+			RegisterSwarmSyncClass(r, netStore, "odd", func(ch chunk.Chunk) bool {
+				_, ok := even.Load(string(ch.Address()))
+				return !ok
+			})
+			bucket.Store(bucketKeyRegistry, r)
+
+			cleanup = func() {
+				r.Close()
+				clean()
+			}
+
+			return r, cleanup, nil
+		},
+	}, nil)
+	defer sim.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := sim.AddNodesAndConnectChain(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) error {
+		nodeIDs := sim.UpNodeIDs()
+		clientID, serverID := nodeIDs[0], nodeIDs[1]
+
+		item, ok := sim.NodeItem(clientID, bucketKeyRegistry)
+		if !ok {
+			return errors.New("no client registry")
+		}
+		clientRegistry := item.(*Registry)
+		item, ok = sim.NodeItem(clientID, bucketKeyStore)
+		if !ok {
+			return errors.New("no client store")
+		}
+		clientStore := item.(chunk.Store)
+		item, ok = sim.NodeItem(serverID, bucketKeyStore)
+		if !ok {
+			return errors.New("no server store")
+		}
+		serverStore := item.(chunk.Store)
+
+		// bin 0 holds about a half of random chunks
+		chunks := storage.GenerateRandomChunks(chunk.DefaultSize, 40)
+		for i, ch := range chunks {
+			if i%2 == 0 {
+				even.Store(string(ch.Address()), struct{}{})
+			}
+			if _, err := serverStore.Put(ctx, chunk.ModePutUpload, ch); err != nil {
+				return err
+			}
+		}
+		chunkCount, err := serverStore.LastPullSubscriptionBinID(0)
+		if err != nil {
+			return err
+		}
+		if chunkCount == 0 {
+			return errors.New("no chunks in bin 0")
+		}
+
+		// wait for the stream peer to be registered
+		var peer *Peer
+		for {
+			if peer = clientRegistry.getPeer(serverID); peer != nil {
+				break
+			}
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		evenStream := NewStream(SyncClassStreamName("even"), FormatSyncBinKey(0), false)
+		oddStream := NewStream(SyncClassStreamName("odd"), FormatSyncBinKey(0), false)
+
+		// intervalsEnd returns the end of synced intervals of the stream,
+		// or zero if none is persisted
+		intervalsEnd := func(s Stream) (uint64, error) {
+			i := &intervals.Intervals{}
+			switch err := clientRegistry.intervalsStore.Get(peerStreamIntervalsKey(peer, s), i); err {
+			case nil:
+				return i.Last(), nil
+			case state.ErrNotFound:
+				return 0, nil
+			default:
+				return 0, err
+			}
+		}
+		// syncClass subscribes to the stream of the class, waits for
+		// it to complete and for chunks of the class to be stored
+		syncClass := func(s Stream, inClass bool) error {
+			if err := clientRegistry.Subscribe(serverID, s, NewRange(1, chunkCount), Top); err != nil {
+				return err
+			}
+			select {
+			case <-streamComplete:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			descriptors, stop := serverStore.SubscribePull(ctx, 0, 0, chunkCount)
+			defer stop()
+			for d := range descriptors {
+				if _, ok := even.Load(string(d.Address)); ok != inClass {
+					continue
+				}
+				for {
+					has, err := clientStore.Has(ctx, d.Address)
+					if err != nil {
+						return err
+					}
+					if has {
+						break
+					}
+					select {
+					case <-time.After(10 * time.Millisecond):
+					case <-ctx.Done():
+						return fmt.Errorf("chunk %s of stream %s not synced: %v", d.Address, s, ctx.Err())
+					}
+				}
+			}
+			return nil
+		}
+
+		if err := syncClass(evenStream, true); err != nil {
+			return err
+		}
+		evenEnd, err := intervalsEnd(evenStream)
+		if err != nil {
+			return err
+		}
+		if evenEnd != chunkCount {
+			return fmt.Errorf("got even stream intervals end %v, want %v", evenEnd, chunkCount)
+		}
+		oddEnd, err := intervalsEnd(oddStream)
+		if err != nil {
+			return err
+		}
+		if oddEnd != 0 {
+			return fmt.Errorf("got odd stream intervals end %v before subscription, want 0", oddEnd)
+		}
+
+		if err := syncClass(oddStream, false); err != nil {
+			return err
+		}
+		oddEnd, err = intervalsEnd(oddStream)
+		if err != nil {
+			return err
+		}
+		if oddEnd != chunkCount {
+			return fmt.Errorf("got odd stream intervals end %v, want %v", oddEnd, chunkCount)
+		}
+		return nil
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+}
+
+// TestSyncFilterNotRegistered validates that a sync stream server
+// is not created for a filter that is not registered.
+func TestSyncFilterNotRegistered(t *testing.T) {
+	tester, streamer, _, teardown, err := newStreamerTester(&RegistryOptions{
+		Syncing: SyncingRegisterOnly,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	peer := streamer.getPeer(tester.Nodes[0].ID())
+	if peer == nil {
+		t.Fatal("no stream peer")
+	}
+	serverFunc, err := streamer.GetServerFunc("SYNC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := serverFunc(peer, FormatFilteredSyncBinKey(0, "even"), false); err != ErrSyncFilterNotFound {
+		t.Fatalf("got error %v, want %v", err, ErrSyncFilterNotFound)
+	}
+
+	streamer.RegisterSyncFilter("even", func(chunk.Chunk) bool { return true })
+	server, err := serverFunc(peer, FormatFilteredSyncBinKey(0, "even"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+}
+
+// TestSyncerServerPullSubscriptionLeak validates that pull subscriptions
+// of syncer servers are terminated in the local store when servers are
+// created and closed many times, while their batches are collected.
+func TestSyncerServerPullSubscriptionLeak(t *testing.T) {
+	addr := network.RandomAddr()
+	localStore, cleanup, err := newTestLocalStore(addr.ID(), addr, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	defer localStore.Close()
+
+	netStore, err := storage.NewNetStore(localStore, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := testutil.ChunksInBin(1, 1, 0, addr.Over())[0]
+	if _, err := localStore.Put(context.Background(), chunk.ModePutUpload, ch); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		s, err := NewSwarmSyncerServer(0, netStore, "leak", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 0 {
+			// the batch is complete when the chunk is received
+			if _, _, _, _, err := s.SetNextBatch(1, 1); err != nil {
+				t.Fatal(err)
+			}
+			s.Close()
+			continue
+		}
+		// the live batch waits for new chunks until the server is closed
+		errC := make(chan error, 1)
+		go func() {
+			_, _, _, _, err := s.SetNextBatch(2, math.MaxUint64)
+			errC <- err
+		}()
+		s.Close()
+		select {
+		case err := <-errC:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("batch not returned after the server is closed")
+		}
+	}
+
+	if count := localStore.PullSubscriptionsCount(); count != 0 {
+		t.Errorf("got %v pull subscriptions, want none", count)
+	}
+}
+
+// TestSyncerServerCancelledTag validates that chunks uploaded with a
+// cancelled tag are not offered by the syncer server, while the batch
+// still covers their bin IDs.
+func TestSyncerServerCancelledTag(t *testing.T) {
+	addr := network.RandomAddr()
+	tags := chunk.NewTags()
+	localStore, cleanup, err := newTestLocalStore(addr.ID(), addr, nil, tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	defer localStore.Close()
+
+	// the local store is wrapped as in a swarm node
+	netStore, err := storage.NewNetStore(chunk.NewValidatorStore(localStore), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tag, err := tags.New("cancel", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want []byte
+	for i, ch := range testutil.ChunksInBin(1, 4, 0, addr.Over()) {
+		if i%2 == 0 {
+			ch = ch.WithTagID(tag.Uid)
+		} else {
+			want = append(want, ch.Address()...)
+		}
+		if _, err := localStore.Put(context.Background(), chunk.ModePutUpload, ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tags.Cancel(tag.Uid); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSwarmSyncerServer(0, netStore, "cancel", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.cancelStore = localStore
+
+	batch, from, to, _, err := s.SetNextBatch(1, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(batch, want) {
+		t.Errorf("got batch %x, want %x", batch, want)
+	}
+	if from != 1 || to != 4 {
+		t.Errorf("got batch range [%v, %v], want [1, 4]", from, to)
+	}
+}
+
+// TestPeersBandwidth validates that bytes of synced chunks are counted
+// as sent by the upstream peer and as received by the downstream peer.
+func TestPeersBandwidth(t *testing.T) {
+	streamComplete := make(chan Stream, 1)
+	sim := simulation.New(map[string]simulation.ServiceFunc{
+		"streamer": func(ctx *adapters.ServiceContext, bucket *sync.Map) (s node.Service, cleanup func(), err error) {
+			addr, netStore, delivery, clean, err := newNetStoreAndDelivery(ctx, bucket)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			r := NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &RegistryOptions{
+				Syncing:   SyncingRegisterOnly,
+				SkipCheck: true,
+				StreamCompleteFunc: func(_ enode.ID, s Stream) {
+					streamComplete <- s
+				},
+			}, nil)
+			bucket.Store(bucketKeyRegistry, r)
+
+			cleanup = func() {
+				r.Close()
+				clean()
+			}
+
+			return r, cleanup, nil
+		},
+	}, nil)
+	defer sim.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := sim.AddNodesAndConnectChain(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) error {
+		nodeIDs := sim.UpNodeIDs()
+		clientID, serverID := nodeIDs[0], nodeIDs[1]
+
+		item, ok := sim.NodeItem(clientID, bucketKeyRegistry)
+		if !ok {
+			return errors.New("no client registry")
+		}
+		clientRegistry := item.(*Registry)
+		item, ok = sim.NodeItem(serverID, bucketKeyRegistry)
+		if !ok {
+			return errors.New("no server registry")
+		}
+		serverRegistry := item.(*Registry)
+		item, ok = sim.NodeItem(clientID, bucketKeyStore)
+		if !ok {
+			return errors.New("no client store")
+		}
+		clientStore := item.(chunk.Store)
+		item, ok = sim.NodeItem(serverID, bucketKeyStore)
+		if !ok {
+			return errors.New("no server store")
+		}
+		serverStore := item.(chunk.Store)
+
+		// bin 0 holds about a half of random chunks
+		chunks := storage.GenerateRandomChunks(chunk.DefaultSize, 30)
+		for _, ch := range chunks {
+			if _, err := serverStore.Put(ctx, chunk.ModePutUpload, ch); err != nil {
+				return err
+			}
+		}
+		chunkCount, err := serverStore.LastPullSubscriptionBinID(0)
+		if err != nil {
+			return err
+		}
+		if chunkCount == 0 {
+			return errors.New("no chunks in bin 0")
+		}
+
+		// wait for the stream peer to be registered
+		for clientRegistry.getPeer(serverID) == nil {
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if err := clientRegistry.Subscribe(serverID, NewStream("SYNC", FormatSyncBinKey(0), false), NewRange(1, chunkCount), Top); err != nil {
+			return err
+		}
+		select {
+		case <-streamComplete:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		// wait for all chunks in bin 0 to be delivered
+		var size uint64
+		descriptors, stop := serverStore.SubscribePull(ctx, 0, 0, chunkCount)
+		defer stop()
+		for d := range descriptors {
+			ch, err := serverStore.Get(ctx, chunk.ModeGetLookup, d.Address)
+			if err != nil {
+				return err
+			}
+			size += uint64(len(ch.Data()))
+			for {
+				has, err := clientStore.Has(ctx, d.Address)
+				if err != nil {
+					return err
+				}
+				if has {
+					break
+				}
+				select {
+				case <-time.After(10 * time.Millisecond):
+				case <-ctx.Done():
+					return fmt.Errorf("chunk %s not synced: %v", d.Address, ctx.Err())
+				}
+			}
+		}
+
+		// chunk data and addresses, offered and wanted hashes, and
+		// other messages are counted, but they are much smaller than
+		// the chunk data
+		min, max := size, 2*size
+		received := clientRegistry.PeersBandwidth()[serverID].BytesReceived
+		if received < min || received > max {
+			return fmt.Errorf("got %v bytes received by client, want between %v and %v", received, min, max)
+		}
+		sent := serverRegistry.PeersBandwidth()[clientID].BytesSent
+		if sent < min || sent > max {
+			return fmt.Errorf("got %v bytes sent by server, want between %v and %v", sent, min, max)
+		}
+		return nil
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+}
+
+// TestSyncProximityOrder validates that with ProximityOrderedSync option
+// hashes in a syncing batch are ordered by proximity to the subscribed peer,
+// so that the first offered hash is the closest one to the peer.
+func TestSyncProximityOrder(t *testing.T) {
+	tester, streamer, localStore, teardown, err := newStreamerTester(&RegistryOptions{
+		Syncing:              SyncingRegisterOnly,
+		ProximityOrderedSync: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	ctx := context.Background()
+	// bin 0 holds about a half of random chunks
+	for _, ch := range storage.GenerateRandomChunks(chunk.DefaultSize, 30) {
+		if _, err := localStore.Put(ctx, chunk.ModePutUpload, ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	chunkCount, err := localStore.LastPullSubscriptionBinID(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunkCount < 2 {
+		t.Fatalf("got %v chunks in bin 0, want at least 2", chunkCount)
+	}
+
+	peer := streamer.getPeer(tester.Nodes[0].ID())
+	if peer == nil {
+		t.Fatal("no stream peer")
+	}
+	serverFunc, err := streamer.GetServerFunc("SYNC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := serverFunc(peer, FormatSyncBinKey(0), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	batch, _, _, _, err := server.SetNextBatch(1, chunkCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := uint64(len(batch) / HashSize); got != chunkCount {
+		t.Fatalf("got %v hashes in batch, want %v", got, chunkCount)
+	}
+
+	peerAddr := peer.BzzAddr.Over()
+	first := batch[:HashSize]
+	for i := HashSize; i < len(batch); i += HashSize {
+		hash := batch[i : i+HashSize]
+		if pot.ProxCmp(peerAddr, first, hash) > 0 {
+			t.Fatalf("hash %x at position %v is closer to the peer than the first hash %x", hash, i/HashSize, first)
+		}
+		if pot.ProxCmp(peerAddr, batch[i-HashSize:i], hash) > 0 {
+			t.Fatalf("hash %x at position %v is closer to the peer than the previous one", hash, i/HashSize)
+		}
+	}
+}
+
+// TestFileStoreStoreAndSync validates that content stored with
+// FileStore.StoreAndSync on one node is retrievable from another node
+// once the call returns.
+func TestFileStoreStoreAndSync(t *testing.T) {
+	sim := simulation.New(map[string]simulation.ServiceFunc{
+		"streamer": func(ctx *adapters.ServiceContext, bucket *sync.Map) (s node.Service, cleanup func(), err error) {
+			addr, netStore, delivery, clean, err := newNetStoreAndDelivery(ctx, bucket)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			r := NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &RegistryOptions{
+				Syncing:         SyncingAutoSubscribe,
+				SyncUpdateDelay: 100 * time.Millisecond,
+				SkipCheck:       true,
+			}, nil)
+
+			cleanup = func() {
+				r.Close()
+				clean()
+			}
+
+			return r, cleanup, nil
+		},
+	}, nil)
+	defer sim.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := sim.AddNodesAndConnectChain(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) error {
+		nodeIDs := sim.UpNodeIDs()
+
+		item, ok := sim.NodeItem(nodeIDs[0], bucketKeyFileStore)
+		if !ok {
+			return errors.New("no filestore")
+		}
+		uploaderFileStore := item.(*storage.FileStore)
+		item, ok = sim.NodeItem(nodeIDs[1], bucketKeyFileStore)
+		if !ok {
+			return errors.New("no filestore")
+		}
+		downloaderFileStore := item.(*storage.FileStore)
+
+		size := 10000
+		data := testutil.RandomBytes(1, size)
+
+		addr, err := uploaderFileStore.StoreAndSync(ctx, bytes.NewReader(data), int64(size), false)
+		if err != nil {
+			return err
+		}
+
+		reader, _ := downloaderFileStore.Retrieve(ctx, addr)
+		got, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, data) {
+			return errors.New("retrieved data is not equal to stored data")
+		}
+		return nil
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+}
+
+// TestTagSynced validates that a tag of content uploaded on one node
+// reports all chunks as synced once they are synced to another node.
+func TestTagSynced(t *testing.T) {
+	sim := simulation.New(map[string]simulation.ServiceFunc{
+		"streamer": func(ctx *adapters.ServiceContext, bucket *sync.Map) (s node.Service, cleanup func(), err error) {
+			addr, netStore, delivery, clean, err := newNetStoreAndDelivery(ctx, bucket)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			r := NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &RegistryOptions{
+				Syncing:         SyncingAutoSubscribe,
+				SyncUpdateDelay: 100 * time.Millisecond,
+				SkipCheck:       true,
+			}, nil)
+
+			cleanup = func() {
+				r.Close()
+				clean()
+			}
+
+			return r, cleanup, nil
+		},
+	}, nil)
+	defer sim.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := sim.AddNodesAndConnectChain(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) error {
+		nodeID := sim.UpNodeIDs()[0]
+
+		item, ok := sim.NodeItem(nodeID, bucketKeyFileStore)
+		if !ok {
+			return errors.New("no filestore")
+		}
+		fileStore := item.(*storage.FileStore)
+		item, ok = sim.NodeItem(nodeID, bucketKeyTags)
+		if !ok {
+			return errors.New("no tags")
+		}
+		tags := item.(*chunk.Tags)
+
+		tag, err := tags.New("upload", 0)
+		if err != nil {
+			return err
+		}
+
+		size := 10000
+		addr, wait, err := fileStore.Store(sctx.SetTag(ctx, tag.Uid), bytes.NewReader(testutil.RandomBytes(1, size)), int64(size), false)
+		if err != nil {
+			return err
+		}
+		if err := wait(ctx); err != nil {
+			return err
+		}
+		tag.DoneSplit(addr)
+
+		if err := tags.WaitSynced(ctx, tag.Uid); err != nil {
+			return err
+		}
+		if sent, synced := tag.Get(chunk.StateSent), tag.Get(chunk.StateSynced); sent != synced {
+			return fmt.Errorf("got %v sent chunks, want %v", sent, synced)
+		}
+		return nil
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+}
+
+// TestResyncBin validates that chunks removed from the local store of
+// a client are synced again from the server after the bin is resynced.
+func TestResyncBin(t *testing.T) {
+	sim := simulation.New(map[string]simulation.ServiceFunc{
+		"streamer": func(ctx *adapters.ServiceContext, bucket *sync.Map) (s node.Service, cleanup func(), err error) {
+			addr, netStore, delivery, clean, err := newNetStoreAndDelivery(ctx, bucket)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			r := NewRegistry(addr.ID(), delivery, netStore, state.NewInmemoryStore(), &RegistryOptions{
+				Syncing:   SyncingRegisterOnly,
+				SkipCheck: true,
+			}, nil)
+			bucket.Store(bucketKeyRegistry, r)
+
+			cleanup = func() {
+				r.Close()
+				clean()
+			}
+
+			return r, cleanup, nil
+		},
+	}, nil)
+	defer sim.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := sim.AddNodesAndConnectChain(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := sim.Run(ctx, func(ctx context.Context, sim *simulation.Simulation) error {
+		nodeIDs := sim.UpNodeIDs()
+		clientID, serverID := nodeIDs[0], nodeIDs[1]
+
+		registry := func(id enode.ID) *Registry {
+			item, _ := sim.NodeItem(id, bucketKeyRegistry)
+			return item.(*Registry)
+		}
+		clientRegistry, serverRegistry := registry(clientID), registry(serverID)
+		item, ok := sim.NodeItem(clientID, bucketKeyStore)
+		if !ok {
+			return errors.New("no client store")
+		}
+		clientStore := item.(chunk.Store)
+
+		chunks := testutil.ChunksInBin(1, 20, 0, serverRegistry.delivery.kad.BaseAddr())
+		for _, ch := range chunks {
+			if err := sim.PutChunk(serverID, ch); err != nil {
+				return err
+			}
+		}
+
+		waitSynced := func(chunks []chunk.Chunk) error {
+			for _, ch := range chunks {
+				for {
+					has, err := clientStore.Has(ctx, ch.Address())
+					if err != nil {
+						return err
+					}
+					if has {
+						break
+					}
+					select {
+					case <-time.After(10 * time.Millisecond):
+					case <-ctx.Done():
+						return fmt.Errorf("chunk %s not synced: %v", ch.Address(), ctx.Err())
+					}
+				}
+			}
+			return nil
+		}
+
+		// wait for the stream peer to be registered
+		for clientRegistry.getPeer(serverID) == nil {
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if err := clientRegistry.Subscribe(serverID, NewStream("SYNC", FormatSyncBinKey(0), true), NewRange(0, 0), High); err != nil {
+			return err
+		}
+		if err := waitSynced(chunks); err != nil {
+			return err
+		}
+
+		removed := chunks[:5]
+		for _, ch := range removed {
+			if err := clientStore.Set(ctx, chunk.ModeSetRemove, ch.Address()); err != nil {
+				return err
+			}
+		}
+		for _, ch := range removed {
+			has, err := clientStore.Has(ctx, ch.Address())
+			if err != nil {
+				return err
+			}
+			if has {
+				return fmt.Errorf("chunk %s not removed", ch.Address())
+			}
+		}
+
+		if err := clientRegistry.ResyncBin(0); err != nil {
+			return err
+		}
+		return waitSynced(removed)
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+}
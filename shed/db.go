@@ -33,6 +33,7 @@ import (
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 const (
@@ -47,15 +48,62 @@ const (
 type DB struct {
 	ldb  *leveldb.DB
 	quit chan struct{} // Quit channel to stop the metrics collection before closing the database
+	// schema of a read-only database, where fields and indexes
+	// that are not in the stored schema are added only in memory
+	readOnlySchema *schema
 }
 
 // NewDB constructs a new DB and validates the schema
 // if it exists in database on the given path.
 // metricsPrefix is used for metrics collection for the given DB.
 func NewDB(path string, metricsPrefix string) (db *DB, err error) {
-	ldb, err := leveldb.OpenFile(path, &opt.Options{
-		OpenFilesCacheCapacity: openFileLimit,
-	})
+	return NewDBWithOptions(path, metricsPrefix, nil)
+}
+
+// NewDBWithOptions is the same as NewDB, but the LevelDB database
+// is opened with the provided options, for example to tune the block
+// cache and write buffer sizes. If OpenFilesCacheCapacity is not set,
+// the default limit is used. Options can be nil.
+func NewDBWithOptions(path string, metricsPrefix string, o *opt.Options) (db *DB, err error) {
+	return newDB(path, metricsPrefix, o, false)
+}
+
+// NewRecoveredDB is the same as NewDBWithOptions, but the existing
+// LevelDB database is recovered first by rebuilding its manifest from
+// the table files. Corrupted tables are dropped, so some data may be
+// lost. It can be used to open a database that fails with a corruption
+// error.
+func NewRecoveredDB(path string, metricsPrefix string, o *opt.Options) (db *DB, err error) {
+	return newDB(path, metricsPrefix, o, true)
+}
+
+// NewReadOnlyDB opens an existing DB on the given path in read-only
+// mode. All write operations return leveldb.ErrReadOnly. Fields and
+// indexes that are not in the stored schema can be constructed, as
+// the schema is not changed, and they are empty.
+func NewReadOnlyDB(path string, metricsPrefix string) (db *DB, err error) {
+	return newDB(path, metricsPrefix, &opt.Options{
+		ReadOnly:       true,
+		ErrorIfMissing: true,
+	}, false)
+}
+
+func newDB(path string, metricsPrefix string, o *opt.Options, recoverManifest bool) (db *DB, err error) {
+	var options opt.Options
+	if o != nil {
+		options = *o
+	}
+	if options.OpenFilesCacheCapacity == 0 {
+		options.OpenFilesCacheCapacity = openFileLimit
+	}
+	readOnly := options.ReadOnly
+
+	var ldb *leveldb.DB
+	if recoverManifest {
+		ldb, err = leveldb.RecoverFile(path, &options)
+	} else {
+		ldb, err = leveldb.OpenFile(path, &options)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -63,19 +111,25 @@ func NewDB(path string, metricsPrefix string) (db *DB, err error) {
 		ldb: ldb,
 	}
 
-	if _, err = db.getSchema(); err != nil {
-		if err == leveldb.ErrNotFound {
+	s, err := db.getSchema()
+	if err != nil {
+		if err == leveldb.ErrNotFound && !readOnly {
 			// save schema with initialized default fields
 			if err = db.putSchema(schema{
 				Fields:  make(map[string]fieldSpec),
 				Indexes: make(map[byte]indexSpec),
 			}); err != nil {
+				ldb.Close()
 				return nil, err
 			}
 		} else {
+			ldb.Close()
 			return nil, err
 		}
 	}
+	if readOnly {
+		db.readOnlySchema = &s
+	}
 
 	// Create a quit channel for the periodic metrics collector and run it
 	db.quit = make(chan struct{})
@@ -151,6 +205,18 @@ func (db *DB) WriteBatch(batch *leveldb.Batch) (err error) {
 	return nil
 }
 
+// Compact wraps LevelDB CompactRange method to compact
+// the whole key range and increment metrics counter.
+func (db *DB) Compact() (err error) {
+	err = db.ldb.CompactRange(util.Range{})
+	if err != nil {
+		metrics.GetOrRegisterCounter("DB.compactFail", nil).Inc(1)
+		return err
+	}
+	metrics.GetOrRegisterCounter("DB.compact", nil).Inc(1)
+	return nil
+}
+
 // Close closes LevelDB database.
 func (db *DB) Close() (err error) {
 	close(db.quit)
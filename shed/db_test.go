@@ -20,6 +20,8 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
 )
 
 // TestNewDB constructs a new DB
@@ -90,6 +92,82 @@ func TestDB_persistence(t *testing.T) {
 	}
 }
 
+// TestNewReadOnlyDB validates that an existing DB can be opened
+// in read-only mode, that stored values can be retrieved and
+// that write operations fail.
+func TestNewReadOnlyDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shed-test-read-only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// database must exist
+	if _, err := NewReadOnlyDB(dir, ""); err == nil {
+		t.Fatal("expected error opening missing database")
+	}
+
+	db, err := NewDB(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stringField, err := db.NewStringField("preserve-me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "persistent value"
+	if err := stringField.Put(want); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewReadOnlyDB(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	stringField, err = db.NewStringField("preserve-me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := stringField.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got string %q, want %q", got, want)
+	}
+
+	if err := stringField.Put("new value"); err != leveldb.ErrReadOnly {
+		t.Errorf("got error %v, want %v", err, leveldb.ErrReadOnly)
+	}
+	// fields and indexes that are not in the schema are empty
+	newField, err := db.NewStringField("new-field")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := newField.Get(); err != nil || got != "" {
+		t.Errorf("got string %q and error %v, want empty string", got, err)
+	}
+	if err := newField.Put("new value"); err != leveldb.ErrReadOnly {
+		t.Errorf("got error %v, want %v", err, leveldb.ErrReadOnly)
+	}
+	newIndex, err := db.NewIndex("new-index", retrievalIndexFuncs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := newIndex.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("got %v items in new index, want 0", count)
+	}
+}
+
 // newTestDB is a helper function that constructs a
 // temporary database and returns a cleanup function that must
 // be called to remove the data.
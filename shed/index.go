@@ -39,8 +39,11 @@ type Item struct {
 	Address         []byte
 	Data            []byte
 	AccessTimestamp int64
+	AccessCount     uint64
 	StoreTimestamp  int64
 	BinID           uint64
+	Tag             uint32
+	Expiry          int64
 }
 
 // Merge is a helper method to construct a new
@@ -56,12 +59,21 @@ func (i Item) Merge(i2 Item) (new Item) {
 	if i.AccessTimestamp == 0 {
 		i.AccessTimestamp = i2.AccessTimestamp
 	}
+	if i.AccessCount == 0 {
+		i.AccessCount = i2.AccessCount
+	}
 	if i.StoreTimestamp == 0 {
 		i.StoreTimestamp = i2.StoreTimestamp
 	}
 	if i.BinID == 0 {
 		i.BinID = i2.BinID
 	}
+	if i.Tag == 0 {
+		i.Tag = i2.Tag
+	}
+	if i.Expiry == 0 {
+		i.Expiry = i2.Expiry
+	}
 	return i
 }
 
@@ -143,6 +155,40 @@ func (f Index) Get(keyFields Item) (out Item, err error) {
 	return out.Merge(keyFields), nil
 }
 
+// Fill retrieves values for all provided key fields from a single
+// database snapshot and merges them into the items in place. The
+// returned found slice reports for every item whether its key is
+// stored in the index. Items that are not found are left unchanged.
+func (f Index) Fill(items []Item) (found []bool, err error) {
+	snapshot, err := f.db.ldb.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Release()
+
+	found = make([]bool, len(items))
+	for i, item := range items {
+		key, err := f.encodeKeyFunc(item)
+		if err != nil {
+			return nil, err
+		}
+		value, err := snapshot.Get(key, nil)
+		if err != nil {
+			if err == leveldb.ErrNotFound {
+				continue
+			}
+			return nil, err
+		}
+		out, err := f.decodeValueFunc(item, value)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = out.Merge(item)
+		found[i] = true
+	}
+	return found, nil
+}
+
 // Has accepts key fields represented as Item to check
 // if there this Item's encoded key is stored in
 // the index.
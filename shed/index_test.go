@@ -937,6 +937,58 @@ func TestIndex_firstAndLast(t *testing.T) {
 	}
 }
 
+// TestIndex_Fill validates that Index Fill function
+// populates stored items and reports missing ones.
+func TestIndex_Fill(t *testing.T) {
+	db, cleanupFunc := newTestDB(t)
+	defer cleanupFunc()
+
+	index, err := db.NewIndex("retrieval", retrievalIndexFuncs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Item{
+		{
+			Address:        []byte("fill-hash-1"),
+			Data:           []byte("DATA1"),
+			StoreTimestamp: time.Now().UTC().UnixNano(),
+		},
+		{
+			Address:        []byte("fill-hash-2"),
+			Data:           []byte("DATA2"),
+			StoreTimestamp: time.Now().UTC().UnixNano(),
+		},
+	}
+	for _, item := range want {
+		if err := index.Put(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items := []Item{
+		{Address: want[0].Address},
+		{Address: []byte("fill-hash-missing")},
+		{Address: want[1].Address},
+	}
+	found, err := index.Fill(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantFound := []bool{true, false, true}
+	for i, f := range found {
+		if f != wantFound[i] {
+			t.Errorf("item %v: got found %v, want %v", i, f, wantFound[i])
+		}
+	}
+	checkItem(t, items[0], want[0])
+	checkItem(t, items[2], want[1])
+	if items[1].Data != nil {
+		t.Errorf("got data %q for missing item, want none", items[1].Data)
+	}
+}
+
 // TestIncByteSlice validates returned values of incByteSlice function.
 func TestIncByteSlice(t *testing.T) {
 	for _, tc := range []struct {
@@ -0,0 +1,149 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethersphere/swarm/chunk"
+)
+
+// CachingNetStore serves frequently accessed chunks, like manifest roots,
+// from memory. It keeps recently retrieved and stored chunks in a least
+// recently used cache bounded by the total size of cached chunks in bytes.
+// All other methods are handled by the wrapped NetStore.
+type CachingNetStore struct {
+	*NetStore
+	capacity uint64                   // maximal size of cached chunks in bytes
+	size     uint64                   // current size of cached chunks in bytes
+	items    map[string]*list.Element // cached chunks by address
+	order    *list.List               // cached chunks, most recently used first
+	mu       sync.Mutex               // protects size, items and order
+}
+
+// NewCachingNetStore creates a new CachingNetStore that caches at most
+// capacity bytes of chunk data in front of the provided NetStore.
+func NewCachingNetStore(n *NetStore, capacity uint64) *CachingNetStore {
+	return &CachingNetStore{
+		NetStore: n,
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns the chunk from the cache if it is present, otherwise it
+// retrieves it from the NetStore and adds it to the cache.
+func (c *CachingNetStore) Get(ctx context.Context, mode chunk.ModeGet, ref Address) (Chunk, error) {
+	if ch := c.cached(ref); ch != nil {
+		metrics.GetOrRegisterCounter("cachingnetstore.get.hit", nil).Inc(1)
+		return ch, nil
+	}
+	metrics.GetOrRegisterCounter("cachingnetstore.get.miss", nil).Inc(1)
+
+	ch, err := c.NetStore.Get(ctx, mode, ref)
+	if err != nil {
+		return nil, err
+	}
+	c.add(ch)
+	return ch, nil
+}
+
+// Put stores the chunk in the NetStore and adds it to the cache.
+func (c *CachingNetStore) Put(ctx context.Context, mode chunk.ModePut, ch Chunk) (bool, error) {
+	exists, err := c.NetStore.Put(ctx, mode, ch)
+	if err != nil {
+		return exists, err
+	}
+	c.add(ch)
+	return exists, nil
+}
+
+// Set updates the chunk in the NetStore and removes it from the
+// cache if the chunk is removed, so that it is not served anymore.
+func (c *CachingNetStore) Set(ctx context.Context, mode chunk.ModeSet, addr Address) error {
+	err := c.NetStore.Set(ctx, mode, addr)
+	if mode == chunk.ModeSetRemove {
+		c.remove(addr)
+	}
+	return err
+}
+
+// cached returns the chunk with the provided address if it is
+// in the cache, marking it as the most recently used one.
+func (c *CachingNetStore) cached(addr Address) Chunk {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[string(addr)]
+	if !ok {
+		return nil
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(Chunk)
+}
+
+// add puts the chunk in the cache, evicting the least recently
+// used chunks until the cache size is within its capacity.
+// Chunks larger than the capacity are not cached.
+func (c *CachingNetStore) add(ch Chunk) {
+	size := chunkCacheSize(ch)
+	if size > c.capacity {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := string(ch.Address())
+	if e, ok := c.items[key]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.order.PushFront(ch)
+	c.size += size
+	for c.size > c.capacity {
+		e := c.order.Back()
+		evicted := c.order.Remove(e).(Chunk)
+		delete(c.items, string(evicted.Address()))
+		c.size -= chunkCacheSize(evicted)
+	}
+}
+
+// remove removes the chunk with the provided address from the cache.
+func (c *CachingNetStore) remove(addr Address) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := string(addr)
+	e, ok := c.items[key]
+	if !ok {
+		return
+	}
+	c.order.Remove(e)
+	delete(c.items, key)
+	c.size -= chunkCacheSize(e.Value.(Chunk))
+}
+
+// chunkCacheSize returns the number of bytes that the chunk
+// occupies in the cache.
+func chunkCacheSize(ch Chunk) uint64 {
+	return uint64(len(ch.Address()) + len(ch.Data()))
+}
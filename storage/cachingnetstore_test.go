@@ -0,0 +1,125 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethersphere/swarm/chunk"
+)
+
+// TestCachingNetStoreGet validates that the second get of the same chunk
+// is served from the cache and does not reach the underlying store.
+func TestCachingNetStoreGet(t *testing.T) {
+	netStore, _, cleanup := newTestNetStore(t)
+	defer cleanup()
+
+	store := &countingStore{ChunkStore: netStore.Store}
+	netStore.Store = store
+	cachingNetStore := NewCachingNetStore(netStore, 10*chunk.DefaultSize)
+
+	ch := GenerateRandomChunk(chunk.DefaultSize)
+	// put the chunk directly to the local store to bypass the cache
+	if _, err := store.ChunkStore.Put(context.Background(), chunk.ModePutUpload, ch); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := cachingNetStore.Get(context.Background(), chunk.ModeGetRequest, ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Data(), ch.Data()) {
+			t.Fatalf("got chunk data %x, want %x", got.Data(), ch.Data())
+		}
+	}
+	if gets := atomic.LoadInt64(&store.gets); gets != 1 {
+		t.Errorf("got %v gets from the underlying store, want 1", gets)
+	}
+}
+
+// TestCachingNetStoreEviction validates that the total size of cached
+// chunks does not exceed the cache capacity and that the least recently
+// used chunks are evicted first.
+func TestCachingNetStoreEviction(t *testing.T) {
+	netStore, _, cleanup := newTestNetStore(t)
+	defer cleanup()
+
+	store := &countingStore{ChunkStore: netStore.Store}
+	netStore.Store = store
+	capacity := uint64(3 * (chunk.DefaultSize + 32 + 8))
+	cachingNetStore := NewCachingNetStore(netStore, capacity)
+
+	chunks := make([]Chunk, 4)
+	for i := range chunks {
+		chunks[i] = GenerateRandomChunk(chunk.DefaultSize)
+		if _, err := cachingNetStore.Put(context.Background(), chunk.ModePutUpload, chunks[i]); err != nil {
+			t.Fatal(err)
+		}
+		if cachingNetStore.size > capacity {
+			t.Fatalf("got cache size %v, want at most %v", cachingNetStore.size, capacity)
+		}
+	}
+
+	// the most recently used chunks are served from the cache
+	for i := len(chunks) - 1; i > 0; i-- {
+		if _, err := cachingNetStore.Get(context.Background(), chunk.ModeGetRequest, chunks[i].Address()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if gets := atomic.LoadInt64(&store.gets); gets != 0 {
+		t.Fatalf("got %v gets from the underlying store, want 0", gets)
+	}
+
+	// the first chunk is evicted and retrieved from the underlying store
+	if _, err := cachingNetStore.Get(context.Background(), chunk.ModeGetRequest, chunks[0].Address()); err != nil {
+		t.Fatal(err)
+	}
+	if gets := atomic.LoadInt64(&store.gets); gets != 1 {
+		t.Fatalf("got %v gets from the underlying store, want 1", gets)
+	}
+}
+
+// TestCachingNetStoreSetRemove validates that a removed chunk
+// is removed from the cache.
+func TestCachingNetStoreSetRemove(t *testing.T) {
+	netStore, _, cleanup := newTestNetStore(t)
+	defer cleanup()
+
+	cachingNetStore := NewCachingNetStore(netStore, 10*chunk.DefaultSize)
+
+	ch := GenerateRandomChunk(chunk.DefaultSize)
+	if _, err := cachingNetStore.Put(context.Background(), chunk.ModePutUpload, ch); err != nil {
+		t.Fatal(err)
+	}
+	if cachingNetStore.cached(ch.Address()) == nil {
+		t.Fatal("chunk is not cached")
+	}
+
+	if err := cachingNetStore.Set(context.Background(), chunk.ModeSetRemove, ch.Address()); err != nil {
+		t.Fatal(err)
+	}
+	if cachingNetStore.cached(ch.Address()) != nil {
+		t.Error("removed chunk is cached")
+	}
+	if cachingNetStore.size != 0 {
+		t.Errorf("got cache size %v, want 0", cachingNetStore.size)
+	}
+}
@@ -125,9 +125,14 @@ type TreeChunker struct {
 	is because it is left to the DPA to decide which sources are trusted.
 */
 func TreeJoin(ctx context.Context, addr Address, getter Getter, depth int) *LazyChunkReader {
+	return treeJoin(ctx, addr, getter, depth, chunk.DefaultSize)
+}
+
+// treeJoin is TreeJoin for content split into chunks of chunkSize.
+func treeJoin(ctx context.Context, addr Address, getter Getter, depth int, chunkSize int64) *LazyChunkReader {
 	jp := &JoinerParams{
 		ChunkerParams: ChunkerParams{
-			chunkSize: chunk.DefaultSize,
+			chunkSize: chunkSize,
 			hashSize:  int64(len(addr)),
 		},
 		addr:   addr,
@@ -243,6 +243,16 @@ func (m *MapChunkStore) Get(_ context.Context, _ chunk.ModeGet, ref Address) (Ch
 	return chunk, nil
 }
 
+func (m *MapChunkStore) GetMulti(_ context.Context, _ chunk.ModeGet, refs ...Address) ([]Chunk, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	chunks := make([]Chunk, len(refs))
+	for i, ref := range refs {
+		chunks[i] = m.chunks[ref.Hex()]
+	}
+	return chunks, nil
+}
+
 // Need to implement Has from SyncChunkStore
 func (m *MapChunkStore) Has(ctx context.Context, ref Address) (has bool, err error) {
 	m.mu.RLock()
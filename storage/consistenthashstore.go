@@ -0,0 +1,232 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/ethersphere/swarm/chunk"
+	"golang.org/x/crypto/sha3"
+)
+
+// consistentHashReplicas is the number of points on the ring
+// for every store. More points give a more even distribution.
+const consistentHashReplicas = 256
+
+// ConsistentHashStore distributes chunks between multiple chunk stores
+// by mapping chunk addresses to stores on a consistent hash ring. Adding
+// or removing a store remaps only the chunks in the ring segments that
+// the store gains or loses, in contrast to RoundRobinStore where the
+// store holding a chunk is not known from its address.
+type ConsistentHashStore struct {
+	ring   []ringPoint
+	stores map[uint64]ChunkStore
+	nextID uint64
+	mu     sync.RWMutex // protects ring, stores and nextID
+}
+
+// ringPoint is a position on the ring owned by a store.
+type ringPoint struct {
+	pos uint64
+	id  uint64
+}
+
+// ConsistentHashStore implements ChunkStore.
+var _ ChunkStore = &ConsistentHashStore{}
+
+// NewConsistentHashStore creates a new ConsistentHashStore over provided stores.
+func NewConsistentHashStore(stores ...ChunkStore) *ConsistentHashStore {
+	chs := &ConsistentHashStore{
+		stores: make(map[uint64]ChunkStore),
+	}
+	for _, s := range stores {
+		chs.AddStore(s)
+	}
+	return chs
+}
+
+// AddStore adds a store to the ring. Chunks already stored in other
+// stores whose addresses are now mapped to the new store are not moved.
+func (chs *ConsistentHashStore) AddStore(s ChunkStore) {
+	chs.mu.Lock()
+	defer chs.mu.Unlock()
+
+	id := chs.nextID
+	chs.nextID++
+	chs.stores[id] = s
+	for i := 0; i < consistentHashReplicas; i++ {
+		chs.ring = append(chs.ring, ringPoint{
+			pos: ringPosition(id, uint64(i)),
+			id:  id,
+		})
+	}
+	sort.Slice(chs.ring, func(i, j int) bool {
+		return chs.ring[i].pos < chs.ring[j].pos
+	})
+}
+
+// RemoveStore removes a store from the ring without closing it.
+// It returns false if the store is not on the ring.
+func (chs *ConsistentHashStore) RemoveStore(s ChunkStore) bool {
+	chs.mu.Lock()
+	defer chs.mu.Unlock()
+
+	for id, store := range chs.stores {
+		if store != s {
+			continue
+		}
+		delete(chs.stores, id)
+		ring := chs.ring[:0]
+		for _, p := range chs.ring {
+			if p.id != id {
+				ring = append(ring, p)
+			}
+		}
+		chs.ring = ring
+		return true
+	}
+	return false
+}
+
+// ringPosition returns the position on the ring
+// of a replica point of the store with the id.
+func ringPosition(id, replica uint64) uint64 {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b, id)
+	binary.BigEndian.PutUint64(b[8:], replica)
+	h := sha3.NewLegacyKeccak256()
+	h.Write(b)
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+// store returns the store that the address is mapped to, which is
+// the owner of the first ring point at or after the address position.
+func (chs *ConsistentHashStore) store(addr Address) (ChunkStore, error) {
+	chs.mu.RLock()
+	defer chs.mu.RUnlock()
+
+	if len(chs.ring) == 0 {
+		return nil, errors.New("no stores")
+	}
+	var b [8]byte
+	copy(b[:], addr)
+	pos := binary.BigEndian.Uint64(b[:])
+	i := sort.Search(len(chs.ring), func(i int) bool {
+		return chs.ring[i].pos >= pos
+	})
+	if i == len(chs.ring) {
+		i = 0
+	}
+	return chs.stores[chs.ring[i].id], nil
+}
+
+// Put stores the chunk in the store that its address is mapped to.
+func (chs *ConsistentHashStore) Put(ctx context.Context, mode chunk.ModePut, ch Chunk) (bool, error) {
+	s, err := chs.store(ch.Address())
+	if err != nil {
+		return false, err
+	}
+	return s.Put(ctx, mode, ch)
+}
+
+// Has returns true if the store that the address is mapped to holds the chunk.
+func (chs *ConsistentHashStore) Has(ctx context.Context, addr Address) (bool, error) {
+	s, err := chs.store(addr)
+	if err != nil {
+		return false, err
+	}
+	return s.Has(ctx, addr)
+}
+
+// Get returns the chunk from the store that its address is mapped to.
+func (chs *ConsistentHashStore) Get(ctx context.Context, mode chunk.ModeGet, addr Address) (Chunk, error) {
+	s, err := chs.store(addr)
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, mode, addr)
+}
+
+// GetMulti returns chunks for all provided addresses, querying every
+// store once for the addresses that are mapped to it. Chunks that are
+// not found are represented by nil entries in the returned slice.
+func (chs *ConsistentHashStore) GetMulti(ctx context.Context, mode chunk.ModeGet, addrs ...Address) ([]Chunk, error) {
+	// indexes of addresses grouped by stores they are mapped to
+	groups := make(map[ChunkStore][]int)
+	for i, addr := range addrs {
+		s, err := chs.store(addr)
+		if err != nil {
+			return nil, err
+		}
+		groups[s] = append(groups[s], i)
+	}
+	chunks := make([]Chunk, len(addrs))
+	for s, indexes := range groups {
+		query := make([]Address, len(indexes))
+		for i, j := range indexes {
+			query[i] = addrs[j]
+		}
+		got, err := s.GetMulti(ctx, mode, query...)
+		if err != nil {
+			return nil, err
+		}
+		for i, ch := range got {
+			chunks[indexes[i]] = ch
+		}
+	}
+	return chunks, nil
+}
+
+// Set applies the mode to the chunk in the store that its address is mapped to.
+func (chs *ConsistentHashStore) Set(ctx context.Context, mode chunk.ModeSet, addr chunk.Address) (err error) {
+	s, err := chs.store(addr)
+	if err != nil {
+		return err
+	}
+	return s.Set(ctx, mode, addr)
+}
+
+// LastPullSubscriptionBinID is not supported as bin IDs are not
+// comparable between stores.
+func (chs *ConsistentHashStore) LastPullSubscriptionBinID(bin uint8) (id uint64, err error) {
+	return 0, errors.New("ConsistentHashStore doesn't support LastPullSubscriptionBinID")
+}
+
+// SubscribePull is not supported as bin IDs are not comparable between
+// stores. It returns a closed channel.
+func (chs *ConsistentHashStore) SubscribePull(ctx context.Context, bin uint8, since, until uint64) (c <-chan chunk.Descriptor, stop func()) {
+	descriptors := make(chan chunk.Descriptor)
+	close(descriptors)
+	return descriptors, func() {}
+}
+
+// Close closes all stores on the ring.
+func (chs *ConsistentHashStore) Close() (err error) {
+	chs.mu.RLock()
+	defer chs.mu.RUnlock()
+
+	for _, s := range chs.stores {
+		if e := s.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,178 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ethersphere/swarm/chunk"
+)
+
+// TestConsistentHashStore validates that chunks are routed to the
+// store that their addresses are mapped to.
+func TestConsistentHashStore(t *testing.T) {
+	stores := []*MapChunkStore{NewMapChunkStore(), NewMapChunkStore(), NewMapChunkStore()}
+	chs := NewConsistentHashStore(stores[0], stores[1], stores[2])
+
+	ctx := context.Background()
+
+	chunks := GenerateRandomChunks(chunk.DefaultSize, 30)
+	for _, ch := range chunks {
+		if _, err := chs.Put(ctx, chunk.ModePutUpload, ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, ch := range chunks {
+		s, err := chs.store(ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// every chunk is stored only in the store it is mapped to
+		for _, ms := range stores {
+			has, err := ms.Has(ctx, ch.Address())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := ms == s; has != want {
+				t.Errorf("chunk %v: got has %v, want %v", i, has, want)
+			}
+		}
+
+		has, err := chs.Has(ctx, ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !has {
+			t.Errorf("chunk %v not found", i)
+		}
+
+		got, err := chs.Get(ctx, chunk.ModeGetRequest, ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Address(), ch.Address()) {
+			t.Errorf("got chunk %v, want %v", got.Address(), ch.Address())
+		}
+
+		if err := chs.Set(ctx, chunk.ModeSetSync, ch.Address()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	missing := GenerateRandomChunk(chunk.DefaultSize)
+
+	_, err := chs.Get(ctx, chunk.ModeGetRequest, missing.Address())
+	if err != ErrChunkNotFound {
+		t.Errorf("got error %v, want %v", err, ErrChunkNotFound)
+	}
+
+	addrs := []Address{missing.Address()}
+	for _, ch := range chunks {
+		addrs = append(addrs, ch.Address())
+	}
+	got, err := chs.GetMulti(ctx, chunk.ModeGetLookup, addrs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != nil {
+		t.Error("missing chunk found")
+	}
+	for i, ch := range chunks {
+		if got[i+1] == nil {
+			t.Errorf("chunk %v not found", i)
+			continue
+		}
+		if !bytes.Equal(got[i+1].Address(), ch.Address()) {
+			t.Errorf("got chunk %v, want %v", got[i+1].Address(), ch.Address())
+		}
+	}
+
+	if !chs.RemoveStore(stores[0]) {
+		t.Error("store not removed")
+	}
+	if chs.RemoveStore(stores[0]) {
+		t.Error("removed store removed again")
+	}
+	for i, ch := range chunks {
+		s, err := chs.store(ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s == stores[0] {
+			t.Errorf("chunk %v mapped to removed store", i)
+		}
+	}
+}
+
+// TestConsistentHashStoreRemap validates that adding a store remaps
+// fewer addresses than the 1/N fraction, only to the new store.
+func TestConsistentHashStoreRemap(t *testing.T) {
+	n := 4
+	chs := NewConsistentHashStore()
+	for i := 0; i < n; i++ {
+		chs.AddStore(NewMapChunkStore())
+	}
+
+	addrs := make([]Address, 10000)
+	owners := make([]ChunkStore, len(addrs))
+	for i := range addrs {
+		addrs[i] = GenerateRandomChunk(10).Address()
+		s, err := chs.store(addrs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		owners[i] = s
+	}
+
+	added := NewMapChunkStore()
+	chs.AddStore(added)
+
+	var remapped int
+	for i, addr := range addrs {
+		s, err := chs.store(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s == owners[i] {
+			continue
+		}
+		if s != added {
+			t.Errorf("address %v remapped to an old store", i)
+		}
+		remapped++
+	}
+
+	fraction := float64(remapped) / float64(len(addrs))
+	if max := 1 / float64(n); fraction >= max {
+		t.Errorf("remapped %v of addresses, want less than %v", fraction, max)
+	}
+	if remapped == 0 {
+		t.Error("no addresses remapped to the new store")
+	}
+}
+
+// TestConsistentHashStoreNoStores validates that operations
+// fail when there are no stores on the ring.
+func TestConsistentHashStoreNoStores(t *testing.T) {
+	chs := NewConsistentHashStore()
+	if _, err := chs.Put(context.Background(), chunk.ModePutUpload, GenerateRandomChunk(10)); err == nil {
+		t.Error("expected error for no stores")
+	}
+}
@@ -16,7 +16,11 @@
 
 package storage
 
-import "github.com/ethersphere/swarm/chunk"
+import (
+	"errors"
+
+	"github.com/ethersphere/swarm/chunk"
+)
 
 const (
 	ErrInit = iota
@@ -32,5 +36,18 @@ const (
 // Errors are the same as the ones in chunk package for backward compatibility.
 var (
 	ErrChunkNotFound = chunk.ErrChunkNotFound
-	ErrChunkInvalid  = chunk.ErrChunkNotFound
+	ErrChunkInvalid  = chunk.ErrChunkInvalid
+)
+
+// Errors returned by NetStore.Get when the chunk is not retrieved before
+// the request context deadline. ErrChunkInvalid is returned if a chunk with
+// invalid content was delivered. Errors are returned unwrapped, so that they
+// can be compared directly or with errors.Is.
+var (
+	// ErrNoPeers is returned when the last request for the chunk
+	// failed as there were no peers to request it from.
+	ErrNoPeers = errors.New("no peers to request chunk from")
+	// ErrFetchTimeout is returned when the chunk was requested,
+	// but it was not delivered in time.
+	ErrFetchTimeout = errors.New("chunk fetch timeout")
 )
@@ -194,7 +194,8 @@ func (h *Handler) Lookup(ctx context.Context, query *Query) (*cacheEntry, error)
 
 		ch, err := h.chunkStore.Get(ctx, chunk.ModeGetLookup, id.Addr())
 		if err != nil {
-			if err == context.DeadlineExceeded { // chunk not found
+			// chunk not found
+			if err == context.DeadlineExceeded || err == storage.ErrFetchTimeout || err == storage.ErrNoPeers || err == storage.ErrChunkNotFound {
 				return nil, nil
 			}
 			return nil, err //something else happened or context was cancelled.
@@ -62,7 +62,7 @@ func NewTestHandler(datadir string, params *HandlerParams) (*TestHandler, error)
 
 	localStore := chunk.NewValidatorStore(db, storage.NewContentAddressValidator(storage.MakeHashFunc(feedsHashAlgorithm)), fh)
 
-	netStore, err := storage.NewNetStore(localStore, nil)
+	netStore, err := storage.NewNetStore(localStore, nil, nil)
 	if err != nil {
 		return nil, err
 	}
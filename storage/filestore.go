@@ -18,11 +18,19 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/log"
+	"github.com/ethersphere/swarm/state"
 	"github.com/ethersphere/swarm/storage/localstore"
 )
 
@@ -46,12 +54,73 @@ const (
 
 type FileStore struct {
 	ChunkStore
-	hashFunc SwarmHasher
-	tags     *chunk.Tags
+	hashFunc        SwarmHasher
+	chunkSize       int64 // maximal size of chunk data without the span
+	tags            *chunk.Tags
+	checkpoints     state.Store
+	checkpointsOnce sync.Once  // guards creating in-memory checkpoints store
+	checkpointsMu   sync.Mutex // serializes checkpoint writes and the checkpoint index updates
+	rateLimit       int64      // maximal number of bytes per second read by the chunker
+	maxWriteRetries int        // maximal number of retries of a failed chunk write
+	syncStatus      SyncStatusStore
 }
 
 type FileStoreParams struct {
 	Hash string
+	// Hasher, if set, overrides the hash function selected by Hash. It is
+	// used both for chunking and for content address validation.
+	Hasher SwarmHasher `toml:"-"`
+	// CheckpointStore persists upload checkpoints used by FileStore.Resume.
+	// If not set, checkpoints are kept in memory. Checkpoints that are not
+	// removed by a complete upload expire after a day.
+	CheckpointStore state.Store `toml:"-"`
+	// RateLimit is the maximal number of bytes per second that Store
+	// reads from the data reader. Zero value means no limit.
+	RateLimit int64
+	// ChunkSize is the maximal size of chunk data, chunk.DefaultSize if
+	// zero. It must be a power of two, not smaller than MinChunkSize.
+	// Content can be retrieved only by nodes with the same chunk size,
+	// as chunks of other sizes do not pass content address validation.
+	// The same chunk size must be set in the Delivery and local store
+	// options, and the local store VerifyOnGet option requires the
+	// validator returned by Validator.
+	ChunkSize int
+	// MaxWriteRetries is the maximal number of times a chunk write
+	// is retried, with increasing delays, if it fails during Store,
+	// so that transient chunk store errors do not abort the upload.
+	// Invalid chunks are not retried. Zero value disables retries.
+	MaxWriteRetries int
+	// SyncStatusStore reports whether uploaded chunks are synced to the
+	// network. It is required by FileStore.StoreAndSync, as the chunk
+	// store passed to NewFileStore is usually wrapped.
+	SyncStatusStore SyncStatusStore `toml:"-"`
+}
+
+// MinChunkSize is the smallest chunk size that holds
+// two references of encrypted content.
+const MinChunkSize = 128
+
+// ErrInvalidChunkSize is returned by FileStoreParams.Validate when
+// the chunk size is not a power of two or is smaller than MinChunkSize.
+var ErrInvalidChunkSize = errors.New("invalid chunk size")
+
+// Validate returns ErrInvalidChunkSize if the ChunkSize is not valid.
+func (p *FileStoreParams) Validate() error {
+	if p.ChunkSize == 0 {
+		return nil
+	}
+	if p.ChunkSize < MinChunkSize || p.ChunkSize&(p.ChunkSize-1) != 0 {
+		return ErrInvalidChunkSize
+	}
+	return nil
+}
+
+// chunkSize returns the chunk size selected by the params.
+func (p *FileStoreParams) chunkSize() int {
+	if p.ChunkSize == 0 {
+		return chunk.DefaultSize
+	}
+	return p.ChunkSize
 }
 
 func NewFileStoreParams() *FileStoreParams {
@@ -60,21 +129,45 @@ func NewFileStoreParams() *FileStoreParams {
 	}
 }
 
+// HashFunc returns the hash function selected by the params.
+func (p *FileStoreParams) HashFunc() SwarmHasher {
+	if p.Hasher != nil {
+		return p.Hasher
+	}
+	return MakeHashFuncWithChunkSize(p.Hash, p.chunkSize())
+}
+
+// Validator returns the content address validator for chunks
+// created with the hash function and chunk size of the params.
+func (p *FileStoreParams) Validator() *ContentAddressValidator {
+	v := NewContentAddressValidator(p.HashFunc())
+	v.ChunkSize = p.chunkSize()
+	return v
+}
+
 // for testing locally
 func NewLocalFileStore(datadir string, basekey []byte, tags *chunk.Tags) (*FileStore, error) {
 	localStore, err := localstore.New(datadir, basekey, nil)
 	if err != nil {
 		return nil, err
 	}
-	return NewFileStore(chunk.NewValidatorStore(localStore, NewContentAddressValidator(MakeHashFunc(DefaultHash))), NewFileStoreParams(), tags), nil
+	params := NewFileStoreParams()
+	return NewFileStore(chunk.NewValidatorStore(localStore, params.Validator()), params, tags), nil
 }
 
+// NewFileStore returns a new FileStore. The params are expected
+// to be valid, as reported by FileStoreParams.Validate.
 func NewFileStore(store ChunkStore, params *FileStoreParams, tags *chunk.Tags) *FileStore {
-	hashFunc := MakeHashFunc(params.Hash)
+	hashFunc := params.HashFunc()
 	return &FileStore{
-		ChunkStore: store,
-		hashFunc:   hashFunc,
-		tags:       tags,
+		ChunkStore:      store,
+		hashFunc:        hashFunc,
+		chunkSize:       int64(params.chunkSize()),
+		tags:            tags,
+		checkpoints:     params.CheckpointStore,
+		rateLimit:       params.RateLimit,
+		maxWriteRetries: params.MaxWriteRetries,
+		syncStatus:      params.SyncStatusStore,
 	}
 }
 
@@ -89,14 +182,349 @@ func (f *FileStore) Retrieve(ctx context.Context, addr Address) (reader *LazyChu
 	if err != nil {
 		tag = chunk.NewTag(0, "ephemeral-retrieval-tag", 0)
 	}
-	getter := NewHasherStore(f.ChunkStore, f.hashFunc, isEncrypted, tag)
-	reader = TreeJoin(ctx, addr, getter, 0)
+	getter := f.newHasherStore(f.ChunkStore, isEncrypted, tag)
+	reader = treeJoin(ctx, addr, getter, 0, f.chunkSize)
 	return
 }
 
+// RetrieveStreaming returns a reader for sequential reading of the content
+// with the provided address. Unlike Retrieve, intermediate tree chunks are
+// discarded as soon as all of their data is read, and only a bounded number
+// of data chunks is fetched ahead of the read position, which limits memory
+// usage for very large content. Close must be called to stop prefetching.
+func (f *FileStore) RetrieveStreaming(ctx context.Context, addr Address) io.ReadCloser {
+	isEncrypted := len(addr) > f.hashFunc().Size()
+	tag, err := f.tags.GetFromContext(ctx)
+	if err != nil {
+		tag = chunk.NewTag(0, "ephemeral-retrieval-tag", 0)
+	}
+	getter := f.newHasherStore(f.ChunkStore, isEncrypted, tag)
+	return newStreamingReader(ctx, addr, getter, f.chunkSize)
+}
+
+// ErrInvalidRange is returned by RetrieveRange if the offset or
+// length is negative or the offset is beyond the end of content.
+var ErrInvalidRange = errors.New("invalid range")
+
+// RetrieveRange returns a reader for length bytes of the content with the
+// provided address, starting at offset. Only chunks that overlap with the
+// range are fetched. The range is truncated at the end of content. The root
+// chunk is retrieved before returning to validate the range. Close must be
+// called to stop prefetching.
+func (f *FileStore) RetrieveRange(ctx context.Context, addr Address, offset, length int64) (io.ReadCloser, error) {
+	isEncrypted := len(addr) > f.hashFunc().Size()
+	tag, err := f.tags.GetFromContext(ctx)
+	if err != nil {
+		tag = chunk.NewTag(0, "ephemeral-retrieval-tag", 0)
+	}
+	getter := f.newHasherStore(f.ChunkStore, isEncrypted, tag)
+	r, err := newStreamingRangeReader(ctx, addr, getter, f.chunkSize, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
 // Store is a public API. Main entry point for document storage directly. Used by the
 // FS-aware API and httpaccess
+// If the context has a tag, the upload progress is periodically saved
+// under the tag uid, and a failed upload can be continued with Resume.
+// If FileStoreParams.RateLimit is set, data is read at most at that
+// number of bytes per second.
 func (f *FileStore) Store(ctx context.Context, data io.Reader, size int64, toEncrypt bool) (addr Address, wait func(context.Context) error, err error) {
+	tag := f.storeTag(ctx)
+	putter := f.newHasherStore(f.ChunkStore, toEncrypt, tag)
+	return f.split(ctx, data, putter, tag, nil)
+}
+
+// StoreStream stores the data read from the reader until EOF, in the same
+// way as Store does, for sources without a known size, such as standard
+// input or network streams. The chunk tree is built incrementally while
+// the data is read, and the returned address is the same as the one of
+// Store for the same data.
+func (f *FileStore) StoreStream(ctx context.Context, data io.Reader, toEncrypt bool) (addr Address, wait func(context.Context) error, err error) {
+	tag := f.storeTag(ctx)
+	putter := f.newHasherStore(f.ChunkStore, toEncrypt, tag)
+	return f.split(ctx, data, putter, tag, nil)
+}
+
+// StoreStats holds the numbers of chunks stored by StoreWithStats.
+type StoreStats struct {
+	Total     int64 // number of chunks created from the data
+	New       int64 // number of chunks written to the chunk store
+	Duplicate int64 // number of chunks already in the chunk store
+}
+
+// StoreWithStats stores the data in the same way as Store does and
+// waits until all resulting chunks are stored, returning the root
+// address and the numbers of new and duplicate chunks. Chunks are
+// duplicate if they are already in the chunk store, including the
+// ones with repeated content within the same data.
+func (f *FileStore) StoreWithStats(ctx context.Context, data io.Reader, size int64, toEncrypt bool) (addr Address, stats StoreStats, err error) {
+	tag := f.storeTag(ctx)
+	store := &statsChunkStore{ChunkStore: f.ChunkStore}
+	putter := f.newHasherStore(store, toEncrypt, tag)
+	addr, wait, err := f.split(ctx, data, putter, tag, nil)
+	if err != nil {
+		return nil, stats, err
+	}
+	if err := wait(ctx); err != nil {
+		return nil, stats, err
+	}
+	stats.Total = atomic.LoadInt64(&store.total)
+	stats.Duplicate = atomic.LoadInt64(&store.duplicate)
+	stats.New = stats.Total - stats.Duplicate
+	return addr, stats, nil
+}
+
+// statsChunkStore counts chunks put to the embedded ChunkStore
+// and the ones among them that already existed.
+type statsChunkStore struct {
+	ChunkStore
+	total     int64 // accessed atomically
+	duplicate int64 // accessed atomically
+}
+
+func (s *statsChunkStore) Put(ctx context.Context, mode chunk.ModePut, ch Chunk) (exists bool, err error) {
+	exists, err = s.ChunkStore.Put(ctx, mode, ch)
+	if err != nil {
+		return exists, err
+	}
+	atomic.AddInt64(&s.total, 1)
+	if exists {
+		atomic.AddInt64(&s.duplicate, 1)
+	}
+	return exists, nil
+}
+
+// ErrCheckpointNotFound is returned by Resume if there is
+// no saved upload progress for the tag.
+var ErrCheckpointNotFound = errors.New("upload checkpoint not found")
+
+// Resume continues the upload of the data that failed with Store, using the
+// last saved progress for the tag with the provided uid. The data reader
+// must provide the complete content, from its beginning. Data before the
+// checkpoint offset is skipped without reading it if the reader is an
+// io.Seeker, and it is not hashed or stored again. The returned address
+// is the same as if the data was stored with a single Store call.
+func (f *FileStore) Resume(ctx context.Context, tagUID uint32, data io.Reader) (addr Address, wait func(context.Context) error, err error) {
+	tag, err := f.tags.Get(tagUID)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := f.getCheckpoint(tagUID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s, ok := data.(io.Seeker); ok {
+		_, err = s.Seek(c.Offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(ioutil.Discard, data, c.Offset)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("skip to checkpoint offset %d: %v", c.Offset, err)
+	}
+	toEncrypt := c.RefSize > int64(f.hashFunc().Size())
+	putter := f.newHasherStore(f.ChunkStore, toEncrypt, tag)
+	return f.split(ctx, data, putter, tag, c)
+}
+
+// split splits the data with the pyramid chunker, continuing from the
+// checkpoint if it is not nil. The data is read at most at the rate
+// limit from FileStoreParams. Checkpoints are saved only for uploads
+// with a tag that has a non zero uid, when all chunks before them are
+// stored, and are removed when all chunks of the upload are stored.
+func (f *FileStore) split(ctx context.Context, data io.Reader, putter *hasherStore, tag *chunk.Tag, c *splitCheckpoint) (addr Address, wait func(context.Context) error, err error) {
+	if f.rateLimit > 0 {
+		data = newRateLimitedReader(ctx, data, f.rateLimit)
+	}
+	pc := NewPyramidSplitter(NewPyramidSplitterParams(nil, data, putter, putter, f.chunkSize), tag)
+	if tag.Uid == 0 {
+		return pc.Split(ctx)
+	}
+	if c != nil {
+		pc.restoreCheckpoint(c)
+	}
+	u := &uploadCheckpoints{f: f, tagUID: tag.Uid}
+	var saved <-chan struct{}
+	pc.checkpoint = func(c *splitCheckpoint) {
+		saved = putter.afterStored(func() {
+			u.save(c)
+		})
+	}
+	addr, splitWait, err := pc.Split(ctx)
+	if err != nil {
+		// wait for the last checkpoint so that the upload
+		// can be resumed as soon as this function returns
+		if saved != nil {
+			<-saved
+		}
+		return nil, nil, err
+	}
+	return addr, func(ctx context.Context) error {
+		if err := splitWait(ctx); err != nil {
+			return err
+		}
+		u.remove()
+		return nil
+	}, nil
+}
+
+// checkpointTTL is the duration after which saved upload
+// checkpoints that were not used by Resume are removed.
+var checkpointTTL = 24 * time.Hour
+
+// uploadCheckpoints saves the checkpoints of a single upload.
+type uploadCheckpoints struct {
+	f      *FileStore
+	tagUID uint32
+	done   bool // set when the upload is complete, protected by FileStore.checkpointsMu
+}
+
+// save saves the checkpoint, unless the upload is already complete.
+func (u *uploadCheckpoints) save(c *splitCheckpoint) {
+	u.f.checkpointsMu.Lock()
+	defer u.f.checkpointsMu.Unlock()
+
+	if u.done {
+		return
+	}
+	if err := u.f.putCheckpoint(u.tagUID, c); err != nil {
+		log.Warn("filestore: save upload checkpoint", "tag", u.tagUID, "offset", c.Offset, "err", err)
+	}
+}
+
+// remove removes the checkpoint of the complete upload.
+func (u *uploadCheckpoints) remove() {
+	u.f.checkpointsMu.Lock()
+	defer u.f.checkpointsMu.Unlock()
+
+	u.done = true
+	if err := u.f.deleteCheckpoint(u.tagUID); err != nil {
+		log.Warn("filestore: delete upload checkpoint", "tag", u.tagUID, "err", err)
+	}
+}
+
+// checkpointIndexKey is the checkpoint store key of the index that
+// holds the times when checkpoints were saved, by tag uid.
+const checkpointIndexKey = "filestore_checkpoints"
+
+// getCheckpoint returns the saved checkpoint for the tag uid. It returns
+// ErrCheckpointNotFound if the checkpoint is not saved or if it expired.
+func (f *FileStore) getCheckpoint(tagUID uint32) (*splitCheckpoint, error) {
+	f.checkpointsMu.Lock()
+	defer f.checkpointsMu.Unlock()
+
+	index, err := f.checkpointIndex()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := index[tagUID]; !ok {
+		return nil, ErrCheckpointNotFound
+	}
+	c := new(splitCheckpoint)
+	if err := f.checkpointStore().Get(checkpointKey(tagUID), c); err != nil {
+		if err == state.ErrNotFound {
+			return nil, ErrCheckpointNotFound
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// putCheckpoint saves the checkpoint for the tag uid and records it in the
+// checkpoint index. It must be called with checkpointsMu locked.
+func (f *FileStore) putCheckpoint(tagUID uint32, c *splitCheckpoint) error {
+	index, err := f.checkpointIndex()
+	if err != nil {
+		return err
+	}
+	if err := f.checkpointStore().Put(checkpointKey(tagUID), c); err != nil {
+		return err
+	}
+	index[tagUID] = time.Now().UnixNano()
+	return f.checkpointStore().Put(checkpointIndexKey, index)
+}
+
+// deleteCheckpoint removes the checkpoint for the tag uid and its record in
+// the checkpoint index. It must be called with checkpointsMu locked.
+func (f *FileStore) deleteCheckpoint(tagUID uint32) error {
+	index, err := f.checkpointIndex()
+	if err != nil {
+		return err
+	}
+	if _, ok := index[tagUID]; !ok {
+		return nil
+	}
+	if err := f.checkpointStore().Delete(checkpointKey(tagUID)); err != nil {
+		return err
+	}
+	delete(index, tagUID)
+	return f.checkpointStore().Put(checkpointIndexKey, index)
+}
+
+// checkpointIndex returns the checkpoint index, removing the checkpoints
+// that are older than checkpointTTL. It must be called with
+// checkpointsMu locked.
+func (f *FileStore) checkpointIndex() (map[uint32]int64, error) {
+	index := make(map[uint32]int64)
+	if err := f.checkpointStore().Get(checkpointIndexKey, &index); err != nil && err != state.ErrNotFound {
+		return nil, err
+	}
+	var expired bool
+	for tagUID, saved := range index {
+		if time.Since(time.Unix(0, saved)) <= checkpointTTL {
+			continue
+		}
+		if err := f.checkpointStore().Delete(checkpointKey(tagUID)); err != nil {
+			return nil, err
+		}
+		delete(index, tagUID)
+		expired = true
+	}
+	if expired {
+		if err := f.checkpointStore().Put(checkpointIndexKey, index); err != nil {
+			return nil, err
+		}
+	}
+	return index, nil
+}
+
+// checkpointStore returns the store for upload checkpoints, creating
+// an in-memory one if it is not provided in FileStoreParams.
+func (f *FileStore) checkpointStore() state.Store {
+	f.checkpointsOnce.Do(func() {
+		if f.checkpoints == nil {
+			f.checkpoints = state.NewInmemoryStore()
+		}
+	})
+	return f.checkpoints
+}
+
+// checkpointKey returns the checkpoint store key for the tag uid.
+func checkpointKey(tagUID uint32) string {
+	return fmt.Sprintf("filestore_checkpoint_%d", tagUID)
+}
+
+// Hash returns the address of the data as it would be returned by Store,
+// without storing any chunks. For encrypted content, the address is
+// different on every call, as encryption keys and padding are random.
+func (f *FileStore) Hash(ctx context.Context, data io.Reader, size int64, toEncrypt bool) (addr Address, err error) {
+	tag := chunk.NewTag(0, "ephemeral-hash-tag", 0)
+	putter := f.newHasherStore(&FakeChunkStore{}, toEncrypt, tag)
+	addr, wait, err := f.pyramidSplit(ctx, data, putter, putter, tag)
+	if err != nil {
+		return nil, err
+	}
+	if err := wait(ctx); err != nil {
+		return nil, err
+	}
+	return addr, nil
+}
+
+// storeTag returns the tag from the context or an ephemeral tag
+// if the context does not have one.
+func (f *FileStore) storeTag(ctx context.Context) *chunk.Tag {
 	tag, err := f.tags.GetFromContext(ctx)
 	if err != nil {
 		// some of the parts of the codebase, namely the manifest trie, do not store the context
@@ -104,10 +532,109 @@ func (f *FileStore) Store(ctx context.Context, data io.Reader, size int64, toEnc
 		// loses the tag uid. thus we create an ephemeral tag here for that purpose
 
 		tag = chunk.NewTag(0, "", 0)
-		//return nil, nil, err
 	}
-	putter := NewHasherStore(f.ChunkStore, f.hashFunc, toEncrypt, tag)
-	return PyramidSplit(ctx, data, putter, putter, tag)
+	return tag
+}
+
+// ErrSyncStatusNotSupported is returned by StoreAndSync if the
+// FileStoreParams SyncStatusStore is not set.
+var ErrSyncStatusNotSupported = errors.New("chunk store does not support sync status")
+
+// SyncError is returned by StoreAndSync when some of the stored
+// chunks are not synced before the context is done.
+type SyncError struct {
+	Addrs []Address // addresses of chunks that are not synced
+	Err   error     // context error
+}
+
+func (e *SyncError) Error() string {
+	addrs := make([]string, len(e.Addrs))
+	for i, a := range e.Addrs {
+		addrs[i] = a.Hex()
+	}
+	return fmt.Sprintf("%d chunks not synced: %v: %s", len(e.Addrs), e.Err, strings.Join(addrs, ", "))
+}
+
+// SyncStatusStore is implemented by chunk stores that report whether
+// locally uploaded chunks are synced to the network, such as localstore.DB.
+type SyncStatusStore interface {
+	IsSynced(ctx context.Context, addr chunk.Address) (bool, error)
+}
+
+// syncCheckInterval is the time between two checks of chunk sync status
+// in StoreAndSync.
+var syncCheckInterval = 100 * time.Millisecond
+
+// StoreAndSync stores the data in the same way as Store does and blocks
+// until all resulting chunks are synced to the network, returning the root
+// address. A chunk is synced when it is removed from the local store push
+// syncing index after it is offered to a peer. If the context is done before
+// all chunks are synced, the root address is returned together with
+// SyncError that lists addresses of chunks that are not synced.
+func (f *FileStore) StoreAndSync(ctx context.Context, data io.Reader, size int64, toEncrypt bool) (addr Address, err error) {
+	s := f.syncStatus
+	if s == nil {
+		return nil, ErrSyncStatusNotSupported
+	}
+
+	tag := f.storeTag(ctx)
+	putter := &hashExplorer{
+		hasherStore: f.newHasherStore(f.ChunkStore, toEncrypt, tag),
+	}
+	addr, wait, err := f.pyramidSplit(ctx, data, putter, putter, tag)
+	if err != nil {
+		return nil, err
+	}
+	if err := wait(ctx); err != nil {
+		return nil, err
+	}
+
+	// references of encrypted chunks also contain the decryption key
+	pending := make([]Address, 0, len(putter.references))
+	for _, ref := range putter.references {
+		pending = append(pending, Address(ref[:f.HashSize()]))
+	}
+
+	ticker := time.NewTicker(syncCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		unsynced := pending[:0]
+		for _, a := range pending {
+			synced, err := s.IsSynced(ctx, a)
+			if err != nil {
+				return nil, err
+			}
+			if !synced {
+				unsynced = append(unsynced, a)
+			}
+		}
+		pending = unsynced
+		if len(pending) == 0 {
+			return addr, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return addr, &SyncError{
+				Addrs: pending,
+				Err:   ctx.Err(),
+			}
+		}
+	}
+}
+
+// newHasherStore returns a hasherStore for chunks of the FileStore chunk size.
+func (f *FileStore) newHasherStore(store ChunkStore, toEncrypt bool, tag *chunk.Tag) *hasherStore {
+	h := NewHasherStore(store, f.hashFunc, toEncrypt, tag)
+	h.chunkSize = f.chunkSize
+	h.retries = f.maxWriteRetries
+	return h
+}
+
+// pyramidSplit is PyramidSplit with the FileStore chunk size.
+func (f *FileStore) pyramidSplit(ctx context.Context, data io.Reader, putter Putter, getter Getter, tag *chunk.Tag) (Address, func(context.Context) error, error) {
+	return NewPyramidSplitter(NewPyramidSplitterParams(nil, data, putter, getter, f.chunkSize), tag).Split(ctx)
 }
 
 func (f *FileStore) HashSize() int {
@@ -120,10 +647,10 @@ func (f *FileStore) GetAllReferences(ctx context.Context, data io.Reader, toEncr
 
 	// create a special kind of putter, which only will store the references
 	putter := &hashExplorer{
-		hasherStore: NewHasherStore(f.ChunkStore, f.hashFunc, toEncrypt, tag),
+		hasherStore: f.newHasherStore(f.ChunkStore, toEncrypt, tag),
 	}
 	// do the actual splitting anyway, no way around it
-	_, wait, err := PyramidSplit(ctx, data, putter, putter, tag)
+	_, wait, err := f.pyramidSplit(ctx, data, putter, putter, tag)
 	if err != nil {
 		return nil, err
 	}
@@ -19,15 +19,25 @@ package storage
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/sctx"
+	"github.com/ethersphere/swarm/state"
+	"github.com/ethersphere/swarm/storage/encryption"
 	"github.com/ethersphere/swarm/storage/localstore"
 	"github.com/ethersphere/swarm/testutil"
+	"golang.org/x/crypto/sha3"
 )
 
 const testDataSize = 0x0001000
@@ -200,3 +210,986 @@ func TestGetAllReferences(t *testing.T) {
 		}
 	}
 }
+
+// TestFileStoreHash validates that FileStore.Hash returns the same
+// address as Store for the same data and that it does not store chunks.
+func TestFileStoreHash(t *testing.T) {
+	// derive encryption keys from chunk data,
+	// so that encrypted references are deterministic
+	defer func(f func(ChunkData) encryption.Key) { encryptionKey = f }(encryptionKey)
+	encryptionKey = func(data ChunkData) encryption.Key {
+		h := sha3.NewLegacyKeccak256()
+		h.Write(data)
+		return h.Sum(nil)
+	}
+
+	testFileStoreHash(false, []int{1024, 8192, 1000000}, t)
+	// encrypted chunks with less data than chunk.DefaultSize
+	// are padded with random bytes, so only a single full
+	// chunk has a deterministic reference
+	testFileStoreHash(true, []int{chunk.DefaultSize}, t)
+}
+
+func testFileStoreHash(toEncrypt bool, dataSizes []int, t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-storage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localStore, err := localstore.New(dir, make([]byte, 32), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localStore.Close()
+
+	fileStore := NewFileStore(localStore, NewFileStoreParams(), chunk.NewTags())
+
+	ctx := context.Background()
+	for _, dataSize := range dataSizes {
+		slice := testutil.RandomBytes(1, dataSize)
+
+		hashAddr, err := fileStore.Hash(ctx, bytes.NewReader(slice), int64(dataSize), toEncrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		has, err := localStore.Has(ctx, hashAddr[:AddressLength])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if has {
+			t.Fatalf("data size %v: root chunk stored by Hash", dataSize)
+		}
+
+		storeAddr, wait, err := fileStore.Store(ctx, bytes.NewReader(slice), int64(dataSize), toEncrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(hashAddr, storeAddr) {
+			t.Errorf("data size %v: got hash address %s, want %s", dataSize, hashAddr, storeAddr)
+		}
+	}
+}
+
+// TestFileStoreStoreAndSync validates that StoreAndSync returns when all
+// chunks are set as synced in the local store, and that it returns SyncError
+// with addresses of unsynced chunks if the context is done before.
+func TestFileStoreStoreAndSync(t *testing.T) {
+	testFileStoreStoreAndSync(false, t)
+	testFileStoreStoreAndSync(true, t)
+}
+
+func testFileStoreStoreAndSync(toEncrypt bool, t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-storage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localStore, err := localstore.New(dir, make([]byte, 32), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localStore.Close()
+
+	params := NewFileStoreParams()
+	store := chunk.NewValidatorStore(localStore, params.Validator())
+
+	fileStore := NewFileStore(store, params, chunk.NewTags())
+	if _, err := fileStore.StoreAndSync(context.Background(), bytes.NewReader(testutil.RandomBytes(1, 10)), 10, toEncrypt); err != ErrSyncStatusNotSupported {
+		t.Fatalf("got error %v, want %v", err, ErrSyncStatusNotSupported)
+	}
+
+	// the sync status is reported by the local store behind the wrapper
+	params.SyncStatusStore = localStore
+	fileStore = NewFileStore(store, params, chunk.NewTags())
+
+	// 8192 bytes are split into 3 chunks
+	dataSize := 8192
+	chunkCount := 3
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	_, err = fileStore.StoreAndSync(ctx, bytes.NewReader(testutil.RandomBytes(1, dataSize)), int64(dataSize), toEncrypt)
+	syncErr, ok := err.(*SyncError)
+	if !ok {
+		t.Fatalf("got error %v, want SyncError", err)
+	}
+	if len(syncErr.Addrs) != chunkCount {
+		t.Fatalf("got %v unsynced chunks, want %v", len(syncErr.Addrs), chunkCount)
+	}
+
+	// set all pushed chunks as synced
+	pushC, stop := localStore.SubscribePush(context.Background())
+	defer stop()
+	go func() {
+		for ch := range pushC {
+			if err := localStore.Set(context.Background(), chunk.ModeSetSync, ch.Address()); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	slice := testutil.RandomBytes(2, dataSize)
+	addr, err := fileStore.StoreAndSync(ctx, bytes.NewReader(slice), int64(dataSize), toEncrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resultSlice := make([]byte, dataSize)
+	reader, _ := fileStore.Retrieve(context.Background(), addr)
+	n, err := reader.ReadAt(resultSlice, 0)
+	if err != io.EOF {
+		t.Fatalf("Retrieve error: %v", err)
+	}
+	if n != dataSize {
+		t.Fatalf("got %v bytes, want %v", n, dataSize)
+	}
+	if !bytes.Equal(slice, resultSlice) {
+		t.Fatal("retrieved data is not equal to stored data")
+	}
+}
+
+// countingStore counts chunks retrieved from the chunk store.
+type countingStore struct {
+	ChunkStore
+	gets int64
+}
+
+func (s *countingStore) Get(ctx context.Context, mode chunk.ModeGet, addr chunk.Address) (chunk.Chunk, error) {
+	atomic.AddInt64(&s.gets, 1)
+	return s.ChunkStore.Get(ctx, mode, addr)
+}
+
+// TestFileStoreRetrieveStreaming validates that RetrieveStreaming returns
+// the stored content and that it fetches only a bounded number of chunks
+// ahead of the read position.
+func TestFileStoreRetrieveStreaming(t *testing.T) {
+	testFileStoreRetrieveStreaming(false, t)
+	testFileStoreRetrieveStreaming(true, t)
+}
+
+func testFileStoreRetrieveStreaming(toEncrypt bool, t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-storage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localStore, err := localstore.New(dir, make([]byte, 32), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localStore.Close()
+
+	store := &countingStore{ChunkStore: localStore}
+	fileStore := NewFileStore(store, NewFileStoreParams(), chunk.NewTags())
+
+	// 5MB of data is split into 1280 data chunks and a few intermediate chunks
+	dataSize := 5 * 1024 * 1024
+	data := testutil.RandomBytes(1, dataSize)
+
+	ctx := context.Background()
+	addr, wait, err := fileStore.Store(ctx, bytes.NewReader(data), int64(dataSize), toEncrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	r := fileStore.RetrieveStreaming(ctx, addr)
+	defer r.Close()
+
+	first := make([]byte, chunk.DefaultSize)
+	if _, err := io.ReadFull(r, first); err != nil {
+		t.Fatal(err)
+	}
+	// allow prefetching to fill up the buffers
+	time.Sleep(200 * time.Millisecond)
+
+	// root and intermediate chunks on the path to the current leaf,
+	// the prefetch window and the buffered leaf chunks
+	maxGets := int64(4 + 2*streamingReadAhead + 1)
+	if gets := atomic.LoadInt64(&store.gets); gets > maxGets {
+		t.Errorf("got %v retrieved chunks after reading the first chunk, want at most %v", gets, maxGets)
+	}
+
+	rest, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(append(first, rest...), data) {
+		t.Fatal("retrieved data is not equal to stored data")
+	}
+}
+
+// TestFileStoreRetrieveRange validates that RetrieveRange returns the
+// requested range of the stored content, fetching only chunks that
+// overlap with it, and that invalid ranges are rejected.
+func TestFileStoreRetrieveRange(t *testing.T) {
+	testFileStoreRetrieveRange(false, t)
+	testFileStoreRetrieveRange(true, t)
+}
+
+func testFileStoreRetrieveRange(toEncrypt bool, t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-storage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localStore, err := localstore.New(dir, make([]byte, 32), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localStore.Close()
+
+	store := &countingStore{ChunkStore: localStore}
+	fileStore := NewFileStore(store, NewFileStoreParams(), chunk.NewTags())
+
+	// the last data chunk is partial
+	dataSize := 1000000
+	data := testutil.RandomBytes(1, dataSize)
+
+	ctx := context.Background()
+	addr, wait, err := fileStore.Store(ctx, bytes.NewReader(data), int64(dataSize), toEncrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		offset    int64
+		length    int64
+		want      []byte
+		maxGets   int64
+		wantError error
+	}{
+		{
+			name:    "within data chunks",
+			offset:  100000,
+			length:  100,
+			want:    data[100000:100100],
+			maxGets: 3,
+		},
+		{
+			name:    "multiple data chunks",
+			offset:  5000,
+			length:  20000,
+			want:    data[5000:25000],
+			maxGets: 8,
+		},
+		{
+			name:    "final partial chunk",
+			offset:  int64(dataSize) - 5000,
+			length:  5000,
+			want:    data[dataSize-5000:],
+			maxGets: 5,
+		},
+		{
+			name:    "truncated at the end",
+			offset:  int64(dataSize) - 100,
+			length:  1000,
+			want:    data[dataSize-100:],
+			maxGets: 3,
+		},
+		{
+			name:      "offset beyond the end",
+			offset:    int64(dataSize) + 1,
+			length:    1,
+			wantError: ErrInvalidRange,
+		},
+		{
+			name:      "negative offset",
+			offset:    -1,
+			length:    1,
+			wantError: ErrInvalidRange,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s encrypted %v", tc.name, toEncrypt), func(t *testing.T) {
+			atomic.StoreInt64(&store.gets, 0)
+
+			r, err := fileStore.RetrieveRange(ctx, addr, tc.offset, tc.length)
+			if err != tc.wantError {
+				t.Fatalf("got error %v, want %v", err, tc.wantError)
+			}
+			if err != nil {
+				return
+			}
+			defer r.Close()
+
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tc.want) {
+				t.Fatal("retrieved range is not equal to stored data")
+			}
+			if gets := atomic.LoadInt64(&store.gets); gets > tc.maxGets {
+				t.Errorf("got %v retrieved chunks, want at most %v", gets, tc.maxGets)
+			}
+		})
+	}
+}
+
+// TestFileStoreHasher validates that a custom hasher set in FileStoreParams
+// is used both for chunking and for content address validation.
+func TestFileStoreHasher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-storage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localStore, err := localstore.New(dir, make([]byte, 32), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localStore.Close()
+
+	params := NewFileStoreParams()
+	params.Hasher = func() SwarmHash {
+		return &HashWithLength{sha3.NewLegacyKeccak256()}
+	}
+	store := chunk.NewValidatorStore(localStore, NewContentAddressValidator(params.HashFunc()))
+	fileStore := NewFileStore(store, params, chunk.NewTags())
+
+	data := testutil.RandomBytes(1, testDataSize)
+	ctx := context.Background()
+	addr, wait, err := fileStore.Store(ctx, bytes.NewReader(data), testDataSize, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	defaultAddr, _, err := NewFileStore(&FakeChunkStore{}, NewFileStoreParams(), chunk.NewTags()).Store(ctx, bytes.NewReader(data), testDataSize, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(addr, defaultAddr) {
+		t.Fatal("got the same address as with the default hasher")
+	}
+
+	reader, _ := fileStore.Retrieve(ctx, addr)
+	result, err := ioutil.ReadAll(io.NewSectionReader(reader, 0, testDataSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result, data) {
+		t.Fatal("retrieved data is not equal to stored data")
+	}
+
+	// chunks hashed with the custom hasher are not valid for the default one
+	defaultStore := chunk.NewValidatorStore(localStore, NewContentAddressValidator(MakeHashFunc(DefaultHash)))
+	_, wait, err = NewFileStore(defaultStore, params, chunk.NewTags()).Store(ctx, bytes.NewReader(data), testDataSize, false)
+	if err == nil {
+		err = wait(ctx)
+	}
+	if err != chunk.ErrChunkInvalid {
+		t.Fatalf("got error %v, want %v", err, chunk.ErrChunkInvalid)
+	}
+}
+
+// failingReader returns an error after reading the limit number of bytes.
+type failingReader struct {
+	r     io.Reader
+	limit int64
+}
+
+var errFailingReader = errors.New("failing reader")
+
+func (r *failingReader) Read(p []byte) (n int, err error) {
+	if r.limit <= 0 {
+		return 0, errFailingReader
+	}
+	if int64(len(p)) > r.limit {
+		p = p[:r.limit]
+	}
+	n, err = r.r.Read(p)
+	r.limit -= int64(n)
+	return n, err
+}
+
+// putCountingStore counts chunks put to the chunk store.
+type putCountingStore struct {
+	ChunkStore
+	puts int64
+}
+
+func (s *putCountingStore) Put(ctx context.Context, mode chunk.ModePut, ch chunk.Chunk) (bool, error) {
+	atomic.AddInt64(&s.puts, 1)
+	return s.ChunkStore.Put(ctx, mode, ch)
+}
+
+// TestFileStoreResume validates that an interrupted upload can be continued
+// with Resume without storing the chunks before the checkpoint again, and
+// that the resulting address is the same as of an uninterrupted upload.
+func TestFileStoreResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-storage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localStore, err := localstore.New(dir, make([]byte, 32), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localStore.Close()
+
+	store := &putCountingStore{ChunkStore: localStore}
+	tags := chunk.NewTags()
+	fileStore := NewFileStore(store, NewFileStoreParams(), tags)
+
+	// more than two levels of tree chunks
+	dataSize := 5*chunk.DefaultSize*128 + 1000
+	data := testutil.RandomBytes(1, dataSize)
+
+	ctx := context.Background()
+	wantAddr, err := NewFileStore(&FakeChunkStore{}, NewFileStoreParams(), chunk.NewTags()).Hash(ctx, bytes.NewReader(data), int64(dataSize), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tag, err := tags.New("resume", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagCtx := sctx.SetTag(ctx, tag.Uid)
+
+	// interrupt the upload after the third level 0 tree chunk
+	_, _, err = fileStore.Store(tagCtx, &failingReader{
+		r:     bytes.NewReader(data),
+		limit: int64(3*chunk.DefaultSize*128 + 100),
+	}, int64(dataSize), false)
+	if err != errFailingReader {
+		t.Fatalf("got error %v, want %v", err, errFailingReader)
+	}
+
+	putsBefore := atomic.LoadInt64(&store.puts)
+
+	addr, wait, err := fileStore.Resume(ctx, tag.Uid, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(addr, wantAddr) {
+		t.Fatalf("got address %s, want %s", addr, wantAddr)
+	}
+
+	// data chunks after the checkpoint and tree chunks, the checkpoint
+	// saved at a level 0 tree chunk boundary is the one from the previous
+	// boundary, as its tree chunk keys are known without waiting
+	maxPuts := int64(3*128 + 1 + 10)
+	if puts := atomic.LoadInt64(&store.puts) - putsBefore; puts > maxPuts {
+		t.Errorf("got %v chunks put on resume, want at most %v", puts, maxPuts)
+	}
+
+	reader, _ := fileStore.Retrieve(ctx, addr)
+	result, err := ioutil.ReadAll(io.NewSectionReader(reader, 0, int64(dataSize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result, data) {
+		t.Fatal("retrieved data is not equal to stored data")
+	}
+
+	// the checkpoint is removed after the upload is done
+	if _, _, err := fileStore.Resume(ctx, tag.Uid, bytes.NewReader(data)); err != ErrCheckpointNotFound {
+		t.Fatalf("got error %v, want %v", err, ErrCheckpointNotFound)
+	}
+}
+
+// failingPutStore fails to put chunks to the chunk store.
+type failingPutStore struct {
+	ChunkStore
+}
+
+var errFailingPut = errors.New("failing put")
+
+func (s *failingPutStore) Put(_ context.Context, _ chunk.ModePut, _ chunk.Chunk) (bool, error) {
+	return false, errFailingPut
+}
+
+// TestFileStoreCheckpoints validates that upload checkpoints are not saved
+// if chunks before them are not stored and that they expire after
+// checkpointTTL.
+func TestFileStoreCheckpoints(t *testing.T) {
+	defer func(ttl time.Duration) { checkpointTTL = ttl }(checkpointTTL)
+
+	data := testutil.RandomBytes(1, 3*chunk.DefaultSize*128+1000)
+	ctx := context.Background()
+
+	store := func(t *testing.T, fileStore *FileStore, tags *chunk.Tags) *chunk.Tag {
+		t.Helper()
+
+		tag, err := tags.New("checkpoints", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = fileStore.Store(sctx.SetTag(ctx, tag.Uid), &failingReader{
+			r:     bytes.NewReader(data),
+			limit: int64(2*chunk.DefaultSize*128 + 100),
+		}, int64(len(data)), false)
+		if err != errFailingReader {
+			t.Fatalf("got error %v, want %v", err, errFailingReader)
+		}
+		return tag
+	}
+
+	t.Run("not stored", func(t *testing.T) {
+		tags := chunk.NewTags()
+		fileStore := NewFileStore(&failingPutStore{ChunkStore: &FakeChunkStore{}}, NewFileStoreParams(), tags)
+		tag := store(t, fileStore, tags)
+
+		if _, _, err := fileStore.Resume(ctx, tag.Uid, bytes.NewReader(data)); err != ErrCheckpointNotFound {
+			t.Fatalf("got error %v, want %v", err, ErrCheckpointNotFound)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		checkpoints := state.NewInmemoryStore()
+		defer checkpoints.Close()
+
+		params := NewFileStoreParams()
+		params.CheckpointStore = checkpoints
+		tags := chunk.NewTags()
+		fileStore := NewFileStore(&FakeChunkStore{}, params, tags)
+		tag := store(t, fileStore, tags)
+
+		if err := checkpoints.Get(checkpointKey(tag.Uid), new(splitCheckpoint)); err != nil {
+			t.Fatalf("got error %v for saved checkpoint", err)
+		}
+
+		checkpointTTL = 0
+		if _, _, err := fileStore.Resume(ctx, tag.Uid, bytes.NewReader(data)); err != ErrCheckpointNotFound {
+			t.Fatalf("got error %v, want %v", err, ErrCheckpointNotFound)
+		}
+		if err := checkpoints.Get(checkpointKey(tag.Uid), new(splitCheckpoint)); err != state.ErrNotFound {
+			t.Fatalf("got error %v for expired checkpoint, want %v", err, state.ErrNotFound)
+		}
+	})
+}
+
+// TestFileStoreRateLimit validates that storing data with a rate limit
+// takes at least as long as reading the data at the limited rate.
+func TestFileStoreRateLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-storage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localStore, err := localstore.New(dir, make([]byte, 32), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localStore.Close()
+
+	params := NewFileStoreParams()
+	params.RateLimit = 16 * 1024
+	fileStore := NewFileStore(localStore, params, chunk.NewTags())
+
+	size := int64(3 * chunk.DefaultSize)
+	data := testutil.RandomBytes(1, int(size))
+	want := time.Duration(size) * time.Second / time.Duration(params.RateLimit)
+
+	ctx := context.Background()
+	start := time.Now()
+	addr, wait, err := fileStore.Store(ctx, bytes.NewReader(data), size, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < want {
+		t.Errorf("got store duration %v, want at least %v", elapsed, want)
+	}
+
+	reader, _ := fileStore.Retrieve(ctx, addr)
+	got, err := ioutil.ReadAll(io.NewSectionReader(reader, 0, size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("retrieved data does not match stored data")
+	}
+}
+
+// TestRateLimitedReaderLongIdle validates that a rate limited reader
+// idle for a long time refills its tokens up to the rate only,
+// without overflowing.
+func TestRateLimitedReaderLongIdle(t *testing.T) {
+	rate := int64(16 * 1024)
+	r := newRateLimitedReader(context.Background(), bytes.NewReader(nil), rate)
+	r.tokens = 0
+	r.last = time.Now().Add(-24 * 365 * time.Hour)
+	r.refill()
+	if r.tokens != rate {
+		t.Errorf("got %v tokens, want %v", r.tokens, rate)
+	}
+}
+
+// TestFileStoreChunkSize validates that content stored with a custom
+// chunk size is retrieved correctly, that its chunks are not accepted
+// by a store that validates chunks of the default size, and that only
+// valid chunk sizes pass params validation.
+func TestFileStoreChunkSize(t *testing.T) {
+	for _, tc := range []struct {
+		chunkSize int
+		wantErr   error
+	}{
+		{chunkSize: 0},
+		{chunkSize: MinChunkSize},
+		{chunkSize: 8192},
+		{chunkSize: MinChunkSize / 2, wantErr: ErrInvalidChunkSize},
+		{chunkSize: 5000, wantErr: ErrInvalidChunkSize},
+	} {
+		params := NewFileStoreParams()
+		params.ChunkSize = tc.chunkSize
+		if err := params.Validate(); err != tc.wantErr {
+			t.Errorf("chunk size %v: got error %v, want %v", tc.chunkSize, err, tc.wantErr)
+		}
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		testFileStoreChunkSize(false, t)
+	})
+	t.Run("round trip encrypted", func(t *testing.T) {
+		testFileStoreChunkSize(true, t)
+	})
+
+	t.Run("chunk size mismatch", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "swarm-storage-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		localStore, err := localstore.New(dir, make([]byte, 32), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer localStore.Close()
+
+		params := NewFileStoreParams()
+		params.ChunkSize = 8192
+		store := chunk.NewValidatorStore(localStore, NewFileStoreParams().Validator())
+		fileStore := NewFileStore(store, params, chunk.NewTags())
+
+		data := testutil.RandomBytes(1, 100000)
+		ctx := context.Background()
+		_, wait, err := fileStore.Store(ctx, bytes.NewReader(data), int64(len(data)), false)
+		if err == nil {
+			err = wait(ctx)
+		}
+		if err == nil {
+			t.Fatal("chunks of a different size stored without error")
+		}
+	})
+}
+
+func testFileStoreChunkSize(toEncrypt bool, t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-storage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const chunkSize = 8192
+	params := NewFileStoreParams()
+	params.ChunkSize = chunkSize
+	// chunks are verified on get with the validator for the chunk size
+	localStore, err := localstore.New(dir, make([]byte, 32), &localstore.Options{
+		VerifyOnGet: true,
+		Validators:  []chunk.Validator{params.Validator()},
+		ChunkSize:   chunkSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localStore.Close()
+
+	store := chunk.NewValidatorStore(localStore, params.Validator())
+	fileStore := NewFileStore(store, params, chunk.NewTags())
+
+	dataSize := 1000000
+	data := testutil.RandomBytes(1, dataSize)
+
+	ctx := context.Background()
+	addr, wait, err := fileStore.Store(ctx, bytes.NewReader(data), int64(dataSize), toEncrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, _ := fileStore.Retrieve(ctx, addr)
+	got, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("retrieved data is not the same as stored")
+	}
+
+	streaming := fileStore.RetrieveStreaming(ctx, addr)
+	defer streaming.Close()
+	got, err = ioutil.ReadAll(streaming)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("data retrieved by streaming is not the same as stored")
+	}
+
+	refs, err := fileStore.GetAllReferences(ctx, bytes.NewReader(data), toEncrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// data chunks and a single root chunk
+	wantRefs := (dataSize+chunkSize-1)/chunkSize + 1
+	if len(refs) != wantRefs {
+		t.Errorf("got %v chunks, want %v", len(refs), wantRefs)
+	}
+}
+
+// TestFileStoreStoreStream validates that content stored from a pipe
+// without a known size has the same address as when it is stored with
+// the size and that it can be retrieved.
+func TestFileStoreStoreStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-storage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localStore, err := localstore.New(dir, make([]byte, 32), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localStore.Close()
+
+	fileStore := NewFileStore(localStore, NewFileStoreParams(), chunk.NewTags())
+
+	rnd := rand.New(rand.NewSource(1))
+	for _, size := range []int{
+		100,
+		chunk.DefaultSize,
+		128 * chunk.DefaultSize,
+		128*chunk.DefaultSize + 1,
+		rnd.Intn(1000000),
+	} {
+		t.Run(fmt.Sprintf("size %v", size), func(t *testing.T) {
+			data := testutil.RandomBytes(size, size)
+			ctx := context.Background()
+
+			want, wait, err := fileStore.Store(ctx, bytes.NewReader(data), int64(size), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := wait(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			// the data is written to the pipe in parts of random sizes
+			pr, pw := io.Pipe()
+			go func() {
+				d := data
+				for len(d) > 0 {
+					n := rnd.Intn(10000) + 1
+					if n > len(d) {
+						n = len(d)
+					}
+					if _, err := pw.Write(d[:n]); err != nil {
+						return
+					}
+					d = d[n:]
+				}
+				pw.Close()
+			}()
+
+			addr, wait, err := fileStore.StoreStream(ctx, pr, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := wait(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(addr, want) {
+				t.Fatalf("got address %s, want %s", addr, want)
+			}
+
+			reader, _ := fileStore.Retrieve(ctx, addr)
+			got, err := ioutil.ReadAll(reader)
+			if err != nil && err != io.EOF {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatal("retrieved data is not the same as stored")
+			}
+		})
+	}
+}
+
+// TestFileStoreStoreWithStats stores data that consists of a repeated
+// block of chunk size and validates that repeated data chunks are
+// reported as duplicates, and that all chunks are duplicates when
+// the same data is stored again.
+func TestFileStoreStoreWithStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-storage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localStore, err := localstore.New(dir, make([]byte, 32), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localStore.Close()
+
+	fileStore := NewFileStore(localStore, NewFileStoreParams(), chunk.NewTags())
+
+	blockCount := 64
+	block := testutil.RandomBytes(1, chunk.DefaultSize)
+	data := bytes.Repeat(block, blockCount)
+	ctx := context.Background()
+
+	addr, stats, err := fileStore.StoreWithStats(ctx, bytes.NewReader(data), int64(len(data)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// all data chunks are the same and are referenced by the root chunk
+	want := StoreStats{
+		Total:     int64(blockCount) + 1,
+		New:       2,
+		Duplicate: int64(blockCount) - 1,
+	}
+	if stats != want {
+		t.Errorf("got stats %+v, want %+v", stats, want)
+	}
+
+	reader, _ := fileStore.Retrieve(ctx, addr)
+	got, err := ioutil.ReadAll(reader)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("retrieved data is not the same as stored")
+	}
+
+	_, stats, err = fileStore.StoreWithStats(ctx, bytes.NewReader(data), int64(len(data)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = StoreStats{
+		Total:     int64(blockCount) + 1,
+		New:       0,
+		Duplicate: int64(blockCount) + 1,
+	}
+	if stats != want {
+		t.Errorf("got stats %+v, want %+v", stats, want)
+	}
+}
+
+// flakyChunkStore fails the first Put of every chunk with the
+// provided error and counts Put calls for every chunk.
+type flakyChunkStore struct {
+	ChunkStore
+	err   error
+	puts  map[string]int
+	putMu sync.Mutex
+}
+
+func (s *flakyChunkStore) Put(ctx context.Context, mode chunk.ModePut, ch Chunk) (exists bool, err error) {
+	s.putMu.Lock()
+	s.puts[string(ch.Address())]++
+	first := s.puts[string(ch.Address())] == 1
+	s.putMu.Unlock()
+
+	if first || s.err == ErrChunkInvalid {
+		return false, s.err
+	}
+	return s.ChunkStore.Put(ctx, mode, ch)
+}
+
+// TestFileStoreStoreWriteRetries validates that Store succeeds with
+// MaxWriteRetries if the first write of every chunk fails with
+// a transient error, and that invalid chunks are not retried.
+func TestFileStoreStoreWriteRetries(t *testing.T) {
+	defer func(b time.Duration) { writeRetryBackoff = b }(writeRetryBackoff)
+	writeRetryBackoff = time.Millisecond
+
+	for _, tc := range []struct {
+		name     string
+		err      error
+		wantErr  error
+		wantPuts int
+	}{
+		{name: "transient", err: errors.New("transient"), wantErr: nil, wantPuts: 2},
+		{name: "invalid", err: ErrChunkInvalid, wantErr: ErrChunkInvalid, wantPuts: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "swarm-storage-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			localStore, err := localstore.New(dir, make([]byte, 32), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer localStore.Close()
+
+			store := &flakyChunkStore{
+				ChunkStore: localStore,
+				err:        tc.err,
+				puts:       make(map[string]int),
+			}
+			params := NewFileStoreParams()
+			params.MaxWriteRetries = 3
+			fileStore := NewFileStore(store, params, chunk.NewTags())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			data := testutil.RandomBytes(1, 10*chunk.DefaultSize)
+			addr, wait, err := fileStore.Store(ctx, bytes.NewReader(data), int64(len(data)), false)
+			if err == nil {
+				err = wait(ctx)
+			}
+			if err != tc.wantErr {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+
+			store.putMu.Lock()
+			for a, n := range store.puts {
+				if n != tc.wantPuts {
+					t.Errorf("chunk %x: got %v puts, want %v", a, n, tc.wantPuts)
+				}
+			}
+			store.putMu.Unlock()
+
+			if tc.wantErr != nil {
+				return
+			}
+			reader, _ := fileStore.Retrieve(ctx, addr)
+			got, err := ioutil.ReadAll(reader)
+			if err != nil && err != io.EOF {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatal("retrieved data is not the same as stored")
+			}
+		})
+	}
+}
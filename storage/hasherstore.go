@@ -19,13 +19,22 @@ package storage
 import (
 	"context"
 	"fmt"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/log"
 	"github.com/ethersphere/swarm/storage/encryption"
 	"golang.org/x/crypto/sha3"
 )
 
+// encryptionKey returns a key to encrypt the chunk data with. Keys are
+// random, but tests can replace this function to get deterministic references.
+var encryptionKey = func(ChunkData) encryption.Key {
+	return encryption.GenerateRandomKey(encryption.KeyLength)
+}
+
 type hasherStore struct {
 	store     ChunkStore
 	tag       *chunk.Tag
@@ -33,9 +42,16 @@ type hasherStore struct {
 	hashFunc  SwarmHasher
 	hashSize  int           // content hash size
 	refSize   int64         // reference size (content hash + possibly encryption key)
+	chunkSize int64         // maximal size of chunk data without the span
+	retries   int           // maximal number of retries of a failed chunk write
 	errC      chan error    // global error channel
 	doneC     chan struct{} // closed by Close() call to indicate that count is the final number of chunks
 	quitC     chan struct{} // closed to quit unterminated routines
+
+	storedMu sync.Mutex      // protects storedWG and storedC
+	storedWG *sync.WaitGroup // chunks put since the last afterStored call
+	storedC  chan struct{}   // closed when the function from the last afterStored call returns
+	failed   int32           // set to 1 when a chunk is not stored
 	// nrChunks is used with atomic functions
 	// it is required to be at the end of the struct to ensure 64bit alignment for arm architecture
 	// see: https://golang.org/pkg/sync/atomic/#pkg-note-BUG
@@ -59,9 +75,11 @@ func NewHasherStore(store ChunkStore, hashFunc SwarmHasher, toEncrypt bool, tag
 		hashFunc:  hashFunc,
 		hashSize:  hashSize,
 		refSize:   refSize,
+		chunkSize: chunk.DefaultSize,
 		errC:      make(chan error),
 		doneC:     make(chan struct{}),
 		quitC:     make(chan struct{}),
+		storedWG:  new(sync.WaitGroup),
 	}
 
 	return h
@@ -191,9 +209,10 @@ func (h *hasherStore) decryptChunkData(chunkData ChunkData, encryptionKey encryp
 
 	// removing extra bytes which were just added for padding
 	length := ChunkData(decryptedSpan).Size()
-	for length > chunk.DefaultSize {
-		length = length + (chunk.DefaultSize - 1)
-		length = length / chunk.DefaultSize
+	chunkSize := uint64(h.chunkSize)
+	for length > chunkSize {
+		length = length + (chunkSize - 1)
+		length = length / chunkSize
 		length *= uint64(h.refSize)
 	}
 
@@ -209,7 +228,7 @@ func (h *hasherStore) RefSize() int64 {
 }
 
 func (h *hasherStore) encrypt(chunkData ChunkData) (encryption.Key, []byte, []byte, error) {
-	key := encryption.GenerateRandomKey(encryption.KeyLength)
+	key := encryptionKey(chunkData)
 	encryptedSpan, err := h.newSpanEncryption(key).Encrypt(chunkData[:8])
 	if err != nil {
 		return nil, nil, nil, err
@@ -234,20 +253,53 @@ func (h *hasherStore) decrypt(chunkData ChunkData, key encryption.Key) ([]byte,
 }
 
 func (h *hasherStore) newSpanEncryption(key encryption.Key) encryption.Encryption {
-	return encryption.New(key, 0, uint32(chunk.DefaultSize/h.refSize), sha3.NewLegacyKeccak256)
+	return encryption.New(key, 0, uint32(h.chunkSize/h.refSize), sha3.NewLegacyKeccak256)
 }
 
 func (h *hasherStore) newDataEncryption(key encryption.Key) encryption.Encryption {
-	return encryption.New(key, int(chunk.DefaultSize), 0, sha3.NewLegacyKeccak256)
+	return encryption.New(key, int(h.chunkSize), 0, sha3.NewLegacyKeccak256)
+}
+
+// afterStored calls the function when all chunks that are put before
+// the call are stored. The function is not called if any chunk fails
+// to be stored. Functions are called in the order of afterStored calls.
+// The returned channel is closed when the function returns or when it
+// is known that it will not be called.
+func (h *hasherStore) afterStored(f func()) <-chan struct{} {
+	h.storedMu.Lock()
+	wg, prev := h.storedWG, h.storedC
+	done := make(chan struct{})
+	h.storedWG, h.storedC = new(sync.WaitGroup), done
+	h.storedMu.Unlock()
+
+	go func() {
+		defer close(done)
+		wg.Wait()
+		if prev != nil {
+			<-prev
+		}
+		if atomic.LoadInt32(&h.failed) == 0 {
+			f()
+		}
+	}()
+	return done
 }
 
 func (h *hasherStore) storeChunk(ctx context.Context, ch Chunk) {
 	atomic.AddUint64(&h.nrChunks, 1)
+	h.storedMu.Lock()
+	wg := h.storedWG
+	wg.Add(1)
+	h.storedMu.Unlock()
 	go func() {
-		seen, err := h.store.Put(ctx, chunk.ModePutUpload, ch)
-		h.tag.Inc(chunk.StateStored)
+		seen, err := h.putChunk(ctx, ch.WithTagID(h.tag.Uid))
+		if err != nil {
+			atomic.StoreInt32(&h.failed, 1)
+		}
+		wg.Done()
+		h.tag.IncChunk(chunk.StateStored, ch.Address())
 		if seen {
-			h.tag.Inc(chunk.StateSeen)
+			h.tag.IncChunk(chunk.StateSeen, ch.Address())
 		}
 		select {
 		case h.errC <- err:
@@ -256,6 +308,42 @@ func (h *hasherStore) storeChunk(ctx context.Context, ch Chunk) {
 	}()
 }
 
+// writeRetryBackoff is the delay before the first retry of a failed
+// chunk write. It is doubled for every next retry.
+var writeRetryBackoff = 100 * time.Millisecond
+
+// putChunk puts the chunk to the store. Failed writes are retried up to
+// the configured number of times with increasing delays, unless the error
+// is permanent, for example if the chunk is invalid.
+func (h *hasherStore) putChunk(ctx context.Context, ch Chunk) (seen bool, err error) {
+	backoff := writeRetryBackoff
+	for retry := 1; ; retry++ {
+		seen, err = h.store.Put(ctx, chunk.ModePutUpload, ch)
+		if err == nil || retry > h.retries || permanentWriteError(err) {
+			return seen, err
+		}
+		log.Debug("hasherstore: retrying chunk write", "ref", ch.Address(), "retry", retry, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return seen, err
+		case <-h.quitC:
+			return seen, err
+		}
+		backoff *= 2
+	}
+}
+
+// permanentWriteError returns true if the chunk
+// write is not expected to succeed when retried.
+func permanentWriteError(err error) bool {
+	switch err {
+	case ErrChunkInvalid, ErrFetchTimeout, context.Canceled, context.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
 func parseReference(ref Reference, hashSize int) (Address, encryption.Key, error) {
 	encryptedRefLength := hashSize + encryption.KeyLength
 	switch len(ref) {
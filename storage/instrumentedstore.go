@@ -0,0 +1,134 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ethersphere/swarm/chunk"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// InstrumentedStore implements ChunkStore.
+var _ ChunkStore = &InstrumentedStore{}
+
+// InstrumentedStore records access statistics for chunks retrieved
+// from and stored in the wrapped chunk store, for workload analysis.
+// Statistics are kept for a bounded number of most recently accessed
+// chunks. All methods are forwarded to the wrapped store and its
+// results are returned unchanged.
+type InstrumentedStore struct {
+	ChunkStore
+	stats *lru.Cache // *ChunkStats by chunk address
+	mu    sync.Mutex // serializes updates of stats
+}
+
+// ChunkStats holds access statistics for a single chunk.
+type ChunkStats struct {
+	Address    Address
+	Gets       uint64    // number of get calls
+	Hits       uint64    // number of get calls that returned the chunk
+	Puts       uint64    // number of put calls
+	LastAccess time.Time // time of the last get or put call
+}
+
+// NewInstrumentedStore creates a new InstrumentedStore that keeps
+// statistics for at most size chunks in front of the provided store.
+func NewInstrumentedStore(store ChunkStore, size int) (*InstrumentedStore, error) {
+	stats, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &InstrumentedStore{
+		ChunkStore: store,
+		stats:      stats,
+	}, nil
+}
+
+// Get retrieves the chunk from the wrapped store and records the access.
+func (s *InstrumentedStore) Get(ctx context.Context, mode chunk.ModeGet, addr Address) (Chunk, error) {
+	ch, err := s.ChunkStore.Get(ctx, mode, addr)
+	s.record(addr, func(cs *ChunkStats) {
+		cs.Gets++
+		if err == nil {
+			cs.Hits++
+		}
+	})
+	return ch, err
+}
+
+// GetMulti retrieves chunks from the wrapped store and records
+// the access of every requested chunk.
+func (s *InstrumentedStore) GetMulti(ctx context.Context, mode chunk.ModeGet, addrs ...Address) ([]Chunk, error) {
+	chs, err := s.ChunkStore.GetMulti(ctx, mode, addrs...)
+	for _, addr := range addrs {
+		s.record(addr, func(cs *ChunkStats) {
+			cs.Gets++
+			if err == nil {
+				cs.Hits++
+			}
+		})
+	}
+	return chs, err
+}
+
+// Put stores the chunk in the wrapped store and records the access.
+func (s *InstrumentedStore) Put(ctx context.Context, mode chunk.ModePut, ch Chunk) (bool, error) {
+	exists, err := s.ChunkStore.Put(ctx, mode, ch)
+	s.record(ch.Address(), func(cs *ChunkStats) {
+		cs.Puts++
+	})
+	return exists, err
+}
+
+// Stats returns access statistics of recorded chunks,
+// from the most to the least recently accessed one.
+func (s *InstrumentedStore) Stats() []ChunkStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := s.stats.Keys()
+	stats := make([]ChunkStats, 0, len(keys))
+	for i := len(keys) - 1; i >= 0; i-- {
+		v, ok := s.stats.Peek(keys[i])
+		if !ok {
+			continue
+		}
+		stats = append(stats, *v.(*ChunkStats))
+	}
+	return stats
+}
+
+// record updates the statistics of the chunk with the
+// provided address and marks it as the most recently accessed.
+func (s *InstrumentedStore) record(addr Address, update func(cs *ChunkStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := string(addr)
+	var cs *ChunkStats
+	if v, ok := s.stats.Get(key); ok {
+		cs = v.(*ChunkStats)
+	} else {
+		cs = &ChunkStats{Address: append(Address(nil), addr...)}
+		s.stats.Add(key, cs)
+	}
+	update(cs)
+	cs.LastAccess = time.Now()
+}
@@ -0,0 +1,176 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/storage/localstore"
+)
+
+// newTestInstrumentedStore creates an InstrumentedStore over
+// a local store that keeps statistics for at most size chunks.
+func newTestInstrumentedStore(t *testing.T, size int) (*InstrumentedStore, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "swarm-instrumented-store-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	localStore, err := localstore.New(dir, make([]byte, 32), nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	store, err := NewInstrumentedStore(localStore, size)
+	if err != nil {
+		localStore.Close()
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return store, func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// TestInstrumentedStoreStats validates that gets, hits and puts
+// are counted per chunk and that statistics are bounded by size.
+func TestInstrumentedStoreStats(t *testing.T) {
+	store, cleanup := newTestInstrumentedStore(t, 2)
+	defer cleanup()
+
+	ctx := context.Background()
+	chunks := GenerateRandomChunks(chunk.DefaultSize, 3)
+
+	if _, err := store.Put(ctx, chunk.ModePutUpload, chunks[0]); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		got, err := store.Get(ctx, chunk.ModeGetRequest, chunks[0].Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Data(), chunks[0].Data()) {
+			t.Fatalf("got chunk data %x, want %x", got.Data(), chunks[0].Data())
+		}
+	}
+	// chunk is not in the store
+	if _, err := store.Get(ctx, chunk.ModeGetRequest, chunks[1].Address()); err != chunk.ErrChunkNotFound {
+		t.Fatalf("got error %v, want %v", err, chunk.ErrChunkNotFound)
+	}
+
+	stats := store.Stats()
+	if len(stats) != 2 {
+		t.Fatalf("got stats for %v chunks, want 2", len(stats))
+	}
+	// the most recently accessed chunk is the first one
+	checkChunkStats(t, stats[0], chunks[1].Address(), 1, 0, 0)
+	checkChunkStats(t, stats[1], chunks[0].Address(), 2, 2, 1)
+
+	// the least recently accessed chunk is evicted
+	if _, err := store.Put(ctx, chunk.ModePutUpload, chunks[2]); err != nil {
+		t.Fatal(err)
+	}
+	stats = store.Stats()
+	if len(stats) != 2 {
+		t.Fatalf("got stats for %v chunks, want 2", len(stats))
+	}
+	checkChunkStats(t, stats[0], chunks[2].Address(), 0, 0, 1)
+	checkChunkStats(t, stats[1], chunks[1].Address(), 1, 0, 0)
+}
+
+func checkChunkStats(t *testing.T, cs ChunkStats, addr Address, gets, hits, puts uint64) {
+	t.Helper()
+
+	if !bytes.Equal(cs.Address, addr) {
+		t.Errorf("got address %s, want %s", cs.Address, addr)
+	}
+	if cs.Gets != gets {
+		t.Errorf("chunk %s: got %v gets, want %v", addr, cs.Gets, gets)
+	}
+	if cs.Hits != hits {
+		t.Errorf("chunk %s: got %v hits, want %v", addr, cs.Hits, hits)
+	}
+	if cs.Puts != puts {
+		t.Errorf("chunk %s: got %v puts, want %v", addr, cs.Puts, puts)
+	}
+	if cs.LastAccess.IsZero() {
+		t.Errorf("chunk %s: last access time is not set", addr)
+	}
+}
+
+// errorStore is a chunk store that returns
+// the same error from all its methods.
+type errorStore struct {
+	ChunkStore
+	err error
+}
+
+func (s *errorStore) Get(_ context.Context, _ chunk.ModeGet, _ Address) (Chunk, error) {
+	return nil, s.err
+}
+
+func (s *errorStore) GetMulti(_ context.Context, _ chunk.ModeGet, _ ...Address) ([]Chunk, error) {
+	return nil, s.err
+}
+
+func (s *errorStore) Put(_ context.Context, _ chunk.ModePut, _ Chunk) (bool, error) {
+	return false, s.err
+}
+
+func (s *errorStore) Has(_ context.Context, _ Address) (bool, error) {
+	return false, s.err
+}
+
+// TestInstrumentedStoreErrors validates that errors
+// from the wrapped store are returned unchanged.
+func TestInstrumentedStoreErrors(t *testing.T) {
+	errTest := errors.New("test error")
+	store, err := NewInstrumentedStore(&errorStore{err: errTest}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	ch := GenerateRandomChunk(chunk.DefaultSize)
+
+	if _, err := store.Get(ctx, chunk.ModeGetRequest, ch.Address()); err != errTest {
+		t.Errorf("got get error %v, want %v", err, errTest)
+	}
+	if _, err := store.GetMulti(ctx, chunk.ModeGetRequest, ch.Address()); err != errTest {
+		t.Errorf("got get multi error %v, want %v", err, errTest)
+	}
+	if _, err := store.Put(ctx, chunk.ModePutUpload, ch); err != errTest {
+		t.Errorf("got put error %v, want %v", err, errTest)
+	}
+	if _, err := store.Has(ctx, ch.Address()); err != errTest {
+		t.Errorf("got has error %v, want %v", err, errTest)
+	}
+
+	stats := store.Stats()
+	if len(stats) != 1 {
+		t.Fatalf("got stats for %v chunks, want 1", len(stats))
+	}
+	checkChunkStats(t, stats[0], ch.Address(), 2, 0, 1)
+}
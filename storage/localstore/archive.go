@@ -0,0 +1,166 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package localstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/ethersphere/swarm/bmt"
+	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/log"
+	"github.com/ethersphere/swarm/shed"
+)
+
+// archive format version written at the start of
+// the archive, before chunk records
+const archiveVersion uint32 = 1
+
+// importArchiveBatchSize limits the number of chunks
+// stored in a single batch by ImportArchive.
+var importArchiveBatchSize = 100
+
+// ExportArchive writes all chunks in the retrieval data index to
+// the writer as a stream of records, each prefixed with its length
+// and holding the chunk address followed by the chunk data. Chunks
+// are written while the index is iterated, so the whole store is
+// never held in memory. It returns the number of chunks exported.
+func (db *DB) ExportArchive(w io.Writer) (count int64, err error) {
+	bw := bufio.NewWriter(w)
+
+	prefix := make([]byte, 4)
+	binary.BigEndian.PutUint32(prefix, archiveVersion)
+	if _, err := bw.Write(prefix); err != nil {
+		return 0, err
+	}
+
+	err = db.retrievalDataIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+		binary.BigEndian.PutUint32(prefix, uint32(len(item.Address)+len(item.Data)))
+		if _, err := bw.Write(prefix); err != nil {
+			return true, err
+		}
+		if _, err := bw.Write(item.Address); err != nil {
+			return true, err
+		}
+		if _, err := bw.Write(item.Data); err != nil {
+			return true, err
+		}
+		count++
+		return false, nil
+	}, nil)
+	if err != nil {
+		return count, err
+	}
+	return count, bw.Flush()
+}
+
+// ImportArchive reads chunk records written by ExportArchive from
+// the reader and stores them in the database in batches. Chunks are
+// verified with the Validators option, or against their content
+// address if no validators are set, and chunks that are not valid are
+// skipped, unless the TrustLocalPuts option is set. Validators that
+// accept feed chunks are required to import them. It returns the
+// number of chunks imported.
+func (db *DB) ImportArchive(r io.Reader) (count int64, err error) {
+	br := bufio.NewReader(r)
+
+	prefix := make([]byte, 4)
+	if _, err := io.ReadFull(br, prefix); err != nil {
+		return 0, err
+	}
+	if version := binary.BigEndian.Uint32(prefix); version != archiveVersion {
+		return 0, fmt.Errorf("unsupported archive version %d", version)
+	}
+
+	var pool *bmt.TreePool
+	if !db.trustLocalPuts && len(db.validators) == 0 {
+		pool = newVerifyHashPool(db.chunkSize)
+	}
+	// maximal size of a single archive record that
+	// holds a chunk address and chunk data with its span
+	maxRecordSize := uint32(chunk.AddressLength + db.chunkSize + 8)
+
+	chunks := make([]chunk.Chunk, 0, importArchiveBatchSize)
+	putChunks := func() (err error) {
+		if len(chunks) == 0 {
+			return nil
+		}
+		if _, err := db.PutBatch(context.Background(), chunk.ModePutUpload, chunks); err != nil {
+			return err
+		}
+		count += int64(len(chunks))
+		chunks = chunks[:0]
+		return nil
+	}
+
+	for {
+		_, err := io.ReadFull(br, prefix)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+		size := binary.BigEndian.Uint32(prefix)
+		if size <= chunk.AddressLength || size > maxRecordSize {
+			return count, fmt.Errorf("invalid archive record size %d", size)
+		}
+		record := make([]byte, size)
+		if _, err := io.ReadFull(br, record); err != nil {
+			return count, err
+		}
+		ch := chunk.NewChunk(record[:chunk.AddressLength], record[chunk.AddressLength:])
+		if !db.trustLocalPuts && !db.validImport(pool, ch) {
+			log.Warn("ignoring invalid archive chunk", "addr", ch.Address())
+			continue
+		}
+		chunks = append(chunks, ch)
+		if len(chunks) >= importArchiveBatchSize {
+			if err := putChunks(); err != nil {
+				return count, err
+			}
+		}
+	}
+	return count, putChunks()
+}
+
+// validImport returns true if the imported chunk is accepted by any
+// of the validators or, if there are no validators, if its data
+// hashes to its address with hashers from the pool.
+func (db *DB) validImport(pool *bmt.TreePool, ch chunk.Chunk) bool {
+	if len(db.validators) > 0 {
+		return db.valid(ch)
+	}
+	return verifyChunk(pool, ch.Address(), ch.Data())
+}
+
+// verifyChunk returns true if the chunk data, prefixed with its
+// span, hashes to the chunk address. Data larger than the chunk
+// size of hashers in the pool is not valid.
+func verifyChunk(pool *bmt.TreePool, addr chunk.Address, data []byte) bool {
+	if l := len(data); l < 9 || l > pool.SegmentCount*pool.SegmentSize+8 {
+		return false
+	}
+	hasher := bmt.New(pool)
+	hasher.ResetWithLength(data[:8])
+	hasher.Write(data[8:])
+	return bytes.Equal(hasher.Sum(nil), addr)
+}
@@ -0,0 +1,186 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package localstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/testutil"
+)
+
+// TestExportImportArchive constructs two databases, one to put and
+// export chunks as an archive and another one to import the archive
+// and validate that all chunks are imported.
+func TestExportImportArchive(t *testing.T) {
+	db1, cleanup1 := newTestDB(t, nil)
+	defer cleanup1()
+
+	var chunkCount = 1000
+
+	// chunks are content addressed to pass validation on import
+	chunks := make(map[string][]byte, chunkCount)
+	for _, ch := range testutil.DeterministicChunks(1, chunkCount) {
+		_, err := db1.Put(context.Background(), chunk.ModePutUpload, ch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks[string(ch.Address())] = ch.Data()
+	}
+
+	var buf bytes.Buffer
+
+	c, err := db1.ExportArchive(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantChunksCount := int64(len(chunks))
+	if c != wantChunksCount {
+		t.Errorf("got export count %v, want %v", c, wantChunksCount)
+	}
+
+	db2, cleanup2 := newTestDB(t, nil)
+	defer cleanup2()
+
+	c, err = db2.ImportArchive(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != wantChunksCount {
+		t.Errorf("got import count %v, want %v", c, wantChunksCount)
+	}
+
+	for a, want := range chunks {
+		addr := chunk.Address([]byte(a))
+		ch, err := db2.Get(context.Background(), chunk.ModeGetLookup, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ch.Data()
+		if !bytes.Equal(got, want) {
+			t.Fatalf("chunk %s: got data %x, want %x", addr.Hex(), got, want)
+		}
+	}
+}
+
+// TestImportArchive_verify validates that chunks with data that does
+// not match their addresses are skipped by ImportArchive by default,
+// that they are imported with the TrustLocalPuts option and that
+// chunks are verified with the Validators option if it is set.
+func TestImportArchive_verify(t *testing.T) {
+	db, cleanup := newTestDB(t, nil)
+	defer cleanup()
+
+	valid := testutil.DeterministicChunks(1, 10)
+	for _, ch := range valid {
+		if _, err := db.Put(context.Background(), chunk.ModePutUpload, ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	invalid := generateTestRandomChunk()
+	if _, err := db.Put(context.Background(), chunk.ModePutUpload, invalid); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := db.ExportArchive(&buf); err != nil {
+		t.Fatal(err)
+	}
+	archive := buf.Bytes()
+
+	// accepts only the invalid chunk, as a feed validator would
+	// accept a chunk that is not content addressed
+	invalidValidator := testValidatorFunc(func(ch chunk.Chunk) bool {
+		return bytes.Equal(ch.Address(), invalid.Address())
+	})
+
+	for _, tc := range []struct {
+		name       string
+		trust      bool
+		validators []chunk.Validator
+		wantCount  int64
+		wantStored bool
+	}{
+		{name: "default", trust: false, wantCount: int64(len(valid)), wantStored: false},
+		{name: "trusted", trust: true, wantCount: int64(len(valid)) + 1, wantStored: true},
+		{name: "validators", validators: []chunk.Validator{invalidValidator}, wantCount: 1, wantStored: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, cleanup := newTestDB(t, &Options{
+				TrustLocalPuts: tc.trust,
+				Validators:     tc.validators,
+			})
+			defer cleanup()
+
+			c, err := db.ImportArchive(bytes.NewReader(archive))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c != tc.wantCount {
+				t.Errorf("got import count %v, want %v", c, tc.wantCount)
+			}
+			has, err := db.Has(context.Background(), invalid.Address())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if has != tc.wantStored {
+				t.Errorf("got invalid chunk stored %v, want %v", has, tc.wantStored)
+			}
+		})
+	}
+}
+
+// BenchmarkImportArchive measures ImportArchive with and without
+// verification of chunk content addresses.
+func BenchmarkImportArchive(b *testing.B) {
+	db, cleanup := newTestDB(b, nil)
+	defer cleanup()
+
+	for _, ch := range testutil.DeterministicChunks(1, 1000) {
+		if _, err := db.Put(context.Background(), chunk.ModePutUpload, ch); err != nil {
+			b.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := db.ExportArchive(&buf); err != nil {
+		b.Fatal(err)
+	}
+	archive := buf.Bytes()
+
+	for _, trust := range []bool{false, true} {
+		b.Run(fmt.Sprintf("trust %v", trust), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				b.StopTimer()
+				db, cleanup := newTestDB(b, &Options{
+					TrustLocalPuts: trust,
+				})
+				b.StartTimer()
+
+				if _, err := db.ImportArchive(bytes.NewReader(archive)); err != nil {
+					b.Fatal(err)
+				}
+
+				b.StopTimer()
+				cleanup()
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package localstore
+
+import (
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/shed"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Cancelled returns true if the chunk with the provided address is not
+// yet synced and it was uploaded with a tag that is cancelled. Such
+// chunks are kept in the database, but they should not be sent to
+// other nodes. Once a push subscription skips such chunk, it is removed
+// from the push and pull indexes and false is returned for it.
+func (db *DB) Cancelled(addr chunk.Address) (cancelled bool, err error) {
+	metrics.GetOrRegisterCounter("localstore.Cancelled", nil).Inc(1)
+
+	if db.tags == nil {
+		return false, nil
+	}
+	item, err := db.retrievalDataIndex.Get(addressToItem(addr))
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	item, err = db.pushIndex.Get(item)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return db.tagCancelled(item.Tag), nil
+}
+
+// tagCancelled returns true if the tag with the provided uid exists
+// and it is cancelled.
+func (db *DB) tagCancelled(uid uint32) bool {
+	if uid == 0 || db.tags == nil {
+		return false
+	}
+	t, err := db.tags.Get(uid)
+	if err != nil {
+		return false
+	}
+	return t.Cancelled()
+}
+
+// dropCancelled removes the chunk of a cancelled upload from the push
+// index, so that it is not kept there forever, and from the pull index,
+// so that it is not offered to other nodes by pull syncing. The chunk
+// is added to the garbage collection index as if it is synced, but
+// it is not counted as synced by its tag.
+func (db *DB) dropCancelled(item shed.Item) (err error) {
+	db.batchMu.Lock()
+	defer db.batchMu.Unlock()
+
+	batch := new(leveldb.Batch)
+
+	i, err := db.retrievalDataIndex.Get(item)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			// chunk is not found,
+			// just delete it from the push index
+			db.pushIndex.DeleteInBatch(batch, item)
+			return db.shed.WriteBatch(batch)
+		}
+		return err
+	}
+	item.StoreTimestamp = i.StoreTimestamp
+	item.BinID = i.BinID
+
+	var gcSizeChange int64
+	i, err = db.retrievalAccessIndex.Get(item)
+	switch err {
+	case nil:
+		item.AccessTimestamp = i.AccessTimestamp
+		change, err := db.deleteGCInBatch(batch, item)
+		if err != nil {
+			return err
+		}
+		gcSizeChange += change
+	case leveldb.ErrNotFound:
+		// the chunk is not accessed before
+	default:
+		return err
+	}
+	item.AccessTimestamp = now()
+	db.retrievalAccessIndex.PutInBatch(batch, item)
+	db.pushIndex.DeleteInBatch(batch, item)
+	db.pullIndex.DeleteInBatch(batch, item)
+	change, err := db.putGCInBatch(batch, item)
+	if err != nil {
+		return err
+	}
+	gcSizeChange += change
+
+	if err := db.incGCSizeInBatch(batch, gcSizeChange); err != nil {
+		return err
+	}
+	return db.shed.WriteBatch(batch)
+}
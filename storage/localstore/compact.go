@@ -0,0 +1,53 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package localstore
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// Compact runs a compaction of the whole database key range and returns
+// when it is complete. It reclaims disk space after a large number of
+// chunks is removed, for example by garbage collection. Only one
+// compaction can run at a time, ErrCompactionInProgress is returned
+// if Compact is called while another compaction is running.
+func (db *DB) Compact() (err error) {
+	metricName := "localstore.compact"
+	metrics.GetOrRegisterCounter(metricName, nil).Inc(1)
+	defer func() {
+		if err != nil {
+			metrics.GetOrRegisterCounter(metricName+".error", nil).Inc(1)
+		}
+	}()
+
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	if !atomic.CompareAndSwapUint32(&db.compacting, 0, 1) {
+		return ErrCompactionInProgress
+	}
+	defer atomic.StoreUint32(&db.compacting, 0)
+
+	start := time.Now()
+	err = db.shed.Compact()
+	// duration of the last compaction in milliseconds
+	metrics.GetOrRegisterGauge(metricName+".duration", nil).Update(int64(time.Since(start) / time.Millisecond))
+	return err
+}
@@ -0,0 +1,77 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package localstore
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethersphere/swarm/chunk"
+)
+
+// TestDB_Compact validates that Compact completes after many chunks are
+// removed and that the remaining chunks are still served.
+func TestDB_Compact(t *testing.T) {
+	db, cleanupFunc := newTestDB(t, nil)
+	defer cleanupFunc()
+
+	chunkCount := 1000
+	removeCount := 900
+
+	chunks := make([]chunk.Chunk, chunkCount)
+	for i := range chunks {
+		ch := generateTestRandomChunk()
+		if _, err := db.Put(context.Background(), chunk.ModePutUpload, ch); err != nil {
+			t.Fatal(err)
+		}
+		chunks[i] = ch
+	}
+	for _, ch := range chunks[:removeCount] {
+		if err := db.Set(context.Background(), chunk.ModeSetRemove, ch.Address()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ch := range chunks[:removeCount] {
+		_, err := db.Get(context.Background(), chunk.ModeGetRequest, ch.Address())
+		if err != chunk.ErrChunkNotFound {
+			t.Fatalf("got error %v, want %v", err, chunk.ErrChunkNotFound)
+		}
+	}
+	for _, ch := range chunks[removeCount:] {
+		got, err := db.Get(context.Background(), chunk.ModeGetRequest, ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Data(), ch.Data()) {
+			t.Fatalf("got chunk %s data not equal to the stored data", ch.Address())
+		}
+	}
+
+	// only one compaction runs at a time
+	atomic.StoreUint32(&db.compacting, 1)
+	if err := db.Compact(); err != ErrCompactionInProgress {
+		t.Fatalf("got error %v, want %v", err, ErrCompactionInProgress)
+	}
+	atomic.StoreUint32(&db.compacting, 0)
+}
@@ -0,0 +1,179 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package localstore
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethersphere/swarm/shed"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// collectExpiredInterval is the period in which
+// expired chunks are removed from the database.
+var collectExpiredInterval = time.Minute
+
+// putExpiryInBatch adds the item to expiry indexes if it is uploaded
+// with a tag that has a TTL. The item must have StoreTimestamp set.
+func (db *DB) putExpiryInBatch(batch *leveldb.Batch, item shed.Item) {
+	if db.tags == nil || item.Tag == 0 {
+		return
+	}
+	tag, err := db.tags.Get(item.Tag)
+	if err != nil {
+		return
+	}
+	ttl := tag.TTL()
+	if ttl <= 0 {
+		return
+	}
+	item.Expiry = now() + int64(ttl)
+	db.expiryIndex.PutInBatch(batch, item)
+	db.retrievalExpiryIndex.PutInBatch(batch, item)
+}
+
+// expired returns true if the chunk with the item address has
+// a TTL that is passed. Pinned chunks do not expire.
+func (db *DB) expired(item shed.Item) (bool, error) {
+	i, err := db.retrievalExpiryIndex.Get(item)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	if i.Expiry > now() {
+		return false, nil
+	}
+	pinned, err := db.pinIndex.Has(item)
+	if err != nil {
+		return false, err
+	}
+	return !pinned, nil
+}
+
+// collectExpired removes chunks with passed TTL from retrieval and
+// other indexes. Pinned chunks are not removed, but they are removed
+// from expiry indexes. This function returns the number of removed
+// chunks. If done is false, another call to this function is needed
+// to remove the rest of expired chunks as the batch size limit is
+// reached. This function is called in collectGarbageWorker.
+func (db *DB) collectExpired() (collectedCount uint64, done bool, err error) {
+	metricName := "localstore.expiry"
+	metrics.GetOrRegisterCounter(metricName, nil).Inc(1)
+	defer totalTimeMetric(metricName, time.Now())
+	defer func() {
+		if err != nil {
+			metrics.GetOrRegisterCounter(metricName+".error", nil).Inc(1)
+		}
+	}()
+
+	if db.readOnly {
+		return 0, true, ErrReadOnly
+	}
+
+	batch := new(leveldb.Batch)
+
+	// protect database from changing idexes and gcSize
+	db.batchMu.Lock()
+	defer db.batchMu.Unlock()
+
+	var gcSizeChange int64
+	var count uint64
+	expiry := now()
+	done = true
+	err = db.expiryIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+		if item.Expiry > expiry {
+			return true, nil
+		}
+		count++
+		if count > gcBatchSize {
+			// bach size limit reached,
+			// another run is needed
+			done = false
+			return true, nil
+		}
+		db.expiryIndex.DeleteInBatch(batch, item)
+
+		i, err := db.retrievalDataIndex.Get(item)
+		if err != nil {
+			if err == leveldb.ErrNotFound {
+				// chunk is already garbage collected
+				return false, nil
+			}
+			return true, err
+		}
+		if i.StoreTimestamp != item.StoreTimestamp {
+			// chunk is garbage collected and stored again
+			return false, nil
+		}
+		item.BinID = i.BinID
+
+		db.retrievalExpiryIndex.DeleteInBatch(batch, item)
+
+		pinned, err := db.pinIndex.Has(item)
+		if err != nil {
+			return true, err
+		}
+		if pinned {
+			return false, nil
+		}
+
+		i, err = db.retrievalAccessIndex.Get(item)
+		switch err {
+		case nil:
+			item.AccessTimestamp = i.AccessTimestamp
+		case leveldb.ErrNotFound:
+		default:
+			return true, err
+		}
+		if item.AccessTimestamp != 0 {
+			// synced chunks are in gc index
+			change, err := db.deleteGCInBatch(batch, item)
+			if err != nil {
+				return true, err
+			}
+			gcSizeChange += change
+		}
+		db.gcAccessCountIndex.DeleteInBatch(batch, item)
+		db.retrievalSoftExpiryIndex.DeleteInBatch(batch, item)
+
+		// delete from retrieve, pull, push
+		db.retrievalDataIndex.DeleteInBatch(batch, item)
+		db.retrievalAccessIndex.DeleteInBatch(batch, item)
+		db.pullIndex.DeleteInBatch(batch, item)
+		db.pushIndex.DeleteInBatch(batch, item)
+		collectedCount++
+		return false, nil
+	}, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	metrics.GetOrRegisterCounter(metricName+".collected-count", nil).Inc(int64(collectedCount))
+
+	err = db.incGCSizeInBatch(batch, gcSizeChange)
+	if err != nil {
+		return 0, false, err
+	}
+	err = db.shed.WriteBatch(batch)
+	if err != nil {
+		metrics.GetOrRegisterCounter(metricName+".writebatch.err", nil).Inc(1)
+		return 0, false, err
+	}
+	return collectedCount, done, nil
+}
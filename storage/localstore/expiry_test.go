@@ -0,0 +1,128 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package localstore
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/shed"
+)
+
+// TestDB_collectExpired validates that chunks uploaded with a tag
+// that has a TTL are not found once the TTL passes and that they
+// are removed from all indexes, except when they are pinned.
+func TestDB_collectExpired(t *testing.T) {
+	defer func(i time.Duration) { collectExpiredInterval = i }(collectExpiredInterval)
+	collectExpiredInterval = 10 * time.Millisecond
+
+	var timestamp int64 = 1000
+	defer setNow(func() int64 {
+		return atomic.LoadInt64(&timestamp)
+	})()
+
+	tags := chunk.NewTags()
+	tag, err := tags.New("ttl", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag.SetTTL(time.Hour)
+
+	db, cleanupFunc := newTestDB(t, &Options{
+		Tags: tags,
+	})
+	defer cleanupFunc()
+
+	ctx := context.Background()
+	uploadSyncChunk := func(ch chunk.Chunk) chunk.Chunk {
+		t.Helper()
+
+		if _, err := db.Put(ctx, chunk.ModePutUpload, ch); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Set(ctx, chunk.ModeSetSync, ch.Address()); err != nil {
+			t.Fatal(err)
+		}
+		return ch
+	}
+
+	expiring := uploadSyncChunk(generateTestRandomChunk().WithTagID(tag.Uid))
+	pinned := uploadSyncChunk(generateTestRandomChunk().WithTagID(tag.Uid))
+	if err := db.Pin(pinned.Address()); err != nil {
+		t.Fatal(err)
+	}
+	persistent := uploadSyncChunk(generateTestRandomChunk())
+
+	if _, err := db.Get(ctx, chunk.ModeGetRequest, expiring.Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt64(&timestamp, 1000+int64(2*time.Hour))
+
+	if _, err := db.Get(ctx, chunk.ModeGetRequest, expiring.Address()); err != chunk.ErrChunkNotFound {
+		t.Errorf("got error %v, want %v", err, chunk.ErrChunkNotFound)
+	}
+	has, err := db.Has(ctx, expiring.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has {
+		t.Error("expired chunk found")
+	}
+
+	// wait for the expired chunk to be removed
+	timeout := time.After(10 * time.Second)
+	for {
+		var count int
+		if err := db.expiryIndex.Iterate(func(_ shed.Item) (bool, error) {
+			count++
+			return false, nil
+		}, nil); err != nil {
+			t.Fatal(err)
+		}
+		if count == 0 {
+			break
+		}
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("timeout waiting for expired chunk to be removed")
+		}
+	}
+
+	t.Run("retrieve indexes", func(t *testing.T) {
+		newItemsCountTest(db.retrievalDataIndex, 2)(t)
+		newItemsCountTest(db.retrievalAccessIndex, 2)(t)
+		newItemsCountTest(db.retrievalExpiryIndex, 0)(t)
+	})
+
+	t.Run("pull index count", newItemsCountTest(db.pullIndex, 2))
+
+	// pinned chunks are not in the gc index
+	t.Run("gc index count", newItemsCountTest(db.gcIndex, 1))
+
+	t.Run("gc size", newIndexGCSizeTest(db))
+
+	for _, ch := range []chunk.Chunk{pinned, persistent} {
+		if _, err := db.Get(ctx, chunk.ModeGetRequest, ch.Address()); err != nil {
+			t.Errorf("chunk %s: %v", ch.Address(), err)
+		}
+	}
+}
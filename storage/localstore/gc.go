@@ -17,6 +17,8 @@
 package localstore
 
 import (
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/log"
@@ -40,13 +42,46 @@ var (
 	gcBatchSize uint64 = 1000
 )
 
+// gcMetricsPrefix is the prefix of the names of metrics for
+// garbage collection rounds, evicted chunks and gc index size.
+const gcMetricsPrefix = "swarm/localstore/gc/"
+
+// GCPolicy defines the order in which garbage collection
+// evicts chunks from the database.
+type GCPolicy int
+
+const (
+	// GCPolicyLRU evicts least recently accessed chunks first.
+	GCPolicyLRU GCPolicy = iota
+	// GCPolicyLFU evicts least frequently accessed chunks first,
+	// and the least recently accessed ones among the chunks with
+	// the same number of accesses.
+	GCPolicyLFU
+)
+
+// String returns a name of the gc policy.
+func (p GCPolicy) String() string {
+	switch p {
+	case GCPolicyLRU:
+		return "lru"
+	case GCPolicyLFU:
+		return "lfu"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(p))
+	}
+}
+
 // collectGarbageWorker is a long running function that waits for
 // collectGarbageTrigger channel to signal a garbage collection
 // run. GC run iterates on gcIndex and removes older items
 // form retrieval and other indexes.
+// Expired chunks are removed periodically by the same worker.
 func (db *DB) collectGarbageWorker() {
 	defer close(db.collectGarbageWorkerDone)
 
+	expiryTicker := time.NewTicker(collectExpiredInterval)
+	defer expiryTicker.Stop()
+
 	for {
 		select {
 		case <-db.collectGarbageTrigger:
@@ -65,6 +100,15 @@ func (db *DB) collectGarbageWorker() {
 			if collectedCount > 0 && testHookCollectGarbage != nil {
 				testHookCollectGarbage(collectedCount)
 			}
+		case <-expiryTicker.C:
+			for done := false; !done; {
+				var err error
+				_, done, err = db.collectExpired()
+				if err != nil {
+					log.Error("localstore collect expired", "err", err)
+					break
+				}
+			}
 		case <-db.close:
 			return
 		}
@@ -80,6 +124,8 @@ func (db *DB) collectGarbageWorker() {
 func (db *DB) collectGarbage() (collectedCount uint64, done bool, err error) {
 	metricName := "localstore.gc"
 	metrics.GetOrRegisterCounter(metricName, nil).Inc(1)
+	// count garbage collection rounds
+	metrics.GetOrRegisterCounter(gcMetricsPrefix+"rounds", nil).Inc(1)
 	defer totalTimeMetric(metricName, time.Now())
 	defer func() {
 		if err != nil {
@@ -87,6 +133,10 @@ func (db *DB) collectGarbage() (collectedCount uint64, done bool, err error) {
 		}
 	}()
 
+	if db.readOnly {
+		return 0, true, ErrReadOnly
+	}
+
 	batch := new(leveldb.Batch)
 	target := db.gcTarget()
 
@@ -100,8 +150,14 @@ func (db *DB) collectGarbage() (collectedCount uint64, done bool, err error) {
 	}
 	metrics.GetOrRegisterGauge(metricName+".gcsize", nil).Update(int64(gcSize))
 
+	// chunks are evicted in the order of the index for the gc policy
+	gcIndex := db.gcIndex
+	if db.gcPolicy == GCPolicyLFU {
+		gcIndex = db.gcFrequencyIndex
+	}
+
 	done = true
-	err = db.gcIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+	err = gcIndex.Iterate(func(item shed.Item) (stop bool, err error) {
 		if gcSize-collectedCount <= target {
 			return true, nil
 		}
@@ -113,7 +169,13 @@ func (db *DB) collectGarbage() (collectedCount uint64, done bool, err error) {
 		db.retrievalDataIndex.DeleteInBatch(batch, item)
 		db.retrievalAccessIndex.DeleteInBatch(batch, item)
 		db.pullIndex.DeleteInBatch(batch, item)
-		db.gcIndex.DeleteInBatch(batch, item)
+		if _, err := db.deleteGCInBatch(batch, item); err != nil {
+			return true, err
+		}
+		db.gcAccessCountIndex.DeleteInBatch(batch, item)
+		// the entry in expiry index is removed when it expires
+		db.retrievalExpiryIndex.DeleteInBatch(batch, item)
+		db.retrievalSoftExpiryIndex.DeleteInBatch(batch, item)
 		collectedCount++
 		if collectedCount >= gcBatchSize {
 			// bach size limit reached,
@@ -127,6 +189,11 @@ func (db *DB) collectGarbage() (collectedCount uint64, done bool, err error) {
 		return 0, false, err
 	}
 	metrics.GetOrRegisterCounter(metricName+".collected-count", nil).Inc(int64(collectedCount))
+	metrics.GetOrRegisterCounter(gcMetricsPrefix+"evicted", nil).Inc(int64(collectedCount))
+	if collectedCount > 0 {
+		// the number of chunks removed in the last round that removed any
+		metrics.GetOrRegisterGauge(gcMetricsPrefix+"round-evicted", nil).Update(int64(collectedCount))
+	}
 
 	db.gcSize.PutInBatch(batch, gcSize-collectedCount)
 
@@ -135,13 +202,181 @@ func (db *DB) collectGarbage() (collectedCount uint64, done bool, err error) {
 		metrics.GetOrRegisterCounter(metricName+".writebatch.err", nil).Inc(1)
 		return 0, false, err
 	}
+	db.updateGCSizeMetrics(gcSize - collectedCount)
 	return collectedCount, done, nil
 }
 
+// initGCPolicy persists the gc policy of the database. The gc
+// frequency index is rebuilt from gc index with access counts reset
+// when the database was last opened with a different policy, as
+// the index and access counts are not maintained in that case.
+func (db *DB) initGCPolicy() (err error) {
+	policy, err := db.gcPolicyField.Get()
+	if err != nil {
+		return err
+	}
+	if db.gcPolicy != GCPolicyLFU || GCPolicy(policy) == GCPolicyLFU {
+		return db.gcPolicyField.Put(uint64(db.gcPolicy))
+	}
+
+	log.Info("localstore rebuilding gc frequency index", "policy", db.gcPolicy)
+	batch := new(leveldb.Batch)
+	err = db.gcFrequencyIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+		db.gcFrequencyIndex.DeleteInBatch(batch, item)
+		return false, nil
+	}, nil)
+	if err != nil {
+		return err
+	}
+	err = db.gcAccessCountIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+		db.gcAccessCountIndex.DeleteInBatch(batch, item)
+		return false, nil
+	}, nil)
+	if err != nil {
+		return err
+	}
+	err = db.gcIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+		db.gcFrequencyIndex.PutInBatch(batch, item)
+		return false, nil
+	}, nil)
+	if err != nil {
+		return err
+	}
+	db.gcPolicyField.PutInBatch(batch, uint64(db.gcPolicy))
+	return db.shed.WriteBatch(batch)
+}
+
+// accessCount returns the number of accesses of a chunk
+// stored in gc access count index.
+func (db *DB) accessCount(item shed.Item) (count uint64, err error) {
+	i, err := db.gcAccessCountIndex.Get(item)
+	switch err {
+	case nil:
+		return i.AccessCount, nil
+	case leveldb.ErrNotFound:
+		return 0, nil
+	default:
+		return 0, err
+	}
+}
+
+// putGCInBatch adds the item to gc index and, with the least
+// frequently used gc policy, to gc frequency index. Pinned chunks
+// are not added as they are not garbage collected. It returns the
+// change of the gc size. The item must have AccessTimestamp and
+// BinID set. This function must be called under batchMu lock.
+func (db *DB) putGCInBatch(batch *leveldb.Batch, item shed.Item) (change int64, err error) {
+	pinned, err := db.pinIndex.Has(item)
+	if err != nil || pinned {
+		return 0, err
+	}
+	db.gcIndex.PutInBatch(batch, item)
+	if db.gcPolicy != GCPolicyLFU {
+		return 1, nil
+	}
+	item.AccessCount, err = db.accessCount(item)
+	if err != nil {
+		return 0, err
+	}
+	db.gcFrequencyIndex.PutInBatch(batch, item)
+	return 1, nil
+}
+
+// deleteGCInBatch removes the item from gc index and, with the least
+// frequently used gc policy, from gc frequency index. Pinned chunks
+// are not in gc index. It returns the change of the gc size. The item
+// must have AccessTimestamp and BinID set. This function must be
+// called under batchMu lock.
+func (db *DB) deleteGCInBatch(batch *leveldb.Batch, item shed.Item) (change int64, err error) {
+	pinned, err := db.pinIndex.Has(item)
+	if err != nil || pinned {
+		return 0, err
+	}
+	db.gcIndex.DeleteInBatch(batch, item)
+	if db.gcPolicy != GCPolicyLFU {
+		return -1, nil
+	}
+	item.AccessCount, err = db.accessCount(item)
+	if err != nil {
+		return 0, err
+	}
+	db.gcFrequencyIndex.DeleteInBatch(batch, item)
+	return -1, nil
+}
+
 // gcTrigger retruns the absolute value for garbage collection
 // target value, calculated from db.capacity and gcTargetRatio.
 func (db *DB) gcTarget() (target uint64) {
-	return uint64(float64(db.capacity) * gcTargetRatio)
+	return uint64(float64(db.getCapacity()) * gcTargetRatio)
+}
+
+// getCapacity returns the current database capacity.
+func (db *DB) getCapacity() (capacity uint64) {
+	return atomic.LoadUint64(&db.capacity)
+}
+
+// SetCapacity changes the maximal number of chunks in garbage
+// collection index and persists it, so that it is used instead of
+// the Capacity option when the database is opened again. Garbage
+// collection is triggered if the database is over the new capacity.
+func (db *DB) SetCapacity(capacity uint64) (err error) {
+	if capacity == 0 {
+		return ErrInvalidCapacity
+	}
+	if db.readOnly {
+		return ErrReadOnly
+	}
+
+	db.batchMu.Lock()
+	defer db.batchMu.Unlock()
+
+	if err := db.capacityField.Put(capacity); err != nil {
+		return err
+	}
+	atomic.StoreUint64(&db.capacity, capacity)
+
+	gcSize, err := db.gcSize.Get()
+	if err != nil {
+		return err
+	}
+	db.updateGCSizeMetrics(gcSize)
+	if gcSize >= capacity {
+		db.triggerGarbageCollection()
+	}
+	return nil
+}
+
+// GCTarget returns the number of chunks in garbage collection
+// index that are left in the database after garbage collection run.
+func (db *DB) GCTarget() (target uint64) {
+	return db.gcTarget()
+}
+
+// Capacity returns the maximal number of chunks in garbage
+// collection index before garbage collection is triggered.
+func (db *DB) Capacity() (capacity uint64) {
+	return db.getCapacity()
+}
+
+// GCSize returns the number of chunks in garbage collection index.
+func (db *DB) GCSize() (size uint64, err error) {
+	return db.gcSize.Get()
+}
+
+// FreeCapacity returns the number of chunks that can be added to
+// garbage collection index before the garbage collection target
+// is reached. Zero is returned if the database holds the target
+// number of chunks or more.
+func (db *DB) FreeCapacity() (free uint64, err error) {
+	gcSize, err := db.gcSize.Get()
+	if err != nil {
+		return 0, err
+	}
+	target := db.gcTarget()
+	if gcSize >= target {
+		return 0, nil
+	}
+	return target - gcSize, nil
 }
 
 // triggerGarbageCollection signals collectGarbageWorker
@@ -180,14 +415,23 @@ func (db *DB) incGCSizeInBatch(batch *leveldb.Batch, change int64) (err error) {
 		new = gcSize - c
 	}
 	db.gcSize.PutInBatch(batch, new)
+	db.updateGCSizeMetrics(new)
 
 	// trigger garbage collection if we reached the capacity
-	if new >= db.capacity {
+	if new >= db.getCapacity() {
 		db.triggerGarbageCollection()
 	}
 	return nil
 }
 
+// updateGCSizeMetrics sets gauges for the number of chunks in
+// garbage collection index and the difference between the database
+// capacity and that number, which is negative if the capacity is exceeded.
+func (db *DB) updateGCSizeMetrics(gcSize uint64) {
+	metrics.GetOrRegisterGauge(gcMetricsPrefix+"size", nil).Update(int64(gcSize))
+	metrics.GetOrRegisterGauge(gcMetricsPrefix+"capacity-gap", nil).Update(int64(db.getCapacity()) - int64(gcSize))
+}
+
 // testHookCollectGarbage is a hook that can provide
 // information when a garbage collection run is done
 // and how many items it removed.
@@ -24,6 +24,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethersphere/swarm/chunk"
 )
 
@@ -123,6 +124,85 @@ func testDB_collectGarbageWorker(t *testing.T) {
 	})
 }
 
+// TestDB_collectGarbageMetrics validates that garbage collection
+// metrics are updated when garbage collection is triggered.
+func TestDB_collectGarbageMetrics(t *testing.T) {
+	defer func(enabled bool) { metrics.Enabled = enabled }(metrics.Enabled)
+	metrics.Enabled = true
+
+	// metrics may be already registered as nil
+	// while metrics were disabled in other tests
+	for _, name := range []string{
+		"swarm/localstore/gc/rounds",
+		"swarm/localstore/gc/evicted",
+		"swarm/localstore/gc/round-evicted",
+		"swarm/localstore/gc/size",
+		"swarm/localstore/gc/capacity-gap",
+	} {
+		metrics.DefaultRegistry.Unregister(name)
+	}
+
+	capacity := uint64(100)
+	db, cleanupFunc := newTestDB(t, &Options{
+		Capacity: capacity,
+	})
+	testHookCollectGarbageChan := make(chan uint64)
+	defer setTestHookCollectGarbage(func(collectedCount uint64) {
+		select {
+		case testHookCollectGarbageChan <- collectedCount:
+		case <-db.close:
+		}
+	})()
+	defer cleanupFunc()
+
+	for i := 0; i < 150; i++ {
+		ch := generateTestRandomChunk()
+
+		_, err := db.Put(context.Background(), chunk.ModePutUpload, ch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = db.Set(context.Background(), chunk.ModeSetSync, ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gcTarget := db.gcTarget()
+
+	for {
+		select {
+		case <-testHookCollectGarbageChan:
+		case <-time.After(10 * time.Second):
+			t.Fatal("collect garbage timeout")
+		}
+		gcSize, err := db.gcSize.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gcSize == gcTarget {
+			break
+		}
+	}
+
+	if got := metrics.GetOrRegisterCounter("swarm/localstore/gc/rounds", nil).Count(); got <= 0 {
+		t.Errorf("got %v gc rounds, want more then 0", got)
+	}
+	if got := metrics.GetOrRegisterCounter("swarm/localstore/gc/evicted", nil).Count(); got <= 0 {
+		t.Errorf("got %v collected chunks, want more then 0", got)
+	}
+	if got := metrics.GetOrRegisterGauge("swarm/localstore/gc/round-evicted", nil).Value(); got <= 0 {
+		t.Errorf("got %v collected chunks in the last round, want more then 0", got)
+	}
+	if got := metrics.GetOrRegisterGauge("swarm/localstore/gc/size", nil).Value(); got != int64(gcTarget) {
+		t.Errorf("got gc size %v, want %v", got, gcTarget)
+	}
+	if got, want := metrics.GetOrRegisterGauge("swarm/localstore/gc/capacity-gap", nil).Value(), int64(capacity-gcTarget); got != want {
+		t.Errorf("got capacity gap %v, want %v", got, want)
+	}
+}
+
 // TestDB_collectGarbageWorker_withRequests is a helper test function
 // to test garbage collection runs by uploading, syncing and
 // requesting a number of chunks.
@@ -253,6 +333,116 @@ func TestDB_collectGarbageWorker_withRequests(t *testing.T) {
 	})
 }
 
+// TestDB_collectGarbageWorker_lfu validates that garbage collection
+// with the least frequently used policy keeps frequently requested
+// chunks, even if they are not accessed recently.
+func TestDB_collectGarbageWorker_lfu(t *testing.T) {
+	db, cleanupFunc := newTestDB(t, &Options{
+		Capacity: 100,
+		GCPolicy: GCPolicyLFU,
+	})
+	testHookCollectGarbageChan := make(chan uint64)
+	defer setTestHookCollectGarbage(func(collectedCount uint64) {
+		select {
+		case testHookCollectGarbageChan <- collectedCount:
+		case <-db.close:
+		}
+	})()
+	defer cleanupFunc()
+
+	uploadChunks := func(count int) (addrs []chunk.Address) {
+		for i := 0; i < count; i++ {
+			ch := generateTestRandomChunk()
+
+			_, err := db.Put(context.Background(), chunk.ModePutUpload, ch)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = db.Set(context.Background(), chunk.ModeSetSync, ch.Address())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			addrs = append(addrs, ch.Address())
+		}
+		return addrs
+	}
+
+	// upload random chunks below the capacity
+	addrs := uploadChunks(50)
+
+	testHookUpdateGCChan := make(chan struct{})
+	resetTestHookUpdateGC := setTestHookUpdateGC(func() {
+		select {
+		case testHookUpdateGCChan <- struct{}{}:
+		case <-db.close:
+		}
+	})
+
+	// request the first uploaded chunks
+	// multiple times to make them hot
+	hotAddrs := addrs[:10]
+	for i := 0; i < 3; i++ {
+		for _, addr := range hotAddrs {
+			_, err := db.Get(context.Background(), chunk.ModeGetRequest, addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-testHookUpdateGCChan:
+			case <-time.After(10 * time.Second):
+				t.Fatal("updateGC was not called after getting chunk with ModeGetRequest")
+			}
+		}
+	}
+
+	resetTestHookUpdateGC()
+
+	// upload more chunks than the capacity after the hot chunks
+	// are requested, so that they are not the most recently used
+	addrs = append(addrs, uploadChunks(100)...)
+
+	gcTarget := db.gcTarget()
+
+	for {
+		select {
+		case <-testHookCollectGarbageChan:
+		case <-time.After(10 * time.Second):
+			t.Fatal("collect garbage timeout")
+		}
+		gcSize, err := db.gcSize.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gcSize == gcTarget {
+			break
+		}
+	}
+
+	t.Run("gc index count", newItemsCountTest(db.gcIndex, int(gcTarget)))
+
+	t.Run("gc frequency index count", newItemsCountTest(db.gcFrequencyIndex, int(gcTarget)))
+
+	t.Run("gc size", newIndexGCSizeTest(db))
+
+	t.Run("get hot chunks", func(t *testing.T) {
+		for _, addr := range hotAddrs {
+			_, err := db.Get(context.Background(), chunk.ModeGetLookup, addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	t.Run("get first cold chunk", func(t *testing.T) {
+		_, err := db.Get(context.Background(), chunk.ModeGetLookup, addrs[len(hotAddrs)])
+		if err != chunk.ErrChunkNotFound {
+			t.Errorf("got error %v, want %v", err, chunk.ErrChunkNotFound)
+		}
+	})
+}
+
 // TestDB_gcSize checks if gcSize has a correct value after
 // database is initialized with existing data.
 func TestDB_gcSize(t *testing.T) {
@@ -299,6 +489,160 @@ func TestDB_gcSize(t *testing.T) {
 	t.Run("gc index size", newIndexGCSizeTest(db))
 }
 
+// TestDB_FreeCapacity validates that FreeCapacity reports
+// the number of chunks that can be stored before the
+// garbage collection target is reached.
+func TestDB_FreeCapacity(t *testing.T) {
+	db, cleanupFunc := newTestDB(t, &Options{
+		Capacity: 100,
+	})
+	defer cleanupFunc()
+
+	target := db.GCTarget()
+	if target != 90 {
+		t.Fatalf("got gc target %v, want %v", target, 90)
+	}
+
+	for i := uint64(0); i < target; i++ {
+		free, err := db.FreeCapacity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if free != target-i {
+			t.Fatalf("got free capacity %v, want %v", free, target-i)
+		}
+
+		ch := generateTestRandomChunk()
+
+		_, err = db.Put(context.Background(), chunk.ModePutUpload, ch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = db.Set(context.Background(), chunk.ModeSetSync, ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	free, err := db.FreeCapacity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free != 0 {
+		t.Fatalf("got free capacity %v, want %v", free, 0)
+	}
+}
+
+// TestDB_SetCapacity validates that lowering the capacity
+// triggers garbage collection down to the new target and that
+// the capacity is persisted.
+func TestDB_SetCapacity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localstore-set-capacity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	baseKey := make([]byte, 32)
+	if _, err := rand.Read(baseKey); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := New(dir, baseKey, &Options{
+		Capacity: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testHookCollectGarbageChan := make(chan uint64)
+	defer setTestHookCollectGarbage(func(collectedCount uint64) {
+		select {
+		case testHookCollectGarbageChan <- collectedCount:
+		case <-db.close:
+		}
+	})()
+
+	chunkCount := 80
+	addrs := make([]chunk.Address, 0, chunkCount)
+	for i := 0; i < chunkCount; i++ {
+		ch := generateTestRandomChunk()
+
+		_, err := db.Put(context.Background(), chunk.ModePutUpload, ch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = db.Set(context.Background(), chunk.ModeSetSync, ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		addrs = append(addrs, ch.Address())
+	}
+
+	if err := db.SetCapacity(0); err != ErrInvalidCapacity {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidCapacity)
+	}
+
+	if err := db.SetCapacity(50); err != nil {
+		t.Fatal(err)
+	}
+
+	gcTarget := db.gcTarget()
+	if gcTarget != 45 {
+		t.Fatalf("got gc target %v, want %v", gcTarget, 45)
+	}
+
+	for {
+		select {
+		case <-testHookCollectGarbageChan:
+		case <-time.After(10 * time.Second):
+			t.Fatal("collect garbage timeout")
+		}
+		gcSize, err := db.gcSize.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gcSize == gcTarget {
+			break
+		}
+	}
+
+	t.Run("gc index count", newItemsCountTest(db.gcIndex, int(gcTarget)))
+
+	t.Run("get the first synced chunk", func(t *testing.T) {
+		_, err := db.Get(context.Background(), chunk.ModeGetRequest, addrs[0])
+		if err != chunk.ErrChunkNotFound {
+			t.Errorf("got error %v, want %v", err, chunk.ErrChunkNotFound)
+		}
+	})
+
+	t.Run("get most recent synced chunk", func(t *testing.T) {
+		_, err := db.Get(context.Background(), chunk.ModeGetRequest, addrs[len(addrs)-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = New(dir, baseKey, &Options{
+		Capacity: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if got := db.getCapacity(); got != 50 {
+		t.Errorf("got capacity %v after reopening, want %v", got, 50)
+	}
+}
+
 // setTestHookCollectGarbage sets testHookCollectGarbage and
 // returns a function that will reset it to the
 // value before the change.
@@ -0,0 +1,53 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package localstore
+
+import (
+	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/shed"
+)
+
+// IterateOptions holds optional parameters for the Iterate method.
+type IterateOptions struct {
+	// FromBin and ToBin limit the iteration to chunks
+	// with proximity order to the base key within
+	// this range, both ends inclusive.
+	FromBin uint8
+	ToBin   uint8
+}
+
+// Iterate calls the function for every chunk in the retrieval
+// index, in the order of the index keys, providing the chunk address,
+// bin ID and store timestamp. Iteration is done on a database snapshot
+// and it is safe to call it while other operations are in progress.
+// If options are nil, all chunks are iterated over. Iteration stops
+// if the function returns true or an error, which is returned by Iterate.
+func (db *DB) Iterate(fn func(chunk.Descriptor) (stop bool, err error), o *IterateOptions) (err error) {
+	return db.retrievalDataIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+		if o != nil {
+			po := db.po(item.Address)
+			if po < o.FromBin || po > o.ToBin {
+				return false, nil
+			}
+		}
+		return fn(chunk.Descriptor{
+			Address:        item.Address,
+			BinID:          item.BinID,
+			StoreTimestamp: item.StoreTimestamp,
+		})
+	}, nil)
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package localstore
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ethersphere/swarm/chunk"
+)
+
+// TestDB_Iterate validates that Iterate visits all stored chunks
+// exactly once, in the retrieval index order, and that it respects
+// the bin range option.
+func TestDB_Iterate(t *testing.T) {
+	db, cleanupFunc := newTestDB(t, nil)
+	defer cleanupFunc()
+
+	chunkCount := 500
+
+	chunks := make(map[string]chunk.Chunk, chunkCount)
+	for i := 0; i < chunkCount; i++ {
+		ch := generateTestRandomChunk()
+		if _, err := db.Put(context.Background(), chunk.ModePutUpload, ch); err != nil {
+			t.Fatal(err)
+		}
+		chunks[string(ch.Address())] = ch
+	}
+
+	visited := make(map[string]struct{})
+	var last chunk.Address
+	err := db.Iterate(func(d chunk.Descriptor) (stop bool, err error) {
+		if _, ok := chunks[string(d.Address)]; !ok {
+			t.Errorf("unexpected chunk %s", d.Address.Hex())
+		}
+		if _, ok := visited[string(d.Address)]; ok {
+			t.Errorf("chunk %s visited more than once", d.Address.Hex())
+		}
+		if last != nil && bytes.Compare(last, d.Address) >= 0 {
+			t.Errorf("chunk %s visited out of order", d.Address.Hex())
+		}
+		if d.StoreTimestamp == 0 {
+			t.Errorf("chunk %s has no store timestamp", d.Address.Hex())
+		}
+		visited[string(d.Address)] = struct{}{}
+		last = append(chunk.Address(nil), d.Address...)
+		return false, nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(visited) != chunkCount {
+		t.Errorf("got %v visited chunks, want %v", len(visited), chunkCount)
+	}
+
+	t.Run("bin range", func(t *testing.T) {
+		var fromBin, toBin uint8 = 1, 2
+
+		var want int
+		for _, ch := range chunks {
+			if po := db.po(ch.Address()); po >= fromBin && po <= toBin {
+				want++
+			}
+		}
+
+		var got int
+		err := db.Iterate(func(d chunk.Descriptor) (stop bool, err error) {
+			if po := db.po(d.Address); po < fromBin || po > toBin {
+				t.Errorf("chunk %s in bin %v", d.Address.Hex(), po)
+			}
+			got++
+			return false, nil
+		}, &IterateOptions{
+			FromBin: fromBin,
+			ToBin:   toBin,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("got %v visited chunks, want %v", got, want)
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		var count int
+		err := db.Iterate(func(d chunk.Descriptor) (stop bool, err error) {
+			count++
+			return count == 10, nil
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 10 {
+			t.Errorf("got %v visited chunks, want 10", count)
+		}
+	})
+}
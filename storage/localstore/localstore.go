@@ -19,14 +19,19 @@ package localstore
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethersphere/swarm/bmt"
 	"github.com/ethersphere/swarm/chunk"
 	"github.com/ethersphere/swarm/shed"
 	"github.com/ethersphere/swarm/storage/mock"
+	leveldberrors "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"golang.org/x/crypto/sha3"
 )
 
 // DB implements chunk.Store.
@@ -40,8 +45,43 @@ var (
 	// is updated in parallel and one of the updates
 	// takes longer then the configured timeout duration.
 	ErrAddressLockTimeout = errors.New("address lock timeout")
+	// ErrReadOnly is returned when an operation that
+	// changes the database is called on a read-only DB.
+	ErrReadOnly = errors.New("read-only database")
+	// ErrInvalidCapacity is returned by SetCapacity
+	// when the capacity is zero.
+	ErrInvalidCapacity = errors.New("invalid capacity")
+	// ErrCompactionInProgress is returned by Compact
+	// when another compaction is not yet complete.
+	ErrCompactionInProgress = errors.New("compaction in progress")
+	// ErrChunkCorrupted is returned by Get and GetMulti when the
+	// VerifyOnGet option is set and the stored chunk is not valid
+	// by any of the Validators.
+	ErrChunkCorrupted = errors.New("chunk corrupted")
+	// ErrNoValidators is returned by New when the VerifyOnGet
+	// option is set without Validators.
+	ErrNoValidators = errors.New("no chunk validators")
+	// ErrInvalidGCPolicy is returned by New when an unknown
+	// GCPolicy is provided in options.
+	ErrInvalidGCPolicy = errors.New("invalid gc policy")
+	// ErrInvalidPageLimit is returned by IteratePage
+	// when the limit is not positive.
+	ErrInvalidPageLimit = errors.New("invalid page limit")
 )
 
+// ErrStoreCorrupted is returned by New when the LevelDB database
+// on the path is corrupted and it could not be recovered. Tooling
+// can use the path to decide whether to remove the database and
+// sync chunks again.
+type ErrStoreCorrupted struct {
+	Path string // path of the corrupted database
+	Err  error  // LevelDB corruption error
+}
+
+func (e *ErrStoreCorrupted) Error() string {
+	return fmt.Sprintf("localstore %s corrupted: %v", e.Path, e.Err)
+}
+
 var (
 	// Default value for Capacity DB option.
 	defaultCapacity uint64 = 5000000
@@ -83,9 +123,45 @@ type DB struct {
 	// field that stores number of intems in gc index
 	gcSize shed.Uint64Field
 
+	// policy that defines the order of garbage collection
+	gcPolicy GCPolicy
+	// persisted gc policy of the last database open
+	gcPolicyField shed.Uint64Field
+	// number of accesses of a chunk and the gc index ordered
+	// by it, maintained only with GCPolicyLFU
+	gcAccessCountIndex shed.Index
+	gcFrequencyIndex   shed.Index
+
+	// index of pinned chunks that are skipped by garbage collection
+	pinIndex shed.Index
+
+	// expiry indexes of chunks uploaded with a tag that has a TTL,
+	// ordered by expiry time and by chunk address
+	expiryIndex          shed.Index
+	retrievalExpiryIndex shed.Index
+
+	// soft expiry time of chunks after which they are stale
+	// and refetched from the network when they are served
+	retrievalSoftExpiryIndex shed.Index
+	// duration until chunks retrieved from the network become
+	// stale, soft expiry is not set if it is zero
+	softTTL time.Duration
+
+	// skip content address verification on ImportArchive
+	trustLocalPuts bool
+	// maximal size of chunk data without the span
+	// that is accepted by ImportArchive
+	chunkSize int
+
+	// index of values stored through the state store
+	stateIndex shed.Index
+
 	// garbage collection is triggered when gcSize exceeds
-	// the capacity value
+	// the capacity value, accessed atomically
 	capacity uint64
+	// capacity set by SetCapacity that overrides
+	// the Capacity option on database open
+	capacityField shed.Uint64Field
 
 	// triggers garbage collection event loop
 	collectGarbageTrigger chan struct{}
@@ -100,6 +176,21 @@ type DB struct {
 
 	baseKey []byte
 
+	// database is opened in read-only mode
+	readOnly bool
+
+	// set to 1 while Compact is running, accessed atomically
+	compacting uint32
+
+	// validators used to verify chunk data on Get
+	// and on archive import
+	validators []chunk.Validator
+	// verify chunk data on Get with validators
+	verifyOnGet bool
+
+	// tags of uploaded chunks, updated when chunks are synced
+	tags *chunk.Tags
+
 	batchMu sync.Mutex
 
 	// this channel is closed when close function is called
@@ -125,6 +216,58 @@ type Options struct {
 	Capacity uint64
 	// MetricsPrefix defines a prefix for metrics names.
 	MetricsPrefix string
+	// ReadOnly opens an existing database without changing it.
+	// Put, Set, Pin, Unpin and garbage collection return ErrReadOnly,
+	// and Get does not update access timestamps. Indexes that are
+	// not in the schema of a database created by an earlier version
+	// are empty.
+	ReadOnly bool
+	// Tags are used to update the Sent and Synced counters
+	// of tags that uploaded chunks are associated with. Chunks
+	// of tags with a TTL are removed when they expire.
+	Tags *chunk.Tags
+	// LevelDBOptions are used to open the underlying LevelDB
+	// database, for example to set the block cache size, write
+	// buffer size or compaction settings. If nil, default
+	// options are used. ReadOnly and ErrorIfMissing are set
+	// from the ReadOnly option.
+	LevelDBOptions *opt.Options
+	// VerifyOnGet makes Get and GetMulti validate the stored chunks
+	// with Validators and return ErrChunkCorrupted if none of them
+	// accepts a chunk, trading CPU time for detection of corrupted
+	// storage. Validators must be set with this option.
+	VerifyOnGet bool
+	// Validators are used to verify stored chunks with the VerifyOnGet
+	// option and imported chunks in ImportArchive. They should accept
+	// all chunk types that are stored, such as content addressed and
+	// feed chunks of the configured chunk size.
+	Validators []chunk.Validator
+	// RecoverCorrupted makes New try to recover a corrupted
+	// LevelDB database by rebuilding its manifest from the
+	// table files, dropping the tables that are corrupted.
+	// If the recovery fails, *ErrStoreCorrupted is returned.
+	// It has no effect on read-only databases.
+	RecoverCorrupted bool
+	// GCPolicy defines which chunks are evicted first by
+	// garbage collection. The default is GCPolicyLRU.
+	GCPolicy GCPolicy
+	// SoftTTL is the duration after which chunks retrieved from
+	// the network with ModePutRequest become stale. Stale chunks
+	// are not removed, but they are refetched when they are served
+	// by a NetStore with the ServeStale option. Retrieving the
+	// chunk again renews its soft expiry. Zero disables it.
+	SoftTTL time.Duration
+	// TrustLocalPuts disables verification of chunk data against
+	// content addresses when chunks are imported with ImportArchive,
+	// saving CPU time on bulk imports of chunks that are already
+	// verified elsewhere. It is unsafe for chunks that are received
+	// from the network or from any untrusted source, and it should
+	// be enabled only for trusted local imports.
+	TrustLocalPuts bool
+	// ChunkSize is the maximal size of chunk data without the span
+	// of chunks imported with ImportArchive. It must match the chunk
+	// size of the stored content. If zero, chunk.DefaultSize is used.
+	ChunkSize int
 }
 
 // New returns a new DB.  All fields and indexes are initialized
@@ -138,8 +281,14 @@ func New(path string, baseKey []byte, o *Options) (db *DB, err error) {
 		}
 	}
 	db = &DB{
-		capacity: o.Capacity,
-		baseKey:  baseKey,
+		capacity:       o.Capacity,
+		baseKey:        baseKey,
+		readOnly:       o.ReadOnly,
+		tags:           o.Tags,
+		gcPolicy:       o.GCPolicy,
+		softTTL:        o.SoftTTL,
+		trustLocalPuts: o.TrustLocalPuts,
+		chunkSize:      o.ChunkSize,
 		// channel collectGarbageTrigger
 		// needs to be buffered with the size of 1
 		// to signal another event if it
@@ -151,13 +300,44 @@ func New(path string, baseKey []byte, o *Options) (db *DB, err error) {
 	if db.capacity <= 0 {
 		db.capacity = defaultCapacity
 	}
+	if db.chunkSize <= 0 {
+		db.chunkSize = chunk.DefaultSize
+	}
+	switch db.gcPolicy {
+	case GCPolicyLRU, GCPolicyLFU:
+	default:
+		return nil, ErrInvalidGCPolicy
+	}
+	if o.VerifyOnGet && len(o.Validators) == 0 {
+		return nil, ErrNoValidators
+	}
+	db.validators = o.Validators
+	db.verifyOnGet = o.VerifyOnGet
 	if maxParallelUpdateGC > 0 {
 		db.updateGCSem = make(chan struct{}, maxParallelUpdateGC)
 	}
 
-	db.shed, err = shed.NewDB(path, o.MetricsPrefix)
+	var ldbOptions opt.Options
+	if o.LevelDBOptions != nil {
+		ldbOptions = *o.LevelDBOptions
+	}
+	ldbOptions.ReadOnly = db.readOnly
+	ldbOptions.ErrorIfMissing = db.readOnly
+	db.shed, err = shed.NewDBWithOptions(path, o.MetricsPrefix, &ldbOptions)
 	if err != nil {
-		return nil, err
+		if !leveldberrors.IsCorrupted(err) {
+			return nil, err
+		}
+		if !o.RecoverCorrupted || db.readOnly {
+			return nil, &ErrStoreCorrupted{Path: path, Err: err}
+		}
+		log.Warn("localstore corrupted, recovering", "path", path, "err", err)
+		db.shed, err = shed.NewRecoveredDB(path, o.MetricsPrefix, &ldbOptions)
+		if err != nil {
+			log.Error("localstore recovery failed", "path", path, "err", err)
+			return nil, &ErrStoreCorrupted{Path: path, Err: err}
+		}
+		log.Info("localstore recovered", "path", path)
 	}
 
 	// Identify current storage schema by arbitrary name.
@@ -181,6 +361,24 @@ func New(path string, baseKey []byte, o *Options) (db *DB, err error) {
 	if err != nil {
 		return nil, err
 	}
+	// Persist gc policy to detect when the
+	// gc frequency index needs to be rebuilt.
+	db.gcPolicyField, err = db.shed.NewUint64Field("gc-policy")
+	if err != nil {
+		return nil, err
+	}
+	// Persist capacity set at runtime.
+	db.capacityField, err = db.shed.NewUint64Field("capacity")
+	if err != nil {
+		return nil, err
+	}
+	capacity, err := db.capacityField.Get()
+	if err != nil {
+		return nil, err
+	}
+	if capacity > 0 {
+		db.capacity = capacity
+	}
 	// Functions for retrieval data index.
 	var (
 		encodeValueFunc func(fields shed.Item) (value []byte, err error)
@@ -300,9 +498,16 @@ func New(path string, baseKey []byte, o *Options) (db *DB, err error) {
 			return e, nil
 		},
 		EncodeValue: func(fields shed.Item) (value []byte, err error) {
-			return nil, nil
+			tag := make([]byte, 4)
+			binary.BigEndian.PutUint32(tag, fields.Tag)
+			return tag, nil
 		},
 		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			// values of items stored before tags were
+			// added to the push index are empty
+			if len(value) == 4 {
+				e.Tag = binary.BigEndian.Uint32(value)
+			}
 			return e, nil
 		},
 	})
@@ -336,6 +541,175 @@ func New(path string, baseKey []byte, o *Options) (db *DB, err error) {
 	if err != nil {
 		return nil, err
 	}
+	// number of accesses of a chunk for the least frequently used gc policy
+	db.gcAccessCountIndex, err = db.shed.NewIndex("Address->AccessCount", shed.IndexFuncs{
+		EncodeKey: func(fields shed.Item) (key []byte, err error) {
+			return fields.Address, nil
+		},
+		DecodeKey: func(key []byte) (e shed.Item, err error) {
+			e.Address = key
+			return e, nil
+		},
+		EncodeValue: func(fields shed.Item) (value []byte, err error) {
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b, fields.AccessCount)
+			return b, nil
+		},
+		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			e.AccessCount = binary.BigEndian.Uint64(value)
+			return e, nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// gc index for removable chunk ordered by ascending number of accesses
+	// and last access time, used by the least frequently used gc policy
+	db.gcFrequencyIndex, err = db.shed.NewIndex("AccessCount|AccessTimestamp|BinID|Hash->nil", shed.IndexFuncs{
+		EncodeKey: func(fields shed.Item) (key []byte, err error) {
+			b := make([]byte, 24, 24+len(fields.Address))
+			binary.BigEndian.PutUint64(b[:8], fields.AccessCount)
+			binary.BigEndian.PutUint64(b[8:16], uint64(fields.AccessTimestamp))
+			binary.BigEndian.PutUint64(b[16:24], fields.BinID)
+			key = append(b, fields.Address...)
+			return key, nil
+		},
+		DecodeKey: func(key []byte) (e shed.Item, err error) {
+			e.AccessCount = binary.BigEndian.Uint64(key[:8])
+			e.AccessTimestamp = int64(binary.BigEndian.Uint64(key[8:16]))
+			e.BinID = binary.BigEndian.Uint64(key[16:24])
+			e.Address = key[24:]
+			return e, nil
+		},
+		EncodeValue: func(fields shed.Item) (value []byte, err error) {
+			return nil, nil
+		},
+		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			return e, nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// pin index for chunks that must not be garbage collected
+	db.pinIndex, err = db.shed.NewIndex("Hash->nil", shed.IndexFuncs{
+		EncodeKey: func(fields shed.Item) (key []byte, err error) {
+			return fields.Address, nil
+		},
+		DecodeKey: func(key []byte) (e shed.Item, err error) {
+			e.Address = key
+			return e, nil
+		},
+		EncodeValue: func(fields shed.Item) (value []byte, err error) {
+			return nil, nil
+		},
+		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			return e, nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// expiry index for removing expired chunks ordered by expiry time
+	db.expiryIndex, err = db.shed.NewIndex("Expiry|Hash->StoreTimestamp", shed.IndexFuncs{
+		EncodeKey: func(fields shed.Item) (key []byte, err error) {
+			b := make([]byte, 8, 8+len(fields.Address))
+			binary.BigEndian.PutUint64(b, uint64(fields.Expiry))
+			key = append(b, fields.Address...)
+			return key, nil
+		},
+		DecodeKey: func(key []byte) (e shed.Item, err error) {
+			e.Expiry = int64(binary.BigEndian.Uint64(key[:8]))
+			e.Address = key[8:]
+			return e, nil
+		},
+		EncodeValue: func(fields shed.Item) (value []byte, err error) {
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b, uint64(fields.StoreTimestamp))
+			return b, nil
+		},
+		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			e.StoreTimestamp = int64(binary.BigEndian.Uint64(value))
+			return e, nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// expiry time for a particular address, needed to
+	// check if the chunk is expired when it is retrieved
+	db.retrievalExpiryIndex, err = db.shed.NewIndex("Address->Expiry", shed.IndexFuncs{
+		EncodeKey: func(fields shed.Item) (key []byte, err error) {
+			return fields.Address, nil
+		},
+		DecodeKey: func(key []byte) (e shed.Item, err error) {
+			e.Address = key
+			return e, nil
+		},
+		EncodeValue: func(fields shed.Item) (value []byte, err error) {
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b, uint64(fields.Expiry))
+			return b, nil
+		},
+		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			e.Expiry = int64(binary.BigEndian.Uint64(value))
+			return e, nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// soft expiry time for a particular address, needed to
+	// check if the chunk is stale when it is retrieved
+	db.retrievalSoftExpiryIndex, err = db.shed.NewIndex("Address->SoftExpiry", shed.IndexFuncs{
+		EncodeKey: func(fields shed.Item) (key []byte, err error) {
+			return fields.Address, nil
+		},
+		DecodeKey: func(key []byte) (e shed.Item, err error) {
+			e.Address = key
+			return e, nil
+		},
+		EncodeValue: func(fields shed.Item) (value []byte, err error) {
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b, uint64(fields.Expiry))
+			return b, nil
+		},
+		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			e.Expiry = int64(binary.BigEndian.Uint64(value))
+			return e, nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// values of the state store, keyed by arbitrary strings
+	db.stateIndex, err = db.shed.NewIndex("StateKey->Value", shed.IndexFuncs{
+		EncodeKey: func(fields shed.Item) (key []byte, err error) {
+			return fields.Address, nil
+		},
+		DecodeKey: func(key []byte) (e shed.Item, err error) {
+			e.Address = key
+			return e, nil
+		},
+		EncodeValue: func(fields shed.Item) (value []byte, err error) {
+			return fields.Data, nil
+		},
+		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			e.Data = value
+			return e, nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if db.readOnly {
+		// garbage collection is disabled
+		close(db.collectGarbageWorkerDone)
+		return db, nil
+	}
+	if err := db.initGCPolicy(); err != nil {
+		return nil, err
+	}
 	// start garbage collection worker
 	go db.collectGarbageWorker()
 	return db, nil
@@ -362,11 +736,20 @@ func (db *DB) po(addr chunk.Address) (bin uint8) {
 	return uint8(chunk.Proximity(db.baseKey, addr))
 }
 
+// newVerifyHashPool returns a pool of hashers that are
+// used to verify chunk data of at most chunkSize.
+func newVerifyHashPool(chunkSize int) *bmt.TreePool {
+	hasher := sha3.NewLegacyKeccak256
+	segmentCount := chunkSize / hasher().Size()
+	return bmt.NewTreePool(hasher, segmentCount, bmt.PoolSize)
+}
+
 // chunkToItem creates new Item with data provided by the Chunk.
 func chunkToItem(ch chunk.Chunk) shed.Item {
 	return shed.Item{
 		Address: ch.Address(),
 		Data:    ch.Data(),
+		Tag:     ch.TagID(),
 	}
 }
 
@@ -19,10 +19,12 @@ package localstore
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"runtime"
 	"sort"
 	"sync"
@@ -32,6 +34,8 @@ import (
 	"github.com/ethersphere/swarm/chunk"
 	"github.com/ethersphere/swarm/shed"
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 func init() {
@@ -80,6 +84,34 @@ func TestDB(t *testing.T) {
 	}
 }
 
+// TestDB_levelDBOptions validates that the database opened
+// with custom LevelDB options serves puts and gets.
+func TestDB_levelDBOptions(t *testing.T) {
+	db, cleanupFunc := newTestDB(t, &Options{
+		LevelDBOptions: &opt.Options{
+			BlockCacheCapacity: 1 * opt.MiB,
+			WriteBuffer:        2 * opt.MiB,
+		},
+	})
+	defer cleanupFunc()
+
+	ch := generateTestRandomChunk()
+
+	_, err := db.Put(context.Background(), chunk.ModePutUpload, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.Get(context.Background(), chunk.ModeGetRequest, ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got.Data(), ch.Data()) {
+		t.Errorf("got data %x, want %x", got.Data(), ch.Data())
+	}
+}
+
 // TestDB_updateGCSem tests maxParallelUpdateGC limit.
 // This test temporary sets the limit to a low number,
 // makes updateGC function execution time longer by
@@ -135,6 +167,219 @@ func TestDB_updateGCSem(t *testing.T) {
 	}
 }
 
+// TestDB_readOnly validates that an existing database opened in
+// read-only mode returns stored chunks, that operations changing
+// the database return ErrReadOnly and that no files are written.
+func TestDB_readOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localstore-read-only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	baseKey := make([]byte, 32)
+	if _, err := rand.Read(baseKey); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := New(dir, baseKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := generateTestRandomChunk()
+	if _, err := db.Put(context.Background(), chunk.ModePutUpload, ch); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set(context.Background(), chunk.ModeSetSync, ch.Address()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// dirState returns names, sizes and modification times of files in dir
+	dirState := func() string {
+		t.Helper()
+
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var state string
+		for _, f := range files {
+			state += fmt.Sprintf("%s %v %v\n", f.Name(), f.Size(), f.ModTime().UnixNano())
+		}
+		return state
+	}
+	before := dirState()
+
+	db, err = New(dir, baseKey, &Options{
+		ReadOnly: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.Get(context.Background(), chunk.ModeGetRequest, ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Data(), ch.Data()) {
+		t.Errorf("got chunk data %x, want %x", got.Data(), ch.Data())
+	}
+	has, err := db.Has(context.Background(), ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !has {
+		t.Error("chunk not found")
+	}
+	c, stop := db.SubscribePull(context.Background(), db.po(ch.Address()), 0, 1)
+	d, ok := <-c
+	stop()
+	if !ok || !bytes.Equal(d.Address, ch.Address()) {
+		t.Errorf("got pull subscription descriptor %v, want chunk %s", d, ch.Address())
+	}
+
+	if _, err := db.Put(context.Background(), chunk.ModePutUpload, generateTestRandomChunk()); err != ErrReadOnly {
+		t.Errorf("got put error %v, want %v", err, ErrReadOnly)
+	}
+	if err := db.Set(context.Background(), chunk.ModeSetRemove, ch.Address()); err != ErrReadOnly {
+		t.Errorf("got set error %v, want %v", err, ErrReadOnly)
+	}
+	if err := db.Pin(ch.Address()); err != ErrReadOnly {
+		t.Errorf("got pin error %v, want %v", err, ErrReadOnly)
+	}
+	if _, _, err := db.collectGarbage(); err != ErrReadOnly {
+		t.Errorf("got garbage collection error %v, want %v", err, ErrReadOnly)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if after := dirState(); after != before {
+		t.Errorf("files changed in read-only mode\nbefore:\n%safter:\n%s", before, after)
+	}
+}
+
+// TestDB_readOnlyLegacySchema validates that a database created before
+// indexes and fields were added to the schema can be opened in read-only
+// mode and that its stored chunks are returned.
+func TestDB_readOnlyLegacySchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localstore-read-only-legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	baseKey := make([]byte, 32)
+	if _, err := rand.Read(baseKey); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := New(dir, baseKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := generateTestRandomChunk()
+	if _, err := db.Put(context.Background(), chunk.ModePutUpload, ch); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// remove indexes and fields that are not in the original schema
+	// together with their data, as if the database was created by
+	// an earlier version
+	legacyIndexes := map[string]bool{
+		"Address->StoreTimestamp|BinID|Data": true,
+		"Address->AccessTimestamp":           true,
+		"PO|BinID->Hash":                     true,
+		"StoreTimestamp|Hash->Tags":          true,
+		"AccessTimestamp|BinID|Hash->nil":    true,
+	}
+	legacyFields := map[string]bool{
+		"schema-name": true,
+		"gc-size":     true,
+	}
+	ldb, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemaKey := []byte{0}
+	b, err := ldb.Get(schemaKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Fields  map[string]json.RawMessage `json:"fields"`
+		Indexes map[byte]struct {
+			Name string `json:"name"`
+		} `json:"indexes"`
+	}
+	if err := json.Unmarshal(b, &schema); err != nil {
+		t.Fatal(err)
+	}
+	batch := new(leveldb.Batch)
+	for name := range schema.Fields {
+		if !legacyFields[name] {
+			delete(schema.Fields, name)
+			batch.Delete(append([]byte{1}, name...))
+		}
+	}
+	for id, index := range schema.Indexes {
+		if legacyIndexes[index.Name] {
+			continue
+		}
+		delete(schema.Indexes, id)
+		it := ldb.NewIterator(util.BytesPrefix([]byte{id}), nil)
+		for it.Next() {
+			batch.Delete(append([]byte(nil), it.Key()...))
+		}
+		it.Release()
+	}
+	if len(schema.Indexes) != len(legacyIndexes) {
+		t.Fatalf("got %v legacy indexes, want %v", len(schema.Indexes), len(legacyIndexes))
+	}
+	b, err = json.Marshal(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch.Put(schemaKey, b)
+	if err := ldb.Write(batch, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ldb.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = New(dir, baseKey, &Options{
+		ReadOnly: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	got, err := db.Get(context.Background(), chunk.ModeGetRequest, ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Data(), ch.Data()) {
+		t.Errorf("got chunk data %x, want %x", got.Data(), ch.Data())
+	}
+	// indexes that are missing in the schema are empty
+	pinned, err := db.pinIndex.Has(shed.Item{Address: ch.Address()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinned {
+		t.Error("chunk pinned in a database without the pin index")
+	}
+}
+
 // newTestDB is a helper function that constructs a
 // temporary database and returns a cleanup function that must
 // be called to remove the data.
@@ -187,6 +432,75 @@ func generateTestRandomChunk() chunk.Chunk {
 
 // TestGenerateTestRandomChunk validates that
 // generateTestRandomChunk returns random data by comparing
+// TestDB_recoverCorrupted corrupts the LevelDB manifest file and validates
+// that New returns ErrStoreCorrupted with the database path, and that
+// with the RecoverCorrupted option the database is recovered and usable.
+func TestDB_recoverCorrupted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localstore-recover-corrupted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	baseKey := make([]byte, 32)
+	if _, err := rand.Read(baseKey); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := New(dir, baseKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := generateTestRandomChunk()
+	if _, err := db.Put(context.Background(), chunk.ModePutUpload, ch); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	manifests, err := filepath.Glob(filepath.Join(dir, "MANIFEST-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) == 0 {
+		t.Fatal("no manifest file")
+	}
+	for _, m := range manifests {
+		if err := ioutil.WriteFile(m, []byte("corrupted manifest"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = New(dir, baseKey, nil)
+	corrupted, ok := err.(*ErrStoreCorrupted)
+	if !ok {
+		t.Fatalf("got error %v, want ErrStoreCorrupted", err)
+	}
+	if corrupted.Path != dir {
+		t.Errorf("got corrupted path %q, want %q", corrupted.Path, dir)
+	}
+
+	db, err = New(dir, baseKey, &Options{
+		RecoverCorrupted: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	got, err := db.Get(context.Background(), chunk.ModeGetRequest, ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Data(), ch.Data()) {
+		t.Errorf("got chunk data %x, want %x", got.Data(), ch.Data())
+	}
+	if _, err := db.Put(context.Background(), chunk.ModePutUpload, generateTestRandomChunk()); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // two generated chunks.
 func TestGenerateTestRandomChunk(t *testing.T) {
 	c1 := generateTestRandomChunk()
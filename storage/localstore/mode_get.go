@@ -31,8 +31,8 @@ import (
 // Get returns a chunk from the database. If the chunk is
 // not found chunk.ErrChunkNotFound will be returned.
 // All required indexes will be updated required by the
-// Getter Mode. Get is required to implement chunk.Store
-// interface.
+// Getter Mode. Chunks with a passed TTL are not found.
+// Get is required to implement chunk.Store interface.
 func (db *DB) Get(ctx context.Context, mode chunk.ModeGet, addr chunk.Address) (ch chunk.Chunk, err error) {
 	metricName := fmt.Sprintf("localstore.Get.%s", mode)
 
@@ -52,7 +52,23 @@ func (db *DB) Get(ctx context.Context, mode chunk.ModeGet, addr chunk.Address) (
 		}
 		return nil, err
 	}
-	return chunk.NewChunk(out.Address, out.Data), nil
+	ch = chunk.NewChunk(out.Address, out.Data)
+	if db.verifyOnGet && !db.valid(ch) {
+		metrics.GetOrRegisterCounter(metricName+".corrupted", nil).Inc(1)
+		log.Error("localstore get: corrupted chunk", "addr", addr)
+		return nil, ErrChunkCorrupted
+	}
+	return ch, nil
+}
+
+// valid returns true if any of the validators accepts the chunk.
+func (db *DB) valid(ch chunk.Chunk) bool {
+	for _, v := range db.validators {
+		if v.Validate(ch) {
+			return true
+		}
+	}
+	return false
 }
 
 // get returns Item from the retrieval index
@@ -64,28 +80,120 @@ func (db *DB) get(mode chunk.ModeGet, addr chunk.Address) (out shed.Item, err er
 	if err != nil {
 		return out, err
 	}
+	expired, err := db.expired(item)
+	if err != nil {
+		return out, err
+	}
+	if expired {
+		return out, leveldb.ErrNotFound
+	}
 	switch mode {
 	// update the access timestamp and gc index
 	case chunk.ModeGetRequest:
+		db.updateGCInBackground(out)
+
+	// no updates to indexes
+	case chunk.ModeGetSync:
+	case chunk.ModeGetLookup:
+	default:
+		return out, ErrInvalidMode
+	}
+	return out, nil
+}
+
+// GetMulti returns chunks from the database for all provided addresses.
+// Retrieval data is read from a single database snapshot. Chunks that
+// are not found are represented by nil entries in the returned slice
+// at the position of their addresses, without failing the whole call.
+// With the VerifyOnGet option, ErrChunkCorrupted is returned if any of
+// the found chunks is corrupted.
+// All required indexes will be updated required by the Getter Mode.
+// GetMulti is required to implement chunk.Store interface.
+func (db *DB) GetMulti(ctx context.Context, mode chunk.ModeGet, addrs ...chunk.Address) (chunks []chunk.Chunk, err error) {
+	metricName := fmt.Sprintf("localstore.GetMulti.%s", mode)
+
+	metrics.GetOrRegisterCounter(metricName, nil).Inc(1)
+	defer totalTimeMetric(metricName, time.Now())
+
+	defer func() {
+		if err != nil {
+			metrics.GetOrRegisterCounter(metricName+".error", nil).Inc(1)
+		}
+	}()
+
+	switch mode {
+	case chunk.ModeGetRequest, chunk.ModeGetSync, chunk.ModeGetLookup:
+	default:
+		return nil, ErrInvalidMode
+	}
+
+	items := make([]shed.Item, len(addrs))
+	for i, addr := range addrs {
+		items[i] = addressToItem(addr)
+	}
+	found, err := db.retrievalDataIndex.Fill(items)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks = make([]chunk.Chunk, len(items))
+	accessed := make([]shed.Item, 0, len(items))
+	for i, item := range items {
+		if !found[i] {
+			continue
+		}
+		expired, err := db.expired(item)
+		if err != nil {
+			return nil, err
+		}
+		if expired {
+			continue
+		}
+		ch := chunk.NewChunk(item.Address, item.Data)
+		if db.verifyOnGet && !db.valid(ch) {
+			metrics.GetOrRegisterCounter(metricName+".corrupted", nil).Inc(1)
+			log.Error("localstore get multi: corrupted chunk", "addr", item.Address)
+			return nil, ErrChunkCorrupted
+		}
+		chunks[i] = ch
+		accessed = append(accessed, item)
+	}
+
+	// update the access timestamp and gc index
+	if mode == chunk.ModeGetRequest && len(accessed) > 0 {
+		db.updateGCInBackground(accessed...)
+	}
+	return chunks, nil
+}
+
+// updateGCInBackground calls updateGC for provided items
+// in a new goroutine, limited by updateGCSem.
+func (db *DB) updateGCInBackground(items ...shed.Item) {
+	if db.readOnly {
+		// access timestamps are not updated
+		return
+	}
+	if db.updateGCSem != nil {
+		// wait before creating new goroutines
+		// if updateGCSem buffer id full
+		db.updateGCSem <- struct{}{}
+	}
+	db.updateGCWG.Add(1)
+	go func() {
+		defer db.updateGCWG.Done()
 		if db.updateGCSem != nil {
-			// wait before creating new goroutines
-			// if updateGCSem buffer id full
-			db.updateGCSem <- struct{}{}
+			// free a spot in updateGCSem buffer
+			// for a new goroutine
+			defer func() { <-db.updateGCSem }()
 		}
-		db.updateGCWG.Add(1)
-		go func() {
-			defer db.updateGCWG.Done()
-			if db.updateGCSem != nil {
-				// free a spot in updateGCSem buffer
-				// for a new goroutine
-				defer func() { <-db.updateGCSem }()
-			}
 
+		for _, item := range items {
 			metricName := "localstore.updateGC"
 			metrics.GetOrRegisterCounter(metricName, nil).Inc(1)
-			defer totalTimeMetric(metricName, time.Now())
+			start := time.Now()
 
-			err := db.updateGC(out)
+			err := db.updateGC(item)
+			totalTimeMetric(metricName, start)
 			if err != nil {
 				metrics.GetOrRegisterCounter(metricName+".error", nil).Inc(1)
 				log.Error("localstore update gc", "err", err)
@@ -94,15 +202,8 @@ func (db *DB) get(mode chunk.ModeGet, addr chunk.Address) (out shed.Item, err er
 			if testHookUpdateGC != nil {
 				testHookUpdateGC()
 			}
-		}()
-
-	// no updates to indexes
-	case chunk.ModeGetSync:
-	case chunk.ModeGetLookup:
-	default:
-		return out, ErrInvalidMode
-	}
-	return out, nil
+		}
+	}()
 }
 
 // updateGC updates garbage collection index for
@@ -132,13 +233,30 @@ func (db *DB) updateGC(item shed.Item) (err error) {
 		return nil
 	}
 	// delete current entry from the gc index
-	db.gcIndex.DeleteInBatch(batch, item)
+	change, err := db.deleteGCInBatch(batch, item)
+	if err != nil {
+		return err
+	}
 	// update access timestamp
 	item.AccessTimestamp = now()
 	// update retrieve access index
 	db.retrievalAccessIndex.PutInBatch(batch, item)
+	if change == 0 {
+		// pinned chunks are not in the gc index
+		return db.shed.WriteBatch(batch)
+	}
 	// add new entry to gc index
 	db.gcIndex.PutInBatch(batch, item)
+	if db.gcPolicy == GCPolicyLFU {
+		// count the access and add new entry to gc frequency index
+		item.AccessCount, err = db.accessCount(item)
+		if err != nil {
+			return err
+		}
+		item.AccessCount++
+		db.gcAccessCountIndex.PutInBatch(batch, item)
+		db.gcFrequencyIndex.PutInBatch(batch, item)
+	}
 
 	return db.shed.WriteBatch(batch)
 }
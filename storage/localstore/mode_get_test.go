@@ -19,10 +19,14 @@ package localstore
 import (
 	"bytes"
 	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
 	"time"
 
 	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/testutil"
 )
 
 // TestModeGetRequest validates ModeGetRequest index values on the provided DB.
@@ -236,3 +240,205 @@ func TestSetTestHookUpdateGC(t *testing.T) {
 		t.Errorf("got hook value %v, want %v", got, original)
 	}
 }
+
+// TestGetMulti validates that GetMulti returns stored chunks
+// and nil entries for chunks that are not in the database.
+func TestGetMulti(t *testing.T) {
+	db, cleanupFunc := newTestDB(t, nil)
+	defer cleanupFunc()
+
+	chunks := make([]chunk.Chunk, 3)
+	for i := range chunks {
+		chunks[i] = generateTestRandomChunk()
+	}
+	// the chunk in the middle is not stored
+	for _, i := range []int{0, 2} {
+		_, err := db.Put(context.Background(), chunk.ModePutUpload, chunks[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	addrs := make([]chunk.Address, len(chunks))
+	for i, ch := range chunks {
+		addrs[i] = ch.Address()
+	}
+
+	got, err := db.GetMulti(context.Background(), chunk.ModeGetLookup, addrs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(chunks) {
+		t.Fatalf("got %v chunks, want %v", len(got), len(chunks))
+	}
+	for _, i := range []int{0, 2} {
+		if got[i] == nil {
+			t.Fatalf("chunk %v not found", i)
+		}
+		if !bytes.Equal(got[i].Address(), chunks[i].Address()) {
+			t.Errorf("chunk %v: got chunk address %x, want %x", i, got[i].Address(), chunks[i].Address())
+		}
+		if !bytes.Equal(got[i].Data(), chunks[i].Data()) {
+			t.Errorf("chunk %v: got chunk data %x, want %x", i, got[i].Data(), chunks[i].Data())
+		}
+	}
+	if got[1] != nil {
+		t.Errorf("got chunk %x, want nil", got[1].Address())
+	}
+
+	_, err = db.GetMulti(context.Background(), chunk.ModeGet(42), addrs...)
+	if err != ErrInvalidMode {
+		t.Errorf("got error %v, want %v", err, ErrInvalidMode)
+	}
+}
+
+// BenchmarkGetMulti compares getting a number of chunks
+// one by one with Get and in bulk with GetMulti.
+func BenchmarkGetMulti(b *testing.B) {
+	for _, count := range []int{
+		10,
+		100,
+		1000,
+	} {
+		db, cleanupFunc := newTestDB(b, nil)
+
+		addrs := make([]chunk.Address, count)
+		for i := 0; i < count; i++ {
+			ch := generateTestRandomChunk()
+			_, err := db.Put(context.Background(), chunk.ModePutUpload, ch)
+			if err != nil {
+				b.Fatal(err)
+			}
+			addrs[i] = ch.Address()
+		}
+
+		b.Run(fmt.Sprintf("get count %v", count), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				for _, addr := range addrs {
+					_, err := db.Get(context.Background(), chunk.ModeGetLookup, addr)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("get multi count %v", count), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				_, err := db.GetMulti(context.Background(), chunk.ModeGetLookup, addrs...)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		cleanupFunc()
+	}
+}
+
+// TestModeGetVerifyOnGet validates that Get and GetMulti detect chunk
+// data corrupted in the database only if the VerifyOnGet option is set,
+// and that chunks that are not content addressed are accepted by their
+// own validators.
+func TestModeGetVerifyOnGet(t *testing.T) {
+	pool := newVerifyHashPool(chunk.DefaultSize)
+	contentValidator := testValidatorFunc(func(ch chunk.Chunk) bool {
+		return verifyChunk(pool, ch.Address(), ch.Data())
+	})
+	// a chunk that is not content addressed, as a feed chunk
+	other := chunk.NewChunk(testutil.RandomBytes(2, 32), testutil.RandomBytes(3, 100))
+	otherValidator := testValidatorFunc(func(ch chunk.Chunk) bool {
+		return bytes.Equal(ch.Address(), other.Address())
+	})
+
+	for _, tc := range []struct {
+		name        string
+		verifyOnGet bool
+		wantErr     error
+	}{
+		{
+			name:        "enabled",
+			verifyOnGet: true,
+			wantErr:     ErrChunkCorrupted,
+		},
+		{
+			name:        "disabled",
+			verifyOnGet: false,
+			wantErr:     nil,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, cleanupFunc := newTestDB(t, &Options{
+				VerifyOnGet: tc.verifyOnGet,
+				Validators:  []chunk.Validator{contentValidator, otherValidator},
+			})
+			defer cleanupFunc()
+
+			ch := testutil.DeterministicChunks(1, 1)[0]
+
+			for _, c := range []chunk.Chunk{ch, other} {
+				if _, err := db.Put(context.Background(), chunk.ModePutUpload, c); err != nil {
+					t.Fatal(err)
+				}
+				got, err := db.Get(context.Background(), chunk.ModeGetLookup, c.Address())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got.Data(), c.Data()) {
+					t.Errorf("got chunk data %x, want %x", got.Data(), c.Data())
+				}
+			}
+			if _, err := db.GetMulti(context.Background(), chunk.ModeGetLookup, ch.Address(), other.Address()); err != nil {
+				t.Fatal(err)
+			}
+
+			// flip a bit of the stored chunk data
+			item, err := db.retrievalDataIndex.Get(addressToItem(ch.Address()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := make([]byte, len(item.Data))
+			copy(data, item.Data)
+			data[len(data)-1] ^= 1
+			item.Data = data
+			if err := db.retrievalDataIndex.Put(item); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := db.Get(context.Background(), chunk.ModeGetLookup, ch.Address())
+			if err != tc.wantErr {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+			if err == nil && !bytes.Equal(got.Data(), data) {
+				t.Errorf("got chunk data %x, want %x", got.Data(), data)
+			}
+
+			_, err = db.GetMulti(context.Background(), chunk.ModeGetLookup, ch.Address(), other.Address())
+			if err != tc.wantErr {
+				t.Fatalf("get multi: got error %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+
+	t.Run("no validators", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "localstore-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		_, err = New(dir, make([]byte, 32), &Options{
+			VerifyOnGet: true,
+		})
+		if err != ErrNoValidators {
+			t.Fatalf("got error %v, want %v", err, ErrNoValidators)
+		}
+	})
+}
+
+// testValidatorFunc is a chunk.Validator that
+// accepts chunks for which the function returns true.
+type testValidatorFunc func(ch chunk.Chunk) bool
+
+func (f testValidatorFunc) Validate(ch chunk.Chunk) bool {
+	return f(ch)
+}
@@ -22,18 +22,70 @@ import (
 
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethersphere/swarm/chunk"
+	"github.com/syndtr/goleveldb/leveldb"
 )
 
-// Has returns true if the chunk is stored in database.
+// Has returns true if the chunk is stored in database
+// and its TTL, if it has one, is not passed.
 func (db *DB) Has(ctx context.Context, addr chunk.Address) (bool, error) {
 	metricName := "localstore.Has"
 
 	metrics.GetOrRegisterCounter(metricName, nil).Inc(1)
 	defer totalTimeMetric(metricName, time.Now())
 
-	has, err := db.retrievalDataIndex.Has(addressToItem(addr))
+	item := addressToItem(addr)
+	has, err := db.retrievalDataIndex.Has(item)
+	if err == nil && has {
+		var expired bool
+		expired, err = db.expired(item)
+		has = !expired
+	}
 	if err != nil {
 		metrics.GetOrRegisterCounter(metricName+".error", nil).Inc(1)
 	}
 	return has, err
 }
+
+// IsSynced returns true if the chunk is stored in database and
+// it is not waiting in push syncing index to be synced.
+// ErrChunkNotFound is returned if the chunk is not stored.
+func (db *DB) IsSynced(ctx context.Context, addr chunk.Address) (bool, error) {
+	metricName := "localstore.IsSynced"
+
+	metrics.GetOrRegisterCounter(metricName, nil).Inc(1)
+	defer totalTimeMetric(metricName, time.Now())
+
+	item, err := db.retrievalDataIndex.Get(addressToItem(addr))
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return false, chunk.ErrChunkNotFound
+		}
+		metrics.GetOrRegisterCounter(metricName+".error", nil).Inc(1)
+		return false, err
+	}
+	has, err := db.pushIndex.Has(item)
+	if err != nil {
+		metrics.GetOrRegisterCounter(metricName+".error", nil).Inc(1)
+		return false, err
+	}
+	return !has, nil
+}
+
+// StoredAt returns the time when the chunk was stored in database.
+// ErrChunkNotFound is returned if the chunk is not stored.
+func (db *DB) StoredAt(addr chunk.Address) (time.Time, error) {
+	metricName := "localstore.StoredAt"
+
+	metrics.GetOrRegisterCounter(metricName, nil).Inc(1)
+	defer totalTimeMetric(metricName, time.Now())
+
+	item, err := db.retrievalDataIndex.Get(addressToItem(addr))
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return time.Time{}, chunk.ErrChunkNotFound
+		}
+		metrics.GetOrRegisterCounter(metricName+".error", nil).Inc(1)
+		return time.Time{}, err
+	}
+	return time.Unix(0, item.StoreTimestamp), nil
+}
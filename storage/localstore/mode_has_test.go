@@ -19,6 +19,7 @@ package localstore
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/ethersphere/swarm/chunk"
 )
@@ -54,3 +55,72 @@ func TestHas(t *testing.T) {
 		t.Error("unexpected chunk is found")
 	}
 }
+
+// TestIsSynced validates that IsSynced is returning false for
+// an uploaded chunk until it is set as synced.
+func TestIsSynced(t *testing.T) {
+	db, cleanupFunc := newTestDB(t, nil)
+	defer cleanupFunc()
+
+	ch := generateTestRandomChunk()
+
+	_, err := db.Put(context.Background(), chunk.ModePutUpload, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	synced, err := db.IsSynced(context.Background(), ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if synced {
+		t.Error("uploaded chunk is synced")
+	}
+
+	err = db.Set(context.Background(), chunk.ModeSetSync, ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	synced, err = db.IsSynced(context.Background(), ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !synced {
+		t.Error("chunk is not synced")
+	}
+
+	missingChunk := generateTestRandomChunk()
+
+	_, err = db.IsSynced(context.Background(), missingChunk.Address())
+	if err != chunk.ErrChunkNotFound {
+		t.Errorf("got error %v, want %v", err, chunk.ErrChunkNotFound)
+	}
+}
+
+// TestStoredAt validates that StoredAt returns the time when the chunk
+// was stored and ErrChunkNotFound for a chunk that is not stored.
+func TestStoredAt(t *testing.T) {
+	db, cleanupFunc := newTestDB(t, nil)
+	defer cleanupFunc()
+
+	ch := generateTestRandomChunk()
+
+	_, err := db.Put(context.Background(), chunk.ModePutUpload, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storedAt, err := db.StoredAt(ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(storedAt); d < 0 || d > time.Second {
+		t.Errorf("got stored at %v, want within a second of now", storedAt)
+	}
+
+	_, err = db.StoredAt(generateTestRandomChunk().Address())
+	if err != chunk.ErrChunkNotFound {
+		t.Errorf("got error %v, want %v", err, chunk.ErrChunkNotFound)
+	}
+}
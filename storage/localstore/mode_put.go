@@ -37,19 +37,48 @@ func (db *DB) Put(ctx context.Context, mode chunk.ModePut, ch chunk.Chunk) (exis
 	metrics.GetOrRegisterCounter(metricName, nil).Inc(1)
 	defer totalTimeMetric(metricName, time.Now())
 
-	exists, err = db.put(mode, chunkToItem(ch))
+	e, err := db.put(mode, chunkToItem(ch))
+	if err != nil {
+		metrics.GetOrRegisterCounter(metricName+".error", nil).Inc(1)
+		return false, err
+	}
+	return e[0], nil
+}
+
+// PutBatch stores multiple chunks to database in a single batch and
+// depending on the Putter mode, it updates required indexes. It is
+// faster than storing chunks one by one, for example when importing
+// a large number of chunks. Returned slice holds the information if
+// the chunk with the same index already existed in the database.
+// Chunks with the same address are stored only once. Very large
+// batches, above the LevelDB write buffer size, may be slower than
+// storing chunks in multiple smaller batches.
+func (db *DB) PutBatch(ctx context.Context, mode chunk.ModePut, chs []chunk.Chunk) (exists []bool, err error) {
+	metricName := fmt.Sprintf("localstore.PutBatch.%s", mode)
+
+	metrics.GetOrRegisterCounter(metricName, nil).Inc(1)
+	defer totalTimeMetric(metricName, time.Now())
+
+	items := make([]shed.Item, len(chs))
+	for i, ch := range chs {
+		items[i] = chunkToItem(ch)
+	}
+	exists, err = db.put(mode, items...)
 	if err != nil {
 		metrics.GetOrRegisterCounter(metricName+".error", nil).Inc(1)
 	}
 	return exists, err
 }
 
-// put stores Item to database and updates other
-// indexes. It acquires lockAddr to protect two calls
-// of this function for the same address in parallel.
-// Item fields Address and Data must not be
-// with their nil values.
-func (db *DB) put(mode chunk.ModePut, item shed.Item) (exists bool, err error) {
+// put stores Items to database in a single batch and
+// updates other indexes. It acquires batchMu to protect
+// parallel updates. Item fields Address and Data must not
+// be with their nil values.
+func (db *DB) put(mode chunk.ModePut, items ...shed.Item) (exists []bool, err error) {
+	if db.readOnly {
+		return nil, ErrReadOnly
+	}
+
 	// protect parallel updates
 	db.batchMu.Lock()
 	defer db.batchMu.Unlock()
@@ -58,10 +87,61 @@ func (db *DB) put(mode chunk.ModePut, item shed.Item) (exists bool, err error) {
 
 	// variables that provide information for operations
 	// to be done after write batch function successfully executes
-	var gcSizeChange int64   // number to add or subtract from gcSize
-	var triggerPullFeed bool // signal pull feed subscriptions to iterate
-	var triggerPushFeed bool // signal push feed subscriptions to iterate
+	var gcSizeChange int64                      // number to add or subtract from gcSize
+	triggerPullFeed := make(map[uint8]struct{}) // signal pull feed subscriptions to iterate
+	var triggerPushFeed bool                    // signal push feed subscriptions to iterate
+
+	// bin IDs incremented in this batch, as they
+	// are not yet written to the database
+	binIDs := make(map[uint8]uint64)
+	// chunks already put in this batch
+	seen := make(map[string]struct{})
+
+	exists = make([]bool, len(items))
+	for i, item := range items {
+		if _, ok := seen[string(item.Address)]; ok {
+			exists[i] = true
+			continue
+		}
+		seen[string(item.Address)] = struct{}{}
+
+		var c int64
+		exists[i], c, err = db.putItemInBatch(batch, binIDs, mode, item)
+		if err != nil {
+			return nil, err
+		}
+		gcSizeChange += c
+		if !exists[i] && (mode == chunk.ModePutUpload || mode == chunk.ModePutSync) {
+			triggerPullFeed[db.po(item.Address)] = struct{}{}
+			if mode == chunk.ModePutUpload {
+				triggerPushFeed = true
+			}
+		}
+	}
+
+	err = db.incGCSizeInBatch(batch, gcSizeChange)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.shed.WriteBatch(batch)
+	if err != nil {
+		return nil, err
+	}
+	for bin := range triggerPullFeed {
+		db.triggerPullSubscriptions(bin)
+	}
+	if triggerPushFeed {
+		db.triggerPushSubscriptions()
+	}
+	return exists, nil
+}
 
+// putItemInBatch updates indexes for a single Item in the batch
+// depending on the Putter mode. It returns if the Item already
+// exists in the database and the change of the gcSize. Bin IDs
+// map holds bin IDs that are incremented in the same batch.
+func (db *DB) putItemInBatch(batch *leveldb.Batch, binIDs map[uint8]uint64, mode chunk.ModePut, item shed.Item) (exists bool, gcSizeChange int64, err error) {
 	switch mode {
 	case chunk.ModePutRequest:
 		// put to indexes: retrieve, gc; it does not enter the syncpool
@@ -77,7 +157,7 @@ func (db *DB) put(mode chunk.ModePut, item shed.Item) (exists bool, err error) {
 			exists = false
 			// no chunk accesses
 		default:
-			return false, err
+			return false, 0, err
 		}
 		i, err = db.retrievalDataIndex.Get(item)
 		switch err {
@@ -89,20 +169,23 @@ func (db *DB) put(mode chunk.ModePut, item shed.Item) (exists bool, err error) {
 			// no chunk accesses
 			exists = false
 		default:
-			return false, err
+			return false, 0, err
 		}
 		if item.AccessTimestamp != 0 {
 			// delete current entry from the gc index
-			db.gcIndex.DeleteInBatch(batch, item)
-			gcSizeChange--
+			change, err := db.deleteGCInBatch(batch, item)
+			if err != nil {
+				return false, 0, err
+			}
+			gcSizeChange += change
 		}
 		if item.StoreTimestamp == 0 {
 			item.StoreTimestamp = now()
 		}
 		if item.BinID == 0 {
-			item.BinID, err = db.binIDs.IncInBatch(batch, uint64(db.po(item.Address)))
+			item.BinID, err = db.incBinIDInBatch(batch, binIDs, db.po(item.Address))
 			if err != nil {
-				return false, err
+				return false, 0, err
 			}
 		}
 		// update access timestamp
@@ -110,29 +193,31 @@ func (db *DB) put(mode chunk.ModePut, item shed.Item) (exists bool, err error) {
 		// update retrieve access index
 		db.retrievalAccessIndex.PutInBatch(batch, item)
 		// add new entry to gc index
-		db.gcIndex.PutInBatch(batch, item)
-		gcSizeChange++
+		change, err := db.putGCInBatch(batch, item)
+		if err != nil {
+			return false, 0, err
+		}
+		gcSizeChange += change
 
 		db.retrievalDataIndex.PutInBatch(batch, item)
+		db.putSoftExpiryInBatch(batch, item)
 
 	case chunk.ModePutUpload:
 		// put to indexes: retrieve, push, pull
 
 		exists, err = db.retrievalDataIndex.Has(item)
 		if err != nil {
-			return false, err
+			return false, 0, err
 		}
 		if !exists {
 			item.StoreTimestamp = now()
-			item.BinID, err = db.binIDs.IncInBatch(batch, uint64(db.po(item.Address)))
+			item.BinID, err = db.incBinIDInBatch(batch, binIDs, db.po(item.Address))
 			if err != nil {
-				return false, err
+				return false, 0, err
 			}
 			db.retrievalDataIndex.PutInBatch(batch, item)
 			db.pullIndex.PutInBatch(batch, item)
-			triggerPullFeed = true
 			db.pushIndex.PutInBatch(batch, item)
-			triggerPushFeed = true
 		}
 
 	case chunk.ModePutSync:
@@ -140,37 +225,40 @@ func (db *DB) put(mode chunk.ModePut, item shed.Item) (exists bool, err error) {
 
 		exists, err = db.retrievalDataIndex.Has(item)
 		if err != nil {
-			return exists, err
+			return exists, 0, err
 		}
 		if !exists {
 			item.StoreTimestamp = now()
-			item.BinID, err = db.binIDs.IncInBatch(batch, uint64(db.po(item.Address)))
+			item.BinID, err = db.incBinIDInBatch(batch, binIDs, db.po(item.Address))
 			if err != nil {
-				return false, err
+				return false, 0, err
 			}
 			db.retrievalDataIndex.PutInBatch(batch, item)
 			db.pullIndex.PutInBatch(batch, item)
-			triggerPullFeed = true
 		}
 
 	default:
-		return false, ErrInvalidMode
+		return false, 0, ErrInvalidMode
 	}
-
-	err = db.incGCSizeInBatch(batch, gcSizeChange)
-	if err != nil {
-		return false, err
+	if !exists {
+		db.putExpiryInBatch(batch, item)
 	}
+	return exists, gcSizeChange, nil
+}
 
-	err = db.shed.WriteBatch(batch)
-	if err != nil {
-		return false, err
-	}
-	if triggerPullFeed {
-		db.triggerPullSubscriptions(db.po(item.Address))
-	}
-	if triggerPushFeed {
-		db.triggerPushSubscriptions()
+// incBinIDInBatch increments the bin ID for the bin in the batch and
+// returns the new value. Bin IDs that are already incremented in the
+// same batch are kept in the provided map.
+func (db *DB) incBinIDInBatch(batch *leveldb.Batch, binIDs map[uint8]uint64, bin uint8) (id uint64, err error) {
+	id, ok := binIDs[bin]
+	if !ok {
+		id, err = db.binIDs.Get(uint64(bin))
+		if err != nil && err != leveldb.ErrNotFound {
+			return 0, err
+		}
 	}
-	return exists, nil
+	id++
+	db.binIDs.PutInBatch(batch, uint64(bin), id)
+	binIDs[bin] = id
+	return id, nil
 }
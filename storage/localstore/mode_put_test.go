@@ -265,6 +265,74 @@ func TestModePut_sameChunk(t *testing.T) {
 	}
 }
 
+// TestModePutBatch validates that chunks put in a single batch
+// are retrievable and that indexes, bin IDs and gc size are
+// updated correctly for all modes.
+func TestModePutBatch(t *testing.T) {
+	for _, mode := range []chunk.ModePut{
+		chunk.ModePutRequest,
+		chunk.ModePutSync,
+		chunk.ModePutUpload,
+	} {
+		t.Run(mode.String(), func(t *testing.T) {
+			db, cleanupFunc := newTestDB(t, nil)
+			defer cleanupFunc()
+
+			const count = 100
+			chunks := make([]chunk.Chunk, count)
+			for i := range chunks {
+				chunks[i] = generateTestRandomChunk()
+			}
+			// one chunk is already stored
+			if _, err := db.Put(context.Background(), mode, chunks[0]); err != nil {
+				t.Fatal(err)
+			}
+			// and one is put twice in the same batch
+			batch := append(chunks, chunks[1])
+
+			exists, err := db.PutBatch(context.Background(), mode, batch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(exists) != len(batch) {
+				t.Fatalf("got %v exists values, want %v", len(exists), len(batch))
+			}
+			for i, e := range exists {
+				want := i == 0 || i == count
+				if e != want {
+					t.Errorf("chunk %v: got exists %v, want %v", i, e, want)
+				}
+			}
+
+			for _, ch := range chunks {
+				got, err := db.Get(context.Background(), chunk.ModeGetLookup, ch.Address())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got.Data(), ch.Data()) {
+					t.Fatalf("got chunk %s data %x, want %x", ch.Address(), got.Data(), ch.Data())
+				}
+			}
+
+			t.Run("retrieve data index count", newItemsCountTest(db.retrievalDataIndex, count))
+
+			switch mode {
+			case chunk.ModePutRequest:
+				t.Run("gc index count", newItemsCountTest(db.gcIndex, count))
+
+				t.Run("gc size", newIndexGCSizeTest(db))
+			case chunk.ModePutSync:
+				// every chunk has a unique bin ID in its bin
+				t.Run("pull index count", newItemsCountTest(db.pullIndex, count))
+			case chunk.ModePutUpload:
+				t.Run("pull index count", newItemsCountTest(db.pullIndex, count))
+
+				t.Run("push index count", newItemsCountTest(db.pushIndex, count))
+			}
+		})
+	}
+}
+
 // BenchmarkPutUpload runs a series of benchmarks that upload
 // a specific number of chunks in parallel.
 //
@@ -360,3 +428,60 @@ func benchmarkPutUpload(b *testing.B, o *Options, count, maxParallelUploads int)
 		}
 	}
 }
+
+// BenchmarkPutBatch compares the time needed to put chunks
+// to the database in a single batch and one by one.
+//
+// # go test -run=none github.com/ethersphere/swarm/storage/localstore -bench BenchmarkPutBatch -benchtime=3x -v
+//
+// goos: linux
+// goarch: amd64
+// pkg: github.com/ethersphere/swarm/storage/localstore
+// BenchmarkPutBatch/count_100_batch_true         	       3	   5576665 ns/op
+// BenchmarkPutBatch/count_100_batch_false        	       3	  18216444 ns/op
+// BenchmarkPutBatch/count_1000_batch_true        	       3	  22666370 ns/op
+// BenchmarkPutBatch/count_1000_batch_false       	       3	  70738325 ns/op
+// BenchmarkPutBatch/count_10000_batch_true       	       3	4094289662 ns/op
+// BenchmarkPutBatch/count_10000_batch_false      	       3	3302392228 ns/op
+func BenchmarkPutBatch(b *testing.B) {
+	for _, count := range []int{
+		100,
+		1000,
+		10000,
+	} {
+		for _, batch := range []bool{true, false} {
+			name := fmt.Sprintf("count %v batch %v", count, batch)
+			b.Run(name, func(b *testing.B) {
+				for n := 0; n < b.N; n++ {
+					benchmarkPutBatch(b, count, batch)
+				}
+			})
+		}
+	}
+}
+
+// benchmarkPutBatch runs a benchmark by uploading a specific number
+// of chunks in a single batch or sequentially.
+func benchmarkPutBatch(b *testing.B, count int, batch bool) {
+	b.StopTimer()
+	db, cleanupFunc := newTestDB(b, nil)
+	defer cleanupFunc()
+
+	chunks := make([]chunk.Chunk, count)
+	for i := 0; i < count; i++ {
+		chunks[i] = generateTestRandomChunk()
+	}
+	b.StartTimer()
+
+	if batch {
+		if _, err := db.PutBatch(context.Background(), chunk.ModePutUpload, chunks); err != nil {
+			b.Fatal(err)
+		}
+		return
+	}
+	for _, ch := range chunks {
+		if _, err := db.Put(context.Background(), chunk.ModePutUpload, ch); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -48,6 +48,10 @@ func (db *DB) Set(ctx context.Context, mode chunk.ModeSet, addr chunk.Address) (
 // It acquires lockAddr to protect two calls
 // of this function for the same address in parallel.
 func (db *DB) set(mode chunk.ModeSet, addr chunk.Address) (err error) {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+
 	// protect parallel updates
 	db.batchMu.Lock()
 	defer db.batchMu.Unlock()
@@ -58,6 +62,7 @@ func (db *DB) set(mode chunk.ModeSet, addr chunk.Address) (err error) {
 	// to be done after write batch function successfully executes
 	var gcSizeChange int64   // number to add or subtract from gcSize
 	var triggerPullFeed bool // signal pull feed subscriptions to iterate
+	var syncedTag uint32     // tag uid of the chunk removed from the push index
 
 	item := addressToItem(addr)
 
@@ -89,8 +94,11 @@ func (db *DB) set(mode chunk.ModeSet, addr chunk.Address) (err error) {
 		switch err {
 		case nil:
 			item.AccessTimestamp = i.AccessTimestamp
-			db.gcIndex.DeleteInBatch(batch, item)
-			gcSizeChange--
+			change, err := db.deleteGCInBatch(batch, item)
+			if err != nil {
+				return err
+			}
+			gcSizeChange += change
 		case leveldb.ErrNotFound:
 			// the chunk is not accessed before
 		default:
@@ -100,8 +108,11 @@ func (db *DB) set(mode chunk.ModeSet, addr chunk.Address) (err error) {
 		db.retrievalAccessIndex.PutInBatch(batch, item)
 		db.pullIndex.PutInBatch(batch, item)
 		triggerPullFeed = true
-		db.gcIndex.PutInBatch(batch, item)
-		gcSizeChange++
+		change, err := db.putGCInBatch(batch, item)
+		if err != nil {
+			return err
+		}
+		gcSizeChange += change
 
 	case chunk.ModeSetSync:
 		// delete from push, insert to gc
@@ -124,12 +135,27 @@ func (db *DB) set(mode chunk.ModeSet, addr chunk.Address) (err error) {
 		item.StoreTimestamp = i.StoreTimestamp
 		item.BinID = i.BinID
 
+		// the tag is counted only once, when the chunk
+		// is removed from the push index
+		i, err = db.pushIndex.Get(item)
+		switch err {
+		case nil:
+			syncedTag = i.Tag
+		case leveldb.ErrNotFound:
+			// the chunk is already synced or not uploaded
+		default:
+			return err
+		}
+
 		i, err = db.retrievalAccessIndex.Get(item)
 		switch err {
 		case nil:
 			item.AccessTimestamp = i.AccessTimestamp
-			db.gcIndex.DeleteInBatch(batch, item)
-			gcSizeChange--
+			change, err := db.deleteGCInBatch(batch, item)
+			if err != nil {
+				return err
+			}
+			gcSizeChange += change
 		case leveldb.ErrNotFound:
 			// the chunk is not accessed before
 		default:
@@ -138,8 +164,11 @@ func (db *DB) set(mode chunk.ModeSet, addr chunk.Address) (err error) {
 		item.AccessTimestamp = now()
 		db.retrievalAccessIndex.PutInBatch(batch, item)
 		db.pushIndex.DeleteInBatch(batch, item)
-		db.gcIndex.PutInBatch(batch, item)
-		gcSizeChange++
+		change, err := db.putGCInBatch(batch, item)
+		if err != nil {
+			return err
+		}
+		gcSizeChange += change
 
 	case chunk.ModeSetRemove:
 		// delete from retrieve, pull, gc
@@ -166,7 +195,13 @@ func (db *DB) set(mode chunk.ModeSet, addr chunk.Address) (err error) {
 		db.retrievalDataIndex.DeleteInBatch(batch, item)
 		db.retrievalAccessIndex.DeleteInBatch(batch, item)
 		db.pullIndex.DeleteInBatch(batch, item)
-		db.gcIndex.DeleteInBatch(batch, item)
+		if _, err := db.deleteGCInBatch(batch, item); err != nil {
+			return err
+		}
+		db.pinIndex.DeleteInBatch(batch, item)
+		db.gcAccessCountIndex.DeleteInBatch(batch, item)
+		db.retrievalExpiryIndex.DeleteInBatch(batch, item)
+		db.retrievalSoftExpiryIndex.DeleteInBatch(batch, item)
 		// a check is needed for decrementing gcSize
 		// as delete is not reporting if the key/value pair
 		// is deleted or not
@@ -190,5 +225,13 @@ func (db *DB) set(mode chunk.ModeSet, addr chunk.Address) (err error) {
 	if triggerPullFeed {
 		db.triggerPullSubscriptions(db.po(item.Address))
 	}
+	if syncedTag != 0 && db.tags != nil {
+		// pull syncing marks a chunk as synced when a peer
+		// accepts it, so it is counted as both sent and synced
+		if t, err := db.tags.Get(syncedTag); err == nil {
+			t.IncChunk(chunk.StateSent, item.Address)
+			t.IncChunk(chunk.StateSynced, item.Address)
+		}
+	}
 	return nil
 }
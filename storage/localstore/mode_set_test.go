@@ -86,6 +86,39 @@ func TestModeSetSync(t *testing.T) {
 	t.Run("gc size", newIndexGCSizeTest(db))
 }
 
+// TestModeSetSync_tags validates that ModeSetSync increments
+// Sent and Synced counters of the chunk tag only once.
+func TestModeSetSync_tags(t *testing.T) {
+	tags := chunk.NewTags()
+	db, cleanupFunc := newTestDB(t, &Options{Tags: tags})
+	defer cleanupFunc()
+
+	tag, err := tags.New("test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := generateTestRandomChunk().WithTagID(tag.Uid)
+
+	_, err = db.Put(context.Background(), chunk.ModePutUpload, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = db.Set(context.Background(), chunk.ModeSetSync, ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, state := range []chunk.State{chunk.StateSent, chunk.StateSynced} {
+		if got := tag.Get(state); got != 1 {
+			t.Errorf("got state %v count %v, want 1", state, got)
+		}
+	}
+}
+
 // TestModeSetRemove validates ModeSetRemove index values on the provided DB.
 func TestModeSetRemove(t *testing.T) {
 	db, cleanupFunc := newTestDB(t, nil)
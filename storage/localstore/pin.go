@@ -0,0 +1,157 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package localstore
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/shed"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Pin marks the chunk with the provided address as pinned.
+// Pinned chunks are kept in the database and are never
+// removed by the garbage collection, until they are unpinned.
+// ErrChunkNotFound is returned if the chunk is not stored.
+func (db *DB) Pin(addr chunk.Address) (err error) {
+	metricName := "localstore.Pin"
+
+	metrics.GetOrRegisterCounter(metricName, nil).Inc(1)
+	defer totalTimeMetric(metricName, time.Now())
+	defer func() {
+		if err != nil && err != chunk.ErrChunkNotFound {
+			metrics.GetOrRegisterCounter(metricName+".error", nil).Inc(1)
+		}
+	}()
+
+	if db.readOnly {
+		return ErrReadOnly
+	}
+
+	// protect from garbage collection removing
+	// the chunk before it is pinned
+	db.batchMu.Lock()
+	defer db.batchMu.Unlock()
+
+	item, err := db.gcItem(addr)
+	if err != nil {
+		return err
+	}
+	pinned, err := db.pinIndex.Has(item)
+	if err != nil || pinned {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	var gcSizeChange int64
+	if item.AccessTimestamp != 0 {
+		// pinned chunks are removed from the gc index,
+		// so that garbage collection does not iterate them
+		gcSizeChange, err = db.deleteGCInBatch(batch, item)
+		if err != nil {
+			return err
+		}
+	}
+	db.pinIndex.PutInBatch(batch, item)
+	if err := db.incGCSizeInBatch(batch, gcSizeChange); err != nil {
+		return err
+	}
+	return db.shed.WriteBatch(batch)
+}
+
+// Unpin removes the pin from the chunk with the provided address,
+// making it eligible for garbage collection again. Unpinning a chunk
+// that is not pinned is not an error.
+func (db *DB) Unpin(addr chunk.Address) (err error) {
+	metricName := "localstore.Unpin"
+
+	metrics.GetOrRegisterCounter(metricName, nil).Inc(1)
+	defer totalTimeMetric(metricName, time.Now())
+	defer func() {
+		if err != nil {
+			metrics.GetOrRegisterCounter(metricName+".error", nil).Inc(1)
+		}
+	}()
+
+	if db.readOnly {
+		return ErrReadOnly
+	}
+
+	db.batchMu.Lock()
+	defer db.batchMu.Unlock()
+
+	item, err := db.gcItem(addr)
+	if err == chunk.ErrChunkNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	pinned, err := db.pinIndex.Has(item)
+	if err != nil || !pinned {
+		return err
+	}
+	err = db.pinIndex.Delete(item)
+	if err != nil {
+		return err
+	}
+	if item.AccessTimestamp == 0 {
+		// chunk is not yet synced
+		// do not add it to the gc index
+		return nil
+	}
+	batch := new(leveldb.Batch)
+	gcSizeChange, err := db.putGCInBatch(batch, item)
+	if err != nil {
+		return err
+	}
+	if err := db.incGCSizeInBatch(batch, gcSizeChange); err != nil {
+		return err
+	}
+	return db.shed.WriteBatch(batch)
+}
+
+// gcItem returns the item for the chunk with the provided address with
+// the fields that are required for gc index set. AccessTimestamp is zero
+// if the chunk is not in the gc index. ErrChunkNotFound is returned if
+// the chunk is not stored. This function must be called under batchMu
+// lock.
+func (db *DB) gcItem(addr chunk.Address) (item shed.Item, err error) {
+	item = addressToItem(addr)
+	i, err := db.retrievalDataIndex.Get(item)
+	switch err {
+	case nil:
+		item.StoreTimestamp = i.StoreTimestamp
+		item.BinID = i.BinID
+	case leveldb.ErrNotFound:
+		return item, chunk.ErrChunkNotFound
+	default:
+		return item, err
+	}
+	i, err = db.retrievalAccessIndex.Get(item)
+	switch err {
+	case nil:
+		item.AccessTimestamp = i.AccessTimestamp
+	case leveldb.ErrNotFound:
+		// the chunk is not accessed before
+	default:
+		return item, err
+	}
+	return item, nil
+}
@@ -0,0 +1,187 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package localstore
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethersphere/swarm/chunk"
+)
+
+// TestDB_Pin validates that a pinned chunk is not removed
+// by the garbage collection and that it is collected
+// once it is unpinned.
+func TestDB_Pin(t *testing.T) {
+	db, cleanupFunc := newTestDB(t, &Options{
+		Capacity: 100,
+	})
+	testHookCollectGarbageChan := make(chan uint64)
+	defer setTestHookCollectGarbage(func(collectedCount uint64) {
+		select {
+		case testHookCollectGarbageChan <- collectedCount:
+		case <-db.close:
+		}
+	})()
+	defer cleanupFunc()
+
+	uploadSyncChunk := func() chunk.Chunk {
+		ch := generateTestRandomChunk()
+
+		_, err := db.Put(context.Background(), chunk.ModePutUpload, ch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = db.Set(context.Background(), chunk.ModeSetSync, ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ch
+	}
+
+	waitGC := func() {
+		t.Helper()
+
+		for {
+			select {
+			case <-testHookCollectGarbageChan:
+			case <-time.After(10 * time.Second):
+				t.Fatal("collect garbage timeout")
+			}
+			gcSize, err := db.gcSize.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gcSize == db.gcTarget() {
+				break
+			}
+		}
+	}
+
+	// the first synced chunk has the oldest access timestamp
+	// and would be the first one to be garbage collected
+	pinned := uploadSyncChunk()
+
+	err := db.Pin(pinned.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 150; i++ {
+		uploadSyncChunk()
+	}
+
+	waitGC()
+
+	t.Run("get pinned chunk", func(t *testing.T) {
+		got, err := db.Get(context.Background(), chunk.ModeGetLookup, pinned.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Address(), pinned.Address()) {
+			t.Errorf("got chunk address %x, want %x", got.Address(), pinned.Address())
+		}
+	})
+
+	t.Run("gc size", newIndexGCSizeTest(db))
+
+	err = db.Unpin(pinned.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 150; i++ {
+		uploadSyncChunk()
+	}
+
+	waitGC()
+
+	t.Run("get unpinned chunk", func(t *testing.T) {
+		_, err := db.Get(context.Background(), chunk.ModeGetLookup, pinned.Address())
+		if err != chunk.ErrChunkNotFound {
+			t.Errorf("got error %v, want %v", err, chunk.ErrChunkNotFound)
+		}
+	})
+}
+
+// TestDB_Pin_notFound validates that pinning a chunk
+// that is not stored returns ErrChunkNotFound.
+func TestDB_Pin_notFound(t *testing.T) {
+	db, cleanupFunc := newTestDB(t, nil)
+	defer cleanupFunc()
+
+	addr := generateTestRandomChunk().Address()
+
+	err := db.Pin(addr)
+	if err != chunk.ErrChunkNotFound {
+		t.Errorf("got error %v, want %v", err, chunk.ErrChunkNotFound)
+	}
+
+	has, err := db.pinIndex.Has(addressToItem(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has {
+		t.Error("chunk is pinned")
+	}
+}
+
+// TestDB_Pin_gcIndex validates that a pinned chunk is removed from
+// the gc index and gc size, that it is added back when it is unpinned,
+// and that removing a pinned chunk removes its pin.
+func TestDB_Pin_gcIndex(t *testing.T) {
+	db, cleanupFunc := newTestDB(t, nil)
+	defer cleanupFunc()
+
+	ch := generateTestRandomChunk()
+	_, err := db.Put(context.Background(), chunk.ModePutUpload, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Set(context.Background(), chunk.ModeSetSync, ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Pin(ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("pinned gc index count", newItemsCountTest(db.gcIndex, 0))
+	t.Run("pinned gc size", newIndexGCSizeTest(db))
+
+	err = db.Unpin(ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("unpinned gc index count", newItemsCountTest(db.gcIndex, 1))
+	t.Run("unpinned gc size", newIndexGCSizeTest(db))
+
+	err = db.Pin(ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Set(context.Background(), chunk.ModeSetRemove, ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("removed pin index count", newItemsCountTest(db.pinIndex, 0))
+	t.Run("removed gc size", newIndexGCSizeTest(db))
+}
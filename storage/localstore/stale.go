@@ -0,0 +1,87 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package localstore
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/shed"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// putSoftExpiryInBatch sets the soft expiry of the item to the current
+// time increased by the soft TTL, if the soft TTL is set.
+func (db *DB) putSoftExpiryInBatch(batch *leveldb.Batch, item shed.Item) {
+	if db.softTTL <= 0 {
+		return
+	}
+	item.Expiry = now() + int64(db.softTTL)
+	db.retrievalSoftExpiryIndex.PutInBatch(batch, item)
+}
+
+// Stale returns true if the chunk with the provided address is stored
+// and its soft expiry is passed. Stale chunks can still be retrieved.
+func (db *DB) Stale(addr chunk.Address) (stale bool, err error) {
+	metrics.GetOrRegisterCounter("localstore.Stale", nil).Inc(1)
+
+	i, err := db.retrievalSoftExpiryIndex.Get(addressToItem(addr))
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return i.Expiry <= now(), nil
+}
+
+// MarkStale sets the soft expiry of the chunk with the provided address
+// to the current time, so that it is stale until it is retrieved from
+// the network again. ErrChunkNotFound is returned if the chunk is not
+// stored.
+func (db *DB) MarkStale(addr chunk.Address) (err error) {
+	metricName := "localstore.MarkStale"
+
+	metrics.GetOrRegisterCounter(metricName, nil).Inc(1)
+	defer totalTimeMetric(metricName, time.Now())
+	defer func() {
+		if err != nil && err != chunk.ErrChunkNotFound {
+			metrics.GetOrRegisterCounter(metricName+".error", nil).Inc(1)
+		}
+	}()
+
+	if db.readOnly {
+		return ErrReadOnly
+	}
+
+	// protect from garbage collection removing
+	// the chunk before it is marked
+	db.batchMu.Lock()
+	defer db.batchMu.Unlock()
+
+	item := addressToItem(addr)
+	has, err := db.retrievalDataIndex.Has(item)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return chunk.ErrChunkNotFound
+	}
+	item.Expiry = now()
+	return db.retrievalSoftExpiryIndex.Put(item)
+}
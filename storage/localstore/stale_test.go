@@ -0,0 +1,96 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package localstore
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethersphere/swarm/chunk"
+)
+
+// TestDB_Stale validates that chunks retrieved from the network become
+// stale when the soft TTL passes or when they are marked stale, and that
+// retrieving them again renews their soft expiry.
+func TestDB_Stale(t *testing.T) {
+	var timestamp int64 = 1000
+	defer setNow(func() int64 {
+		return atomic.LoadInt64(&timestamp)
+	})()
+
+	db, cleanupFunc := newTestDB(t, &Options{
+		SoftTTL: time.Hour,
+	})
+	defer cleanupFunc()
+
+	ctx := context.Background()
+
+	checkStale := func(t *testing.T, addr chunk.Address, want bool) {
+		t.Helper()
+
+		stale, err := db.Stale(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stale != want {
+			t.Errorf("got stale %v, want %v", stale, want)
+		}
+	}
+
+	requested := generateTestRandomChunk()
+	if _, err := db.Put(ctx, chunk.ModePutRequest, requested); err != nil {
+		t.Fatal(err)
+	}
+	uploaded := generateTestRandomChunk()
+	if _, err := db.Put(ctx, chunk.ModePutUpload, uploaded); err != nil {
+		t.Fatal(err)
+	}
+
+	checkStale(t, requested.Address(), false)
+	checkStale(t, uploaded.Address(), false)
+
+	atomic.StoreInt64(&timestamp, 1000+int64(2*time.Hour))
+
+	checkStale(t, requested.Address(), true)
+	checkStale(t, uploaded.Address(), false)
+
+	// stale chunks are still retrieved
+	if _, err := db.Get(ctx, chunk.ModeGetRequest, requested.Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Put(ctx, chunk.ModePutRequest, requested); err != nil {
+		t.Fatal(err)
+	}
+	checkStale(t, requested.Address(), false)
+
+	if err := db.MarkStale(uploaded.Address()); err != nil {
+		t.Fatal(err)
+	}
+	checkStale(t, uploaded.Address(), true)
+
+	if err := db.MarkStale(generateTestRandomChunk().Address()); err != chunk.ErrChunkNotFound {
+		t.Errorf("got error %v, want %v", err, chunk.ErrChunkNotFound)
+	}
+
+	if err := db.Set(ctx, chunk.ModeSetRemove, uploaded.Address()); err != nil {
+		t.Fatal(err)
+	}
+	checkStale(t, uploaded.Address(), false)
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package localstore
+
+import (
+	"encoding"
+	"encoding/json"
+
+	"github.com/ethersphere/swarm/shed"
+	"github.com/ethersphere/swarm/state"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// stateStore stores values in the localstore LevelDB database,
+// separately from chunks, so that no additional database needs
+// to be opened for them.
+type stateStore struct {
+	db *DB
+}
+
+// stateStore implements state.Store.
+var _ state.Store = stateStore{}
+
+// StateStore returns a state.Store that keeps values in the same
+// LevelDB database as chunks, for example to store syncing intervals.
+// Values are encoded in the same way as by state.DBStore. Closing the
+// returned store has no effect, the DB must be closed instead.
+func (db *DB) StateStore() state.Store {
+	return stateStore{db: db}
+}
+
+// Get retrieves the value for the key. If there is no value
+// state.ErrNotFound is returned. The provided parameter should be
+// either a byte slice or implement encoding.BinaryUnmarshaler.
+func (s stateStore) Get(key string, i interface{}) (err error) {
+	item, err := s.db.stateIndex.Get(shed.Item{
+		Address: []byte(key),
+	})
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return state.ErrNotFound
+		}
+		return err
+	}
+	if unmarshaler, ok := i.(encoding.BinaryUnmarshaler); ok {
+		return unmarshaler.UnmarshalBinary(item.Data)
+	}
+	return json.Unmarshal(item.Data, i)
+}
+
+// Put stores the value for the key. The value is encoded with its
+// MarshalBinary method if it has one, or as JSON otherwise.
+func (s stateStore) Put(key string, i interface{}) (err error) {
+	if s.db.readOnly {
+		return ErrReadOnly
+	}
+	var data []byte
+	if marshaler, ok := i.(encoding.BinaryMarshaler); ok {
+		if data, err = marshaler.MarshalBinary(); err != nil {
+			return err
+		}
+	} else {
+		if data, err = json.Marshal(i); err != nil {
+			return err
+		}
+	}
+	return s.db.stateIndex.Put(shed.Item{
+		Address: []byte(key),
+		Data:    data,
+	})
+}
+
+// Delete removes the value for the key.
+func (s stateStore) Delete(key string) (err error) {
+	if s.db.readOnly {
+		return ErrReadOnly
+	}
+	return s.db.stateIndex.Delete(shed.Item{
+		Address: []byte(key),
+	})
+}
+
+// Close does not close the DB as it is used for chunks.
+func (s stateStore) Close() error {
+	return nil
+}
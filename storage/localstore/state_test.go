@@ -0,0 +1,68 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package localstore
+
+import (
+	"testing"
+
+	"github.com/ethersphere/swarm/state"
+)
+
+// TestDB_StateStore validates that values are stored, retrieved and
+// deleted through the state store of the database.
+func TestDB_StateStore(t *testing.T) {
+	db, cleanupFunc := newTestDB(t, nil)
+	defer cleanupFunc()
+
+	s := db.StateStore()
+
+	type value struct {
+		Name  string
+		Count int
+	}
+
+	var got value
+	if err := s.Get("key", &got); err != state.ErrNotFound {
+		t.Fatalf("got error %v, want %v", err, state.ErrNotFound)
+	}
+
+	want := value{Name: "swarm", Count: 42}
+	if err := s.Put("key", want); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Get("key", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got value %+v, want %+v", got, want)
+	}
+
+	if err := s.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Get("key", &got); err != state.ErrNotFound {
+		t.Errorf("got error %v, want %v", err, state.ErrNotFound)
+	}
+
+	// closing the state store does not close the database
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("key", want); err != nil {
+		t.Fatal(err)
+	}
+}
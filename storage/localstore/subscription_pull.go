@@ -61,11 +61,31 @@ func (db *DB) SubscribePull(ctx context.Context, bin uint8, since, until uint64)
 	// stop subscription when until chunk descriptor is reached
 	var errStopSubscription = errors.New("stop subscription")
 
+	// removeTrigger is called both by the stop function and when
+	// the subscription terminates by itself, so that the trigger
+	// is not leaked if the subscriber does not call stop
+	removeTrigger := func() {
+		db.pullTriggersMu.Lock()
+		defer db.pullTriggersMu.Unlock()
+
+		for i, t := range db.pullTriggers[bin] {
+			if t == trigger {
+				db.pullTriggers[bin] = append(db.pullTriggers[bin][:i], db.pullTriggers[bin][i+1:]...)
+				break
+			}
+		}
+		if len(db.pullTriggers[bin]) == 0 {
+			delete(db.pullTriggers, bin)
+		}
+	}
+
 	go func() {
 		defer metrics.GetOrRegisterCounter(metricName+".stop", nil).Inc(1)
 		// close the returned chunk.Descriptor channel at the end to
 		// signal that the subscription is done
 		defer close(chunkDescriptors)
+		// remove the trigger before the channel is closed
+		defer removeTrigger()
 		// sinceItem is the Item from which the next iteration
 		// should start. The first iteration starts from the first Item.
 		var sinceItem *shed.Item
@@ -131,6 +151,10 @@ func (db *DB) SubscribePull(ctx context.Context, bin uint8, since, until uint64)
 						// if until is reached
 						return
 					}
+					if err == context.Canceled {
+						// the subscriber canceled the context
+						return
+					}
 					metrics.GetOrRegisterCounter(metricName+".iter.error", nil).Inc(1)
 					log.Error("localstore pull subscription iteration", "bin", bin, "since", since, "until", until, "err", err)
 					return
@@ -148,7 +172,7 @@ func (db *DB) SubscribePull(ctx context.Context, bin uint8, since, until uint64)
 				return
 			case <-ctx.Done():
 				err := ctx.Err()
-				if err != nil {
+				if err != nil && err != context.Canceled {
 					log.Error("localstore pull subscription", "bin", bin, "since", since, "until", until, "err", err)
 				}
 				return
@@ -160,21 +184,154 @@ func (db *DB) SubscribePull(ctx context.Context, bin uint8, since, until uint64)
 		stopChanOnce.Do(func() {
 			close(stopChan)
 		})
+		removeTrigger()
+	}
 
-		db.pullTriggersMu.Lock()
-		defer db.pullTriggersMu.Unlock()
+	return chunkDescriptors, stop
+}
 
-		for i, t := range db.pullTriggers[bin] {
-			if t == trigger {
-				db.pullTriggers[bin] = append(db.pullTriggers[bin][:i], db.pullTriggers[bin][i+1:]...)
-				break
+// SubscribePullRange returns a channel that provides chunk addresses and stored times
+// from pull syncing index for all proximity order bins from binFrom to binTo, both
+// inclusive. It multiplexes SubscribePull subscriptions for every bin in the range with
+// the same since and until arguments. Chunks from one bin are sent in the order of their
+// bin ids, and when chunks from multiple bins are available, the ones from the lower bins
+// are sent first. The returned channel is closed when all bin subscriptions are done.
+// Returned stop function terminates all bin subscriptions and closes the returned channel.
+func (db *DB) SubscribePullRange(ctx context.Context, binFrom, binTo uint8, since, until uint64) (c <-chan chunk.Descriptor, stop func()) {
+	metricName := "localstore.SubscribePullRange"
+	metrics.GetOrRegisterCounter(metricName, nil).Inc(1)
+
+	chunkDescriptors := make(chan chunk.Descriptor)
+	if binTo < binFrom {
+		close(chunkDescriptors)
+		return chunkDescriptors, func() {}
+	}
+
+	// binDescriptor holds the index of the bin
+	// in the range with its chunk descriptor
+	type binDescriptor struct {
+		i int
+		d chunk.Descriptor
+	}
+
+	count := int(binTo) - int(binFrom) + 1
+	stops := make([]func(), count)
+	// acks signal bin goroutines that the last descriptor
+	// is sent, so that there is only one pending descriptor
+	// for every bin
+	acks := make([]chan struct{}, count)
+	in := make(chan binDescriptor)
+
+	stopChan := make(chan struct{})
+	var stopChanOnce sync.Once
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		var binC <-chan chunk.Descriptor
+		binC, stops[i] = db.SubscribePull(ctx, binFrom+uint8(i), since, until)
+		acks[i] = make(chan struct{}, 1)
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for d := range binC {
+				select {
+				case in <- binDescriptor{i: i, d: d}:
+				case <-stopChan:
+					return
+				}
+				select {
+				case <-acks[i]:
+				case <-stopChan:
+					return
+				}
+			}
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(in)
+	}()
+
+	go func() {
+		defer metrics.GetOrRegisterCounter(metricName+".stop", nil).Inc(1)
+		// close the returned chunk.Descriptor channel at the end to
+		// signal that the subscription is done
+		defer close(chunkDescriptors)
+		// terminate bin goroutines that may be
+		// blocked on sending or waiting for ack
+		defer stopChanOnce.Do(func() {
+			close(stopChan)
+		})
+
+		pending := make([]*chunk.Descriptor, count)
+		inC := in
+		for {
+			// send the pending descriptor from the lowest bin
+			var sendC chan chunk.Descriptor
+			var d chunk.Descriptor
+			lowest := -1
+			for i, p := range pending {
+				if p != nil {
+					sendC = chunkDescriptors
+					d = *p
+					lowest = i
+					break
+				}
+			}
+			if inC == nil && lowest < 0 {
+				// all bin subscriptions are done
+				return
+			}
+			select {
+			case sendC <- d:
+				pending[lowest] = nil
+				acks[lowest] <- struct{}{}
+			case bd, ok := <-inC:
+				if !ok {
+					inC = nil
+					continue
+				}
+				pending[bd.i] = &bd.d
+			case <-stopChan:
+				// terminate the subscription
+				// on stop
+				return
+			case <-db.close:
+				// terminate the subscription
+				// on database close
+				return
+			case <-ctx.Done():
+				return
 			}
 		}
+	}()
+
+	stop = func() {
+		stopChanOnce.Do(func() {
+			close(stopChan)
+		})
+		for _, s := range stops {
+			s()
+		}
 	}
 
 	return chunkDescriptors, stop
 }
 
+// PullSubscriptionsCount returns the number of pull subscriptions
+// that are not yet terminated, for all bins.
+func (db *DB) PullSubscriptionsCount() (count int) {
+	db.pullTriggersMu.RLock()
+	defer db.pullTriggersMu.RUnlock()
+
+	for _, triggers := range db.pullTriggers {
+		count += len(triggers)
+	}
+	return count
+}
+
 // LastPullSubscriptionBinID returns chunk bin id of the latest Chunk
 // in pull syncing index for a provided bin. If there are no chunks in
 // that bin, 0 value is returned.
@@ -191,6 +348,51 @@ func (db *DB) LastPullSubscriptionBinID(bin uint8) (id uint64, err error) {
 	return item.BinID, nil
 }
 
+// IteratePage returns up to limit descriptors of chunks from the pull
+// syncing index for a provided bin, ordered by bin ID, starting from the
+// cursor. Zero cursor starts from the first chunk in the bin. Returned
+// nextCursor should be provided to get the next page and it is zero if
+// there are no more chunks in the bin. Unlike SubscribePull, it returns
+// immediately and does not wait for new chunks.
+func (db *DB) IteratePage(bin uint8, cursor uint64, limit int) (descriptors []chunk.Descriptor, nextCursor uint64, err error) {
+	metricName := "localstore.IteratePage"
+
+	metrics.GetOrRegisterCounter(metricName, nil).Inc(1)
+	defer totalTimeMetric(metricName, time.Now())
+
+	if limit <= 0 {
+		return nil, 0, ErrInvalidPageLimit
+	}
+
+	var startFrom *shed.Item
+	if cursor > 0 {
+		startFrom = &shed.Item{
+			Address: db.addressInBin(bin),
+			BinID:   cursor,
+		}
+	}
+	err = db.pullIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+		if len(descriptors) == limit {
+			// there are more chunks, continue from this one
+			nextCursor = item.BinID
+			return true, nil
+		}
+		descriptors = append(descriptors, chunk.Descriptor{
+			Address: item.Address,
+			BinID:   item.BinID,
+		})
+		return false, nil
+	}, &shed.IterateOptions{
+		StartFrom: startFrom,
+		Prefix:    []byte{bin},
+	})
+	if err != nil {
+		metrics.GetOrRegisterCounter(metricName+".error", nil).Inc(1)
+		return nil, 0, err
+	}
+	return descriptors, nextCursor, nil
+}
+
 // triggerPullSubscriptions is used internally for starting iterations
 // on Pull subscriptions for a particular bin. When new item with address
 // that is in particular bin for DB's baseKey is added to pull index
@@ -26,6 +26,7 @@ import (
 
 	"github.com/ethersphere/swarm/chunk"
 	"github.com/ethersphere/swarm/shed"
+	"github.com/ethersphere/swarm/testutil"
 )
 
 // TestDB_SubscribePull_first is a regression test for the first=false (from-1) bug
@@ -423,6 +424,109 @@ func TestDB_SubscribePull_sinceAndUntil(t *testing.T) {
 	checkErrChan(ctx, t, errChan, wantedChunksCount)
 }
 
+// TestDB_SubscribePullRange uploads chunks across multiple bins and
+// validates that the subscription to the range of bins 0 to 3 provides
+// all chunks from these bins in bin id order for every bin.
+func TestDB_SubscribePullRange(t *testing.T) {
+	db, cleanupFunc := newTestDB(t, nil)
+	defer cleanupFunc()
+
+	addrs := make(map[uint8][]chunk.Address)
+	var addrsMu sync.Mutex
+	var wantedChunksCount int
+
+	const binFrom, binTo = 0, 3
+
+	// upload chunks until all bins in the range have some
+	for bin := uint8(binFrom); bin <= binTo; bin++ {
+		for len(addrs[bin]) == 0 {
+			uploadRandomChunksBin(t, db, addrs, &addrsMu, &wantedChunksCount, 10)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ch, stop := db.SubscribePullRange(ctx, binFrom, binTo, 0, 0)
+	defer stop()
+
+	// index of the next expected address for every bin
+	next := make(map[uint8]int)
+	var want int
+	for bin := uint8(binFrom); bin <= binTo; bin++ {
+		want += len(addrs[bin])
+	}
+	for got := 0; got < want; got++ {
+		select {
+		case d, ok := <-ch:
+			if !ok {
+				t.Fatalf("subscription closed after %v chunks, want %v", got, want)
+			}
+			bin := db.po(d.Address)
+			if bin < binFrom || bin > binTo {
+				t.Fatalf("got chunk %s from bin %v", d.Address.Hex(), bin)
+			}
+			i := next[bin]
+			if i >= len(addrs[bin]) {
+				t.Fatalf("got more chunks then expected for bin %v", bin)
+			}
+			if !bytes.Equal(d.Address, addrs[bin][i]) {
+				t.Fatalf("got chunk %s in bin %v at index %v, want %s", d.Address.Hex(), bin, i, addrs[bin][i].Hex())
+			}
+			next[bin]++
+		case <-ctx.Done():
+			t.Fatalf("got %v chunks, want %v: %v", got, want, ctx.Err())
+		}
+	}
+
+	stop()
+	select {
+	case d, ok := <-ch:
+		if ok {
+			t.Fatalf("got chunk %s after stop", d.Address.Hex())
+		}
+	case <-ctx.Done():
+		t.Fatal("subscription channel not closed after stop")
+	}
+}
+
+// TestDB_SubscribePullRange_until validates that the subscription to the
+// range of bins is closed when all bin subscriptions reach until bin id.
+func TestDB_SubscribePullRange_until(t *testing.T) {
+	db, cleanupFunc := newTestDB(t, nil)
+	defer cleanupFunc()
+
+	addrs := make(map[uint8][]chunk.Address)
+	var addrsMu sync.Mutex
+	var wantedChunksCount int
+
+	for len(addrs[0]) < 2 || len(addrs[1]) < 2 {
+		uploadRandomChunksBin(t, db, addrs, &addrsMu, &wantedChunksCount, 10)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ch, stop := db.SubscribePullRange(ctx, 0, 1, 1, 2)
+	defer stop()
+
+	var count int
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				if count != 4 {
+					t.Fatalf("got %v chunks, want 4", count)
+				}
+				return
+			}
+			count++
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		}
+	}
+}
+
 // uploadRandomChunksBin uploads random chunks to database and adds them to
 // the map of addresses ber bin.
 func uploadRandomChunksBin(t *testing.T, db *DB, addrs map[uint8][]chunk.Address, addrsMu *sync.Mutex, wantedChunksCount *int, count int) {
@@ -510,6 +614,57 @@ func checkErrChan(ctx context.Context, t *testing.T, errChan chan error, wantedC
 	}
 }
 
+// TestDB_SubscribePull_leak subscribes and terminates pull subscriptions
+// many times in every possible way and validates that no subscription
+// remains, even if the stop function is not called.
+func TestDB_SubscribePull_leak(t *testing.T) {
+	db, cleanupFunc := newTestDB(t, nil)
+	defer cleanupFunc()
+
+	ch := generateTestRandomChunk()
+	if _, err := db.Put(context.Background(), chunk.ModePutUpload, ch); err != nil {
+		t.Fatal(err)
+	}
+	bin := db.po(ch.Address())
+
+	drain := func(c <-chan chunk.Descriptor) {
+		timeout := time.After(10 * time.Second)
+		for {
+			select {
+			case _, ok := <-c:
+				if !ok {
+					return
+				}
+			case <-timeout:
+				t.Fatal("subscription not terminated")
+			}
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		switch i % 3 {
+		case 0:
+			// terminated by the stop function
+			_, stop := db.SubscribePull(context.Background(), bin, 0, 0)
+			stop()
+		case 1:
+			// terminated when until is reached, without stop
+			c, _ := db.SubscribePull(context.Background(), bin, 0, 1)
+			drain(c)
+		case 2:
+			// terminated by context cancellation, without stop
+			ctx, cancel := context.WithCancel(context.Background())
+			c, _ := db.SubscribePull(ctx, bin, 0, 0)
+			cancel()
+			drain(c)
+		}
+	}
+
+	if count := db.PullSubscriptionsCount(); count != 0 {
+		t.Errorf("got %v pull subscriptions, want none", count)
+	}
+}
+
 // TestDB_LastPullSubscriptionBinID validates that LastPullSubscriptionBinID
 // is returning the last chunk descriptor for proximity order bins by
 // doing a few rounds of chunk uploads.
@@ -567,6 +722,77 @@ func TestDB_LastPullSubscriptionBinID(t *testing.T) {
 	}
 }
 
+// TestDB_IteratePage uploads chunks to two bins and validates that
+// paging through one of them returns all of its chunks in order of
+// bin IDs, in pages of the requested size.
+func TestDB_IteratePage(t *testing.T) {
+	db, cleanupFunc := newTestDB(t, nil)
+	defer cleanupFunc()
+
+	var want []chunk.Address
+	for _, ch := range testutil.ChunksInBin(1, 100, 0, db.baseKey) {
+		if _, err := db.Put(context.Background(), chunk.ModePutUpload, ch); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, ch.Address())
+	}
+	// chunks in another bin must not be returned
+	for _, ch := range testutil.ChunksInBin(2, 10, 1, db.baseKey) {
+		if _, err := db.Put(context.Background(), chunk.ModePutUpload, ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		got    []chunk.Address
+		cursor uint64
+		pages  int
+	)
+	for {
+		descriptors, next, err := db.IteratePage(0, cursor, 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		if len(descriptors) != 20 {
+			t.Fatalf("page %v: got %v descriptors, want 20", pages, len(descriptors))
+		}
+		for _, d := range descriptors {
+			if wantBinID := uint64(len(got) + 1); d.BinID != wantBinID {
+				t.Fatalf("page %v: got bin id %v, want %v", pages, d.BinID, wantBinID)
+			}
+			got = append(got, d.Address)
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if pages != 5 {
+		t.Errorf("got %v pages, want 5", pages)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v chunks, want %v", len(got), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Errorf("chunk %v: got address %s, want %s", i, got[i].Hex(), want[i].Hex())
+		}
+	}
+
+	descriptors, next, err := db.IteratePage(2, 0, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(descriptors) != 0 || next != 0 {
+		t.Errorf("got %v descriptors and next cursor %v for an empty bin, want none", len(descriptors), next)
+	}
+
+	if _, _, err := db.IteratePage(0, 0, 0); err != ErrInvalidPageLimit {
+		t.Errorf("got error %v, want %v", err, ErrInvalidPageLimit)
+	}
+}
+
 // TestAddressInBin validates that function addressInBin
 // returns a valid address for every proximity order bin.
 func TestAddressInBin(t *testing.T) {
@@ -68,6 +68,15 @@ func (db *DB) SubscribePush(ctx context.Context) (c <-chan chunk.Chunk, stop fun
 				iterStart := time.Now()
 				var count int
 				err := db.pushIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+					// chunks of cancelled uploads are not pushed
+					// and they are removed from the push index
+					if db.tagCancelled(item.Tag) {
+						if err := db.dropCancelled(item); err != nil {
+							return true, err
+						}
+						sinceItem = &item
+						return false, nil
+					}
 					// get chunk data
 					dataItem, err := db.retrievalDataIndex.Get(item)
 					if err != nil {
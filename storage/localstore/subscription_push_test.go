@@ -204,3 +204,83 @@ func TestDB_SubscribePush_multiple(t *testing.T) {
 
 	checkErrChan(ctx, t, errChan, wantedChunksCount)
 }
+
+// TestDB_SubscribePush_cancelledTag validates that chunks uploaded
+// with a tag are not provided by the push syncing subscription after
+// the tag is cancelled, while they are still stored in the database.
+func TestDB_SubscribePush_cancelledTag(t *testing.T) {
+	tags := chunk.NewTags()
+	db, cleanupFunc := newTestDB(t, &Options{Tags: tags})
+	defer cleanupFunc()
+
+	tag, err := tags.New("cancel", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload := func(count int, tagged bool) (chunks []chunk.Chunk) {
+		for i := 0; i < count; i++ {
+			ch := generateTestRandomChunk()
+			if tagged {
+				ch = ch.WithTagID(tag.Uid)
+			}
+			if _, err := db.Put(context.Background(), chunk.ModePutUpload, ch); err != nil {
+				t.Fatal(err)
+			}
+			chunks = append(chunks, ch)
+		}
+		return chunks
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ch, stop := db.SubscribePush(ctx)
+	defer stop()
+
+	receive := func(want []chunk.Chunk) {
+		for i, w := range want {
+			select {
+			case got := <-ch:
+				if !bytes.Equal(got.Address(), w.Address()) {
+					t.Fatalf("got chunk %v address %s, want %s", i, got.Address().Hex(), w.Address().Hex())
+				}
+			case <-ctx.Done():
+				t.Fatal(ctx.Err())
+			}
+		}
+	}
+
+	// half of the upload is pushed before it is cancelled
+	receive(upload(5, true))
+
+	if err := tags.Cancel(tag.Uid); err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled := upload(5, true)
+	receive(upload(3, false))
+
+	select {
+	case got := <-ch:
+		t.Fatalf("got unexpected chunk %s", got.Address().Hex())
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	for _, c := range cancelled {
+		has, err := db.Has(context.Background(), c.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !has {
+			t.Errorf("chunk %s of cancelled tag not stored", c.Address().Hex())
+		}
+	}
+
+	// skipped chunks of the cancelled tag are removed from
+	// push and pull indexes and they can be garbage collected
+	t.Run("push index count", newItemsCountTest(db.pushIndex, 8))
+	t.Run("pull index count", newItemsCountTest(db.pullIndex, 8))
+	t.Run("gc index count", newItemsCountTest(db.gcIndex, 5))
+	t.Run("gc size", newIndexGCSizeTest(db))
+}
@@ -0,0 +1,170 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/log"
+)
+
+// defaultMirrorQueueSize is the number of secondary store writes
+// that can wait in MirrorStore queue if the size is not provided.
+const defaultMirrorQueueSize = 1000
+
+// MirrorStore mirrors writes to a secondary chunk store, for example
+// to migrate chunks to a new store or to keep a hot standby. Put and
+// Set are applied to the primary store and, asynchronously and in the
+// same order, to the secondary store. All reads are served by the
+// primary store. Writes that fail on the secondary store or that are
+// dropped because the queue is full are logged and counted, but they
+// do not fail the operation on the primary store.
+type MirrorStore struct {
+	ChunkStore
+	secondary ChunkStore
+	queue     chan mirrorOp
+	closed    bool
+	closedMu  sync.RWMutex // protects closed and sending to queue
+	done      chan struct{}
+	failed    uint64 // number of failed secondary writes, accessed atomically
+}
+
+// mirrorOp is a write that is applied to the secondary store,
+// either Put if the chunk is set, or Set.
+type mirrorOp struct {
+	ch      Chunk
+	putMode chunk.ModePut
+	setMode chunk.ModeSet
+	addr    Address
+}
+
+// MirrorStore implements ChunkStore.
+var _ ChunkStore = &MirrorStore{}
+
+// NewMirrorStore creates a new MirrorStore that reads from and writes
+// to the primary store and mirrors writes to the secondary store.
+// Argument queueSize limits the number of writes that are waiting
+// to be applied to the secondary store, and the default size is
+// used if it is not positive.
+func NewMirrorStore(primary, secondary ChunkStore, queueSize int) *MirrorStore {
+	if queueSize <= 0 {
+		queueSize = defaultMirrorQueueSize
+	}
+	ms := &MirrorStore{
+		ChunkStore: primary,
+		secondary:  secondary,
+		queue:      make(chan mirrorOp, queueSize),
+		done:       make(chan struct{}),
+	}
+	go ms.mirror()
+	return ms
+}
+
+// Put stores the chunk in the primary store and queues it to be stored
+// in the secondary store.
+func (ms *MirrorStore) Put(ctx context.Context, mode chunk.ModePut, ch Chunk) (bool, error) {
+	exists, err := ms.ChunkStore.Put(ctx, mode, ch)
+	if err != nil {
+		return exists, err
+	}
+	ms.enqueue(mirrorOp{ch: ch, putMode: mode, addr: ch.Address()})
+	return exists, nil
+}
+
+// Set applies the mode to the chunk in the primary store and queues
+// it to be applied in the secondary store.
+func (ms *MirrorStore) Set(ctx context.Context, mode chunk.ModeSet, addr Address) error {
+	if err := ms.ChunkStore.Set(ctx, mode, addr); err != nil {
+		return err
+	}
+	ms.enqueue(mirrorOp{setMode: mode, addr: addr})
+	return nil
+}
+
+// SecondaryErrors returns the number of writes that are not
+// applied to the secondary store as they failed or were dropped.
+func (ms *MirrorStore) SecondaryErrors() uint64 {
+	return atomic.LoadUint64(&ms.failed)
+}
+
+// Close waits for the queued writes to be applied
+// to the secondary store and closes both stores.
+func (ms *MirrorStore) Close() (err error) {
+	ms.closedMu.Lock()
+	if !ms.closed {
+		ms.closed = true
+		close(ms.queue)
+	}
+	ms.closedMu.Unlock()
+
+	<-ms.done
+
+	if e := ms.secondary.Close(); e != nil {
+		err = e
+	}
+	if e := ms.ChunkStore.Close(); e != nil {
+		err = e
+	}
+	return err
+}
+
+// enqueue adds the write to the queue of the secondary store,
+// or drops it if the queue is full or the store is closed.
+func (ms *MirrorStore) enqueue(op mirrorOp) {
+	ms.closedMu.RLock()
+	defer ms.closedMu.RUnlock()
+
+	if ms.closed {
+		ms.secondaryFailed(op, "store closed")
+		return
+	}
+	select {
+	case ms.queue <- op:
+	default:
+		ms.secondaryFailed(op, "queue full")
+	}
+}
+
+// mirror applies queued writes to the secondary store
+// until the queue is closed.
+func (ms *MirrorStore) mirror() {
+	defer close(ms.done)
+
+	for op := range ms.queue {
+		var err error
+		if op.ch != nil {
+			_, err = ms.secondary.Put(context.Background(), op.putMode, op.ch)
+		} else {
+			err = ms.secondary.Set(context.Background(), op.setMode, op.addr)
+		}
+		if err != nil {
+			ms.secondaryFailed(op, err)
+		}
+	}
+}
+
+// secondaryFailed logs and counts the write that is not
+// applied to the secondary store.
+func (ms *MirrorStore) secondaryFailed(op mirrorOp, reason interface{}) {
+	atomic.AddUint64(&ms.failed, 1)
+	metrics.GetOrRegisterCounter("mirrorstore.secondary.error", nil).Inc(1)
+	log.Warn("mirror store: secondary write", "ref", op.addr, "put", op.ch != nil, "err", reason)
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/storage/localstore"
+)
+
+// TestMirrorStore validates that chunks put through MirrorStore are
+// stored in both primary and secondary stores.
+func TestMirrorStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-mirror-store-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newStore := func() *localstore.DB {
+		t.Helper()
+
+		path, err := ioutil.TempDir(dir, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, err := localstore.New(path, make([]byte, 32), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	primary, secondary := newStore(), newStore()
+
+	ms := NewMirrorStore(primary, secondary, 0)
+	defer ms.Close()
+
+	ctx := context.Background()
+
+	chunks := GenerateRandomChunks(chunk.DefaultSize, 50)
+	for _, ch := range chunks {
+		if _, err := ms.Put(ctx, chunk.ModePutUpload, ch); err != nil {
+			t.Fatal(err)
+		}
+		if err := ms.Set(ctx, chunk.ModeSetSync, ch.Address()); err != nil {
+			t.Fatal(err)
+		}
+		// reads are served by the primary store
+		has, err := ms.Has(ctx, ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !has {
+			t.Fatalf("chunk %s not found", ch.Address())
+		}
+	}
+
+	timeout := time.After(10 * time.Second)
+	for _, ch := range chunks {
+		for _, s := range []*localstore.DB{primary, secondary} {
+			for {
+				synced, err := s.IsSynced(ctx, ch.Address())
+				if err != nil && err != chunk.ErrChunkNotFound {
+					t.Fatal(err)
+				}
+				if synced {
+					break
+				}
+				select {
+				case <-time.After(10 * time.Millisecond):
+				case <-timeout:
+					t.Fatalf("chunk %s not mirrored", ch.Address())
+				}
+			}
+		}
+	}
+
+	if n := ms.SecondaryErrors(); n != 0 {
+		t.Errorf("got %v secondary errors, want none", n)
+	}
+}
@@ -19,7 +19,7 @@ package storage
 import (
 	"context"
 	"encoding/hex"
-	"fmt"
+	"errors"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -35,6 +35,10 @@ import (
 	lru "github.com/hashicorp/golang-lru"
 )
 
+// ErrStoreClosed is returned by NetStore Get and FetchFunc wait function
+// for chunks that are not fetched before NetStore is closed.
+var ErrStoreClosed = errors.New("store closed")
+
 type (
 	NewNetFetcherFunc func(ctx context.Context, addr Address, peers *sync.Map) NetFetcher
 )
@@ -44,6 +48,12 @@ type NetFetcher interface {
 	Offer(source *enode.ID)
 }
 
+// netFetcherError is implemented by NetFetchers that report why
+// the chunk is not retrieved, for example with ErrNoPeers.
+type netFetcherError interface {
+	Err() error
+}
+
 // NetStore is an extension of local storage
 // it implements the ChunkStore interface
 // on request it initiates remote cloud retrieval using a fetcher
@@ -54,35 +64,119 @@ type NetStore struct {
 	mu                sync.Mutex
 	fetchers          *lru.Cache
 	NewNetFetcherFunc NewNetFetcherFunc
+	PushSyncFunc      PushSyncFunc          // called for chunks stored with ModePutUpload before Put returns, if set
+	replication       int                   // number of closest peers that newly uploaded chunks are replicated to
+	fetchersSem       chan struct{}         // limits the number of concurrent net fetchers, nil if unlimited
+	active            map[*fetcher]struct{} // all fetchers that are not yet destroyed, including the ones evicted from fetchers cache
+	activeMu          sync.Mutex            // protects active map
+	validators        []ChunkValidator
+	serveStale        StaleStore // reports stale chunks that are served while refetched, nil if disabled
 	closeC            chan struct{}
 }
 
+// NetStoreOptions holds optional values for NewNetStore constructor.
+type NetStoreOptions struct {
+	// MaxConcurrentFetches limits the number of net fetchers that
+	// are active at the same time. Zero value means no limit.
+	MaxConcurrentFetches int
+	// Validators are called on every chunk before it is put
+	// to the local store. If any of them returns false,
+	// the chunk is not stored and ErrChunkInvalid is returned.
+	Validators []ChunkValidator
+	// ServeStale, if set, makes Get return chunks that it reports
+	// as stale immediately, while they are refetched from the network
+	// in the background. It is usually the local store wrapped by the
+	// NetStore, such as localstore.DB with SoftTTL option.
+	ServeStale StaleStore
+	// Replication is the number of connected peers closest to the chunk
+	// address that newly uploaded chunks are replicated to by PushSyncFunc,
+	// if the chunk is within the node's area of responsibility, for
+	// redundancy against node churn. Zero disables replication.
+	Replication int
+}
+
+// PushSyncFunc sends a locally uploaded chunk to the nodes that should
+// store it. It is called by NetStore.Put with the Put context, after the
+// chunk is stored locally, and the returned error is returned by Put.
+// Replication is the NetStoreOptions.Replication value for chunks that
+// are newly stored and zero for the ones that are already stored.
+type PushSyncFunc func(ctx context.Context, ch Chunk, replication int) error
+
+// StaleStore is implemented by local stores that keep the soft
+// expiry of chunks, such as localstore.DB.
+type StaleStore interface {
+	Stale(addr chunk.Address) (bool, error)
+}
+
 var fetcherTimeout = 2 * time.Minute // timeout to cancel the fetcher even if requests are coming in
 
 // NewNetStore creates a new NetStore object using the given local store. newFetchFunc is a
 // constructor function that can create a fetch function for a specific chunk address.
-func NewNetStore(store chunk.Store, nnf NewNetFetcherFunc) (*NetStore, error) {
+// Options are optional and can be nil.
+func NewNetStore(store chunk.Store, nnf NewNetFetcherFunc, o *NetStoreOptions) (*NetStore, error) {
+	if o == nil {
+		o = new(NetStoreOptions)
+	}
 	fetchers, err := lru.New(defaultChunkRequestsCacheCapacity)
 	if err != nil {
 		return nil, err
 	}
-	return &NetStore{
+	n := &NetStore{
 		Store:             store,
 		fetchers:          fetchers,
 		NewNetFetcherFunc: nnf,
 		closeC:            make(chan struct{}),
-	}, nil
+		active:            make(map[*fetcher]struct{}),
+		validators:        o.Validators,
+		serveStale:        o.ServeStale,
+		replication:       o.Replication,
+	}
+	if o.MaxConcurrentFetches > 0 {
+		n.fetchersSem = make(chan struct{}, o.MaxConcurrentFetches)
+	}
+	return n, nil
 }
 
 // Put stores a chunk in localstore, and delivers to all requestor peers using the fetcher stored in
-// the fetchers cache
+// the fetchers cache. If any of NetStore validators fails, ErrChunkInvalid is returned.
 func (n *NetStore) Put(ctx context.Context, mode chunk.ModePut, ch Chunk) (bool, error) {
+	for _, v := range n.validators {
+		if !v.Validate(ch) {
+			n.markInvalid(ch.Address())
+			return false, ErrChunkInvalid
+		}
+	}
+
 	n.mu.Lock()
-	defer n.mu.Unlock()
+	exists, err := n.put(ctx, mode, ch)
+	n.mu.Unlock()
+	if err != nil {
+		return exists, err
+	}
 
+	// the lock is not held while the chunk is pushed,
+	// as it may wait for receipts from other nodes
+	if mode == chunk.ModePutUpload && n.PushSyncFunc != nil {
+		replication := n.replication
+		if exists {
+			replication = 0
+		}
+		if err := n.PushSyncFunc(ctx, ch, replication); err != nil {
+			return exists, err
+		}
+	}
+	return exists, nil
+}
+
+// put stores the chunk in the local store and delivers it to the
+// active fetcher for its address. Caller must hold the lock.
+func (n *NetStore) put(ctx context.Context, mode chunk.ModePut, ch Chunk) (bool, error) {
 	// put to the chunk to the store, there should be no error
 	exists, err := n.Store.Put(ctx, mode, ch)
 	if err != nil {
+		if err == ErrChunkInvalid {
+			n.markInvalid(ch.Address())
+		}
 		return exists, err
 	}
 
@@ -96,12 +190,23 @@ func (n *NetStore) Put(ctx context.Context, mode chunk.ModePut, ch Chunk) (bool,
 	return exists, nil
 }
 
+// markInvalid records on the active fetcher for the address
+// that a chunk with invalid content was delivered.
+func (n *NetStore) markInvalid(ref Address) {
+	if f := n.getFetcher(ref); f != nil {
+		atomic.StoreInt32(&f.invalid, 1)
+	}
+}
+
 // Get retrieves the chunk from the NetStore DPA synchronously.
 // It calls NetStore.get, and if the chunk is not in local Storage
 // it calls fetch with the request, which blocks until the chunk
 // arrived or context is done
+// If the chunk is not retrieved before the context deadline, ErrChunkInvalid,
+// ErrNoPeers or ErrFetchTimeout is returned. ErrChunkNotFound is returned
+// if the chunk is not found locally and NetStore has no net fetcher function.
 func (n *NetStore) Get(rctx context.Context, mode chunk.ModeGet, ref Address) (Chunk, error) {
-	chunk, fetch, err := n.get(rctx, mode, ref)
+	chunk, f, err := n.get(rctx, mode, ref)
 	if err != nil {
 		return nil, err
 	}
@@ -116,42 +221,152 @@ func (n *NetStore) Get(rctx context.Context, mode chunk.ModeGet, ref Address) (C
 
 		return chunk, nil
 	}
-	return fetch(rctx)
+	return f.Fetch(rctx)
+}
+
+// RetrievalInfo holds information about how a chunk
+// is retrieved by NetStore.GetWithInfo.
+type RetrievalInfo struct {
+	Local      bool          // chunk is found in the local store
+	Peer       *enode.ID     // peer that delivered the chunk, nil if local or unknown
+	PeersTried int           // number of peers the chunk was requested from
+	Elapsed    time.Duration // time it took to get the chunk
+}
+
+// requestedPeersCounter is implemented by net fetchers that are able
+// to report the number of peers that the chunk was requested from,
+// such as network.Fetcher.
+type requestedPeersCounter interface {
+	RequestedPeers() int
+}
+
+// GetWithInfo retrieves the chunk in the same way as Get, but it also
+// returns information about the retrieval, like the peer that delivered
+// the chunk and the time it took to retrieve it, which is useful for
+// debugging slow retrievals.
+func (n *NetStore) GetWithInfo(ctx context.Context, mode chunk.ModeGet, ref Address) (Chunk, RetrievalInfo, error) {
+	start := time.Now()
+	ch, f, err := n.get(ctx, mode, ref)
+	if err != nil {
+		return nil, RetrievalInfo{Elapsed: time.Since(start)}, err
+	}
+	if ch != nil {
+		return ch, RetrievalInfo{Local: true, Elapsed: time.Since(start)}, nil
+	}
+	ch, err = f.Fetch(ctx)
+	info := RetrievalInfo{Elapsed: time.Since(start)}
+	if c, ok := f.netFetcher.(requestedPeersCounter); ok {
+		info.PeersTried = c.RequestedPeers()
+	}
+	if err != nil {
+		return nil, info, err
+	}
+	info.Peer = f.source
+	return ch, info, nil
+}
+
+// GetFirst retrieves the first chunk that resolves from a list of
+// equivalent addresses, for example of replicated content. Chunks that
+// are in the local store are returned without network requests.
+// Otherwise, fetches are started for all addresses, within the limit of
+// concurrent fetchers, and the ones still running are cancelled when
+// the first chunk arrives. The address of the returned chunk is returned
+// alongside it. If no chunk is retrieved, the error of the last failed
+// fetch is returned.
+func (n *NetStore) GetFirst(ctx context.Context, addrs []Address) (Chunk, Address, error) {
+	if len(addrs) == 0 {
+		return nil, nil, ErrChunkNotFound
+	}
+	for _, addr := range addrs {
+		ch, err := n.Store.Get(ctx, chunk.ModeGetRequest, addr)
+		if err == nil {
+			return ch, addr, nil
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		ch   Chunk
+		addr Address
+		err  error
+	}
+	results := make(chan result, len(addrs))
+	for _, addr := range addrs {
+		go func(addr Address) {
+			ch, err := n.Get(ctx, chunk.ModeGetRequest, addr)
+			results <- result{ch: ch, addr: addr, err: err}
+		}(addr)
+	}
+	var err error
+	for range addrs {
+		r := <-results
+		if r.err == nil {
+			// deferred cancel stops the remaining fetches
+			return r.ch, r.addr, nil
+		}
+		err = r.err
+	}
+	return nil, nil, err
+}
+
+// Prefetch starts retrieving chunks that are not in the local store in
+// the background and returns immediately, so that later Get calls for
+// them do not need to wait for the network. Fetches are subject to the
+// limit of concurrent fetchers and they stop when the context is done.
+func (n *NetStore) Prefetch(ctx context.Context, addrs []Address) {
+	for _, addr := range addrs {
+		go func(addr Address) {
+			if _, err := n.Get(ctx, chunk.ModeGetRequest, addr); err != nil {
+				log.Trace("netstore prefetch", "ref", addr, "err", err)
+			}
+		}(addr)
+	}
 }
 
 // FetchFunc returns nil if the store contains the given address. Otherwise it returns a wait function,
 // which returns after the chunk is available or the context is done
 func (n *NetStore) FetchFunc(ctx context.Context, ref Address) func(context.Context) error {
-	chunk, fetch, _ := n.get(ctx, chunk.ModeGetRequest, ref)
+	chunk, f, err := n.get(ctx, chunk.ModeGetRequest, ref)
+	if err != nil {
+		return func(context.Context) error {
+			return err
+		}
+	}
 	if chunk != nil {
 		return nil
 	}
 	return func(ctx context.Context) error {
-		_, err := fetch(ctx)
+		_, err := f.Fetch(ctx)
 		return err
 	}
 }
 
-// Close chunk store
+// Close chunk store. All active fetchers are cancelled and
+// pending Get calls return ErrStoreClosed.
 func (n *NetStore) Close() (err error) {
 	close(n.closeC)
 
+	n.activeMu.Lock()
+	active := make([]*fetcher, 0, len(n.active))
+	for f := range n.active {
+		active = append(active, f)
+	}
+	n.activeMu.Unlock()
+
 	wg := sync.WaitGroup{}
-	for _, key := range n.fetchers.Keys() {
-		if f, ok := n.fetchers.Get(key); ok {
-			if fetch, ok := f.(*fetcher); ok {
-				wg.Add(1)
-				go func(fetch *fetcher) {
-					defer wg.Done()
-					fetch.cancel()
-
-					select {
-					case <-fetch.deliveredC:
-					case <-fetch.cancelledC:
-					}
-				}(fetch)
+	for _, fetch := range active {
+		wg.Add(1)
+		go func(fetch *fetcher) {
+			defer wg.Done()
+			fetch.cancel()
+
+			select {
+			case <-fetch.deliveredC:
+			case <-fetch.cancelledC:
 			}
-		}
+		}(fetch)
 	}
 	wg.Wait()
 
@@ -164,9 +379,9 @@ func (n *NetStore) Close() (err error) {
 //     2. A new fetcher is created and saved in the fetchers cache
 // From here on, all Get will hit on this fetcher until the chunk is delivered
 // or all fetcher contexts are done.
-// It returns a chunk, a fetcher function and an error
-// If chunk is nil, the returned fetch function needs to be called with a context to return the chunk.
-func (n *NetStore) get(ctx context.Context, mode chunk.ModeGet, ref Address) (Chunk, func(context.Context) (Chunk, error), error) {
+// It returns a chunk, a fetcher and an error
+// If chunk is nil, the returned fetcher Fetch method needs to be called with a context to return the chunk.
+func (n *NetStore) get(ctx context.Context, mode chunk.ModeGet, ref Address) (Chunk, *fetcher, error) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
@@ -178,34 +393,122 @@ func (n *NetStore) get(ctx context.Context, mode chunk.ModeGet, ref Address) (Ch
 		}
 		// The chunk is not available in the LocalStore, let's get the fetcher for it, or create a new one
 		// if it doesn't exist yet
-		f := n.getOrCreateFetcher(ctx, ref)
-		// If the caller needs the chunk, it has to use the returned fetch function to get it
-		return nil, f.Fetch, nil
+		f, err := n.getOrCreateFetcher(ctx, ref)
+		if err != nil {
+			return nil, nil, err
+		}
+		if f == nil {
+			// the chunk is stored while waiting for the fetcher to be created
+			chunk, err = n.Store.Get(ctx, mode, ref)
+			if err != nil {
+				return nil, nil, err
+			}
+			return chunk, nil, nil
+		}
+		// If the caller needs the chunk, it has to use the returned fetcher to get it
+		return nil, f, nil
 	}
 
+	if n.serveStale != nil {
+		n.refetchStale(ref)
+	}
 	return chunk, nil, nil
 }
 
+// refetchStale starts fetching the chunk from the network in the
+// background if it is stale in the local store and it is not already
+// being fetched. The local store updates the chunk when it is delivered.
+// Caller must hold the lock.
+func (n *NetStore) refetchStale(ref Address) {
+	if n.NewNetFetcherFunc == nil {
+		return
+	}
+	stale, err := n.serveStale.Stale(ref)
+	if err != nil {
+		log.Debug("netstore stale check", "ref", ref, "err", err)
+		return
+	}
+	if !stale || n.getFetcher(ref) != nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), fetcherTimeout)
+		defer cancel()
+
+		n.mu.Lock()
+		f, err := n.getOrCreateFetcher(ctx, ref)
+		n.mu.Unlock()
+		if err != nil || f == nil {
+			return
+		}
+		if _, err := f.Fetch(ctx); err != nil {
+			log.Debug("netstore refetch stale", "ref", ref, "err", err)
+		}
+	}()
+}
+
 // getOrCreateFetcher attempts at retrieving an existing fetchers
 // if none exists, creates one and saves it in the fetchers cache
 // caller must hold the lock
-func (n *NetStore) getOrCreateFetcher(ctx context.Context, ref Address) *fetcher {
+// If the number of concurrent fetchers is limited and the limit is reached,
+// the lock is released until a new fetcher can be created or the context is done.
+// In that case, if the chunk is stored in the meantime, nil fetcher is returned.
+func (n *NetStore) getOrCreateFetcher(ctx context.Context, ref Address) (*fetcher, error) {
+	select {
+	case <-n.closeC:
+		return nil, ErrStoreClosed
+	default:
+	}
+
 	if f := n.getFetcher(ref); f != nil {
-		return f
+		return f, nil
+	}
+
+	if n.NewNetFetcherFunc == nil {
+		return nil, ErrChunkNotFound
+	}
+
+	release, err := n.acquireFetcherSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if n.fetchersSem != nil {
+		// the lock may be released while waiting for a slot
+		// check again if the fetcher is created or the chunk stored
+		if f := n.getFetcher(ref); f != nil {
+			release()
+			return f, nil
+		}
+		if has, err := n.Store.Has(ctx, ref); err != nil || has {
+			release()
+			return nil, err
+		}
 	}
 
 	// no fetcher for the given address, we have to create a new one
 	key := hex.EncodeToString(ref)
 	// create the context during which fetching is kept alive
 	cctx, cancel := context.WithTimeout(ctx, fetcherTimeout)
+	var fetcher *fetcher
 	// destroy is called when all requests finish
 	destroy := func() {
 		// remove fetcher from fetchers
 		n.fetchers.Remove(key)
+		n.activeMu.Lock()
+		delete(n.active, fetcher)
+		n.activeMu.Unlock()
 		// stop fetcher by cancelling context called when
 		// all requests cancelled/timedout or chunk is delivered
 		cancel()
 	}
+	if n.fetchersSem != nil {
+		// release the slot when the fetcher is done
+		// regardless if destroy is called or not
+		go func(ctx context.Context) {
+			<-ctx.Done()
+			release()
+		}(cctx)
+	}
 	// peers always stores all the peers which have an active request for the chunk. It is shared
 	// between fetcher and the NewFetchFunc function. It is needed by the NewFetchFunc because
 	// the peers which requested the chunk should not be requested to deliver it.
@@ -217,10 +520,43 @@ func (n *NetStore) getOrCreateFetcher(ctx context.Context, ref Address) *fetcher
 	)
 
 	sp.LogFields(olog.String("ref", ref.String()))
-	fetcher := newFetcher(sp, ref, n.NewNetFetcherFunc(cctx, ref, peers), destroy, peers, n.closeC)
+	fetcher = newFetcher(sp, ref, n.NewNetFetcherFunc(cctx, ref, peers), destroy, peers, n.closeC)
 	n.fetchers.Add(key, fetcher)
+	n.activeMu.Lock()
+	n.active[fetcher] = struct{}{}
+	n.activeMu.Unlock()
+
+	return fetcher, nil
+}
+
+// acquireFetcherSlot blocks until a new fetcher is allowed to be created
+// or the context is done. Returned function must be called to release
+// the slot. If the number of fetchers is not limited, it returns immediately.
+// Caller must hold the lock, which is released while waiting.
+func (n *NetStore) acquireFetcherSlot(ctx context.Context) (release func(), err error) {
+	if n.fetchersSem == nil {
+		return func() {}, nil
+	}
+	release = func() {
+		<-n.fetchersSem
+	}
+	select {
+	case n.fetchersSem <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	n.mu.Unlock()
+	defer n.mu.Lock()
 
-	return fetcher
+	select {
+	case n.fetchersSem <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-n.closeC:
+		return nil, ErrStoreClosed
+	}
 }
 
 // getFetcher retrieves the fetcher for the given address from the fetchers cache if it exists,
@@ -244,12 +580,14 @@ func (n *NetStore) RequestsCacheLen() int {
 type fetcher struct {
 	addr        Address          // address of chunk
 	chunk       Chunk            // fetcher can set the chunk on the fetcher
+	source      *enode.ID        // peer that delivered the chunk, set with the chunk if known
 	deliveredC  chan struct{}    // chan signalling chunk delivery to requests
 	cancelledC  chan struct{}    // chan signalling the fetcher has been cancelled (removed from fetchers in NetStore)
 	netFetcher  NetFetcher       // remote fetch function to be called with a request source taken from the context
 	cancel      func()           // cleanup function for the remote fetcher to call when all upstream contexts are called
 	peers       *sync.Map        // the peers which asked for the chunk
 	requestCnt  int32            // number of requests on this chunk. If all the requests are done (delivered or context is done) the cancel function is called
+	invalid     int32            // set to 1 when a chunk with invalid content is delivered, accessed atomically
 	deliverOnce *sync.Once       // guarantees that we only close deliveredC once
 	span        opentracing.Span // measure retrieve time per chunk
 }
@@ -316,18 +654,43 @@ func (f *fetcher) Fetch(rctx context.Context) (Chunk, error) {
 	// wait until either the chunk is delivered or the context is done
 	select {
 	case <-rctx.Done():
-		return nil, rctx.Err()
+		return nil, f.fetchError(rctx.Err())
 	case <-f.deliveredC:
 		return f.chunk, nil
 	case <-f.cancelledC:
-		return nil, fmt.Errorf("fetcher cancelled")
+		return nil, ErrStoreClosed
 	}
 }
 
+// fetchError returns the reason why the chunk is not fetched when the
+// request context is done with the provided error. The error of a
+// cancelled context is returned unchanged.
+func (f *fetcher) fetchError(err error) error {
+	if err != context.DeadlineExceeded {
+		return err
+	}
+	if atomic.LoadInt32(&f.invalid) == 1 {
+		return ErrChunkInvalid
+	}
+	if nf, ok := f.netFetcher.(netFetcherError); ok {
+		if err := nf.Err(); err != nil {
+			return err
+		}
+	}
+	return ErrFetchTimeout
+}
+
 // deliver is called by NetStore.Put to notify all pending requests
+// The peer that delivered the chunk is taken from the "source" context value.
 func (f *fetcher) deliver(ctx context.Context, ch Chunk) {
 	f.deliverOnce.Do(func() {
 		f.chunk = ch
+		if s, ok := ctx.Value("source").(string); ok {
+			var source enode.ID
+			if err := source.UnmarshalText([]byte(s)); err == nil {
+				f.source = &source
+			}
+		}
 		// closing the deliveredC channel will terminate ongoing requests
 		close(f.deliveredC)
 		log.Trace("n.getFetcher close deliveredC", "ref", ch.Address())
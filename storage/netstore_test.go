@@ -45,9 +45,14 @@ type mockNetFetcher struct {
 	quit            <-chan struct{}
 	ctx             context.Context
 	hopCounts       []uint8
+	err             error // returned by Err
 	mu              sync.Mutex
 }
 
+func (m *mockNetFetcher) Err() error {
+	return m.err
+}
+
 func (m *mockNetFetcher) Offer(source *enode.ID) {
 	m.offerCalled = true
 	m.sources = append(m.sources, source)
@@ -99,7 +104,7 @@ func newTestNetStore(t *testing.T) (netStore *NetStore, fetcher *mockNetFetcher,
 	mockNetFetchFuncFactory := &mockNetFetchFuncFactory{
 		fetcher: fetcher,
 	}
-	netStore, err = NewNetStore(localStore, mockNetFetchFuncFactory.newMockNetFetcher)
+	netStore, err = NewNetStore(localStore, mockNetFetchFuncFactory.newMockNetFetcher, nil)
 	if err != nil {
 		cleanup()
 		t.Fatal(err)
@@ -237,8 +242,8 @@ func TestNetStoreGetTimeout(t *testing.T) {
 	_, err := netStore.Get(ctx, chunk.ModeGetRequest, ch.Address())
 
 	// Check if the timeout happened
-	if err != context.DeadlineExceeded {
-		t.Fatalf("Expected context.DeadLineExceeded err got %v", err)
+	if err != ErrFetchTimeout {
+		t.Fatalf("Expected ErrFetchTimeout err got %v", err)
 	}
 
 	if err := <-fetcherErrC; err != nil {
@@ -310,6 +315,73 @@ func TestNetStoreGetCancel(t *testing.T) {
 	}
 }
 
+// TestNetStoreGetClose tests a Get call for an unavailable chunk, then closes the NetStore
+// and checks that Get returns ErrStoreClosed and that the fetcher context is cancelled.
+func TestNetStoreGetClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-storage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localStore, err := localstore.New(dir, make([]byte, 32), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetcher := new(mockNetFetcher)
+	mockNetFetchFuncFactory := &mockNetFetchFuncFactory{
+		fetcher: fetcher,
+	}
+	netStore, err := NewNetStore(localStore, mockNetFetchFuncFactory.newMockNetFetcher, nil)
+	if err != nil {
+		localStore.Close()
+		t.Fatal(err)
+	}
+
+	ch := GenerateRandomChunk(chunk.DefaultSize)
+
+	// Get is called with a context without deadline,
+	// so it can be terminated only by closing the store
+	errC := make(chan error)
+	go func() {
+		_, err := netStore.Get(context.Background(), chunk.ModeGetRequest, ch.Address())
+		errC <- err
+	}()
+
+	// wait for the fetcher to be created
+	for i := 0; netStore.getFetcher(ch.Address()) == nil; i++ {
+		if i == 100 {
+			t.Fatal("Expected netStore to use a fetcher for the Get call")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := netStore.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errC:
+		if err != ErrStoreClosed {
+			t.Fatalf("Expected ErrStoreClosed err got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Get did not return after the store is closed")
+	}
+
+	// Check if the fetcher context has been cancelled on close
+	select {
+	case <-fetcher.ctx.Done():
+	default:
+		t.Fatal("Expected fetcher context to be cancelled")
+	}
+
+	// Get for unavailable chunk on closed store should not create a new fetcher
+	if _, err := netStore.Get(context.Background(), chunk.ModeGetRequest, ch.Address()); err != ErrStoreClosed {
+		t.Fatalf("Expected ErrStoreClosed err got %v", err)
+	}
+}
+
 // TestNetStoreMultipleGetAndPut tests four Get calls for the same unavailable chunk. The chunk is
 // delivered with a Put, we have to make sure all Get calls return, and they use a single fetcher
 // for the chunk retrieval
@@ -410,8 +482,8 @@ func TestNetStoreFetchFuncTimeout(t *testing.T) {
 
 	// wait function should timeout because we don't deliver the chunk with a Put
 	err := wait(ctx)
-	if err != context.DeadlineExceeded {
-		t.Fatalf("Expected context.DeadLineExceeded err got %v", err)
+	if err != ErrFetchTimeout {
+		t.Fatalf("Expected ErrFetchTimeout err got %v", err)
 	}
 
 	// the fetcher should be removed after timeout
@@ -469,8 +541,8 @@ func TestNetStoreGetCallsRequest(t *testing.T) {
 	// We call get for a not available chunk, it will timeout because the chunk is not delivered
 	_, err := netStore.Get(ctx, chunk.ModeGetRequest, ch.Address())
 
-	if err != context.DeadlineExceeded {
-		t.Fatalf("Expected context.DeadlineExceeded err got %v", err)
+	if err != ErrFetchTimeout {
+		t.Fatalf("Expected ErrFetchTimeout err got %v", err)
 	}
 
 	// NetStore should call NetFetcher.Request and wait for the chunk
@@ -499,8 +571,8 @@ func TestNetStoreGetCallsOffer(t *testing.T) {
 	// We call get for a not available chunk, it will timeout because the chunk is not delivered
 	_, err := netStore.Get(ctx, chunk.ModeGetRequest, ch.Address())
 
-	if err != context.DeadlineExceeded {
-		t.Fatalf("Expect error %v got %v", context.DeadlineExceeded, err)
+	if err != ErrFetchTimeout {
+		t.Fatalf("Expect error %v got %v", ErrFetchTimeout, err)
 	}
 
 	// NetStore should call NetFetcher.Offer with the source peer
@@ -545,8 +617,8 @@ func TestNetStoreFetcherCountPeers(t *testing.T) {
 	// All 3 Get calls should timeout
 	for i := 0; i < nrGets; i++ {
 		err := <-errC
-		if err != context.DeadlineExceeded {
-			t.Fatalf("Expected \"%v\" error got \"%v\"", context.DeadlineExceeded, err)
+		if err != ErrFetchTimeout {
+			t.Fatalf("Expected \"%v\" error got \"%v\"", ErrFetchTimeout, err)
 		}
 	}
 
@@ -666,8 +738,8 @@ func TestNetStoreFetcherLifeCycleWithTimeout(t *testing.T) {
 			rctx, rcancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
 			defer rcancel()
 			err := wait(rctx)
-			if err != context.DeadlineExceeded {
-				errC <- fmt.Errorf("Expected err %v got %v", context.DeadlineExceeded, err)
+			if err != ErrFetchTimeout {
+				errC <- fmt.Errorf("Expected err %v got %v", ErrFetchTimeout, err)
 				return
 			}
 			errC <- nil
@@ -695,8 +767,494 @@ func TestNetStoreFetcherLifeCycleWithTimeout(t *testing.T) {
 	}
 }
 
+// noopNetFetcher is a NetFetcher that does not retrieve chunks.
+type noopNetFetcher struct{}
+
+func (noopNetFetcher) Request(uint8)   {}
+func (noopNetFetcher) Offer(*enode.ID) {}
+
+// TestNetStoreMaxConcurrentFetches validates that NetStore does not create
+// more net fetchers then it is set in MaxConcurrentFetches option and that
+// Get calls block until a fetcher can be created.
+func TestNetStoreMaxConcurrentFetches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-storage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localStore, err := localstore.New(dir, make([]byte, 32), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localStore.Close()
+
+	const maxFetches = 3
+
+	created := make(chan Address, 2*maxFetches)
+	netStore, err := NewNetStore(localStore, func(_ context.Context, addr Address, _ *sync.Map) NetFetcher {
+		created <- addr
+		return noopNetFetcher{}
+	}, &NetStoreOptions{
+		MaxConcurrentFetches: maxFetches,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	chunks := GenerateRandomChunks(chunk.DefaultSize, 2*maxFetches)
+	chunksByAddr := make(map[string]Chunk)
+	errC := make(chan error)
+	for _, ch := range chunks {
+		chunksByAddr[ch.Address().Hex()] = ch
+		go func(ch Chunk) {
+			got, err := netStore.Get(ctx, chunk.ModeGetRequest, ch.Address())
+			if err == nil && !bytes.Equal(got.Data(), ch.Data()) {
+				err = fmt.Errorf("got invalid data for chunk %s", ch.Address())
+			}
+			errC <- err
+		}(ch)
+	}
+
+	// wait for the first fetchers to be created
+	fetching := make([]Address, 0, maxFetches)
+	for i := 0; i < maxFetches; i++ {
+		select {
+		case addr := <-created:
+			fetching = append(fetching, addr)
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		}
+	}
+
+	// no more fetchers should be created while the first ones are active
+	select {
+	case addr := <-created:
+		t.Fatalf("fetcher for chunk %s created over the limit", addr)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// deliver one chunk to allow creating exactly one new fetcher
+	if _, err := netStore.Put(ctx, chunk.ModePutRequest, chunksByAddr[fetching[0].Hex()]); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case addr := <-created:
+		fetching = append(fetching[1:], addr)
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+	select {
+	case addr := <-created:
+		t.Fatalf("fetcher for chunk %s created over the limit", addr)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// deliver all chunks one by one as their fetchers are created
+	createdCount := maxFetches + 1
+	for len(fetching) > 0 {
+		if _, err := netStore.Put(ctx, chunk.ModePutRequest, chunksByAddr[fetching[0].Hex()]); err != nil {
+			t.Fatal(err)
+		}
+		fetching = fetching[1:]
+		if createdCount < len(chunks) {
+			select {
+			case addr := <-created:
+				fetching = append(fetching, addr)
+				createdCount++
+			case <-ctx.Done():
+				t.Fatal(ctx.Err())
+			}
+		}
+	}
+
+	for range chunks {
+		if err := <-errC; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestNetStoreGetFirst validates that GetFirst returns the chunk
+// and the address of the first fetch that succeeds and that the
+// fetches for other addresses are cancelled.
+func TestNetStoreGetFirst(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-storage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localStore, err := localstore.New(dir, make([]byte, 32), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localStore.Close()
+
+	type fetch struct {
+		ctx  context.Context
+		addr Address
+	}
+	created := make(chan fetch, 2)
+	netStore, err := NewNetStore(localStore, func(ctx context.Context, addr Address, _ *sync.Map) NetFetcher {
+		created <- fetch{ctx: ctx, addr: addr}
+		return noopNetFetcher{}
+	}, &NetStoreOptions{
+		MaxConcurrentFetches: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// only the second chunk is available in the network
+	unavailable := randomAddr()
+	available := GenerateRandomChunk(chunk.DefaultSize)
+
+	type result struct {
+		ch   Chunk
+		addr Address
+		err  error
+	}
+	resultC := make(chan result)
+	go func() {
+		ch, addr, err := netStore.GetFirst(ctx, []Address{unavailable, available.Address()})
+		resultC <- result{ch: ch, addr: addr, err: err}
+	}()
+
+	fetches := make(map[string]fetch)
+	for i := 0; i < 2; i++ {
+		select {
+		case f := <-created:
+			fetches[f.addr.Hex()] = f
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		}
+	}
+
+	// deliver the available chunk
+	if _, err := netStore.Put(ctx, chunk.ModePutRequest, available); err != nil {
+		t.Fatal(err)
+	}
+
+	var r result
+	select {
+	case r = <-resultC:
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if !bytes.Equal(r.addr, available.Address()) {
+		t.Errorf("got address %s, want %s", r.addr, available.Address())
+	}
+	if !bytes.Equal(r.ch.Data(), available.Data()) {
+		t.Errorf("got chunk data %x, want %x", r.ch.Data(), available.Data())
+	}
+
+	// the fetch for the unavailable chunk must be cancelled
+	select {
+	case <-fetches[unavailable.Hex()].ctx.Done():
+	case <-time.After(time.Second):
+		t.Error("fetch for unavailable chunk is not cancelled")
+	}
+}
+
 func randomAddr() Address {
 	addr := make([]byte, 32)
 	rand.Read(addr)
 	return Address(addr)
 }
+
+// sizeLimitValidator is a ChunkValidator that
+// rejects chunks with data larger than the limit.
+type sizeLimitValidator struct {
+	limit int
+}
+
+func (v sizeLimitValidator) Validate(ch chunk.Chunk) bool {
+	return len(ch.Data()) <= v.limit
+}
+
+// TestNetStoreValidators validates that NetStore Put returns ErrChunkInvalid
+// and does not store the chunk if any of the Validators rejects it.
+func TestNetStoreValidators(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-storage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localStore, err := localstore.New(dir, make([]byte, 32), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localStore.Close()
+
+	const limit = 1000
+
+	netStore, err := NewNetStore(localStore, nil, &NetStoreOptions{
+		Validators: []ChunkValidator{sizeLimitValidator{limit: limit}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	oversized := GenerateRandomChunk(limit + 1)
+	if _, err := netStore.Put(ctx, chunk.ModePutRequest, oversized); err != ErrChunkInvalid {
+		t.Fatalf("got error %v, want %v", err, ErrChunkInvalid)
+	}
+	has, err := localStore.Has(ctx, oversized.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has {
+		t.Error("invalid chunk is stored")
+	}
+
+	valid := GenerateRandomChunk(limit - 8) // chunk data includes 8 bytes of span
+	if _, err := netStore.Put(ctx, chunk.ModePutRequest, valid); err != nil {
+		t.Fatal(err)
+	}
+	has, err = localStore.Has(ctx, valid.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !has {
+		t.Error("valid chunk is not stored")
+	}
+}
+
+// TestNetStoreGetErrors validates that NetStore Get returns
+// errors that describe why the chunk is not retrieved.
+func TestNetStoreGetErrors(t *testing.T) {
+	t.Run("not found", func(t *testing.T) {
+		netStore, _, cleanup := newTestNetStore(t)
+		defer cleanup()
+		// without the net fetcher, chunks are retrieved only from the local store
+		netStore.NewNetFetcherFunc = nil
+
+		_, err := netStore.Get(context.Background(), chunk.ModeGetRequest, GenerateRandomChunk(chunk.DefaultSize).Address())
+		if err != ErrChunkNotFound {
+			t.Fatalf("got error %v, want %v", err, ErrChunkNotFound)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		netStore, _, cleanup := newTestNetStore(t)
+		defer cleanup()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err := netStore.Get(ctx, chunk.ModeGetRequest, GenerateRandomChunk(chunk.DefaultSize).Address())
+		if err != ErrFetchTimeout {
+			t.Fatalf("got error %v, want %v", err, ErrFetchTimeout)
+		}
+	})
+
+	t.Run("no peers", func(t *testing.T) {
+		netStore, fetcher, cleanup := newTestNetStore(t)
+		defer cleanup()
+		fetcher.err = ErrNoPeers
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err := netStore.Get(ctx, chunk.ModeGetRequest, GenerateRandomChunk(chunk.DefaultSize).Address())
+		if err != ErrNoPeers {
+			t.Fatalf("got error %v, want %v", err, ErrNoPeers)
+		}
+	})
+
+	t.Run("invalid chunk", func(t *testing.T) {
+		netStore, _, cleanup := newTestNetStore(t)
+		defer cleanup()
+		const limit = 1000
+		netStore.validators = []ChunkValidator{sizeLimitValidator{limit: limit}}
+
+		ch := GenerateRandomChunk(limit + 1)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		errC := make(chan error)
+		go func() {
+			_, err := netStore.Get(ctx, chunk.ModeGetRequest, ch.Address())
+			errC <- err
+		}()
+
+		// wait for the fetcher to be created before delivering the invalid chunk
+		for netStore.getFetcher(ch.Address()) == nil {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if _, err := netStore.Put(context.Background(), chunk.ModePutRequest, ch); err != ErrChunkInvalid {
+			t.Fatalf("got put error %v, want %v", err, ErrChunkInvalid)
+		}
+
+		if err := <-errC; err != ErrChunkInvalid {
+			t.Fatalf("got error %v, want %v", err, ErrChunkInvalid)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		netStore, _, cleanup := newTestNetStore(t)
+		defer cleanup()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+		_, err := netStore.Get(ctx, chunk.ModeGetRequest, GenerateRandomChunk(chunk.DefaultSize).Address())
+		if err != context.Canceled {
+			t.Fatalf("got error %v, want %v", err, context.Canceled)
+		}
+	})
+}
+
+// TestNetStoreServeStale validates that with ServeStale option a stale
+// chunk is returned from the local store immediately, that a refetch is
+// started for it and that the chunk is not stale once it is delivered.
+func TestNetStoreServeStale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-storage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localStore, err := localstore.New(dir, make([]byte, 32), &localstore.Options{
+		SoftTTL: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localStore.Close()
+
+	created := make(chan Address, 1)
+	// the local store is wrapped as in a swarm node
+	netStore, err := NewNetStore(chunk.NewValidatorStore(localStore, NewFileStoreParams().Validator()), func(_ context.Context, addr Address, _ *sync.Map) NetFetcher {
+		created <- addr
+		return noopNetFetcher{}
+	}, &NetStoreOptions{
+		ServeStale: localStore,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ch := GenerateRandomChunk(chunk.DefaultSize)
+	if _, err := localStore.Put(ctx, chunk.ModePutRequest, ch); err != nil {
+		t.Fatal(err)
+	}
+
+	// fresh chunks are not refetched
+	if _, err := netStore.Get(ctx, chunk.ModeGetRequest, ch.Address()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case addr := <-created:
+		t.Fatalf("fetcher for fresh chunk %s created", addr)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := localStore.MarkStale(ch.Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	got, err := netStore.Get(ctx, chunk.ModeGetRequest, ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Data(), ch.Data()) {
+		t.Fatal("got invalid data for stale chunk")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("stale chunk returned after %v", d)
+	}
+
+	select {
+	case addr := <-created:
+		if !bytes.Equal(addr, ch.Address()) {
+			t.Fatalf("got fetcher for chunk %s, want %s", addr, ch.Address())
+		}
+	case <-ctx.Done():
+		t.Fatal("stale chunk not refetched")
+	}
+	// wait for the refetch to be requested
+	for netStore.getFetcher(ch.Address()) == nil {
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		}
+	}
+
+	// deliver the refetched chunk
+	if _, err := netStore.Put(ctx, chunk.ModePutRequest, ch); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := localStore.Stale(ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stale {
+		t.Error("chunk is stale after it is refetched")
+	}
+}
+
+// TestNetStorePushSyncFunc validates that PushSyncFunc is called before
+// Put returns only for chunks stored with ModePutUpload, that replication
+// is requested only for newly stored chunks and that its error is
+// returned by Put.
+func TestNetStorePushSyncFunc(t *testing.T) {
+	netStore, _, cleanup := newTestNetStore(t)
+	defer cleanup()
+	netStore.replication = 2
+
+	type push struct {
+		addr        Address
+		replication int
+	}
+	var pushes []push
+	pushErr := errors.New("push-sync error")
+	netStore.PushSyncFunc = func(_ context.Context, ch Chunk, replication int) error {
+		pushes = append(pushes, push{addr: ch.Address(), replication: replication})
+		if len(pushes) == 3 {
+			return pushErr
+		}
+		return nil
+	}
+
+	ctx := context.Background()
+
+	synced := GenerateRandomChunk(chunk.DefaultSize)
+	if _, err := netStore.Put(ctx, chunk.ModePutSync, synced); err != nil {
+		t.Fatal(err)
+	}
+	uploaded := GenerateRandomChunk(chunk.DefaultSize)
+	for i := 0; i < 2; i++ {
+		if _, err := netStore.Put(ctx, chunk.ModePutUpload, uploaded); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := netStore.Put(ctx, chunk.ModePutUpload, GenerateRandomChunk(chunk.DefaultSize)); err != pushErr {
+		t.Fatalf("got error %v, want %v", err, pushErr)
+	}
+
+	if len(pushes) != 3 {
+		t.Fatalf("got %v pushes, want 3", len(pushes))
+	}
+	for i, want := range []int{2, 0, 2} {
+		if pushes[i].replication != want {
+			t.Errorf("push %v: got replication %v, want %v", i, pushes[i].replication, want)
+		}
+	}
+	for _, p := range pushes[:2] {
+		if !bytes.Equal(p.addr, uploaded.Address()) {
+			t.Errorf("got pushed chunk %s, want %s", p.addr, uploaded.Address())
+		}
+	}
+}
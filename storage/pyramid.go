@@ -151,6 +151,34 @@ type PyramidChunker struct {
 	quitC       chan bool
 	rootAddress []byte
 	chunkLevel  [][]*TreeEntry
+	offset      int64                    // number of bytes read from the reader
+	readErr     error                    // error from the reader that terminated prepareChunks
+	checkpoint  func(c *splitCheckpoint) // called periodically with the split progress, if not nil
+	progress    *splitProgress           // progress passed to the next checkpoint call
+}
+
+// splitProgress is the split progress recorded at a level 0 tree chunk
+// boundary, before the keys of its tree entries are known.
+type splitProgress struct {
+	offset int64
+	levels [][]*TreeEntry
+}
+
+// splitCheckpoint holds the progress of the pyramid split: the number of
+// bytes read from the reader and the references and sizes of completed
+// subtrees on every tree level. Splitting can be continued from the
+// checkpoint without reading and hashing the data before its offset.
+type splitCheckpoint struct {
+	Offset  int64
+	RefSize int64
+	Levels  [][]splitCheckpointEntry
+}
+
+// splitCheckpointEntry is a completed subtree in splitCheckpoint.
+type splitCheckpointEntry struct {
+	Key         []byte
+	SubtreeSize uint64
+	BranchCount int64
 }
 
 func NewPyramidSplitter(params *PyramidSplitterParams, tag *chunk.Tag) (pc *PyramidChunker) {
@@ -221,6 +249,10 @@ func (pc *PyramidChunker) Split(ctx context.Context) (k Address, wait func(conte
 	defer close(pc.quitC)
 	defer pc.putter.Close()
 
+	if pc.readErr != nil {
+		return nil, nil, pc.readErr
+	}
+
 	select {
 	case err := <-pc.errC:
 		if err != nil {
@@ -234,6 +266,59 @@ func (pc *PyramidChunker) Split(ctx context.Context) (k Address, wait func(conte
 
 }
 
+// saveCheckpoint calls the checkpoint function with the split progress
+// recorded by the previous call and records the current progress. It must
+// be called only after buildTree for a level 0 tree chunk, as buildTree
+// waits for all previously enqueued chunks to be hashed, so the keys of
+// the previous progress are known without waiting for the current one.
+func (pc *PyramidChunker) saveCheckpoint() {
+	if p := pc.progress; p != nil {
+		c := &splitCheckpoint{
+			Offset:  p.offset,
+			RefSize: pc.hashSize,
+		}
+		for _, entries := range p.levels {
+			level := make([]splitCheckpointEntry, 0, len(entries))
+			for _, ent := range entries {
+				level = append(level, splitCheckpointEntry{
+					Key:         append([]byte(nil), ent.key...),
+					SubtreeSize: ent.subtreeSize,
+					BranchCount: ent.branchCount,
+				})
+			}
+			c.Levels = append(c.Levels, level)
+		}
+		pc.checkpoint(c)
+	}
+
+	p := &splitProgress{
+		offset: pc.offset,
+	}
+	for _, entries := range pc.chunkLevel {
+		if len(entries) == 0 {
+			break
+		}
+		p.levels = append(p.levels, append([]*TreeEntry(nil), entries...))
+	}
+	pc.progress = p
+}
+
+// restoreCheckpoint sets the tree levels and offset from the checkpoint,
+// so that Split continues with the data after the checkpoint offset.
+func (pc *PyramidChunker) restoreCheckpoint(c *splitCheckpoint) {
+	pc.offset = c.Offset
+	for lvl, entries := range c.Levels {
+		for _, e := range entries {
+			pc.chunkLevel[lvl] = append(pc.chunkLevel[lvl], &TreeEntry{
+				level:       lvl,
+				branchCount: e.BranchCount,
+				subtreeSize: e.SubtreeSize,
+				key:         e.Key,
+			})
+		}
+	}
+}
+
 func (pc *PyramidChunker) Append(ctx context.Context) (k Address, wait func(context.Context) error, err error) {
 	// Load the right most unfinished tree chunks in every level
 	pc.loadTree(ctx)
@@ -253,6 +338,10 @@ func (pc *PyramidChunker) Append(ctx context.Context) (k Address, wait func(cont
 	defer close(pc.quitC)
 	defer pc.putter.Close()
 
+	if pc.readErr != nil {
+		return nil, nil, pc.readErr
+	}
+
 	select {
 	case err := <-pc.errC:
 		if err != nil {
@@ -453,6 +542,7 @@ func (pc *PyramidChunker) prepareChunks(ctx context.Context, isAppend bool) {
 		copy(chunkData[8+readBytes:], res)
 
 		readBytes += len(res)
+		pc.offset += int64(len(res))
 		log.Trace("pyramid.chunker: copied all data", "readBytes", readBytes)
 
 		if err != nil {
@@ -469,7 +559,7 @@ func (pc *PyramidChunker) prepareChunks(ctx context.Context, isAppend bool) {
 					break
 				}
 			} else {
-				close(pc.quitC)
+				pc.readErr = err
 				break
 			}
 		}
@@ -514,6 +604,9 @@ func (pc *PyramidChunker) prepareChunks(ctx context.Context, isAppend bool) {
 			if parent.branchCount == pc.branches {
 				pc.buildTree(isAppend, parent, chunkWG, false, nil)
 				parent = NewTreeEntry(pc)
+				if pc.checkpoint != nil && !isAppend {
+					pc.saveCheckpoint()
+				}
 			}
 
 		}
@@ -0,0 +1,85 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// rateLimitedReader limits the rate of reading from the wrapped reader
+// with a token bucket. The bucket is refilled with rate tokens per
+// second up to a capacity of rate tokens, allowing bursts of up to one
+// second of data after idle periods. It starts empty, so that the average
+// rate over the whole read does not exceed the limit.
+type rateLimitedReader struct {
+	ctx    context.Context
+	r      io.Reader
+	rate   int64     // bytes per second
+	tokens int64     // available bytes, negative if the reader is ahead of the rate
+	last   time.Time // time of the last refill
+}
+
+// newRateLimitedReader returns a reader that reads from r
+// at most rate bytes per second.
+func newRateLimitedReader(ctx context.Context, r io.Reader, rate int64) *rateLimitedReader {
+	return &rateLimitedReader{
+		ctx:  ctx,
+		r:    r,
+		rate: rate,
+		last: time.Now(),
+	}
+}
+
+// Read reads from the wrapped reader and blocks until the read bytes
+// are covered by tokens, or the context is done.
+func (r *rateLimitedReader) Read(p []byte) (n int, err error) {
+	if int64(len(p)) > r.rate {
+		p = p[:r.rate]
+	}
+	n, err = r.r.Read(p)
+	r.refill()
+	r.tokens -= int64(n)
+	if r.tokens < 0 {
+		t := time.NewTimer(time.Duration(-r.tokens) * time.Second / time.Duration(r.rate))
+		defer t.Stop()
+		select {
+		case <-t.C:
+			r.refill()
+		case <-r.ctx.Done():
+			return n, r.ctx.Err()
+		}
+	}
+	return n, err
+}
+
+// refill adds tokens for the time passed since the last refill.
+func (r *rateLimitedReader) refill() {
+	now := time.Now()
+	elapsed := now.Sub(r.last)
+	// the bucket holds at most one second of tokens, so a longer
+	// elapsed time would only overflow the multiplication below
+	if elapsed > time.Second {
+		elapsed = time.Second
+	}
+	r.tokens += int64(elapsed) * r.rate / int64(time.Second)
+	if r.tokens > r.rate {
+		r.tokens = r.rate
+	}
+	r.last = now
+}
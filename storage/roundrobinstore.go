@@ -0,0 +1,283 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethersphere/swarm/chunk"
+)
+
+// RoundRobinStore distributes chunks between multiple chunk stores,
+// for example local databases on different disks, by putting every
+// new chunk to the next store in turn. Chunks are retrieved from
+// any of the stores that hold them.
+type RoundRobinStore struct {
+	index  uint32
+	stores []ChunkStore
+}
+
+// RoundRobinStore implements ChunkStore.
+var _ ChunkStore = &RoundRobinStore{}
+
+// NewRoundRobinStore creates a new RoundRobinStore over provided stores.
+// At least one store must be provided.
+func NewRoundRobinStore(stores ...ChunkStore) (*RoundRobinStore, error) {
+	if len(stores) == 0 {
+		return nil, errors.New("no stores")
+	}
+	return &RoundRobinStore{
+		stores: stores,
+	}, nil
+}
+
+// Put stores the chunk in the next store. If the chunk is already
+// stored, it is put to the store that holds it, so that it is not
+// duplicated in other stores.
+func (rrs *RoundRobinStore) Put(ctx context.Context, mode chunk.ModePut, ch Chunk) (bool, error) {
+	s, err := rrs.holder(ctx, ch.Address())
+	if err != nil {
+		return false, err
+	}
+	if s == nil {
+		i := atomic.AddUint32(&rrs.index, 1)
+		s = rrs.stores[int(i)%len(rrs.stores)]
+	}
+	return s.Put(ctx, mode, ch)
+}
+
+// holder returns the first store that holds the chunk,
+// or nil if none of the stores has it.
+func (rrs *RoundRobinStore) holder(ctx context.Context, addr Address) (ChunkStore, error) {
+	for _, s := range rrs.stores {
+		has, err := s.Has(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		if has {
+			return s, nil
+		}
+	}
+	return nil, nil
+}
+
+// Has returns true if any of the stores holds the chunk.
+func (rrs *RoundRobinStore) Has(ctx context.Context, addr Address) (bool, error) {
+	type result struct {
+		has bool
+		err error
+	}
+	results := make(chan result, len(rrs.stores))
+	for _, s := range rrs.stores {
+		go func(s ChunkStore) {
+			has, err := s.Has(ctx, addr)
+			results <- result{has: has, err: err}
+		}(s)
+	}
+	var err error
+	for range rrs.stores {
+		r := <-results
+		if r.err != nil {
+			err = r.err
+			continue
+		}
+		if r.has {
+			return true, nil
+		}
+	}
+	return false, err
+}
+
+// Get returns the chunk from the first store that holds it.
+// ErrChunkNotFound is returned if none of the stores has it.
+func (rrs *RoundRobinStore) Get(ctx context.Context, mode chunk.ModeGet, addr Address) (Chunk, error) {
+	type result struct {
+		ch  Chunk
+		err error
+	}
+	results := make(chan result, len(rrs.stores))
+	for _, s := range rrs.stores {
+		go func(s ChunkStore) {
+			ch, err := s.Get(ctx, mode, addr)
+			results <- result{ch: ch, err: err}
+		}(s)
+	}
+	err := ErrChunkNotFound
+	for range rrs.stores {
+		r := <-results
+		if r.err == nil {
+			return r.ch, nil
+		}
+		if r.err != ErrChunkNotFound {
+			err = r.err
+		}
+	}
+	return nil, err
+}
+
+// GetMulti returns chunks for all provided addresses, each from the
+// first store that holds it. Chunks that none of the stores has are
+// represented by nil entries in the returned slice.
+func (rrs *RoundRobinStore) GetMulti(ctx context.Context, mode chunk.ModeGet, addrs ...Address) ([]Chunk, error) {
+	chunks := make([]Chunk, len(addrs))
+	// indexes of addresses whose chunks are not yet found
+	missing := make([]int, len(addrs))
+	for i := range addrs {
+		missing[i] = i
+	}
+	for _, s := range rrs.stores {
+		if len(missing) == 0 {
+			break
+		}
+		query := make([]Address, len(missing))
+		for i, j := range missing {
+			query[i] = addrs[j]
+		}
+		chs, err := s.GetMulti(ctx, mode, query...)
+		if err != nil {
+			return nil, err
+		}
+		var stillMissing []int
+		for i, ch := range chs {
+			if ch == nil {
+				stillMissing = append(stillMissing, missing[i])
+				continue
+			}
+			chunks[missing[i]] = ch
+		}
+		missing = stillMissing
+	}
+	return chunks, nil
+}
+
+// Set applies the mode to the chunk in all stores that hold it.
+// ErrChunkNotFound is returned if none of the stores has it.
+func (rrs *RoundRobinStore) Set(ctx context.Context, mode chunk.ModeSet, addr chunk.Address) (err error) {
+	var found bool
+	for _, s := range rrs.stores {
+		has, err := s.Has(ctx, addr)
+		if err != nil {
+			return err
+		}
+		if !has {
+			continue
+		}
+		if err := s.Set(ctx, mode, addr); err != nil {
+			return err
+		}
+		found = true
+	}
+	if !found {
+		return ErrChunkNotFound
+	}
+	return nil
+}
+
+// LastPullSubscriptionBinID is not supported as bin IDs are not
+// comparable between stores.
+func (rrs *RoundRobinStore) LastPullSubscriptionBinID(bin uint8) (id uint64, err error) {
+	return 0, errors.New("RoundRobinStore doesn't support LastPullSubscriptionBinID")
+}
+
+// SubscribePull is not supported as bin IDs are not comparable between
+// stores. It returns a closed channel.
+func (rrs *RoundRobinStore) SubscribePull(ctx context.Context, bin uint8, since, until uint64) (c <-chan chunk.Descriptor, stop func()) {
+	descriptors := make(chan chunk.Descriptor)
+	close(descriptors)
+	return descriptors, func() {}
+}
+
+// Close closes all stores.
+func (rrs *RoundRobinStore) Close() (err error) {
+	for _, s := range rrs.stores {
+		if e := s.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// weightedStrideBase is divided by store weights to get the stride
+// by which the pass of a store is advanced when it receives a chunk.
+const weightedStrideBase = 1 << 32
+
+// WeightedStore distributes chunks between multiple chunk stores
+// proportionally to their weights, so that, for example, a larger
+// disk receives more chunks. Stores for new chunks are selected
+// deterministically by stride scheduling.
+type WeightedStore struct {
+	*RoundRobinStore
+	strides []uint64
+	passes  []uint64
+	mu      sync.Mutex // protects passes
+}
+
+// WeightedStore implements ChunkStore.
+var _ ChunkStore = &WeightedStore{}
+
+// NewWeightedStore creates a new WeightedStore over provided stores with
+// corresponding weights. Weights must be positive and there must be the
+// same number of stores and weights.
+func NewWeightedStore(stores []ChunkStore, weights []int) (*WeightedStore, error) {
+	if len(stores) != len(weights) {
+		return nil, fmt.Errorf("got %d stores and %d weights", len(stores), len(weights))
+	}
+	strides := make([]uint64, len(weights))
+	for i, w := range weights {
+		if w <= 0 {
+			return nil, fmt.Errorf("invalid weight %d for store %d", w, i)
+		}
+		strides[i] = weightedStrideBase / uint64(w)
+	}
+	rrs, err := NewRoundRobinStore(stores...)
+	if err != nil {
+		return nil, err
+	}
+	return &WeightedStore{
+		RoundRobinStore: rrs,
+		strides:         strides,
+		passes:          make([]uint64, len(stores)),
+	}, nil
+}
+
+// Put stores the chunk in the store with the lowest pass. If the chunk
+// is already stored, it is put to the store that holds it.
+func (ws *WeightedStore) Put(ctx context.Context, mode chunk.ModePut, ch Chunk) (bool, error) {
+	s, err := ws.holder(ctx, ch.Address())
+	if err != nil {
+		return false, err
+	}
+	if s != nil {
+		return s.Put(ctx, mode, ch)
+	}
+
+	ws.mu.Lock()
+	idx := 0
+	for i, p := range ws.passes {
+		if p < ws.passes[idx] {
+			idx = i
+		}
+	}
+	ws.passes[idx] += ws.strides[idx]
+	ws.mu.Unlock()
+
+	return ws.stores[idx].Put(ctx, mode, ch)
+}
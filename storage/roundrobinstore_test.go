@@ -0,0 +1,254 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/storage/localstore"
+)
+
+// newTestRoundRobinStore creates a RoundRobinStore with count
+// local stores and returns it with the backing stores.
+func newTestRoundRobinStore(t *testing.T, count int) (*RoundRobinStore, []*localstore.DB, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "swarm-round-robin-store-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dbs []*localstore.DB
+	var stores []ChunkStore
+	for i := 0; i < count; i++ {
+		path, err := ioutil.TempDir(dir, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, err := localstore.New(path, make([]byte, 32), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dbs = append(dbs, db)
+		stores = append(stores, db)
+	}
+	rrs, err := NewRoundRobinStore(stores...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rrs, dbs, func() {
+		rrs.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// TestRoundRobinStore validates that chunks are put to every store in turn
+// and that they can be retrieved and set through the round-robin store.
+func TestRoundRobinStore(t *testing.T) {
+	rrs, dbs, cleanup := newTestRoundRobinStore(t, 3)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	chunks := GenerateRandomChunks(chunk.DefaultSize, 6)
+	for _, ch := range chunks {
+		if _, err := rrs.Put(ctx, chunk.ModePutUpload, ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// chunks that are put again are not duplicated to other stores
+	for _, ch := range chunks {
+		if _, err := rrs.Put(ctx, chunk.ModePutUpload, ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, ch := range chunks {
+		// every chunk is stored in exactly one store
+		var count int
+		for _, db := range dbs {
+			has, err := db.Has(ctx, ch.Address())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if has {
+				count++
+			}
+		}
+		if count != 1 {
+			t.Errorf("chunk %v is stored in %v stores", i, count)
+		}
+
+		has, err := rrs.Has(ctx, ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !has {
+			t.Errorf("chunk %v not found", i)
+		}
+
+		got, err := rrs.Get(ctx, chunk.ModeGetRequest, ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Address(), ch.Address()) {
+			t.Errorf("got chunk %v, want %v", got.Address(), ch.Address())
+		}
+
+		if err := rrs.Set(ctx, chunk.ModeSetSync, ch.Address()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// set is applied to the store that holds the chunk
+	for i, ch := range chunks {
+		for _, db := range dbs {
+			synced, err := db.IsSynced(ctx, ch.Address())
+			if err == chunk.ErrChunkNotFound {
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !synced {
+				t.Errorf("chunk %v is not synced", i)
+			}
+		}
+	}
+
+	missing := GenerateRandomChunk(chunk.DefaultSize)
+
+	has, err := rrs.Has(ctx, missing.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has {
+		t.Error("missing chunk found")
+	}
+
+	_, err = rrs.Get(ctx, chunk.ModeGetRequest, missing.Address())
+	if err != ErrChunkNotFound {
+		t.Errorf("got error %v, want %v", err, ErrChunkNotFound)
+	}
+
+	err = rrs.Set(ctx, chunk.ModeSetSync, missing.Address())
+	if err != ErrChunkNotFound {
+		t.Errorf("got error %v, want %v", err, ErrChunkNotFound)
+	}
+
+	// chunks are collected from all stores and
+	// the missing chunk is represented by a nil entry
+	addrs := []Address{missing.Address()}
+	for _, ch := range chunks {
+		addrs = append(addrs, ch.Address())
+	}
+	got, err := rrs.GetMulti(ctx, chunk.ModeGetLookup, addrs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != nil {
+		t.Error("missing chunk found")
+	}
+	for i, ch := range chunks {
+		if got[i+1] == nil {
+			t.Errorf("chunk %v not found", i)
+			continue
+		}
+		if !bytes.Equal(got[i+1].Address(), ch.Address()) {
+			t.Errorf("got chunk %v, want %v", got[i+1].Address(), ch.Address())
+		}
+	}
+}
+
+// TestWeightedStore validates that chunks are distributed between
+// stores proportionally to their weights.
+func TestWeightedStore(t *testing.T) {
+	weights := []int{1, 2, 5}
+	counts := make([]int, len(weights))
+	stores := make([]ChunkStore, len(weights))
+	for i := range stores {
+		i := i
+		stores[i] = &countingChunkStore{
+			put: func() { counts[i]++ },
+		}
+	}
+
+	ws, err := NewWeightedStore(stores, weights)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total := 10000
+	for _, ch := range GenerateRandomChunks(10, total) {
+		if _, err := ws.Put(context.Background(), chunk.ModePutUpload, ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var weightsSum int
+	for _, w := range weights {
+		weightsSum += w
+	}
+	for i, w := range weights {
+		want := total * w / weightsSum
+		// allow a difference of one percent of the total count
+		if d := counts[i] - want; d > total/100 || d < -total/100 {
+			t.Errorf("store %v: got %v chunks, want %v", i, counts[i], want)
+		}
+	}
+}
+
+func TestNewRoundRobinStoreNoStores(t *testing.T) {
+	if _, err := NewRoundRobinStore(); err == nil {
+		t.Error("expected error for no stores")
+	}
+}
+
+func TestNewWeightedStoreInvalidArguments(t *testing.T) {
+	stores := []ChunkStore{&FakeChunkStore{}, &FakeChunkStore{}}
+
+	if _, err := NewWeightedStore(stores, []int{1}); err == nil {
+		t.Error("expected error for different number of stores and weights")
+	}
+	if _, err := NewWeightedStore(stores, []int{1, 0}); err == nil {
+		t.Error("expected error for non-positive weight")
+	}
+	if _, err := NewWeightedStore(nil, nil); err == nil {
+		t.Error("expected error for no stores")
+	}
+}
+
+// countingChunkStore calls put function on every Put call.
+// It never holds any chunk.
+type countingChunkStore struct {
+	FakeChunkStore
+	put func()
+}
+
+func (s *countingChunkStore) Has(_ context.Context, _ Address) (bool, error) {
+	return false, nil
+}
+
+func (s *countingChunkStore) Put(_ context.Context, _ chunk.ModePut, _ Chunk) (bool, error) {
+	s.put()
+	return false, nil
+}
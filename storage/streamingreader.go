@@ -0,0 +1,244 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// streamingReadAhead is the maximal number of chunks that
+// streamingReader fetches ahead of the data that is being read.
+var streamingReadAhead = 16
+
+// streamingReader reads the content of a chunk tree in order.
+// A goroutine walks the tree depth first and sends data of leaf
+// chunks over a buffered channel, so that only the chunks on the
+// path to the current leaf and a bounded number of prefetched
+// chunks are kept in memory.
+type streamingReader struct {
+	getter    Getter
+	hashSize  int64
+	branches  int64
+	chunkSize int64
+	cancel    context.CancelFunc
+	dataC     chan []byte // data of leaf chunks in content order
+	errC      chan error  // result of the tree walk
+	buf       []byte      // unread data of the current leaf chunk
+	err       error
+	start     int64 // offset of the first byte to read
+	end       int64 // offset after the last byte to read, -1 for the end of content
+}
+
+func newStreamingReader(ctx context.Context, addr Address, getter Getter, chunkSize int64) *streamingReader {
+	ctx, cancel := context.WithCancel(ctx)
+	r := newStreamingReaderState(addr, getter, chunkSize, cancel, 0, -1)
+	go func() {
+		defer close(r.dataC)
+		r.errC <- r.walkRoot(ctx, addr)
+	}()
+	return r
+}
+
+// newStreamingRangeReader returns a streamingReader for length bytes of
+// the content starting at offset. Only chunks that overlap with the range
+// are fetched. The root chunk is retrieved before returning, in order to
+// validate the range against the content size. The range is truncated at
+// the end of the content.
+func newStreamingRangeReader(ctx context.Context, addr Address, getter Getter, chunkSize, offset, length int64) (*streamingReader, error) {
+	if offset < 0 || length < 0 {
+		return nil, ErrInvalidRange
+	}
+	root, err := getter.Get(ctx, Reference(addr))
+	if err != nil {
+		return nil, err
+	}
+	size := int64(root.Size())
+	if offset > size {
+		return nil, ErrInvalidRange
+	}
+	end := size
+	if length < size-offset {
+		end = offset + length
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	r := newStreamingReaderState(addr, getter, chunkSize, cancel, offset, end)
+	go func() {
+		defer close(r.dataC)
+		r.errC <- r.walkRootData(ctx, root)
+	}()
+	return r, nil
+}
+
+func newStreamingReaderState(addr Address, getter Getter, chunkSize int64, cancel context.CancelFunc, start, end int64) *streamingReader {
+	return &streamingReader{
+		getter:    getter,
+		hashSize:  int64(len(addr)),
+		branches:  chunkSize / int64(len(addr)),
+		chunkSize: chunkSize,
+		cancel:    cancel,
+		dataC:     make(chan []byte, streamingReadAhead),
+		errC:      make(chan error, 1),
+		start:     start,
+		end:       end,
+	}
+}
+
+// Read reads the content sequentially. It returns io.EOF
+// after all data is read.
+func (r *streamingReader) Read(b []byte) (n int, err error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		data, ok := <-r.dataC
+		if !ok {
+			r.err = <-r.errC
+			if r.err == nil {
+				r.err = io.EOF
+			}
+			continue
+		}
+		r.buf = data
+	}
+	n = copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Close stops fetching of chunks.
+func (r *streamingReader) Close() error {
+	r.cancel()
+	return nil
+}
+
+// walkRoot gets the root chunk and walks the tree.
+func (r *streamingReader) walkRoot(ctx context.Context, addr Address) error {
+	data, err := r.getter.Get(ctx, Reference(addr))
+	if err != nil {
+		return err
+	}
+	return r.walkRootData(ctx, data)
+}
+
+// walkRootData walks the tree with the provided root chunk data,
+// calculating its depth in the same way as LazyChunkReader.ReadAt.
+func (r *streamingReader) walkRootData(ctx context.Context, data ChunkData) error {
+	size := int64(data.Size())
+	if r.end < 0 || r.end > size {
+		r.end = size
+	}
+	if r.start >= r.end {
+		return nil
+	}
+	treeSize := r.chunkSize
+	var depth int
+	for ; treeSize < size; treeSize *= r.branches {
+		depth++
+	}
+	return r.walk(ctx, data, 0, depth, treeSize/r.branches)
+}
+
+// walk sends data of leaf chunks under the chunk with the provided data
+// to the data channel, limited to the reader range. Argument pos is the
+// offset of the chunk data in the content, depth and treeSize have the
+// same meaning as in LazyChunkReader.join.
+func (r *streamingReader) walk(ctx context.Context, data ChunkData, pos int64, depth int, treeSize int64) error {
+	for data.Size() < uint64(treeSize) && depth > 0 {
+		treeSize /= r.branches
+		depth--
+	}
+
+	// leaf chunk found
+	if depth == 0 {
+		end := int64(8 + data.Size())
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		if e := 8 + r.end - pos; e < end {
+			end = e
+		}
+		start := int64(8)
+		if s := 8 + r.start - pos; s > start {
+			start = s
+		}
+		select {
+		case r.dataC <- data[start:end]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return nil
+	}
+
+	type result struct {
+		data ChunkData
+		err  error
+	}
+	fetch := func(ref Reference) chan result {
+		c := make(chan result, 1)
+		go func() {
+			data, err := r.getter.Get(ctx, ref)
+			c <- result{data: data, err: err}
+		}()
+		return c
+	}
+
+	// prefetch only children that are expected to be leaf chunks,
+	// intermediate chunks are fetched one at a time
+	window := 1
+	if depth == 1 {
+		window = streamingReadAhead
+	}
+
+	// only children that overlap with the range are walked
+	first := int64(0)
+	if r.start > pos {
+		first = (r.start - pos) / treeSize
+	}
+	count := int64(len(data)-8) / r.hashSize
+	if c := (r.end - pos + treeSize - 1) / treeSize; c < count {
+		count = c
+	}
+	ref := func(i int64) Reference {
+		return Reference(data[8+i*r.hashSize : 8+(i+1)*r.hashSize])
+	}
+	var pending []chan result
+	next := first
+	for i := first; i < count; i++ {
+		for ; next < count && len(pending) < window; next++ {
+			pending = append(pending, fetch(ref(next)))
+		}
+		var res result
+		select {
+		case res = <-pending[0]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		pending = pending[1:]
+		if res.err != nil {
+			return fmt.Errorf("chunk %x not found: %v", ref(i), res.err)
+		}
+		if l := len(res.data); l < 9 {
+			return fmt.Errorf("chunk %x incomplete, data length %v", ref(i), l)
+		}
+		if err := r.walk(ctx, res.data, pos+i*treeSize, depth-1, treeSize/r.branches); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -47,6 +47,13 @@ var Proximity = chunk.Proximity
 var ZeroAddr = chunk.ZeroAddr
 
 func MakeHashFunc(hash string) SwarmHasher {
+	return MakeHashFuncWithChunkSize(hash, chunk.DefaultSize)
+}
+
+// MakeHashFuncWithChunkSize returns the hash function as MakeHashFunc does,
+// with the BMT hasher sized for chunks of chunkSize bytes. The chunk size
+// must be a power of two.
+func MakeHashFuncWithChunkSize(hash string, chunkSize int) SwarmHasher {
 	switch hash {
 	case "SHA256":
 		return func() SwarmHash { return &HashWithLength{crypto.SHA256.New()} }
@@ -56,7 +63,7 @@ func MakeHashFunc(hash string) SwarmHasher {
 		return func() SwarmHash {
 			hasher := sha3.NewLegacyKeccak256
 			hasherSize := hasher().Size()
-			segmentCount := chunk.DefaultSize / hasherSize
+			segmentCount := chunkSize / hasherSize
 			pool := bmt.NewTreePool(hasher, segmentCount, bmt.PoolSize)
 			return bmt.New(pool)
 		}
@@ -184,6 +191,10 @@ type ChunkValidator = chunk.Validator
 // Holds the corresponding hasher to create the address
 type ContentAddressValidator struct {
 	Hasher SwarmHasher
+	// ChunkSize is the maximal size of chunk data without
+	// the span, chunk.DefaultSize if zero. It must be the
+	// same as the chunk size of the Hasher.
+	ChunkSize int
 }
 
 // Constructor
@@ -196,7 +207,11 @@ func NewContentAddressValidator(hasher SwarmHasher) *ContentAddressValidator {
 // Validate that the given key is a valid content address for the given data
 func (v *ContentAddressValidator) Validate(ch Chunk) bool {
 	data := ch.Data()
-	if l := len(data); l < 9 || l > chunk.DefaultSize+8 {
+	chunkSize := v.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = chunk.DefaultSize
+	}
+	if l := len(data); l < 9 || l > chunkSize+8 {
 		// log.Error("invalid chunk size", "chunk", addr.Hex(), "size", l)
 		return false
 	}
@@ -232,6 +247,11 @@ func (f *FakeChunkStore) Get(_ context.Context, _ chunk.ModeGet, ref Address) (C
 	panic("FakeChunkStore doesn't support Get")
 }
 
+// GetMulti doesn't store anything it is just here to implement ChunkStore
+func (f *FakeChunkStore) GetMulti(_ context.Context, _ chunk.ModeGet, _ ...Address) ([]Chunk, error) {
+	panic("FakeChunkStore doesn't support GetMulti")
+}
+
 func (f *FakeChunkStore) Set(ctx context.Context, mode chunk.ModeSet, addr chunk.Address) (err error) {
 	panic("FakeChunkStore doesn't support Set")
 }
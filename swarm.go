@@ -99,6 +99,9 @@ func NewSwarm(config *api.Config, mockStore *mock.NodeStore) (self *Swarm, err e
 	if bytes.Equal(common.FromHex(config.BzzKey), storage.ZeroAddr) {
 		return nil, fmt.Errorf("empty bzz key")
 	}
+	if err := config.FileStoreParams.Validate(); err != nil {
+		return nil, err
+	}
 
 	var backend chequebook.Backend
 	if config.SwapAPI != "" && config.SwapEnabled {
@@ -161,20 +164,26 @@ func NewSwarm(config *api.Config, mockStore *mock.NodeStore) (self *Swarm, err e
 
 	feedsHandler = feed.NewHandler(fhParams)
 
+	tags := chunk.NewTags() //todo load from state store
+
 	localStore, err := localstore.New(config.ChunkDbPath, config.BaseKey, &localstore.Options{
 		MockStore: mockStore,
 		Capacity:  config.DbCapacity,
+		Tags:      tags,
+		ChunkSize: config.FileStoreParams.ChunkSize,
 	})
 	if err != nil {
 		return nil, err
 	}
 	lstore := chunk.NewValidatorStore(
 		localStore,
-		storage.NewContentAddressValidator(storage.MakeHashFunc(storage.DefaultHash)),
+		config.FileStoreParams.Validator(),
 		feedsHandler,
 	)
 
-	self.netStore, err = storage.NewNetStore(lstore, nil)
+	self.netStore, err = storage.NewNetStore(lstore, nil, &storage.NetStoreOptions{
+		Replication: config.ChunkReplication,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -183,8 +192,16 @@ func NewSwarm(config *api.Config, mockStore *mock.NodeStore) (self *Swarm, err e
 		common.FromHex(config.BzzKey),
 		network.NewKadParams(),
 	)
-	delivery := stream.NewDelivery(to, self.netStore)
-	self.netStore.NewNetFetcherFunc = network.NewFetcherFactory(delivery.RequestFromPeers, config.DeliverySkipCheck).New
+	delivery := stream.NewDelivery(to, self.netStore, &stream.DeliveryOptions{
+		PushSyncReplication: config.PushSyncReplication,
+		ChunkSize:           config.FileStoreParams.ChunkSize,
+	})
+	fetcherFactory := network.NewFetcherFactory(delivery.RequestFromPeers, config.DeliverySkipCheck, nil)
+	if config.FallbackGateway != "" {
+		fetcherFactory = network.NewFetcherFactoryWithFallback(delivery.RequestFromPeers, config.DeliverySkipCheck, config.FallbackGateway, self.netStore, config.FileStoreParams)
+	}
+	self.netStore.NewNetFetcherFunc = fetcherFactory.New
+	self.netStore.PushSyncFunc = delivery.PushSync
 
 	feedsHandler.SetStore(self.netStore)
 
@@ -209,12 +226,26 @@ func NewSwarm(config *api.Config, mockStore *mock.NodeStore) (self *Swarm, err e
 		Syncing:         syncing,
 		SyncUpdateDelay: config.SyncUpdateDelay,
 		MaxPeerServers:  config.MaxStreamPeerServers,
+
+		HighWatermarkRatio: config.SyncHighWatermarkRatio,
+		CapacityStore:      localStore,
+		CancelledStore:     localStore,
 	}
-	self.streamer = stream.NewRegistry(nodeID, delivery, self.netStore, self.stateStore, registryOptions, self.swap)
-	tags := chunk.NewTags() //todo load from state store
+	// the state store is shared with the hive, it must not be closed
+	// with the registry before the hive saves its peers on stop
+	self.streamer = stream.NewRegistry(nodeID, delivery, self.netStore, sharedStateStore{self.stateStore}, registryOptions, self.swap)
+
+	var chunkStore storage.ChunkStore = self.netStore
+	if config.ChunkCacheSize > 0 {
+		chunkStore = storage.NewCachingNetStore(self.netStore, config.ChunkCacheSize)
+	}
+
+	fileStoreParams := *self.config.FileStoreParams
+	fileStoreParams.SyncStatusStore = localStore
+	fileStoreParams.CheckpointStore = self.stateStore
 
 	// Swarm Hash Merklised Chunking for Arbitrary-length Document/File storage
-	self.fileStore = storage.NewFileStore(self.netStore, self.config.FileStoreParams, tags)
+	self.fileStore = storage.NewFileStore(chunkStore, &fileStoreParams, tags)
 
 	log.Debug("Setup local storage")
 
@@ -447,6 +478,13 @@ func (s *Swarm) Stop() error {
 	if s.accountingMetrics != nil {
 		s.accountingMetrics.Close()
 	}
+	// wait for the deliveries of already wanted chunks to be sent
+	// before the stores they are read from are closed
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := s.streamer.CloseContext(ctx); err != nil {
+		log.Error("closing stream registry", "err", err)
+	}
+	cancel()
 	if s.netStore != nil {
 		s.netStore.Close()
 	}
@@ -469,6 +507,17 @@ func (s *Swarm) Stop() error {
 	return err
 }
 
+// sharedStateStore wraps a state store that is closed by its owner,
+// so that closing it through the wrapper has no effect.
+type sharedStateStore struct {
+	state.Store
+}
+
+// Close does not close the wrapped store.
+func (sharedStateStore) Close() error {
+	return nil
+}
+
 // Protocols implements the node.Service interface
 func (s *Swarm) Protocols() (protos []p2p.Protocol) {
 	if s.config.BootnodeMode {
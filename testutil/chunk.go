@@ -0,0 +1,91 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package testutil
+
+import (
+	"encoding/binary"
+	"math/rand"
+
+	"github.com/ethersphere/swarm/chunk"
+	"golang.org/x/crypto/sha3"
+)
+
+// DeterministicChunks returns count chunks with random data of
+// chunk.DefaultSize generated from the seed, addressed with the
+// default BMT hash. The same seed always produces the same sequence
+// of chunks, and a shorter sequence is a prefix of a longer one.
+func DeterministicChunks(seed int64, count int) []chunk.Chunk {
+	r := rand.New(rand.NewSource(seed))
+	chunks := make([]chunk.Chunk, count)
+	for i := range chunks {
+		chunks[i] = deterministicChunk(r)
+	}
+	return chunks
+}
+
+// ChunksInBin returns count chunks generated from the seed, in the same
+// way as DeterministicChunks, that have the proximity order po to the
+// base address. Chunks from the sequence that are in other bins are
+// skipped, so the time needed to generate them grows exponentially
+// with po.
+func ChunksInBin(seed int64, count int, po int, baseAddr chunk.Address) []chunk.Chunk {
+	r := rand.New(rand.NewSource(seed))
+	chunks := make([]chunk.Chunk, 0, count)
+	for len(chunks) < count {
+		ch := deterministicChunk(r)
+		if chunk.Proximity(baseAddr, ch.Address()) == po {
+			chunks = append(chunks, ch)
+		}
+	}
+	return chunks
+}
+
+// deterministicChunk returns a chunk with data read from the
+// random source.
+func deterministicChunk(r *rand.Rand) chunk.Chunk {
+	data := make([]byte, 8+chunk.DefaultSize)
+	binary.LittleEndian.PutUint64(data[:8], chunk.DefaultSize)
+	r.Read(data[8:])
+	return chunk.NewChunk(bmtAddress(data), data)
+}
+
+// bmtAddress returns the BMT hash of the chunk data with the span
+// in its first 8 bytes, as calculated by the default swarm hasher.
+// The bmt package can not be used as its tests import this package.
+func bmtAddress(data []byte) chunk.Address {
+	const segmentSize = 32
+
+	level := make([]byte, chunk.DefaultSize)
+	copy(level, data[8:])
+	for len(level) > segmentSize {
+		next := make([]byte, 0, len(level)/2)
+		for i := 0; i < len(level); i += 2 * segmentSize {
+			next = append(next, keccak256(level[i:i+2*segmentSize])...)
+		}
+		level = next
+	}
+	return keccak256(data[:8], level)
+}
+
+// keccak256 returns the Keccak-256 hash of concatenated byte slices.
+func keccak256(b ...[]byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	for _, d := range b {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package testutil_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethersphere/swarm/chunk"
+	"github.com/ethersphere/swarm/storage"
+	"github.com/ethersphere/swarm/testutil"
+)
+
+// TestDeterministicChunks validates that chunks are valid content
+// addressed chunks and that the same seed produces the same chunks.
+func TestDeterministicChunks(t *testing.T) {
+	validator := storage.NewContentAddressValidator(storage.MakeHashFunc(storage.DefaultHash))
+
+	chunks := testutil.DeterministicChunks(1, 10)
+	if len(chunks) != 10 {
+		t.Fatalf("got %v chunks, want 10", len(chunks))
+	}
+	for i, ch := range chunks {
+		if !validator.Validate(ch) {
+			t.Errorf("chunk %v with address %s is not valid", i, ch.Address())
+		}
+	}
+
+	for i, ch := range testutil.DeterministicChunks(1, 20) {
+		if i >= len(chunks) {
+			break
+		}
+		if !bytes.Equal(ch.Address(), chunks[i].Address()) || !bytes.Equal(ch.Data(), chunks[i].Data()) {
+			t.Errorf("chunk %v is not the same for the same seed", i)
+		}
+	}
+
+	other := testutil.DeterministicChunks(2, 1)
+	if bytes.Equal(other[0].Address(), chunks[0].Address()) {
+		t.Error("chunks are the same for different seeds")
+	}
+}
+
+// TestChunksInBin validates that generated chunks are valid, that they
+// are in the requested bin and that the same seed produces the same chunks.
+func TestChunksInBin(t *testing.T) {
+	validator := storage.NewContentAddressValidator(storage.MakeHashFunc(storage.DefaultHash))
+	baseAddr := testutil.DeterministicChunks(3, 1)[0].Address()
+
+	for _, po := range []int{0, 1, 4} {
+		chunks := testutil.ChunksInBin(1, 5, po, baseAddr)
+		if len(chunks) != 5 {
+			t.Fatalf("po %v: got %v chunks, want 5", po, len(chunks))
+		}
+		again := testutil.ChunksInBin(1, 5, po, baseAddr)
+		for i, ch := range chunks {
+			if got := chunk.Proximity(baseAddr, ch.Address()); got != po {
+				t.Errorf("po %v: chunk %v has proximity order %v", po, i, got)
+			}
+			if !validator.Validate(ch) {
+				t.Errorf("po %v: chunk %v with address %s is not valid", po, i, ch.Address())
+			}
+			if !bytes.Equal(ch.Address(), again[i].Address()) {
+				t.Errorf("po %v: chunk %v is not the same for the same seed", po, i)
+			}
+		}
+	}
+}